@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"investbot/pkg/config"
+	"investbot/pkg/gemini"
+	"investbot/pkg/llama"
+	"investbot/pkg/marketDataScraper"
+	"investbot/pkg/openAI"
+	"investbot/pkg/services"
+	"investbot/pkg/services/prompts"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// getLlm builds the llm of the configured provider, like the investbot server
+func getLlm(conf config.Config) (services.Llm, error) {
+	var llm services.Llm
+	var err error
+	switch conf.LlmProvider {
+	case config.OPEN_AI:
+		openAiClient, _ := openAI.NewOpenAiClient(conf.OpenAiKey, conf.OpenAiBaseUrl)
+		llm, err = openAI.NewOpenAiLLM(conf.OpenAiModelName, openAiClient, float64(conf.BaseLlmTemperature))
+	case config.OLLAMA:
+		llamaClient, _ := llama.NewOllamaClient(conf.OllamaBaseUrl)
+		llm, err = llama.NewLlamaLLM(llama.ModelName(conf.OllamaModelName), llamaClient, conf.BaseLlmTemperature)
+	case config.GEMINI:
+		llmConfig := gemini.GeminiLlmConfig{
+			ModelName:   conf.GeminiModelName,
+			Temperature: conf.BaseLlmTemperature,
+			ApiKey:      conf.GeminiKey,
+		}
+		llm, err = gemini.NewGeminiLLM(llmConfig)
+	default:
+		err = fmt.Errorf("no valid llm provider found")
+	}
+
+	return llm, err
+}
+
+// discardRepository drops the extractions and the llm responses of the evaluation, so that they don't
+// end up in the stores of the server
+type discardRepository struct{}
+
+func (discardRepository) StoreRagResponse(services.RagResponse) error {
+	return nil
+}
+
+func (discardRepository) StoreTopicAndTags(services.Topic, services.Tags, string, string, string, []services.Assignment) error {
+	return nil
+}
+
+// Runs a labelled JSONL dataset through the topic and tag extraction and prints the precision and recall
+// of every topic and the accuracy of the tags. With -baseline it exits with status 1 when a case or a
+// metric got worse than in the saved report.
+func main() {
+	datasetPath := flag.String("dataset", "", "path of the JSONL dataset")
+	baselinePath := flag.String("baseline", "", "optional path of a saved report to compare against")
+	savePath := flag.String("save", "", "optional path where the report is saved, to be used as the next baseline")
+	model := flag.String("model", "", "model of the configured llm provider, empty uses the configured model")
+	tolerance := flag.Float64("tolerance", 0.02, "drop of a metric that is not reported as a regression")
+	concurrency := flag.Int("concurrency", 4, "number of cases evaluated in parallel")
+	flag.Parse()
+
+	if *datasetPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conf, _ := config.LoadConfig()
+	if *model != "" {
+		conf = conf.WithModelName(*model)
+	}
+
+	if err := prompts.Configure(conf.PromptsDir, conf.PromptVersions); err != nil {
+		log.Fatal(err)
+	}
+
+	cases, err := services.LoadExtractionCases(*datasetPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var baseline services.ExtractionEvalReport
+	if *baselinePath != "" {
+		if baseline, err = services.LoadExtractionEvalReport(*baselinePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	llm, err := getLlm(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The cases have no user, so the extractors don't need the user contexts
+	cache, _ := services.NewBadgerCacheService()
+	dataService := marketDataScraper.NewMarketDataScraperWithCache(cache, conf)
+	sessionService, _ := services.NewInMemorySession(conf.ConvMsgLimit)
+	topicExtractorService, _ := services.NewTopicExtractor(llm, nil, discardRepository{})
+	tagExtractorService, _ := services.NewTagExtractor(llm, dataService, nil, discardRepository{})
+	chatService, _ := services.NewChatService(
+		nil,
+		sessionService,
+		topicExtractorService,
+		tagExtractorService,
+		discardRepository{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		services.ChatServiceConf{},
+	)
+
+	evaluator, err := services.NewExtractionEvaluator(chatService, sessionService, *concurrency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Evaluating %d cases with %s", len(cases), llm.GetLlmName())
+	report := evaluator.Evaluate(cases)
+
+	printReport(report)
+
+	if *savePath != "" {
+		reportJson, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*savePath, reportJson, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Saved the report to %s", *savePath)
+	}
+
+	if *baselinePath != "" {
+		regressions := services.CompareExtractionReports(baseline, report, *tolerance)
+		printRegressions(regressions)
+		if len(regressions) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func printReport(report services.ExtractionEvalReport) {
+	fmt.Printf("Cases: %d, errors: %d\n", report.Cases, report.Errors)
+	fmt.Printf("Topic accuracy: %.3f, tag accuracy: %.3f\n\n", report.TopicAccuracy, report.TagAccuracy)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TOPIC\tEXPECTED\tEXTRACTED\tCORRECT\tPRECISION\tRECALL")
+	topics := make([]services.Topic, 0, len(report.Topics))
+	for topic := range report.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i] < topics[j] })
+	for _, topic := range topics {
+		metrics := report.Topics[topic]
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%.3f\t%.3f\n",
+			topic, metrics.Expected, metrics.Extracted, metrics.Correct, metrics.Precision, metrics.Recall)
+	}
+	writer.Flush()
+	fmt.Println()
+
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TAG\tLABELLED\tCORRECT\tACCURACY")
+	tags := make([]string, 0, len(report.Tags))
+	for tag := range report.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		metrics := report.Tags[tag]
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%.3f\n", tag, metrics.Labelled, metrics.Correct, metrics.Accuracy)
+	}
+	writer.Flush()
+	fmt.Println()
+
+	for _, result := range report.Results {
+		switch {
+		case result.Error != "":
+			fmt.Printf("ERROR %s: %s\n", result.ID, result.Error)
+		case !result.TopicCorrect:
+			fmt.Printf("WRONG TOPIC %s: expected %s, extracted %s\n", result.ID, result.ExpectedTopic, result.Topic)
+		case !result.TagsCorrect():
+			fmt.Printf("WRONG TAGS %s: %v, extracted %+v\n", result.ID, result.WrongTags, result.Tags)
+		}
+	}
+}
+
+func printRegressions(regressions []services.ExtractionRegression) {
+	if len(regressions) == 0 {
+		fmt.Println("\nNo regressions against the baseline")
+		return
+	}
+
+	fmt.Printf("\n%d regressions against the baseline\n", len(regressions))
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CASE\tMETRIC\tBASELINE\tCURRENT")
+	for _, regression := range regressions {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", regression.Case, regression.Metric, regression.Baseline, regression.Current)
+	}
+	writer.Flush()
+}
@@ -11,7 +11,9 @@ import (
 	"investbot/pkg/openAI"
 	"investbot/pkg/repositories"
 	"investbot/pkg/services"
+	"investbot/pkg/services/prompts"
 	"log"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
 	"github.com/labstack/echo/v4"
@@ -58,6 +60,10 @@ func main() {
 
 	conf, _ := config.LoadConfig()
 
+	if err := prompts.Configure(conf.PromptsDir, conf.PromptVersions); err != nil {
+		log.Fatal(err)
+	}
+
 	llm, err := getLlm(conf)
 	if err != nil {
 		log.Fatal(err)
@@ -67,8 +73,15 @@ func main() {
 		userContextRepository  services.UserContextRepository
 		topicAndTagsRepository services.TopicAndTagsRepository
 		ragResponsesRepository services.RagResponsesRepository
+		transactionRepository  services.PortfolioTransactionRepository
+		policyDecisionsRepo    services.PolicyDecisionRepository
+		observationsRepo       services.ExperimentObservationRepository
+		judgeScoresRepo        services.JudgeScoreRepository
+		feedbackRepo           services.FeedbackRepository
+		importPreviewsRepo     services.PortfolioImportPreviewRepository
 		sessionService         services.SessionService
 		mongoClient            *mongo.Client
+		badgerDB               *badger.DB
 	)
 
 	// Create Mongo client only once if needed
@@ -84,26 +97,59 @@ func main() {
 		}()
 	}
 
+	// Open badger only once, it's shared by the repositories and the session service
+	if conf.DatabaseProvider == config.BADGER_DB || conf.SessionStorageProvider == config.BADGER_STORAGE {
+		badgerDB, err = badger.Open(badger.DefaultOptions(conf.BadgerDbPath))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer badgerDB.Close()
+	}
+
 	// User context repository
 	switch conf.DatabaseProvider {
 	case config.BADGER_DB:
-		db, err := badger.Open(badger.DefaultOptions(conf.BadgerDbPath))
+		userContextRepository, err = repositories.NewUserContextRepository(badgerDB)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer db.Close()
 
-		userContextRepository, err = repositories.NewUserContextRepository(db)
+		topicAndTagsRepository, err = repositories.NewTopicAndTagsBagderRepo(badgerDB)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		topicAndTagsRepository, err = repositories.NewTopicAndTagsBagderRepo(db)
+		ragResponsesRepository, err = repositories.NewRagResponsesBadgerRepo(badgerDB)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		ragResponsesRepository, err = repositories.NewRagResponsesBadgerRepo(db)
+		transactionRepository, err = repositories.NewPortfolioTransactionsBadgerRepo(badgerDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		policyDecisionsRepo, err = repositories.NewPolicyDecisionsBadgerRepo(badgerDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		observationsRepo, err = repositories.NewExperimentObservationsBadgerRepo(badgerDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		judgeScoresRepo, err = repositories.NewJudgeScoresBadgerRepo(badgerDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		feedbackRepo, err = repositories.NewFeedbackBadgerRepo(badgerDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		importPreviewsRepo, err = repositories.NewPortfolioImportPreviewsBadgerRepo(badgerDB)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -135,6 +181,60 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		transactionRepository, err = repositories.NewPortfolioTransactionsMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.PortfolioTransactionsCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		policyDecisionsRepo, err = repositories.NewPolicyDecisionsMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.PolicyDecisionsCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		observationsRepo, err = repositories.NewExperimentObservationsMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.ExperimentObservationsCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		judgeScoresRepo, err = repositories.NewJudgeScoresMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.JudgeScoresCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		feedbackRepo, err = repositories.NewFeedbackMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.FeedbackCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		importPreviewsRepo, err = repositories.NewPortfolioImportPreviewsMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.ImportPreviewsCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Session service
@@ -153,66 +253,260 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+	case config.BADGER_STORAGE:
+		sessionService, err = services.NewBadgerSession(
+			badgerDB,
+			services.BadgerSessionServiceConf{
+				ConvMsgLimit: conf.ConvMsgLimit,
+				Ttl:          time.Duration(conf.SessionTtl) * time.Second,
+			},
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Retention janitor, the stores that don't support a rule are skipped
+	sessionRetention, _ := sessionService.(services.SessionRetention)
+	ragResponsesRetention, _ := ragResponsesRepository.(services.RagResponsesRetention)
+	topicAndTagsRetention, _ := topicAndTagsRepository.(services.TopicAndTagsRetention)
+	// Only the badger sessions expire by themselves, the other stores expire the sessions once the janitor is enabled
+	var sessionIdleTtl time.Duration
+	if conf.RetentionJanitorInterval > 0 {
+		sessionIdleTtl = time.Duration(conf.SessionTtl) * time.Second
+	}
+	retentionJanitor, _ := services.NewRetentionJanitor(
+		services.RetentionPolicy{
+			SessionIdleTtl:        sessionIdleTtl,
+			MaxSessionsPerUser:    conf.MaxSessionsPerUser,
+			RagResponsesRetention: time.Duration(conf.RagResponsesRetentionDays) * 24 * time.Hour,
+			TopicAndTagsRetention: time.Duration(conf.TopicAndTagsRetentionDays) * 24 * time.Hour,
+		},
+		sessionRetention,
+		ragResponsesRetention,
+		topicAndTagsRetention,
+	)
+	// The indexes follow the policy even when the janitor is disabled, so that disabled rules drop theirs
+	if err := retentionJanitor.EnsureIndexes(); err != nil {
+		log.Printf("Failed to create the retention indexes: %s", err.Error())
+	}
+	if conf.RetentionJanitorInterval > 0 {
+		retentionJanitor.Start(context.Background(), time.Duration(conf.RetentionJanitorInterval)*time.Second)
 	}
 
 	// Setup cache and data services
 	cache, _ := services.NewBadgerCacheService()
 	dataService := marketDataScraper.NewMarketDataScraperWithCache(cache, conf)
-	userContextService, _ := services.NewUserContextService(userContextRepository)
+	userContextService, _ := services.NewUserContextService(userContextRepository, dataService)
+	portfolioLedgerService, _ := services.NewPortfolioLedgerService(transactionRepository, userContextService, dataService)
+	portfolioImportService, _ := services.NewPortfolioImportService(dataService, userContextRepository, importPreviewsRepo)
+
+	contextBudget := services.NewContextBudget(llm.GetLlmName(), conf.LlmContextTokens)
+	conversationSummarizer, _ := services.NewConversationSummarizer(
+		sessionService,
+		llm,
+		ragResponsesRepository,
+		contextBudget,
+		conf.ConvMsgLimit,
+	)
+
+	var factChecker *services.FactChecker
+	if conf.FactCheck {
+		factChecker, _ = services.NewFactChecker(float64(conf.FactCheckTolerance))
+	}
+	var injectionClassifier *services.InjectionClassifier
+	if conf.InjectionThreshold > 0 {
+		injectionClassifier, _ = services.NewInjectionClassifier(float64(conf.InjectionThreshold))
+	}
 
 	// Set up rags
-	sectorRag, _ := services.NewSectorRag(llm, dataService, userContextService, ragResponsesRepository)
-	educationRag, _ := services.NewEducationRag(llm, userContextService, ragResponsesRepository)
-	industryRag, _ := services.NewIndustryRag(llm, dataService)
-	stockOverviewRag, _ := services.NewStockOverviewRag(llm, dataService, userContextService, ragResponsesRepository)
-	stockFinancialsRag, _ := services.NewStockFinancialsRag(llm, dataService, userContextService, ragResponsesRepository)
-	etfRag, _ := services.NewEtfRag(llm, dataService, userContextService, ragResponsesRepository)
-	newsRag, _ := services.NewMarketNewsRag(llm, dataService, userContextService, ragResponsesRepository)
+	newTopicToRagMap := func(llm services.Llm) map[services.Topic]services.Rag {
+		sectorRag, _ := services.NewSectorRag(llm, dataService, userContextService, ragResponsesRepository)
+		educationRag, _ := services.NewEducationRag(llm, userContextService, ragResponsesRepository)
+		industryRag, _ := services.NewIndustryRag(llm, dataService, userContextService, ragResponsesRepository)
+		stockOverviewRag, _ := services.NewStockOverviewRag(llm, dataService, userContextService, ragResponsesRepository)
+		stockFinancialsRag, _ := services.NewStockFinancialsRag(llm, dataService, userContextService, ragResponsesRepository)
+		etfRag, _ := services.NewEtfRag(llm, dataService, userContextService, ragResponsesRepository)
+		newsRag, _ := services.NewMarketNewsRag(llm, dataService, userContextService, ragResponsesRepository)
+		portfolioRag, _ := services.NewPortfolioRag(llm, dataService, userContextService, ragResponsesRepository)
+		superInvestorRag, _ := services.NewSuperInvestorRag(llm, dataService, userContextService, ragResponsesRepository)
+
+		topicToRagMap := map[services.Topic]services.Rag{
+			services.SECTORS:          sectorRag,
+			services.EDUCATION:        educationRag,
+			services.INDUSTRIES:       industryRag,
+			services.STOCK_OVERVIEW:   stockOverviewRag,
+			services.STOCK_FINANCIALS: stockFinancialsRag,
+			services.ETFS:             etfRag,
+			services.NEWS:             newsRag,
+			services.PORTFOLIO:        portfolioRag,
+			services.SUPER_INVESTORS:  superInvestorRag,
+		}
+
+		budget := services.NewContextBudget(llm.GetLlmName(), conf.LlmContextTokens)
+		for _, rag := range topicToRagMap {
+			if budgetSetter, ok := rag.(services.ContextBudgetSetter); ok {
+				budgetSetter.SetContextBudget(budget)
+			}
+			if factCheckerSetter, ok := rag.(services.FactCheckerSetter); ok && factChecker != nil {
+				factCheckerSetter.SetFactChecker(factChecker)
+			}
+			if classifierSetter, ok := rag.(services.InjectionClassifierSetter); ok && injectionClassifier != nil {
+				classifierSetter.SetInjectionClassifier(injectionClassifier)
+			}
+		}
+
+		return topicToRagMap
+	}
+	topicToRagMap := newTopicToRagMap(llm)
 	followUpQuestionsRag, _ := services.NewFollowUpQuestionsRag(llm, ragResponsesRepository)
 
-	topicToRagMap := map[services.Topic]services.Rag{
-		services.SECTORS:          sectorRag,
-		services.EDUCATION:        educationRag,
-		services.INDUSTRIES:       industryRag,
-		services.STOCK_OVERVIEW:   stockOverviewRag,
-		services.STOCK_FINANCIALS: stockFinancialsRag,
-		services.ETFS:             etfRag,
-		services.NEWS:             newsRag,
+	// Models that can be chosen to regenerate a response
+	modelToRagMap := make(map[string]map[services.Topic]services.Rag)
+	for _, model := range conf.RegenerateModels {
+		modelLlm, err := getLlm(conf.WithModelName(model))
+		if err != nil {
+			log.Fatal(err)
+		}
+		modelToRagMap[model] = newTopicToRagMap(modelLlm)
 	}
 
 	// Set up core services
+	compliancePolicy, err := services.LoadCompliancePolicy(conf.CompliancePolicyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	complianceGuard, err := services.NewComplianceGuard(compliancePolicy, userContextService, policyDecisionsRepo)
+	if err != nil {
+		log.Fatal(err)
+	}
 	topicExtractorService, _ := services.NewTopicExtractor(llm, userContextService, ragResponsesRepository)
 	tagExtractorService, _ := services.NewTagExtractor(llm, dataService, userContextService, ragResponsesRepository)
+
+	// Every variant of the experiments has its own components, built with the model of the variant
+	experiments, err := services.LoadExperiments(conf.ExperimentsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	buildVariant := func(experiment services.Experiment, variant services.ExperimentVariant) (services.VariantComponents, error) {
+		variantLlm := llm
+		if variant.Model != "" {
+			var err error
+			if variantLlm, err = getLlm(conf.WithModelName(variant.Model)); err != nil {
+				return services.VariantComponents{}, err
+			}
+		}
+
+		var components services.VariantComponents
+		switch experiment.Target {
+		case services.TopicExtractorTarget:
+			components.TopicExtractor, _ = services.NewTopicExtractor(variantLlm, userContextService, ragResponsesRepository)
+		case services.TagExtractorTarget:
+			components.TagExtractor, _ = services.NewTagExtractor(variantLlm, dataService, userContextService, ragResponsesRepository)
+		case services.RagTarget:
+			components.Rags = newTopicToRagMap(variantLlm)
+		case services.FollowUpQuestionsTarget:
+			components.FollowUpQuestions, _ = services.NewFollowUpQuestionsRag(variantLlm, ragResponsesRepository)
+		}
+		return components, nil
+	}
+	experimentRouter, err := services.NewExperimentRouter(experiments, buildVariant, observationsRepo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Answer judge, it grades samples of the stored rag responses with the judge model
+	ragResponsesReader, _ := ragResponsesRepository.(services.RagResponsesReader)
+	judgeLlm := llm
+	if conf.JudgeModel != "" {
+		if judgeLlm, err = getLlm(conf.WithModelName(conf.JudgeModel)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	answerJudge, err := services.NewAnswerJudge(
+		judgeLlm,
+		ragResponsesReader,
+		judgeScoresRepo,
+		services.AnswerJudgeConf{
+			SampleSize: conf.JudgeSampleSize,
+			Lookback:   time.Duration(conf.JudgeLookbackHours) * time.Hour,
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if conf.JudgeInterval > 0 {
+		answerJudge.Start(context.Background(), time.Duration(conf.JudgeInterval)*time.Second)
+	}
+
+	// The experiment reports compare the variants by the feedback of the users
+	feedbackService, _ := services.NewFeedbackService(sessionService, feedbackRepo)
+	experimentRouter.SetVariantFeedback(feedbackService)
+
+	topicAndTagsReader, _ := topicAndTagsRepository.(services.TopicAndTagsReader)
+	topicAnalyticsService, _ := services.NewTopicAnalyticsService(topicAndTagsReader)
+
 	chatService, _ := services.NewChatService(
 		topicToRagMap,
 		sessionService,
 		topicExtractorService,
 		tagExtractorService,
 		topicAndTagsRepository,
+		conversationSummarizer,
+		modelToRagMap,
+		cache,
+		complianceGuard,
+		experimentRouter,
+		services.ChatServiceConf{RegenerateOnFactCheckMismatch: conf.FactCheckRegenerate},
+	)
+	followUpQuestionsService, _ := services.NewFollowUpQuestionsService(
+		sessionService,
+		followUpQuestionsRag,
+		experimentRouter,
+		userContextService,
 	)
-	followUpQuestionsService, _ := services.NewFollowUpQuestionsService(sessionService, followUpQuestionsRag)
-	faqService, _ := services.NewFaqService(conf.FaqLimit)
+	sessionManagementService, _ := services.NewSessionManagementService(sessionService, llm, ragResponsesRepository)
+	faqService, _ := services.NewFaqService(topicAndTagsReader, userContextService, services.FaqConf{
+		Limit:             conf.FaqLimit,
+		TrendingLimit:     conf.FaqTrendingLimit,
+		TrendingLookback:  time.Duration(conf.FaqTrendingLookbackHours) * time.Hour,
+		TrendingMinAskers: conf.FaqTrendingMinAskers,
+	})
 	tickerService, _ := services.NewTickerService(dataService)
 	etfService, _ := services.NewEtfService(dataService)
 	superInvestorService, _ := services.NewSuperInvestorService(dataService)
 
 	// Set up rest api handlers
 	chatHandler, _ := restHandlers.NewChatHandler(chatService)
-	sessionHandler, _ := restHandlers.NewSessionHandler(sessionService)
+	sessionHandler, _ := restHandlers.NewSessionHandler(sessionManagementService)
 	followUpQuestionsHandler, _ := restHandlers.NewFollowUpQuestionsHandler(followUpQuestionsService, conf.FollowUpQuestionsNum)
 	faqHandler, _ := restHandlers.NewFaqHandler(faqService)
 	tickerHandler, _ := restHandlers.NewTickerHandler(tickerService)
 	etfHandler, _ := restHandlers.NewEtfHandler(etfService)
 	superInvestorHandler, _ := restHandlers.NewSuperInvestorHandler(superInvestorService)
 	sectorHandler, _ := restHandlers.NewSectorHandler(dataService)
+	industryHandler, _ := restHandlers.NewIndustryHandler(dataService)
 	topicHandler, _ := restHandlers.NewTopicHandler()
 	userContextHandler, _ := restHandlers.NewUserContextHandler(userContextService)
+	portfolioHandler, _ := restHandlers.NewPortfolioHandler(portfolioLedgerService)
+	portfolioImportHandler, _ := restHandlers.NewPortfolioImportHandler(portfolioImportService)
+	experimentHandler, _ := restHandlers.NewExperimentHandler(experimentRouter)
+	judgeHandler, _ := restHandlers.NewJudgeHandler(answerJudge)
+	feedbackHandler, _ := restHandlers.NewFeedbackHandler(feedbackService)
+	topicAnalyticsHandler, _ := restHandlers.NewTopicAnalyticsHandler(topicAnalyticsService)
 
 	// Set up api routes
 	e.POST("/chat", chatHandler.ChatCompletion)
 	e.POST("/chat/extract_topic_and_tags", chatHandler.ExtractTopicAndTags)
+	e.POST("/chat/regenerate", chatHandler.RegenerateResponse)
+	e.POST("/chat/edit", chatHandler.EditMessage)
 	e.POST("/session", sessionHandler.CreateNewSession)
 	e.GET("/session/:session_id", sessionHandler.GetSession)
+	e.GET("/sessions", sessionHandler.ListSessions)
+	e.PUT("/session/:session_id/title", sessionHandler.RenameSession)
+	e.PUT("/session/:session_id/archive", sessionHandler.ArchiveSession)
+	e.POST("/session/:session_id/fork", sessionHandler.ForkSession)
+	e.DELETE("/session/:session_id", sessionHandler.DeleteSession)
 	e.POST("/follow_up_questions", followUpQuestionsHandler.GenerateFollowUpQuestions)
 	e.GET("/faq", faqHandler.GetFaq)
 	e.GET("/tickers", tickerHandler.GetTickers)
@@ -221,10 +515,32 @@ func main() {
 	e.GET("/super_investors/portfolio/:super_investor", superInvestorHandler.GetSuperInvestorPortfolio)
 	e.GET("/sectors", sectorHandler.GetSectors)
 	e.GET("/sectors/stocks/:sector", sectorHandler.GetSectorStocks)
+	e.GET("/industries", industryHandler.GetIndustries)
+	e.GET("/industries/stocks/:industry", industryHandler.GetIndustryStocks)
 	e.GET("/topics", topicHandler.GetTopics)
 	e.POST("/user_context", userContextHandler.CreateUserContext)
 	e.PUT("/user_context", userContextHandler.UpdateUserContext)
 	e.GET("/user_context/:user_id", userContextHandler.GetUserContext)
+	e.GET("/user_context/import/profiles", portfolioImportHandler.GetProfiles)
+	e.GET("/user_context/risk_questionnaire", userContextHandler.GetRiskQuestionnaire)
+	e.POST("/user_context/:user_id/risk_questionnaire", userContextHandler.SubmitRiskQuestionnaire)
+	e.POST("/user_context/:user_id/import", portfolioImportHandler.PreviewImport)
+	e.POST("/user_context/:user_id/import/:import_id/commit", portfolioImportHandler.CommitImport)
+	e.GET("/portfolio/:user_id", portfolioHandler.GetLedger)
+	e.GET("/portfolio/:user_id/transactions", portfolioHandler.GetTransactions)
+	e.POST("/portfolio/:user_id/transactions", portfolioHandler.CreateTransaction)
+	e.GET("/portfolio/:user_id/transactions/:transaction_id", portfolioHandler.GetTransaction)
+	e.PUT("/portfolio/:user_id/transactions/:transaction_id", portfolioHandler.UpdateTransaction)
+	e.DELETE("/portfolio/:user_id/transactions/:transaction_id", portfolioHandler.DeleteTransaction)
+	e.GET("/experiments", experimentHandler.GetExperiments)
+	e.GET("/experiments/:experiment/report", experimentHandler.GetExperimentReport)
+	e.POST("/evaluations/judge/run", judgeHandler.RunJudge)
+	e.GET("/evaluations/judge/report", judgeHandler.GetJudgeReport)
+	e.POST("/feedback", feedbackHandler.SubmitFeedback)
+	e.GET("/feedback/report", feedbackHandler.GetFeedbackReport)
+	e.GET("/analytics/topics", topicAnalyticsHandler.GetTopicDistribution)
+	e.GET("/analytics/mentions", topicAnalyticsHandler.GetMostMentioned)
+	e.GET("/analytics/users/:user_id/topics", topicAnalyticsHandler.GetUserTopicHistory)
 
 	e.Logger.Fatal(e.Start(":1323"))
 }
@@ -48,6 +48,8 @@ func main() {
 	getMarketNewsTool, _ := tools.NewGetMarketNewsTool(dataService)
 	getSectorsTool, _ := tools.NewGetSectorsTool(dataService)
 	getSectorStocksTool, _ := tools.NewGetSectorStocksTool(dataService)
+	getIndustriesTool, _ := tools.NewGetIndustriesTool(dataService)
+	getIndustryStocksTool, _ := tools.NewGetIndustryStocksTool(dataService)
 	getStockOverviewTool, _ := tools.NewGetStockOverviewTool(dataService)
 	getStockFinancialsTool, _ := tools.NewGetStockFinancialsTool(dataService)
 
@@ -92,6 +94,16 @@ func main() {
 		mcp.NewStructuredToolHandler(getSectorStocksTool.HandleGetSectorStocks),
 	)
 
+	mcpServer.AddTool(
+		getIndustriesTool.GetTool(),
+		mcp.NewStructuredToolHandler(getIndustriesTool.HandleGetIndustries),
+	)
+
+	mcpServer.AddTool(
+		getIndustryStocksTool.GetTool(),
+		mcp.NewStructuredToolHandler(getIndustryStocksTool.HandleGetIndustryStocks),
+	)
+
 	mcpServer.AddTool(
 		getStockOverviewTool.GetTool(),
 		mcp.NewStructuredToolHandler(getStockOverviewTool.HandleGetStockOverview),
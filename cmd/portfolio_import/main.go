@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"investbot/pkg/config"
+	"investbot/pkg/domain"
+	"investbot/pkg/marketDataScraper"
+	"investbot/pkg/repositories"
+	"investbot/pkg/services"
+	"log"
+	"os"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// columnMapping uses the same json format as the mapping field of the import endpoint
+type columnMapping struct {
+	Symbol      []string `json:"symbol"`
+	Name        []string `json:"name"`
+	Quantity    []string `json:"quantity"`
+	MarketValue []string `json:"market_value"`
+	Price       []string `json:"price"`
+	AssetClass  []string `json:"asset_class"`
+}
+
+// Imports a broker csv export into the portfolio of a user context.
+// Without -commit only the preview is printed.
+func main() {
+	userID := flag.String("user", "", "id of the user whose portfolio is imported")
+	filePath := flag.String("file", "", "path of the csv export")
+	profile := flag.String("profile", services.GenericImportProfile, "broker profile used to map the csv columns")
+	mappingPath := flag.String("mapping", "", "optional path of a json file with a custom column mapping")
+	commit := flag.Bool("commit", false, "write the imported holdings to the user context")
+	flag.Parse()
+
+	if *userID == "" || *filePath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conf, _ := config.LoadConfig()
+
+	var mapping *domain.PortfolioImportMapping
+	if *mappingPath != "" {
+		mappingBytes, err := os.ReadFile(*mappingPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := columnMapping{}
+		if err := json.Unmarshal(mappingBytes, &m); err != nil {
+			log.Fatal(err)
+		}
+		mapping = &domain.PortfolioImportMapping{
+			Symbol:      m.Symbol,
+			Name:        m.Name,
+			Quantity:    m.Quantity,
+			MarketValue: m.MarketValue,
+			Price:       m.Price,
+			AssetClass:  m.AssetClass,
+		}
+	}
+
+	file, err := os.Open(*filePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	var (
+		userContextRepository services.UserContextRepository
+		importPreviewsRepo    services.PortfolioImportPreviewRepository
+	)
+	switch conf.DatabaseProvider {
+	case config.BADGER_DB:
+		db, err := badger.Open(badger.DefaultOptions(conf.BadgerDbPath))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		userContextRepository, err = repositories.NewUserContextRepository(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		importPreviewsRepo, err = repositories.NewPortfolioImportPreviewsBadgerRepo(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case config.MONGO_DB:
+		serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+		mongoClient, err := mongo.Connect(options.Client().ApplyURI(conf.MongoDBConf.Uri).SetServerAPIOptions(serverAPI))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mongoClient.Disconnect(context.TODO())
+
+		userContextRepository, err = repositories.NewUserContextMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.UserContextColletionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		importPreviewsRepo, err = repositories.NewPortfolioImportPreviewsMongoRepo(
+			mongoClient,
+			conf.MongoDBConf.DBName,
+			conf.MongoDBConf.ImportPreviewsCollectionName,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("no valid database provider found")
+	}
+
+	cache, _ := services.NewBadgerCacheService()
+	dataService := marketDataScraper.NewMarketDataScraperWithCache(cache, conf)
+	importService, _ := services.NewPortfolioImportService(dataService, userContextRepository, importPreviewsRepo)
+
+	preview, err := importService.Preview(*userID, *profile, mapping, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(preview); err != nil {
+		log.Fatal(err)
+	}
+
+	if !*commit {
+		log.Printf("Preview only, run again with -commit to import %d holdings", len(preview.Holdings))
+		return
+	}
+
+	if _, err := importService.CommitImport(*userID, preview.ImportID); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Imported %d holdings into the portfolio of user %s", len(preview.Holdings), *userID)
+}
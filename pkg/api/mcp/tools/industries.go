@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"investbot/pkg/domain"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type IndustrySchema struct {
+	Name             string  `json:"name" jsonschema_description:"Industry name"`
+	UrlName          string  `json:"url_name" jsonschema_description:"The identifier of the industry for getIndustryStocks"`
+	NumberOfStocks   int     `json:"number_of_stocks" jsonschema_description:"Number of stocks in the industry"`
+	MarketCap        float32 `json:"market_cap" jsonschema_description:"Market cap of the industry"`
+	DividendYieldPct float32 `json:"dividend_yield_pct" jsonschema_description:"Dividend yield percentage of the industry"`
+	PeRatio          float32 `json:"pe_ratio" jsonschema_description:"PE ratio of the industry"`
+	ProfitMarginPct  float32 `json:"profit_margin_pct" jsonschema_description:"Profit margin percentage of the industry"`
+	OneYearChangePct float32 `json:"one_year_change_pct" jsonschema_description:"One year price change percentage of the industry"`
+}
+
+type IndustryStockSchema struct {
+	Symbol      string  `json:"symbol" jsonschema_description:"Stock symbol"`
+	CompanyName string  `json:"company_name" jsonschema_description:"Company name of the stock"`
+	MarketCap   float32 `json:"market_cap" jsonschema_description:"Market cap of the stock"`
+}
+
+type IndustriesService interface {
+	GetIndustryStocks(industry string) ([]domain.IndustryStock, error)
+	GetIndustries() ([]domain.Industry, error)
+}
+
+type GetIndustriesRequest struct {
+	// No input parameters required
+}
+
+type GetIndustriesResponse struct {
+	Industries []IndustrySchema `json:"industries" jsonschema_description:"A list with the industries"`
+}
+
+type GetIndustriesTool struct {
+	industriesService IndustriesService
+}
+
+func NewGetIndustriesTool(industriesService IndustriesService) (*GetIndustriesTool, error) {
+	return &GetIndustriesTool{
+		industriesService: industriesService,
+	}, nil
+}
+
+func (t *GetIndustriesTool) HandleGetIndustries(ctx context.Context, req mcp.CallToolRequest, args GetIndustriesRequest) (GetIndustriesResponse, error) {
+	industries, err := t.industriesService.GetIndustries()
+	if err != nil {
+		return GetIndustriesResponse{}, err
+	}
+
+	response := GetIndustriesResponse{Industries: make([]IndustrySchema, 0, len(industries))}
+
+	for _, industry := range industries {
+		response.Industries = append(response.Industries, IndustrySchema{
+			Name:             industry.Name,
+			UrlName:          industry.UrlName,
+			NumberOfStocks:   industry.NumberOfStocks,
+			MarketCap:        industry.MarketCap,
+			DividendYieldPct: industry.DividendYieldPct,
+			PeRatio:          industry.PeRatio,
+			ProfitMarginPct:  industry.ProfitMarginPct,
+			OneYearChangePct: industry.OneYearChangePct,
+		})
+	}
+
+	return response, nil
+}
+
+func (t *GetIndustriesTool) GetTool() mcp.Tool {
+	return mcp.NewTool("getIndustries",
+		mcp.WithDescription("Get all stock industries"),
+		mcp.WithInputSchema[GetIndustriesRequest](),
+		mcp.WithOutputSchema[GetIndustriesResponse](),
+	)
+}
+
+type GetIndustryStocksRequest struct {
+	IndustryUrlName string `json:"url_name" jsonschema_description:"The url name of the industry to get the stocks for"`
+	Limit           int    `json:"limit,omitempty" jsonschema_description:"Maximum results" jsonschema:"minimum=1,default=100"`
+}
+
+type GetIndustryStocksResponse struct {
+	IndustryStocks []IndustryStockSchema `json:"industry_stocks" jsonschema_description:"The stocks of the industry"`
+}
+
+type GetIndustryStocksTool struct {
+	industriesService IndustriesService
+}
+
+func NewGetIndustryStocksTool(industriesService IndustriesService) (*GetIndustryStocksTool, error) {
+	return &GetIndustryStocksTool{industriesService: industriesService}, nil
+}
+
+func (t *GetIndustryStocksTool) HandleGetIndustryStocks(ctx context.Context, req mcp.CallToolRequest, args GetIndustryStocksRequest) (GetIndustryStocksResponse, error) {
+	if args.Limit == 0 {
+		args.Limit = 100
+	}
+
+	industryStocks, err := t.industriesService.GetIndustryStocks(args.IndustryUrlName)
+	if err != nil {
+		return GetIndustryStocksResponse{}, err
+	}
+
+	response := GetIndustryStocksResponse{IndustryStocks: make([]IndustryStockSchema, 0, min(args.Limit, len(industryStocks)))}
+
+	for _, industryStock := range industryStocks[:min(args.Limit, len(industryStocks))] {
+		response.IndustryStocks = append(response.IndustryStocks, IndustryStockSchema{
+			Symbol:      industryStock.Symbol,
+			CompanyName: industryStock.CompanyName,
+			MarketCap:   industryStock.MarketCap,
+		})
+	}
+
+	return response, nil
+}
+
+func (t *GetIndustryStocksTool) GetTool() mcp.Tool {
+	return mcp.NewTool("getIndustryStocks",
+		mcp.WithDescription("Get the stocks of an industry"),
+		mcp.WithInputSchema[GetIndustryStocksRequest](),
+		mcp.WithOutputSchema[GetIndustryStocksResponse](),
+	)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	investbotErr "investbot/pkg/errors"
 	"investbot/pkg/services"
@@ -11,7 +12,9 @@ import (
 )
 
 type ChatService interface {
-	GenerateResponse(topic services.Topic, tags services.Tags, sessionId string, question string, responseChannel chan<- string) error
+	GenerateResponse(topic services.Topic, tags services.Tags, sessionId string, question string, responseChannel chan<- services.ChatEvent) error
+	RegenerateResponse(topic services.Topic, tags services.Tags, sessionId string, userID string, model string, responseChannel chan<- services.ChatEvent) error
+	EditMessage(topic services.Topic, tags services.Tags, sessionId string, userID string, messageId string, question string, responseChannel chan<- services.ChatEvent) error
 	ExtractTopicAndTags(question string, sessionId string, userID string) (services.Topic, services.Tags, error)
 }
 
@@ -24,14 +27,15 @@ func NewChatHandler(chatService ChatService) (*ChatHandler, error) {
 }
 
 type TopicTags struct {
-	SectorName      string   `json:"sector_name"`
-	IndustryName    string   `json:"industry_name"`
-	StockSymbols    []string `json:"stock_symbols"`
-	BalanceSheet    bool     `json:"balance_sheet"`
-	IncomeStatement bool     `json:"income_statement"`
-	CashFlow        bool     `json:"cash_flow"`
-	EtfSymbols      []string `json:"etf_symbols"`
-	UserID          string   `json:"user_id"`
+	SectorName        string   `json:"sector_name"`
+	IndustryName      string   `json:"industry_name"`
+	StockSymbols      []string `json:"stock_symbols"`
+	BalanceSheet      bool     `json:"balance_sheet"`
+	IncomeStatement   bool     `json:"income_statement"`
+	CashFlow          bool     `json:"cash_flow"`
+	EtfSymbols        []string `json:"etf_symbols"`
+	SuperInvestorName string   `json:"super_investor_name"`
+	UserID            string   `json:"user_id"`
 }
 
 type ChatRequest struct {
@@ -71,25 +75,62 @@ func (h *ChatHandler) ChatCompletion(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	tags := services.Tags{
-		SectorName:      chatRequest.Tags.SectorName,
-		IndustryName:    chatRequest.Tags.IndustryName,
-		StockSymbols:    chatRequest.Tags.StockSymbols,
-		BalanceSheet:    chatRequest.Tags.BalanceSheet,
-		IncomeStatement: chatRequest.Tags.IncomeStatement,
-		CashFlow:        chatRequest.Tags.CashFlow,
-		EtfSymbols:      chatRequest.Tags.EtfSymbols,
-		UserID:          chatRequest.Tags.UserID,
+	return h.streamResponse(c, func(responseChunkChannel chan<- services.ChatEvent) error {
+		return h.chatService.GenerateResponse(
+			services.Topic(chatRequest.Topic), chatRequest.Tags.toDomain(), chatRequest.SessionID, chatRequest.Question, responseChunkChannel,
+		)
+	})
+}
+
+func (t TopicTags) toDomain() services.Tags {
+	return services.Tags{
+		SectorName:        t.SectorName,
+		IndustryName:      t.IndustryName,
+		StockSymbols:      t.StockSymbols,
+		BalanceSheet:      t.BalanceSheet,
+		IncomeStatement:   t.IncomeStatement,
+		CashFlow:          t.CashFlow,
+		EtfSymbols:        t.EtfSymbols,
+		SuperInvestorName: t.SuperInvestorName,
+		UserID:            t.UserID,
+	}
+}
+
+func newTopicTags(tags services.Tags) TopicTags {
+	return TopicTags{
+		SectorName:        tags.SectorName,
+		IndustryName:      tags.IndustryName,
+		StockSymbols:      tags.StockSymbols,
+		BalanceSheet:      tags.BalanceSheet,
+		IncomeStatement:   tags.IncomeStatement,
+		CashFlow:          tags.CashFlow,
+		EtfSymbols:        tags.EtfSymbols,
+		SuperInvestorName: tags.SuperInvestorName,
+		UserID:            tags.UserID,
 	}
+}
 
+// SourcesEvent is the last line of a streamed response, after the chunks of the response
+type SourcesEvent struct {
+	Event   string       `json:"event"`
+	Sources []DataSource `json:"sources"`
+}
+
+// CorrectionEvent replaces the chunks streamed before it. It's sent when the figures of the response
+// didn't match the market data and the response is generated again.
+type CorrectionEvent struct {
+	Event string `json:"event"`
+}
+
+// streamResponse streams the chunks of the response that generate produces as json strings,
+// followed by the sources event
+func (h *ChatHandler) streamResponse(c echo.Context, generate func(responseChunkChannel chan<- services.ChatEvent) error) error {
 	enc := json.NewEncoder(c.Response())
-	responseChunkChannel := make(chan string)
+	responseChunkChannel := make(chan services.ChatEvent)
 	errorChannel := make(chan error, 1)
 
 	go func() {
-		if err := h.chatService.GenerateResponse(
-			services.Topic(chatRequest.Topic), tags, chatRequest.SessionID, chatRequest.Question, responseChunkChannel,
-		); err != nil {
+		if err := generate(responseChunkChannel); err != nil {
 			errorChannel <- err
 		}
 		close(errorChannel)
@@ -97,12 +138,20 @@ func (h *ChatHandler) ChatCompletion(c echo.Context) error {
 
 	for {
 		select {
-		case chunk, isOpen := <-responseChunkChannel:
+		case event, isOpen := <-responseChunkChannel:
 			if !isOpen {
 				// Channel closed, exit loop
 				return nil
 			}
-			if err = enc.Encode(chunk); err != nil {
+
+			var line any = event.Chunk
+			switch event.Type {
+			case services.SourcesEvent:
+				line = SourcesEvent{Event: string(services.SourcesEvent), Sources: newDataSources(event.Sources)}
+			case services.CorrectionEvent:
+				line = CorrectionEvent{Event: string(services.CorrectionEvent)}
+			}
+			if err := enc.Encode(line); err != nil {
 				return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 			}
 
@@ -111,20 +160,133 @@ func (h *ChatHandler) ChatCompletion(c echo.Context) error {
 
 		case err := <-errorChannel:
 			if err != nil {
-				switch e := err.(type) {
-				case *investbotErr.SessionNotFoundError:
-					return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
-				case *investbotErr.InvalidTopicError:
-					return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
-				default:
-					return c.JSON(http.StatusInternalServerError, map[string]string{"error": e.Error()})
-				}
-
+				return h.handleError(c, err)
 			}
 		}
 	}
 }
 
+func (h *ChatHandler) handleError(c echo.Context, err error) error {
+	switch e := err.(type) {
+	case *investbotErr.SessionNotFoundError:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
+	case *investbotErr.InvalidTopicError:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
+	case *investbotErr.PortfolioNotFoundError:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
+	}
+
+	if errors.As(err, &investbotErr.SessionForbiddenError{}) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	if errors.As(err, &investbotErr.SessionNotFoundError{}) ||
+		errors.As(err, &investbotErr.MessageNotFoundError{}) ||
+		errors.As(err, &investbotErr.InvalidSessionOperationError{}) ||
+		errors.As(err, &investbotErr.InvalidModelError{}) ||
+		errors.As(err, &investbotErr.UserIDRequiredError{}) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+type RegenerateResponseRequest struct {
+	SessionID string    `json:"session_id"`
+	UserID    string    `json:"user_id"`
+	Topic     string    `json:"topic"`
+	Tags      TopicTags `json:"topic_tags"`
+	// Model generates the new response instead of the default model, it must be one of the REGENERATE_MODELS
+	Model string `json:"model"`
+}
+
+func (r RegenerateResponseRequest) validate() error {
+	if r.Topic == "" {
+		return fmt.Errorf("topic field is required")
+	}
+
+	if r.SessionID == "" {
+		return fmt.Errorf("session_id field is required")
+	}
+
+	if r.UserID == "" {
+		return fmt.Errorf("user_id field is required")
+	}
+
+	return nil
+}
+
+func (h *ChatHandler) RegenerateResponse(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	request := new(RegenerateResponseRequest)
+	if err := c.Bind(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return h.streamResponse(c, func(responseChunkChannel chan<- services.ChatEvent) error {
+		return h.chatService.RegenerateResponse(
+			services.Topic(request.Topic), request.Tags.toDomain(), request.SessionID, request.UserID, request.Model, responseChunkChannel,
+		)
+	})
+}
+
+type EditMessageRequest struct {
+	SessionID string    `json:"session_id"`
+	UserID    string    `json:"user_id"`
+	MessageID string    `json:"message_id"`
+	Question  string    `json:"question"`
+	Topic     string    `json:"topic"`
+	Tags      TopicTags `json:"topic_tags"`
+}
+
+func (r EditMessageRequest) validate() error {
+	if r.Question == "" {
+		return fmt.Errorf("question field is required")
+	}
+
+	if r.Topic == "" {
+		return fmt.Errorf("topic field is required")
+	}
+
+	if r.SessionID == "" {
+		return fmt.Errorf("session_id field is required")
+	}
+
+	if r.MessageID == "" {
+		return fmt.Errorf("message_id field is required")
+	}
+
+	if r.UserID == "" {
+		return fmt.Errorf("user_id field is required")
+	}
+
+	return nil
+}
+
+func (h *ChatHandler) EditMessage(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	request := new(EditMessageRequest)
+	if err := c.Bind(request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return h.streamResponse(c, func(responseChunkChannel chan<- services.ChatEvent) error {
+		return h.chatService.EditMessage(
+			services.Topic(request.Topic), request.Tags.toDomain(), request.SessionID, request.UserID, request.MessageID, request.Question, responseChunkChannel,
+		)
+	})
+}
+
 type ExtractTopicAndTagsRequest struct {
 	Question  string `json:"question"`
 	SessionID string `json:"session_id"`
@@ -176,14 +338,15 @@ func (h *ChatHandler) ExtractTopicAndTags(c echo.Context) error {
 	response := ExtractTopicAndTagsResponse{
 		Topic: string(topic),
 		Tags: TopicTags{
-			SectorName:      tags.SectorName,
-			IndustryName:    tags.IndustryName,
-			StockSymbols:    tags.StockSymbols,
-			BalanceSheet:    tags.BalanceSheet,
-			IncomeStatement: tags.IncomeStatement,
-			CashFlow:        tags.CashFlow,
-			EtfSymbols:      tags.EtfSymbols,
-			UserID:          extractTopicAndTagsRequest.UserID,
+			SectorName:        tags.SectorName,
+			IndustryName:      tags.IndustryName,
+			StockSymbols:      tags.StockSymbols,
+			BalanceSheet:      tags.BalanceSheet,
+			IncomeStatement:   tags.IncomeStatement,
+			CashFlow:          tags.CashFlow,
+			EtfSymbols:        tags.EtfSymbols,
+			SuperInvestorName: tags.SuperInvestorName,
+			UserID:            extractTopicAndTagsRequest.UserID,
 		},
 	}
 
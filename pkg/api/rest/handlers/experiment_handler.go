@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	investbotErr "investbot/pkg/errors"
+	"investbot/pkg/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ExperimentService interface {
+	Experiments() []services.Experiment
+	Report(name string) (services.ExperimentReport, error)
+}
+
+type ExperimentHandler struct {
+	experimentService ExperimentService
+}
+
+type ExperimentVariant struct {
+	Name               string            `json:"name"`
+	Weight             int               `json:"weight"`
+	Model              string            `json:"model"`
+	PromptVersions     map[string]string `json:"prompt_versions"`
+	PromptTokenPrice   float64           `json:"prompt_token_price"`
+	ResponseTokenPrice float64           `json:"response_token_price"`
+}
+
+type Experiment struct {
+	Name     string              `json:"name"`
+	Target   string              `json:"target"`
+	Topic    string              `json:"topic"`
+	Unit     string              `json:"unit"`
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+type GetExperimentsResponse struct {
+	Experiments []Experiment `json:"experiments"`
+}
+
+type VariantReport struct {
+	Variant             string            `json:"variant"`
+	Model               string            `json:"model"`
+	PromptVersions      map[string]string `json:"prompt_versions"`
+	Observations        int               `json:"observations"`
+	SuccessRate         float64           `json:"success_rate"`
+	AvgLatencyMs        int64             `json:"avg_latency_ms"`
+	P95LatencyMs        int64             `json:"p95_latency_ms"`
+	AvgPromptTokens     float64           `json:"avg_prompt_tokens"`
+	AvgResponseTokens   float64           `json:"avg_response_tokens"`
+	AvgCost             float64           `json:"avg_cost"`
+	PositiveFeedback    int               `json:"positive_feedback"`
+	NegativeFeedback    int               `json:"negative_feedback"`
+	PositiveFeedbackPct float64           `json:"positive_feedback_pct"`
+}
+
+type GetExperimentReportResponse struct {
+	Experiment Experiment      `json:"experiment"`
+	Variants   []VariantReport `json:"variants"`
+}
+
+func NewExperimentHandler(experimentService ExperimentService) (*ExperimentHandler, error) {
+	return &ExperimentHandler{experimentService: experimentService}, nil
+}
+
+func newExperiment(e services.Experiment) Experiment {
+	variants := make([]ExperimentVariant, 0, len(e.Variants))
+	for _, variant := range e.Variants {
+		variants = append(variants, ExperimentVariant{
+			Name:               variant.Name,
+			Weight:             variant.Weight,
+			Model:              variant.Model,
+			PromptVersions:     newPromptVersions(variant.PromptVersions),
+			PromptTokenPrice:   variant.PromptTokenPrice,
+			ResponseTokenPrice: variant.ResponseTokenPrice,
+		})
+	}
+
+	return Experiment{
+		Name:     e.Name,
+		Target:   string(e.Target),
+		Topic:    string(e.Topic),
+		Unit:     string(e.Unit),
+		Variants: variants,
+	}
+}
+
+func newPromptVersions(versions map[string]string) map[string]string {
+	if versions == nil {
+		return map[string]string{}
+	}
+	return versions
+}
+
+func (h *ExperimentHandler) GetExperiments(c echo.Context) error {
+	experiments := h.experimentService.Experiments()
+
+	response := GetExperimentsResponse{Experiments: make([]Experiment, 0, len(experiments))}
+	for _, experiment := range experiments {
+		response.Experiments = append(response.Experiments, newExperiment(experiment))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *ExperimentHandler) GetExperimentReport(c echo.Context) error {
+	report, err := h.experimentService.Report(c.Param("experiment"))
+	if err != nil {
+		if errors.As(err, &investbotErr.ExperimentNotFoundError{}) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := GetExperimentReportResponse{
+		Experiment: newExperiment(report.Experiment),
+		Variants:   make([]VariantReport, 0, len(report.Variants)),
+	}
+	for _, variant := range report.Variants {
+		response.Variants = append(response.Variants, VariantReport{
+			Variant:             variant.Variant,
+			Model:               variant.Model,
+			PromptVersions:      newPromptVersions(variant.PromptVersions),
+			Observations:        variant.Observations,
+			SuccessRate:         variant.SuccessRate,
+			AvgLatencyMs:        variant.AvgLatency.Milliseconds(),
+			P95LatencyMs:        variant.P95Latency.Milliseconds(),
+			AvgPromptTokens:     variant.AvgPromptTokens,
+			AvgResponseTokens:   variant.AvgResponseTokens,
+			AvgCost:             variant.AvgCost,
+			PositiveFeedback:    variant.Feedback.Positive,
+			NegativeFeedback:    variant.Feedback.Negative,
+			PositiveFeedbackPct: variant.PositiveFeedbackPct,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 type FaqService interface {
-	GetFaqForTopic(topic services.FaqTopic) ([]string, error)
+	GetFaqForTopic(topic services.FaqTopic, userID string) ([]services.FaqQuestion, error)
 }
 
 type FaqHandler struct {
@@ -23,13 +23,14 @@ func NewFaqHandler(faqService FaqService) (*FaqHandler, error) {
 }
 
 type GetFaqResponse struct {
-	Faq []string `json:"faq"`
+	Faq      []string `json:"faq"`
+	Trending []string `json:"trending"` // The questions of the faq that were mined from the recent questions
 }
 
 func (h *FaqHandler) GetFaq(c echo.Context) error {
 	// Get topic from query parameter
 	topic := c.QueryParam("faq_topic")
-	faq, err := h.faqService.GetFaqForTopic(services.FaqTopic(topic))
+	faq, err := h.faqService.GetFaqForTopic(services.FaqTopic(topic), c.QueryParam("user_id"))
 
 	if err != nil {
 		switch e := err.(type) {
@@ -41,6 +42,12 @@ func (h *FaqHandler) GetFaq(c echo.Context) error {
 
 	}
 
-	response := GetFaqResponse{Faq: faq}
+	response := GetFaqResponse{Faq: make([]string, 0, len(faq)), Trending: make([]string, 0)}
+	for _, question := range faq {
+		response.Faq = append(response.Faq, question.Question)
+		if question.Trending {
+			response.Trending = append(response.Trending, question.Question)
+		}
+	}
 	return c.JSON(http.StatusOK, response)
 }
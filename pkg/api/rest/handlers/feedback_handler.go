@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	investbotErr "investbot/pkg/errors"
+	"investbot/pkg/services"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type FeedbackService interface {
+	SubmitFeedback(request services.FeedbackRequest) (services.Feedback, error)
+	Report(from time.Time, to time.Time) (services.FeedbackReport, error)
+}
+
+type FeedbackHandler struct {
+	feedbackService FeedbackService
+}
+
+func NewFeedbackHandler(feedbackService FeedbackService) (*FeedbackHandler, error) {
+	return &FeedbackHandler{feedbackService: feedbackService}, nil
+}
+
+type FeedbackRequest struct {
+	SessionID string `json:"session_id"`
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Rating    string `json:"rating"`
+	Category  string `json:"category"`
+	Comment   string `json:"comment"`
+}
+
+type FeedbackResponse struct {
+	ID            string    `json:"id"`
+	SessionID     string    `json:"session_id"`
+	MessageID     string    `json:"message_id"`
+	UserID        string    `json:"user_id"`
+	Rating        string    `json:"rating"`
+	Category      string    `json:"category,omitempty"`
+	Comment       string    `json:"comment,omitempty"`
+	Question      string    `json:"question"`
+	Topic         string    `json:"topic"`
+	Model         string    `json:"model"`
+	PromptName    string    `json:"prompt_name"`
+	PromptVersion string    `json:"prompt_version"`
+	RagResponseID string    `json:"rag_response_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type FeedbackReportGroup struct {
+	Topic         string         `json:"topic"`
+	Model         string         `json:"model"`
+	PromptName    string         `json:"prompt_name"`
+	PromptVersion string         `json:"prompt_version"`
+	Positive      int            `json:"positive"`
+	Negative      int            `json:"negative"`
+	PositiveRatio float64        `json:"positive_ratio"`
+	Categories    map[string]int `json:"categories"`
+}
+
+type FeedbackReportResponse struct {
+	From   time.Time             `json:"from"`
+	To     time.Time             `json:"to"`
+	Groups []FeedbackReportGroup `json:"groups"`
+}
+
+func newFeedbackResponse(feedback services.Feedback) FeedbackResponse {
+	return FeedbackResponse{
+		ID:            feedback.ID,
+		SessionID:     feedback.SessionID,
+		MessageID:     feedback.MessageID,
+		UserID:        feedback.UserID,
+		Rating:        string(feedback.Rating),
+		Category:      string(feedback.Category),
+		Comment:       feedback.Comment,
+		Question:      feedback.Question,
+		Topic:         string(feedback.Topic),
+		Model:         feedback.Model,
+		PromptName:    feedback.PromptName,
+		PromptVersion: feedback.PromptVersion,
+		RagResponseID: feedback.RagResponseID,
+		CreatedAt:     feedback.CreatedAt,
+	}
+}
+
+func (h *FeedbackHandler) handleError(c echo.Context, err error) error {
+	invalidFeedbackError := investbotErr.InvalidFeedbackError{}
+	if errors.As(err, &invalidFeedbackError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	notFoundError := investbotErr.SessionNotFoundError{}
+	if errors.As(err, &notFoundError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	messageNotFoundError := investbotErr.MessageNotFoundError{}
+	if errors.As(err, &messageNotFoundError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	forbiddenError := investbotErr.SessionForbiddenError{}
+	if errors.As(err, &forbiddenError) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// SubmitFeedback records the rating of a response, a new feedback on the same message replaces the previous one
+func (h *FeedbackHandler) SubmitFeedback(c echo.Context) error {
+	request := FeedbackRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	feedback, err := h.feedbackService.SubmitFeedback(services.FeedbackRequest{
+		SessionID: request.SessionID,
+		MessageID: request.MessageID,
+		UserID:    request.UserID,
+		Rating:    services.FeedbackRating(request.Rating),
+		Category:  services.FeedbackCategory(request.Category),
+		Comment:   request.Comment,
+	})
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, newFeedbackResponse(feedback))
+}
+
+func (h *FeedbackHandler) GetFeedbackReport(c echo.Context) error {
+	from, to, err := parseTimeRange(c, defaultReportDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	report, err := h.feedbackService.Report(from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := FeedbackReportResponse{
+		From:   report.From,
+		To:     report.To,
+		Groups: make([]FeedbackReportGroup, 0, len(report.Groups)),
+	}
+	for _, group := range report.Groups {
+		categories := make(map[string]int, len(group.Categories))
+		for category, count := range group.Categories {
+			categories[string(category)] = count
+		}
+		response.Groups = append(response.Groups, FeedbackReportGroup{
+			Topic:         string(group.Topic),
+			Model:         group.Model,
+			PromptName:    group.PromptName,
+			PromptVersion: group.PromptVersion,
+			Positive:      group.Positive,
+			Negative:      group.Negative,
+			PositiveRatio: group.PositiveRatio,
+			Categories:    categories,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
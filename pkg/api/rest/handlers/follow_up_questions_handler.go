@@ -3,13 +3,14 @@ package handlers
 import (
 	"fmt"
 	"investbot/pkg/errors"
+	"investbot/pkg/services"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 )
 
 type FollowUpService interface {
-	GenerateFollowUpQuestions(sessionId string, followUpQuestionsNum int) ([]string, error)
+	GenerateFollowUpQuestions(sessionId string, followUpQuestionsNum int) ([]services.FollowUpQuestion, error)
 }
 
 type FollowUpQuestionsHandler struct {
@@ -17,8 +18,16 @@ type FollowUpQuestionsHandler struct {
 	followUpQuestionsNum     int
 }
 
+// FollowUpSuggestion is a follow up question with the topic and tags that can be sent as they are to POST /chat
+type FollowUpSuggestion struct {
+	Question string    `json:"question"`
+	Topic    string    `json:"topic"`
+	Tags     TopicTags `json:"topic_tags"`
+}
+
 type FollowUpQuestionsResponse struct {
-	FollowUpQuestions []string `json:"follow_up_questions"`
+	FollowUpQuestions []string             `json:"follow_up_questions"`
+	Suggestions       []FollowUpSuggestion `json:"suggestions"`
 }
 
 type FollowUpQuestionsRequest struct {
@@ -68,6 +77,17 @@ func (h *FollowUpQuestionsHandler) GenerateFollowUpQuestions(c echo.Context) err
 		}
 	}
 
-	response := FollowUpQuestionsResponse{FollowUpQuestions: followUpQuestions}
+	response := FollowUpQuestionsResponse{
+		FollowUpQuestions: make([]string, 0, len(followUpQuestions)),
+		Suggestions:       make([]FollowUpSuggestion, 0, len(followUpQuestions)),
+	}
+	for _, followUpQuestion := range followUpQuestions {
+		response.FollowUpQuestions = append(response.FollowUpQuestions, followUpQuestion.Question)
+		response.Suggestions = append(response.Suggestions, FollowUpSuggestion{
+			Question: followUpQuestion.Question,
+			Topic:    string(followUpQuestion.Topic),
+			Tags:     newTopicTags(followUpQuestion.Tags),
+		})
+	}
 	return c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"investbot/pkg/domain"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type IndustryService interface {
+	GetIndustryStocks(industry string) ([]domain.IndustryStock, error)
+	GetIndustries() ([]domain.Industry, error)
+}
+
+type IndustryHandler struct {
+	industryService IndustryService
+}
+
+type Industry struct {
+	Name             string  `json:"name"`
+	UrlName          string  `json:"url_name"`
+	NumberOfStocks   int     `json:"number_of_stocks"`
+	MarketCap        float32 `json:"market_cap"`
+	DividendYieldPct float32 `json:"dividend_yield_pct"`
+	PeRatio          float32 `json:"pe_ratio"`
+	ProfitMarginPct  float32 `json:"profit_margin_pct"`
+	OneYearChangePct float32 `json:"one_year_change_pct"`
+}
+
+type IndustryStock struct {
+	Symbol      string  `json:"symbol"`
+	CompanyName string  `json:"company_name"`
+	MarketCap   float32 `json:"market_cap"`
+}
+
+type GetIndustriesResponse struct {
+	Industries []Industry `json:"industries"`
+}
+
+type GetIndustryStocksResponse struct {
+	IndustryStocks []IndustryStock `json:"industry_stocks"`
+}
+
+func NewIndustryHandler(industryService IndustryService) (*IndustryHandler, error) {
+	return &IndustryHandler{industryService: industryService}, nil
+}
+
+func (h *IndustryHandler) GetIndustries(c echo.Context) error {
+	industries, err := h.industryService.GetIndustries()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := GetIndustriesResponse{
+		Industries: make([]Industry, 0, len(industries)),
+	}
+
+	for _, industry := range industries {
+		response.Industries = append(
+			response.Industries,
+			Industry{
+				Name:             industry.Name,
+				UrlName:          industry.UrlName,
+				NumberOfStocks:   industry.NumberOfStocks,
+				MarketCap:        industry.MarketCap,
+				DividendYieldPct: industry.DividendYieldPct,
+				PeRatio:          industry.PeRatio,
+				ProfitMarginPct:  industry.ProfitMarginPct,
+				OneYearChangePct: industry.OneYearChangePct,
+			},
+		)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *IndustryHandler) GetIndustryStocks(c echo.Context) error {
+	industry := c.Param("industry")
+	industryStocks, err := h.industryService.GetIndustryStocks(industry)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := GetIndustryStocksResponse{
+		IndustryStocks: make([]IndustryStock, 0, len(industryStocks)),
+	}
+
+	for _, stock := range industryStocks {
+		response.IndustryStocks = append(
+			response.IndustryStocks,
+			IndustryStock{
+				Symbol:      stock.Symbol,
+				CompanyName: stock.CompanyName,
+				MarketCap:   stock.MarketCap,
+			},
+		)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
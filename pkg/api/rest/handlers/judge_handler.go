@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"fmt"
+	"investbot/pkg/services"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultReportDays = 7
+
+type JudgeService interface {
+	Run() (services.JudgeRunReport, error)
+	Report(from time.Time, to time.Time) (services.JudgeReport, error)
+}
+
+type JudgeHandler struct {
+	judgeService JudgeService
+}
+
+type JudgeRunResponse struct {
+	StartedAt  time.Time `json:"started_at"`
+	Candidates int       `json:"candidates"`
+	Graded     int       `json:"graded"`
+	Failed     int       `json:"failed"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+type JudgeReportGroup struct {
+	Topic         string             `json:"topic"`
+	ModelName     string             `json:"model_name"`
+	PromptName    string             `json:"prompt_name"`
+	PromptVersion string             `json:"prompt_version"`
+	Responses     int                `json:"responses"`
+	Averages      map[string]float64 `json:"averages"`
+	Overall       float64            `json:"overall"`
+}
+
+type JudgeReportResponse struct {
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Groups []JudgeReportGroup `json:"groups"`
+}
+
+func NewJudgeHandler(judgeService JudgeService) (*JudgeHandler, error) {
+	return &JudgeHandler{judgeService: judgeService}, nil
+}
+
+// parseTime parses a date like 2025-01-31 or an RFC 3339 time
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(transactionDateLayout, value)
+}
+
+// parseTimeRange returns the range of the from and to query params. to defaults to now and from to
+// defaultDays before to.
+func parseTimeRange(c echo.Context, defaultDays int) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toQueryParam := c.QueryParam("to"); toQueryParam != "" {
+		var err error
+		if to, err = parseTime(toQueryParam); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to query param must be a date or an RFC 3339 time")
+		}
+	}
+
+	from := to.AddDate(0, 0, -defaultDays)
+	if fromQueryParam := c.QueryParam("from"); fromQueryParam != "" {
+		var err error
+		if from, err = parseTime(fromQueryParam); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from query param must be a date or an RFC 3339 time")
+		}
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// RunJudge grades a sample of the recent rag responses, it returns once the whole sample is graded
+func (h *JudgeHandler) RunJudge(c echo.Context) error {
+	report, err := h.judgeService.Run()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, JudgeRunResponse{
+		StartedAt:  report.StartedAt,
+		Candidates: report.Candidates,
+		Graded:     report.Graded,
+		Failed:     report.Failed,
+		DurationMs: report.Duration.Milliseconds(),
+	})
+}
+
+func (h *JudgeHandler) GetJudgeReport(c echo.Context) error {
+	from, to, err := parseTimeRange(c, defaultReportDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	report, err := h.judgeService.Report(from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	response := JudgeReportResponse{
+		From:   report.From,
+		To:     report.To,
+		Groups: make([]JudgeReportGroup, 0, len(report.Groups)),
+	}
+	for _, group := range report.Groups {
+		averages := make(map[string]float64, len(group.Averages))
+		for criterion, average := range group.Averages {
+			averages[string(criterion)] = average
+		}
+		response.Groups = append(response.Groups, JudgeReportGroup{
+			Topic:         string(group.Topic),
+			ModelName:     group.ModelName,
+			PromptName:    group.PromptName,
+			PromptVersion: group.PromptVersion,
+			Responses:     group.Responses,
+			Averages:      averages,
+			Overall:       group.Overall,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
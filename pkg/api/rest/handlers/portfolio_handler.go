@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PortfolioLedgerService interface {
+	GetTransactions(userID string) ([]domain.PortfolioTransaction, error)
+	GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error)
+	AddTransaction(transaction domain.PortfolioTransaction) (domain.PortfolioTransaction, error)
+	UpdateTransaction(transaction domain.PortfolioTransaction) (domain.PortfolioTransaction, error)
+	DeleteTransaction(userID string, transactionID string) error
+	GetLedger(userID string) (domain.PortfolioLedger, error)
+}
+
+type PortfolioHandler struct {
+	ledgerService PortfolioLedgerService
+}
+
+func NewPortfolioHandler(ledgerService PortfolioLedgerService) (*PortfolioHandler, error) {
+	return &PortfolioHandler{ledgerService: ledgerService}, nil
+}
+
+const transactionDateLayout = "2006-01-02"
+
+type PortfolioTransaction struct {
+	TransactionID string  `json:"transaction_id"`
+	Type          string  `json:"type"`
+	AssetClass    string  `json:"asset_class"`
+	Symbol        string  `json:"symbol"`
+	Quantity      float64 `json:"quantity"`
+	Price         float64 `json:"price"`
+	Amount        float64 `json:"amount"`
+	Fees          float64 `json:"fees"`
+	SplitRatio    float64 `json:"split_ratio"`
+	Date          string  `json:"date"`
+}
+
+func (t PortfolioTransaction) validate() error {
+	if _, err := time.Parse(transactionDateLayout, t.Date); err != nil {
+		return fmt.Errorf("date is required and must be in YYYY-MM-DD format")
+	}
+
+	if t.Fees < 0 {
+		return fmt.Errorf("fees can't be negative")
+	}
+
+	switch domain.TransactionType(t.Type) {
+	case domain.Buy, domain.Sell:
+		if t.Symbol == "" {
+			return fmt.Errorf("symbol is required for %s transactions", t.Type)
+		}
+		if t.AssetClass != "stock" && t.AssetClass != "etf" && t.AssetClass != "crypto" {
+			return fmt.Errorf("asset_class valid values are: stock, etf, crypto")
+		}
+		if t.Quantity <= 0 {
+			return fmt.Errorf("quantity must be greater than zero")
+		}
+		if t.Price <= 0 {
+			return fmt.Errorf("price must be greater than zero")
+		}
+	case domain.Dividend:
+		if t.Symbol == "" {
+			return fmt.Errorf("symbol is required for dividend transactions")
+		}
+		if t.Amount <= 0 {
+			return fmt.Errorf("amount must be greater than zero")
+		}
+	case domain.Split:
+		if t.Symbol == "" {
+			return fmt.Errorf("symbol is required for split transactions")
+		}
+		if t.SplitRatio <= 0 {
+			return fmt.Errorf("split_ratio must be greater than zero")
+		}
+	case domain.Deposit:
+		if t.Amount == 0 {
+			return fmt.Errorf("amount is required for deposit transactions")
+		}
+	default:
+		return fmt.Errorf("type valid values are: buy, sell, dividend, split, deposit")
+	}
+
+	return nil
+}
+
+func (t PortfolioTransaction) toDomain(userID string) domain.PortfolioTransaction {
+	date, _ := time.Parse(transactionDateLayout, t.Date)
+	return domain.PortfolioTransaction{
+		ID:         t.TransactionID,
+		UserID:     userID,
+		Type:       domain.TransactionType(t.Type),
+		AssetClass: domain.AssetClass(t.AssetClass),
+		Symbol:     t.Symbol,
+		Quantity:   t.Quantity,
+		Price:      t.Price,
+		Amount:     t.Amount,
+		Fees:       t.Fees,
+		SplitRatio: t.SplitRatio,
+		Date:       date,
+	}
+}
+
+func newPortfolioTransaction(t domain.PortfolioTransaction) PortfolioTransaction {
+	return PortfolioTransaction{
+		TransactionID: t.ID,
+		Type:          string(t.Type),
+		AssetClass:    string(t.AssetClass),
+		Symbol:        t.Symbol,
+		Quantity:      t.Quantity,
+		Price:         t.Price,
+		Amount:        t.Amount,
+		Fees:          t.Fees,
+		SplitRatio:    t.SplitRatio,
+		Date:          t.Date.Format(transactionDateLayout),
+	}
+}
+
+type GetTransactionsResponse struct {
+	Transactions []PortfolioTransaction `json:"transactions"`
+}
+
+type LedgerHolding struct {
+	AssetClass          string  `json:"asset_class"`
+	Symbol              string  `json:"symbol"`
+	Quantity            float64 `json:"quantity"`
+	AverageCost         float64 `json:"average_cost"`
+	CostBasis           float64 `json:"cost_basis"`
+	CurrentPrice        float64 `json:"current_price"`
+	MarketValue         float64 `json:"market_value"`
+	UnrealizedPL        float64 `json:"unrealized_pl"`
+	UnrealizedPLPct     float64 `json:"unrealized_pl_pct"`
+	RealizedPL          float64 `json:"realized_pl"`
+	Dividends           float64 `json:"dividends"`
+	PortfolioPercentage float64 `json:"portfolio_percentage"`
+}
+
+type GetLedgerResponse struct {
+	UserID                string          `json:"user_id"`
+	Holdings              []LedgerHolding `json:"holdings"`
+	Cash                  float64         `json:"cash"`
+	NetDeposits           float64         `json:"net_deposits"`
+	MarketValue           float64         `json:"market_value"`
+	TotalValue            float64         `json:"total_value"`
+	RealizedPL            float64         `json:"realized_pl"`
+	UnrealizedPL          float64         `json:"unrealized_pl"`
+	Dividends             float64         `json:"dividends"`
+	Fees                  float64         `json:"fees"`
+	TimeWeightedReturnPct float64         `json:"time_weighted_return_pct"`
+}
+
+func (h *PortfolioHandler) handleError(c echo.Context, err error) error {
+	notFoundError := investbotErr.PortfolioTransactionNotFoundError{}
+	if errors.As(err, &notFoundError) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	invalidError := investbotErr.InvalidPortfolioTransactionError{}
+	if errors.As(err, &invalidError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+func (h *PortfolioHandler) GetTransactions(c echo.Context) error {
+	userID := c.Param("user_id")
+	transactions, err := h.ledgerService.GetTransactions(userID)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := GetTransactionsResponse{Transactions: make([]PortfolioTransaction, 0, len(transactions))}
+	for _, t := range transactions {
+		response.Transactions = append(response.Transactions, newPortfolioTransaction(t))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *PortfolioHandler) GetTransaction(c echo.Context) error {
+	transaction, err := h.ledgerService.GetTransaction(c.Param("user_id"), c.Param("transaction_id"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newPortfolioTransaction(transaction))
+}
+
+func (h *PortfolioHandler) CreateTransaction(c echo.Context) error {
+	request := PortfolioTransaction{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	transaction, err := h.ledgerService.AddTransaction(request.toDomain(c.Param("user_id")))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, newPortfolioTransaction(transaction))
+}
+
+func (h *PortfolioHandler) UpdateTransaction(c echo.Context) error {
+	request := PortfolioTransaction{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	request.TransactionID = c.Param("transaction_id")
+	transaction, err := h.ledgerService.UpdateTransaction(request.toDomain(c.Param("user_id")))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newPortfolioTransaction(transaction))
+}
+
+func (h *PortfolioHandler) DeleteTransaction(c echo.Context) error {
+	err := h.ledgerService.DeleteTransaction(c.Param("user_id"), c.Param("transaction_id"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *PortfolioHandler) GetLedger(c echo.Context) error {
+	ledger, err := h.ledgerService.GetLedger(c.Param("user_id"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := GetLedgerResponse{
+		UserID:                ledger.UserID,
+		Holdings:              make([]LedgerHolding, 0, len(ledger.Holdings)),
+		Cash:                  ledger.Cash,
+		NetDeposits:           ledger.NetDeposits,
+		MarketValue:           ledger.MarketValue,
+		TotalValue:            ledger.TotalValue,
+		RealizedPL:            ledger.RealizedPL,
+		UnrealizedPL:          ledger.UnrealizedPL,
+		Dividends:             ledger.Dividends,
+		Fees:                  ledger.Fees,
+		TimeWeightedReturnPct: ledger.TimeWeightedReturnPct,
+	}
+
+	for _, h := range ledger.Holdings {
+		response.Holdings = append(response.Holdings, LedgerHolding{
+			AssetClass:          string(h.AssetClass),
+			Symbol:              h.Symbol,
+			Quantity:            h.Quantity,
+			AverageCost:         h.AverageCost,
+			CostBasis:           h.CostBasis,
+			CurrentPrice:        h.CurrentPrice,
+			MarketValue:         h.MarketValue,
+			UnrealizedPL:        h.UnrealizedPL,
+			UnrealizedPLPct:     h.UnrealizedPLPct,
+			RealizedPL:          h.RealizedPL,
+			Dividends:           h.Dividends,
+			PortfolioPercentage: h.PortfolioPercentage,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
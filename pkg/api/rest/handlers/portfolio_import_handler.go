@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PortfolioImportService interface {
+	GetProfiles() []string
+	Preview(userID string, profile string, mapping *domain.PortfolioImportMapping, csvData io.Reader) (domain.PortfolioImportPreview, error)
+	CommitImport(userID string, importID string) (domain.UserContext, error)
+}
+
+type PortfolioImportHandler struct {
+	importService PortfolioImportService
+}
+
+func NewPortfolioImportHandler(importService PortfolioImportService) (*PortfolioImportHandler, error) {
+	return &PortfolioImportHandler{importService: importService}, nil
+}
+
+type PortfolioImportMapping struct {
+	Symbol      []string `json:"symbol"`
+	Name        []string `json:"name"`
+	Quantity    []string `json:"quantity"`
+	MarketValue []string `json:"market_value"`
+	Price       []string `json:"price"`
+	AssetClass  []string `json:"asset_class"`
+}
+
+func (m PortfolioImportMapping) validate() error {
+	if len(m.Symbol) == 0 && len(m.Name) == 0 {
+		return fmt.Errorf("mapping must define either symbol or name columns")
+	}
+
+	if len(m.Quantity) == 0 && len(m.MarketValue) == 0 {
+		return fmt.Errorf("mapping must define either quantity or market_value columns")
+	}
+
+	return nil
+}
+
+type PortfolioImportRowIssue struct {
+	Row    int    `json:"row"`
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type PortfolioImportPreviewResponse struct {
+	ImportID      string                    `json:"import_id"`
+	UserID        string                    `json:"user_id"`
+	Profile       string                    `json:"profile"`
+	Holdings      []UserPortfolioHolding    `json:"holdings"`
+	MergedSymbols []string                  `json:"merged_symbols"`
+	UnknownRows   []PortfolioImportRowIssue `json:"unknown_rows"`
+	SkippedRows   []PortfolioImportRowIssue `json:"skipped_rows"`
+	Warnings      []string                  `json:"warnings"`
+}
+
+type GetImportProfilesResponse struct {
+	Profiles []string `json:"profiles"`
+}
+
+func newPortfolioImportRowIssues(issues []domain.PortfolioImportRowIssue) []PortfolioImportRowIssue {
+	response := make([]PortfolioImportRowIssue, 0, len(issues))
+	for _, i := range issues {
+		response = append(response, PortfolioImportRowIssue{Row: i.Row, Symbol: i.Symbol, Name: i.Name, Reason: i.Reason})
+	}
+	return response
+}
+
+func (h *PortfolioImportHandler) handleError(c echo.Context, err error) error {
+	notFoundError := investbotErr.PortfolioImportNotFoundError{}
+	if errors.As(err, &notFoundError) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	invalidError := investbotErr.InvalidPortfolioImportError{}
+	if errors.As(err, &invalidError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+func (h *PortfolioImportHandler) GetProfiles(c echo.Context) error {
+	return c.JSON(http.StatusOK, GetImportProfilesResponse{Profiles: h.importService.GetProfiles()})
+}
+
+// PreviewImport expects a multipart form with the csv export in the "file" field, the broker
+// profile in the "profile" field and optionally a custom column mapping as json in the "mapping" field
+func (h *PortfolioImportHandler) PreviewImport(c echo.Context) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
+	}
+
+	var mapping *domain.PortfolioImportMapping
+	if rawMapping := c.FormValue("mapping"); rawMapping != "" {
+		request := PortfolioImportMapping{}
+		if err := json.Unmarshal([]byte(rawMapping), &request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "mapping must be valid json"})
+		}
+		if err := request.validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		mapping = &domain.PortfolioImportMapping{
+			Symbol:      request.Symbol,
+			Name:        request.Name,
+			Quantity:    request.Quantity,
+			MarketValue: request.MarketValue,
+			Price:       request.Price,
+			AssetClass:  request.AssetClass,
+		}
+	}
+
+	profile := c.FormValue("profile")
+	if profile == "" {
+		profile = "generic"
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	defer file.Close()
+
+	preview, err := h.importService.Preview(c.Param("user_id"), profile, mapping, file)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := PortfolioImportPreviewResponse{
+		ImportID:      preview.ImportID,
+		UserID:        preview.UserID,
+		Profile:       preview.Profile,
+		Holdings:      make([]UserPortfolioHolding, 0, len(preview.Holdings)),
+		MergedSymbols: preview.MergedSymbols,
+		UnknownRows:   newPortfolioImportRowIssues(preview.UnknownRows),
+		SkippedRows:   newPortfolioImportRowIssues(preview.SkippedRows),
+		Warnings:      preview.Warnings,
+	}
+
+	for _, h := range preview.Holdings {
+		response.Holdings = append(response.Holdings, UserPortfolioHolding{
+			AssetClass:          string(h.AssetClass),
+			Symbol:              h.Symbol,
+			Name:                h.Name,
+			Quantity:            h.Quantity,
+			PortfolioPercentage: h.PortfolioPercentage,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *PortfolioImportHandler) CommitImport(c echo.Context) error {
+	userContext, err := h.importService.CommitImport(c.Param("user_id"), c.Param("import_id"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, GetUserContextResponse{UserContext: newUserContext(userContext)})
+}
@@ -1,34 +1,115 @@
 package handlers
 
 import (
+	"errors"
 	investbotErr "investbot/pkg/errors"
 	"investbot/pkg/services"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-type SessionHandler struct {
-	sessionService services.SessionService
+const (
+	defaultSessionsPageSize = 20
+	maxSessionsPageSize     = 100
+)
+
+type SessionManagementService interface {
+	CreateSession(userID string) (services.Session, error)
+	GetSession(sessionID string, userID string) (services.Session, []services.Message, error)
+	ListSessions(userID string, includeArchived bool, page int, pageSize int) ([]services.Session, int, error)
+	RenameSession(sessionID string, userID string, title string) (services.Session, error)
+	ArchiveSession(sessionID string, userID string, archived bool) (services.Session, error)
+	ForkSession(sessionID string, userID string, messageID string) (services.Session, error)
+	DeleteSession(sessionID string, userID string) error
 }
 
-type CreateSessionResponse struct {
-	SessionId string `json:"session_id"`
+type SessionHandler struct {
+	sessionService SessionManagementService
 }
 
-func NewSessionHandler(sessionService services.SessionService) (*SessionHandler, error) {
+func NewSessionHandler(sessionService SessionManagementService) (*SessionHandler, error) {
 	return &SessionHandler{
 		sessionService: sessionService,
 	}, nil
 }
 
+type Session struct {
+	SessionId    string    `json:"session_id"`
+	UserID       string    `json:"user_id"`
+	Title        string    `json:"title"`
+	Archived     bool      `json:"archived"`
+	TopicHistory []string  `json:"topic_history"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func newSession(s services.Session) Session {
+	topicHistory := make([]string, 0, len(s.TopicHistory))
+	for _, topic := range s.TopicHistory {
+		topicHistory = append(topicHistory, string(topic))
+	}
+
+	return Session{
+		SessionId:    s.SessionID,
+		UserID:       s.UserID,
+		Title:        s.Title,
+		Archived:     s.Archived,
+		TopicHistory: topicHistory,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+	}
+}
+
+func (h *SessionHandler) handleError(c echo.Context, err error) error {
+	notFoundError := investbotErr.SessionNotFoundError{}
+	if errors.As(err, &notFoundError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	forbiddenError := investbotErr.SessionForbiddenError{}
+	if errors.As(err, &forbiddenError) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	messageNotFoundError := investbotErr.MessageNotFoundError{}
+	if errors.As(err, &messageNotFoundError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	invalidOperationError := investbotErr.InvalidSessionOperationError{}
+	if errors.As(err, &invalidOperationError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+type CreateSessionRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type CreateSessionResponse struct {
+	Session
+}
+
 func (h *SessionHandler) CreateNewSession(c echo.Context) error {
-	sessionId, err := h.sessionService.CreateNewSession()
+	// The body is optional, sessions can still be created without a user
+	request := CreateSessionRequest{}
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	session, err := h.sessionService.CreateSession(request.UserID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return h.handleError(c, err)
 	}
-	response := CreateSessionResponse{SessionId: sessionId}
-	return c.JSON(http.StatusCreated, response)
+
+	return c.JSON(http.StatusCreated, CreateSessionResponse{Session: newSession(session)})
 }
 
 type Actor string
@@ -39,30 +120,159 @@ const (
 )
 
 type Message struct {
-	Actor   Actor  `json:"actor"`
-	Message string `json:"message"`
+	ID       string           `json:"id"`
+	Actor    Actor            `json:"actor"`
+	Message  string           `json:"message"`
+	Metadata *MessageMetadata `json:"metadata,omitempty"`
+}
+
+type DataSource struct {
+	ID        int       `json:"id"`
+	Label     string    `json:"label"`
+	Url       string    `json:"url"`
+	Keys      []string  `json:"keys"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func newDataSources(sources []services.DataSource) []DataSource {
+	dataSources := make([]DataSource, 0, len(sources))
+	for _, source := range sources {
+		dataSources = append(dataSources, DataSource{
+			ID:        source.ID,
+			Label:     source.Label,
+			Url:       source.Url,
+			Keys:      source.Keys,
+			FetchedAt: source.FetchedAt,
+		})
+	}
+	return dataSources
+}
+
+// MessageMetadata describes how a response was generated
+type MessageMetadata struct {
+	Topic          string       `json:"topic"`
+	Tags           TopicTags    `json:"topic_tags"`
+	Model          string       `json:"model"`
+	PromptName     string       `json:"prompt_name"`
+	PromptVersion  string       `json:"prompt_version"`
+	LatencyMs      int64        `json:"latency_ms"`
+	PromptTokens   int          `json:"prompt_tokens"`
+	ResponseTokens int          `json:"response_tokens"`
+	RagContext     string       `json:"rag_context"`
+	DataSources    []DataSource `json:"data_sources"`
+	FactCheck      *FactCheck   `json:"fact_check,omitempty"`
+	Compliance     *Compliance  `json:"compliance,omitempty"`
+	Experiment     *Assignment  `json:"experiment,omitempty"`
+	RagResponseID  string       `json:"rag_response_id,omitempty"`
+}
+
+// Assignment is the experiment variant that generated a response
+type Assignment struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+}
+
+func newAssignment(a *services.Assignment) *Assignment {
+	if a == nil {
+		return nil
+	}
+	return &Assignment{Experiment: a.Experiment, Variant: a.Variant}
+}
+
+// Compliance is how the compliance policy applied to a response
+type Compliance struct {
+	Refused    bool     `json:"refused"`
+	Rules      []string `json:"rules"`
+	Disclaimer string   `json:"disclaimer"`
+}
+
+func newCompliance(c *services.ComplianceCheck) *Compliance {
+	if c == nil {
+		return nil
+	}
+
+	rules := c.Rules
+	if rules == nil {
+		rules = []string{}
+	}
+	return &Compliance{Refused: c.Refused, Rules: rules, Disclaimer: c.Disclaimer}
+}
+
+// FactCheck is the result of checking the figures of a response against its market data
+type FactCheck struct {
+	Checked     int            `json:"checked"`
+	Unverified  int            `json:"unverified"`
+	Mismatches  []FactMismatch `json:"mismatches"`
+	Regenerated bool           `json:"regenerated"`
+}
+
+type FactMismatch struct {
+	Metric  string  `json:"metric"`
+	Claim   string  `json:"claim"`
+	Value   float64 `json:"value"`
+	Closest float64 `json:"closest"`
+}
+
+func newFactCheck(c *services.FactCheck) *FactCheck {
+	if c == nil {
+		return nil
+	}
+
+	mismatches := make([]FactMismatch, 0, len(c.Mismatches))
+	for _, mismatch := range c.Mismatches {
+		mismatches = append(mismatches, FactMismatch{
+			Metric:  mismatch.Metric,
+			Claim:   mismatch.Claim,
+			Value:   mismatch.Value,
+			Closest: mismatch.Closest,
+		})
+	}
+
+	return &FactCheck{
+		Checked:     c.Checked,
+		Unverified:  c.Unverified,
+		Mismatches:  mismatches,
+		Regenerated: c.Regenerated,
+	}
+}
+
+func newMessageMetadata(m *services.MessageMetadata) *MessageMetadata {
+	if m == nil {
+		return nil
+	}
+
+	return &MessageMetadata{
+		Topic:          string(m.Topic),
+		Tags:           newTopicTags(m.Tags),
+		Model:          m.Model,
+		PromptName:     m.PromptName,
+		PromptVersion:  m.PromptVersion,
+		LatencyMs:      m.Latency.Milliseconds(),
+		PromptTokens:   m.PromptTokens,
+		ResponseTokens: m.ResponseTokens,
+		RagContext:     m.RagContext,
+		DataSources:    newDataSources(m.DataSources),
+		FactCheck:      newFactCheck(m.FactCheck),
+		Compliance:     newCompliance(m.Compliance),
+		Experiment:     newAssignment(m.Experiment),
+		RagResponseID:  m.RagResponseID,
+	}
 }
 
 type GetSessionResponse struct {
+	Session
 	Conversation []Message `json:"conversation"`
 }
 
 func (h *SessionHandler) GetSession(c echo.Context) error {
-	sessionID := c.Param("session_id")
-
-	session, err := h.sessionService.GetConversationBySessionId(sessionID)
+	session, conversation, err := h.sessionService.GetSession(c.Param("session_id"), c.QueryParam("user_id"))
 	if err != nil {
-		switch e := err.(type) {
-		case *investbotErr.SessionNotFoundError:
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": e.Error()})
-		default:
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": e.Error()})
-		}
+		return h.handleError(c, err)
 	}
 
-	response := GetSessionResponse{}
-	response.Conversation = make([]Message, 0, len(session))
-	for _, m := range session {
+	response := GetSessionResponse{Session: newSession(session)}
+	response.Conversation = make([]Message, 0, len(conversation))
+	for _, m := range conversation {
 		var actor Actor
 		switch m.Role {
 		case services.Assistant:
@@ -73,9 +283,139 @@ func (h *SessionHandler) GetSession(c echo.Context) error {
 			continue
 		}
 
-		msg := Message{Actor: actor, Message: m.Content}
+		msg := Message{ID: m.ID, Actor: actor, Message: m.Content, Metadata: newMessageMetadata(m.Metadata)}
 		response.Conversation = append(response.Conversation, msg)
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
+
+type ListSessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"page_size"`
+	Total    int       `json:"total"`
+}
+
+func (h *SessionHandler) ListSessions(c echo.Context) error {
+	var err error
+
+	userID := c.QueryParam("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user_id query param is required"})
+	}
+
+	page := 1
+	if pageQueryParam := c.QueryParam("page"); pageQueryParam != "" {
+		page, err = strconv.Atoi(pageQueryParam)
+		if err != nil || page < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "page query param must be a positive integer"})
+		}
+	}
+
+	pageSize := defaultSessionsPageSize
+	if pageSizeQueryParam := c.QueryParam("page_size"); pageSizeQueryParam != "" {
+		pageSize, err = strconv.Atoi(pageSizeQueryParam)
+		if err != nil || pageSize < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "page_size query param must be a positive integer"})
+		}
+		pageSize = min(pageSize, maxSessionsPageSize)
+	}
+
+	includeArchived := false
+	if includeArchivedQueryParam := c.QueryParam("include_archived"); includeArchivedQueryParam != "" {
+		includeArchived, err = strconv.ParseBool(includeArchivedQueryParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "include_archived query param must be a boolean"})
+		}
+	}
+
+	sessions, total, err := h.sessionService.ListSessions(userID, includeArchived, page, pageSize)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := ListSessionsResponse{
+		Sessions: make([]Session, 0, len(sessions)),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+	for _, s := range sessions {
+		response.Sessions = append(response.Sessions, newSession(s))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// RenameSessionRequest renames the session, when the title is empty a title is generated from the conversation
+type RenameSessionRequest struct {
+	UserID string `json:"user_id"`
+	Title  string `json:"title"`
+}
+
+func (h *SessionHandler) RenameSession(c echo.Context) error {
+	request := RenameSessionRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	session, err := h.sessionService.RenameSession(c.Param("session_id"), request.UserID, request.Title)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newSession(session))
+}
+
+type ArchiveSessionRequest struct {
+	UserID   string `json:"user_id"`
+	Archived bool   `json:"archived"`
+}
+
+func (h *SessionHandler) ArchiveSession(c echo.Context) error {
+	request := ArchiveSessionRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	session, err := h.sessionService.ArchiveSession(c.Param("session_id"), request.UserID, request.Archived)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newSession(session))
+}
+
+type ForkSessionRequest struct {
+	UserID    string `json:"user_id"`
+	MessageID string `json:"message_id"`
+}
+
+// ForkSession creates a new session with the conversation up to the message, included
+func (h *SessionHandler) ForkSession(c echo.Context) error {
+	request := ForkSessionRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if request.MessageID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "message_id field is required"})
+	}
+
+	session, err := h.sessionService.ForkSession(c.Param("session_id"), request.UserID, request.MessageID)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, newSession(session))
+}
+
+func (h *SessionHandler) DeleteSession(c echo.Context) error {
+	err := h.sessionService.DeleteSession(c.Param("session_id"), c.QueryParam("user_id"))
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
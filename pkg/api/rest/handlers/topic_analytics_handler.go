@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	investbotErr "investbot/pkg/errors"
+	"investbot/pkg/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAnalyticsDays         = 30
+	defaultMostMentionedLimit    = 10
+	defaultUserTopicHistoryLimit = 50
+	maxAnalyticsLimit            = 500
+)
+
+// analyticsBuckets are the bucket sizes of the topic distribution
+var analyticsBuckets = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+type TopicAnalyticsService interface {
+	TopicDistribution(from time.Time, to time.Time, bucket time.Duration) (services.TopicDistribution, error)
+	MostMentioned(from time.Time, to time.Time, limit int) (services.MostMentioned, error)
+	UserTopicHistory(userID string, from time.Time, to time.Time, limit int) (services.UserTopicHistory, error)
+}
+
+type TopicAnalyticsHandler struct {
+	analyticsService TopicAnalyticsService
+}
+
+func NewTopicAnalyticsHandler(analyticsService TopicAnalyticsService) (*TopicAnalyticsHandler, error) {
+	return &TopicAnalyticsHandler{analyticsService: analyticsService}, nil
+}
+
+type TopicBucket struct {
+	Start  time.Time      `json:"start"`
+	Total  int            `json:"total"`
+	Topics map[string]int `json:"topics"`
+}
+
+type TopicDistributionResponse struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Bucket  string         `json:"bucket"`
+	Totals  map[string]int `json:"totals"`
+	Buckets []TopicBucket  `json:"buckets"`
+}
+
+type TagMentions struct {
+	Name     string `json:"name"`
+	Mentions int    `json:"mentions"`
+	Users    int    `json:"users"`
+}
+
+type MostMentionedResponse struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Stocks  []TagMentions `json:"stocks"`
+	Etfs    []TagMentions `json:"etfs"`
+	Sectors []TagMentions `json:"sectors"`
+}
+
+type TopicAndTags struct {
+	Topic     string    `json:"topic"`
+	Tags      TopicTags `json:"topic_tags"`
+	Question  string    `json:"question"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserTopicHistoryResponse struct {
+	UserID string         `json:"user_id"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Topics map[string]int `json:"topics"`
+	Recent []TopicAndTags `json:"recent"`
+}
+
+func newTopicCounts(counts map[services.Topic]int) map[string]int {
+	topics := make(map[string]int, len(counts))
+	for topic, count := range counts {
+		topics[string(topic)] = count
+	}
+	return topics
+}
+
+func newTagMentions(mentions []services.TagMentions) []TagMentions {
+	tagMentions := make([]TagMentions, 0, len(mentions))
+	for _, m := range mentions {
+		tagMentions = append(tagMentions, TagMentions{Name: m.Name, Mentions: m.Mentions, Users: m.Users})
+	}
+	return tagMentions
+}
+
+// parseLimit parses the limit query param, capped by maxAnalyticsLimit
+func parseLimit(c echo.Context, defaultLimit int) (int, bool) {
+	limitQueryParam := c.QueryParam("limit")
+	if limitQueryParam == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(limitQueryParam)
+	if err != nil || limit < 1 {
+		return 0, false
+	}
+	return min(limit, maxAnalyticsLimit), true
+}
+
+func (h *TopicAnalyticsHandler) handleError(c echo.Context, err error) error {
+	invalidQueryError := investbotErr.InvalidTopicAnalyticsQueryError{}
+	if errors.As(err, &invalidQueryError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// GetTopicDistribution counts the questions of every topic by hour, day or week
+func (h *TopicAnalyticsHandler) GetTopicDistribution(c echo.Context) error {
+	from, to, err := parseTimeRange(c, defaultAnalyticsDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	bucketName := c.QueryParam("bucket")
+	if bucketName == "" {
+		bucketName = "day"
+	}
+	bucket, ok := analyticsBuckets[bucketName]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "bucket query param must be hour, day or week"})
+	}
+
+	distribution, err := h.analyticsService.TopicDistribution(from, to, bucket)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := TopicDistributionResponse{
+		From:    distribution.From,
+		To:      distribution.To,
+		Bucket:  bucketName,
+		Totals:  newTopicCounts(distribution.Totals),
+		Buckets: make([]TopicBucket, 0, len(distribution.Buckets)),
+	}
+	for _, b := range distribution.Buckets {
+		response.Buckets = append(response.Buckets, TopicBucket{Start: b.Start, Total: b.Total, Topics: newTopicCounts(b.Topics)})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetMostMentioned returns the stock symbols, ETF symbols and sectors that the questions mention the most
+func (h *TopicAnalyticsHandler) GetMostMentioned(c echo.Context) error {
+	from, to, err := parseTimeRange(c, defaultAnalyticsDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	limit, ok := parseLimit(c, defaultMostMentionedLimit)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit query param must be a positive integer"})
+	}
+
+	mostMentioned, err := h.analyticsService.MostMentioned(from, to, limit)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, MostMentionedResponse{
+		From:    mostMentioned.From,
+		To:      mostMentioned.To,
+		Stocks:  newTagMentions(mostMentioned.Stocks),
+		Etfs:    newTagMentions(mostMentioned.Etfs),
+		Sectors: newTagMentions(mostMentioned.Sectors),
+	})
+}
+
+func (h *TopicAnalyticsHandler) GetUserTopicHistory(c echo.Context) error {
+	from, to, err := parseTimeRange(c, defaultAnalyticsDays)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	limit, ok := parseLimit(c, defaultUserTopicHistoryLimit)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit query param must be a positive integer"})
+	}
+
+	history, err := h.analyticsService.UserTopicHistory(c.Param("user_id"), from, to, limit)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	response := UserTopicHistoryResponse{
+		UserID: history.UserID,
+		From:   history.From,
+		To:     history.To,
+		Topics: newTopicCounts(history.Topics),
+		Recent: make([]TopicAndTags, 0, len(history.Recent)),
+	}
+	for _, extraction := range history.Recent {
+		response.Recent = append(response.Recent, TopicAndTags{
+			Topic:     string(extraction.Topic),
+			Tags:      newTopicTags(extraction.Tags),
+			Question:  extraction.Question,
+			SessionID: extraction.SessionID,
+			CreatedAt: extraction.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -21,10 +21,13 @@ func (h *TopicHandler) GetTopics(c echo.Context) error {
 	topics := []string{
 		string(services.EDUCATION),
 		string(services.SECTORS),
+		string(services.INDUSTRIES),
 		string(services.STOCK_OVERVIEW),
 		string(services.STOCK_FINANCIALS),
 		string(services.ETFS),
 		string(services.NEWS),
+		string(services.PORTFOLIO),
+		string(services.SUPER_INVESTORS),
 	}
 
 	response := GetTopicsResponse{Topics: topics}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"investbot/pkg/domain"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	investbotErr "investbot/pkg/errors"
 
@@ -13,8 +16,10 @@ import (
 
 type UserContextService interface {
 	GetUserContext(userID string) (domain.UserContext, error)
-	CreateUserContext(domain.UserContext) error
-	UpdateUserContext(domain.UserContext) error
+	CreateUserContext(domain.UserContext) (domain.UserContext, error)
+	UpdateUserContext(domain.UserContext) (domain.UserContext, error)
+	GetRiskQuestionnaire() []domain.QuestionnaireQuestion
+	SubmitRiskQuestionnaire(userID string, answers map[string]string) (domain.UserContext, error)
 }
 
 type UserContextHandler struct {
@@ -33,60 +38,184 @@ type UserPortfolioHolding struct {
 	PortfolioPercentage float64 `json:"portfolio_percentage"`
 }
 
-func (h UserPortfolioHolding) validate() error {
+func (h UserPortfolioHolding) validate(field string) []investbotErr.FieldError {
+	fieldErrors := make([]investbotErr.FieldError, 0)
+
 	if h.AssetClass == "" {
-		return fmt.Errorf("asset_class is required")
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{Field: field + ".asset_class", Message: "asset_class is required"})
+	} else if h.AssetClass != "stock" && h.AssetClass != "etf" && h.AssetClass != "crypto" {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   field + ".asset_class",
+			Message: "asset_class valid values are: stock, etf, crypto",
+		})
 	}
 
-	if h.AssetClass != "stock" && h.AssetClass != "etf" && h.AssetClass != "crypto" {
-		return fmt.Errorf("asset_class valid values are: stock, etf, crypto")
+	if h.Symbol == "" && h.Name == "" {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{Field: field, Message: "you must define either symbol or name"})
 	}
 
-	if h.Symbol == "" && h.Name == "" {
-		return fmt.Errorf("you must define either symbol or name")
+	if h.Quantity < 0 {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{Field: field + ".quantity", Message: "quantity can't be negative"})
 	}
 
-	return nil
+	if h.PortfolioPercentage < 0 || h.PortfolioPercentage > 100 {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   field + ".portfolio_percentage",
+			Message: "portfolio_percentage must be between 0 and 100",
+		})
+	}
+
+	return fieldErrors
 }
 
-type UserContext struct {
-	UserID        string                 `json:"user_id"`
-	UserProfile   map[string]any         `json:"user_profile"`
-	UserPortfolio []UserPortfolioHolding `json:"user_portfolio"`
+type InvestorProfile struct {
+	Version               int      `json:"version"`
+	RiskTolerance         string   `json:"risk_tolerance"`
+	RiskScore             int      `json:"risk_score"`
+	InvestmentHorizon     string   `json:"investment_horizon"`
+	Goals                 []string `json:"goals"`
+	ExperienceLevel       string   `json:"experience_level"`
+	AnnualIncome          float64  `json:"annual_income"`
+	TaxRegion             string   `json:"tax_region"`
+	ExcludedSectors       []string `json:"excluded_sectors"`
+	PreferredAssetClasses []string `json:"preferred_asset_classes"`
+	UpdatedAt             string   `json:"updated_at,omitempty"`
 }
 
-func (r UserContext) validate() error {
-	if r.UserID == "" {
-		return fmt.Errorf("user_id is required")
+func validateEnum(field string, value string, validValues ...string) []investbotErr.FieldError {
+	if value == "" || slices.Contains(validValues, value) {
+		return nil
+	}
+	return []investbotErr.FieldError{{
+		Field:       field,
+		Message:     fmt.Sprintf("%s is not valid, valid values are: %s", value, strings.Join(validValues, ", ")),
+		Suggestions: validValues,
+	}}
+}
+
+func (p InvestorProfile) validate() []investbotErr.FieldError {
+	fieldErrors := make([]investbotErr.FieldError, 0)
+
+	fieldErrors = append(fieldErrors, validateEnum(
+		"investor_profile.risk_tolerance",
+		p.RiskTolerance,
+		"conservative", "moderately_conservative", "moderate", "moderately_aggressive", "aggressive",
+	)...)
+	fieldErrors = append(fieldErrors, validateEnum("investor_profile.investment_horizon", p.InvestmentHorizon, "short", "medium", "long")...)
+	fieldErrors = append(fieldErrors, validateEnum("investor_profile.experience_level", p.ExperienceLevel, "beginner", "intermediate", "advanced")...)
+
+	for i, goal := range p.Goals {
+		fieldErrors = append(fieldErrors, validateEnum(
+			fmt.Sprintf("investor_profile.goals[%d]", i),
+			goal,
+			"retirement", "wealth_growth", "income", "capital_preservation", "education", "home_purchase", "speculation",
+		)...)
 	}
 
-	for _, h := range r.UserPortfolio {
-		err := h.validate()
-		if err != nil {
-			return err
-		}
+	for i, assetClass := range p.PreferredAssetClasses {
+		fieldErrors = append(fieldErrors, validateEnum(
+			fmt.Sprintf("investor_profile.preferred_asset_classes[%d]", i),
+			assetClass,
+			"stock", "etf", "crypto",
+		)...)
 	}
 
-	return nil
+	if p.RiskScore < 0 || p.RiskScore > 100 {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   "investor_profile.risk_score",
+			Message: "risk_score must be between 0 and 100",
+		})
+	}
+
+	if p.AnnualIncome < 0 {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   "investor_profile.annual_income",
+			Message: "annual_income can't be negative",
+		})
+	}
+
+	return fieldErrors
 }
 
-type CreateUserContextRequest struct {
-	UserContext
+func (p InvestorProfile) toDomain() *domain.InvestorProfile {
+	profile := domain.InvestorProfile{
+		RiskTolerance:         domain.RiskTolerance(p.RiskTolerance),
+		RiskScore:             p.RiskScore,
+		InvestmentHorizon:     domain.InvestmentHorizon(p.InvestmentHorizon),
+		Goals:                 make([]domain.InvestmentGoal, 0, len(p.Goals)),
+		ExperienceLevel:       domain.ExperienceLevel(p.ExperienceLevel),
+		AnnualIncome:          p.AnnualIncome,
+		TaxRegion:             p.TaxRegion,
+		ExcludedSectors:       p.ExcludedSectors,
+		PreferredAssetClasses: make([]domain.AssetClass, 0, len(p.PreferredAssetClasses)),
+	}
+	for _, goal := range p.Goals {
+		profile.Goals = append(profile.Goals, domain.InvestmentGoal(goal))
+	}
+	for _, assetClass := range p.PreferredAssetClasses {
+		profile.PreferredAssetClasses = append(profile.PreferredAssetClasses, domain.AssetClass(assetClass))
+	}
+	return &profile
 }
 
-func (h *UserContextHandler) CreateUserContext(c echo.Context) error {
-	request := CreateUserContextRequest{}
+func newInvestorProfile(profile *domain.InvestorProfile) *InvestorProfile {
+	if profile == nil {
+		return nil
+	}
 
-	if err := c.Bind(&request); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	response := InvestorProfile{
+		Version:               profile.Version,
+		RiskTolerance:         string(profile.RiskTolerance),
+		RiskScore:             profile.RiskScore,
+		InvestmentHorizon:     string(profile.InvestmentHorizon),
+		Goals:                 make([]string, 0, len(profile.Goals)),
+		ExperienceLevel:       string(profile.ExperienceLevel),
+		AnnualIncome:          profile.AnnualIncome,
+		TaxRegion:             profile.TaxRegion,
+		ExcludedSectors:       profile.ExcludedSectors,
+		PreferredAssetClasses: make([]string, 0, len(profile.PreferredAssetClasses)),
+		UpdatedAt:             profile.UpdatedAt.Format(time.RFC3339),
+	}
+	for _, goal := range profile.Goals {
+		response.Goals = append(response.Goals, string(goal))
 	}
+	for _, assetClass := range profile.PreferredAssetClasses {
+		response.PreferredAssetClasses = append(response.PreferredAssetClasses, string(assetClass))
+	}
+	return &response
+}
 
-	if err := request.validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+type UserContext struct {
+	UserID          string                 `json:"user_id"`
+	UserProfile     map[string]any         `json:"user_profile"`
+	InvestorProfile *InvestorProfile       `json:"investor_profile,omitempty"`
+	UserPortfolio   []UserPortfolioHolding `json:"user_portfolio"`
+}
+
+func (r UserContext) validate() error {
+	fieldErrors := make([]investbotErr.FieldError, 0)
+	if r.UserID == "" {
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{Field: "user_id", Message: "user_id is required"})
 	}
 
-	portfolioHoldings := make([]domain.UserPortfolioHolding, 0, len(request.UserPortfolio))
-	for _, h := range request.UserPortfolio {
+	if r.InvestorProfile != nil {
+		fieldErrors = append(fieldErrors, r.InvestorProfile.validate()...)
+	}
+
+	for i, h := range r.UserPortfolio {
+		fieldErrors = append(fieldErrors, h.validate(fmt.Sprintf("user_portfolio[%d]", i))...)
+	}
+
+	if len(fieldErrors) > 0 {
+		return investbotErr.UserContextValidationError{FieldErrors: fieldErrors}
+	}
+
+	return nil
+}
+
+func (r UserContext) toDomain() domain.UserContext {
+	portfolioHoldings := make([]domain.UserPortfolioHolding, 0, len(r.UserPortfolio))
+	for _, h := range r.UserPortfolio {
 		portfolioHoldings = append(
 			portfolioHoldings,
 			domain.UserPortfolioHolding{
@@ -100,44 +229,23 @@ func (h *UserContextHandler) CreateUserContext(c echo.Context) error {
 	}
 
 	userContext := domain.UserContext{
-		UserID:        request.UserID,
-		UserProfile:   request.UserProfile,
+		UserID:        r.UserID,
+		UserProfile:   r.UserProfile,
 		UserPortfolio: portfolioHoldings,
 	}
-
-	err := h.userContextService.CreateUserContext(userContext)
-	if err != nil {
-		existsError := investbotErr.UserContextAlreadyExistsError{UserID: request.UserID}
-		if errors.As(err, &existsError) {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	if r.InvestorProfile != nil {
+		userContext.InvestorProfile = r.InvestorProfile.toDomain()
 	}
 
-	return c.JSON(http.StatusCreated, request)
+	return userContext
 }
 
-type GetUserContextResponse struct {
-	UserContext
-}
-
-func (h *UserContextHandler) GetUserContext(c echo.Context) error {
-	userID := c.Param("user_id")
-	userContext, err := h.userContextService.GetUserContext(userID)
-	if err != nil {
-		notFoundError := investbotErr.UserContextNotFoundError{UserID: userID}
-		if errors.As(err, &notFoundError) {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	response := GetUserContextResponse{
-		UserContext: UserContext{
-			UserID:        userContext.UserID,
-			UserProfile:   userContext.UserProfile,
-			UserPortfolio: make([]UserPortfolioHolding, 0, len(userContext.UserPortfolio)),
-		},
+func newUserContext(userContext domain.UserContext) UserContext {
+	response := UserContext{
+		UserID:          userContext.UserID,
+		UserProfile:     userContext.UserProfile,
+		InvestorProfile: newInvestorProfile(userContext.InvestorProfile),
+		UserPortfolio:   make([]UserPortfolioHolding, 0, len(userContext.UserPortfolio)),
 	}
 
 	for _, h := range userContext.UserPortfolio {
@@ -153,7 +261,85 @@ func (h *UserContextHandler) GetUserContext(c echo.Context) error {
 		)
 	}
 
-	return c.JSON(http.StatusOK, response)
+	return response
+}
+
+type FieldError struct {
+	Field       string   `json:"field"`
+	Message     string   `json:"message"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+type ValidationErrorResponse struct {
+	Error       string       `json:"error"`
+	FieldErrors []FieldError `json:"field_errors"`
+}
+
+func (h *UserContextHandler) handleError(c echo.Context, err error) error {
+	validationError := investbotErr.UserContextValidationError{}
+	if errors.As(err, &validationError) {
+		response := ValidationErrorResponse{
+			Error:       err.Error(),
+			FieldErrors: make([]FieldError, 0, len(validationError.FieldErrors)),
+		}
+		for _, e := range validationError.FieldErrors {
+			response.FieldErrors = append(response.FieldErrors, FieldError{
+				Field:       e.Field,
+				Message:     e.Message,
+				Suggestions: e.Suggestions,
+			})
+		}
+		return c.JSON(http.StatusBadRequest, response)
+	}
+
+	existsError := investbotErr.UserContextAlreadyExistsError{}
+	if errors.As(err, &existsError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	notFoundError := investbotErr.UserContextNotFoundError{}
+	if errors.As(err, &notFoundError) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+type CreateUserContextRequest struct {
+	UserContext
+}
+
+func (h *UserContextHandler) CreateUserContext(c echo.Context) error {
+	request := CreateUserContextRequest{}
+
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return h.handleError(c, err)
+	}
+
+	userContext, err := h.userContextService.CreateUserContext(request.toDomain())
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, newUserContext(userContext))
+}
+
+type GetUserContextResponse struct {
+	UserContext
+}
+
+func (h *UserContextHandler) GetUserContext(c echo.Context) error {
+	userID := c.Param("user_id")
+	userContext, err := h.userContextService.GetUserContext(userID)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, GetUserContextResponse{UserContext: newUserContext(userContext)})
 }
 
 type UpdateUserContextRequest struct {
@@ -167,37 +353,74 @@ func (h *UserContextHandler) UpdateUserContext(c echo.Context) error {
 	}
 
 	if err := request.validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return h.handleError(c, err)
 	}
 
-	portfolioHoldings := make([]domain.UserPortfolioHolding, 0, len(request.UserPortfolio))
-	for _, h := range request.UserPortfolio {
-		portfolioHoldings = append(
-			portfolioHoldings,
-			domain.UserPortfolioHolding{
-				AssetClass:          domain.AssetClass(h.AssetClass),
-				Symbol:              h.Symbol,
-				Name:                h.Name,
-				Quantity:            h.Quantity,
-				PortfolioPercentage: h.PortfolioPercentage,
-			},
-		)
+	userContext, err := h.userContextService.UpdateUserContext(request.toDomain())
+	if err != nil {
+		return h.handleError(c, err)
 	}
 
-	userContext := domain.UserContext{
-		UserID:        request.UserID,
-		UserProfile:   request.UserProfile,
-		UserPortfolio: portfolioHoldings,
+	return c.JSON(http.StatusOK, newUserContext(userContext))
+}
+
+type QuestionnaireOption struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+type QuestionnaireQuestion struct {
+	ID      string                `json:"id"`
+	Text    string                `json:"text"`
+	Options []QuestionnaireOption `json:"options"`
+}
+
+type GetRiskQuestionnaireResponse struct {
+	Questions []QuestionnaireQuestion `json:"questions"`
+}
+
+// GetRiskQuestionnaire returns the questions without the scores of the options
+// so that the answers are not biased
+func (h *UserContextHandler) GetRiskQuestionnaire(c echo.Context) error {
+	questionnaire := h.userContextService.GetRiskQuestionnaire()
+	response := GetRiskQuestionnaireResponse{Questions: make([]QuestionnaireQuestion, 0, len(questionnaire))}
+	for _, q := range questionnaire {
+		question := QuestionnaireQuestion{ID: q.ID, Text: q.Text, Options: make([]QuestionnaireOption, 0, len(q.Options))}
+		for _, o := range q.Options {
+			question.Options = append(question.Options, QuestionnaireOption{ID: o.ID, Text: o.Text})
+		}
+		response.Questions = append(response.Questions, question)
 	}
 
-	err := h.userContextService.UpdateUserContext(userContext)
+	return c.JSON(http.StatusOK, response)
+}
+
+type SubmitRiskQuestionnaireRequest struct {
+	Answers map[string]string `json:"answers"`
+}
+
+func (r SubmitRiskQuestionnaireRequest) validate() error {
+	if len(r.Answers) == 0 {
+		return fmt.Errorf("answers is required")
+	}
+
+	return nil
+}
+
+func (h *UserContextHandler) SubmitRiskQuestionnaire(c echo.Context) error {
+	request := SubmitRiskQuestionnaireRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := request.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	userContext, err := h.userContextService.SubmitRiskQuestionnaire(c.Param("user_id"), request.Answers)
 	if err != nil {
-		notFoundError := investbotErr.UserContextNotFoundError{UserID: request.UserID}
-		if errors.As(err, &notFoundError) {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return h.handleError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, request)
+	return c.JSON(http.StatusOK, newUserContext(userContext))
 }
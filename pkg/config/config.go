@@ -5,6 +5,7 @@ import (
 	"investbot/pkg/openAI"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -29,15 +30,22 @@ type SessionStorageProvider string
 const (
 	MONGO_DB_STORAGE  SessionStorageProvider = "MONGO_DB"
 	IN_MEMORY_STORAGE SessionStorageProvider = "MEMORY"
+	BADGER_STORAGE    SessionStorageProvider = "BADGER"
 )
 
 type MongoDBConfig struct {
-	Uri                        string
-	DBName                     string
-	SessionCollectionName      string
-	UserContextColletionName   string
-	TopicAndTagsCollectionName string
-	RagResponsesCollectionName string
+	Uri                                  string
+	DBName                               string
+	SessionCollectionName                string
+	UserContextColletionName             string
+	TopicAndTagsCollectionName           string
+	RagResponsesCollectionName           string
+	PortfolioTransactionsCollectionName  string
+	ImportPreviewsCollectionName         string
+	PolicyDecisionsCollectionName        string
+	ExperimentObservationsCollectionName string
+	JudgeScoresCollectionName            string
+	FeedbackCollectionName               string
 }
 
 type Config struct {
@@ -58,11 +66,47 @@ type Config struct {
 	LlmProvider            LlmProvider // Valid values are: "OPEN_AI", "OLLAMA"
 	FaqLimit               int         // Number of faq to return in through the endpoint
 	ConvMsgLimit           int         // The number of most recent messages to get from a session
+	LlmContextTokens       int         // The context window of the llm in tokens, 0 uses the known window of the model
+	RegenerateModels       []string    // Other models of the llm provider that can be used to regenerate a response
 	BaseLlmTemperature     float32     // The temperature to use for the base llm(currently there is only one llm that is used in all the rags)
 	FollowUpQuestionsNum   int         // The number of follow-up questions that the GET /follow_up_questions will return
 	CacheTtl               int         // The ttl for the cache in seconds
 	DatabaseProvider       DatabaseProvider
 	SessionStorageProvider SessionStorageProvider
+	SessionTtl             int // Seconds a session is kept since its last update, 0 keeps them forever
+
+	// Retention configs, 0 disables the rule
+	MaxSessionsPerUser        int // The most recently updated sessions to keep for every user
+	RagResponsesRetentionDays int
+	TopicAndTagsRetentionDays int
+	RetentionJanitorInterval  int // Seconds between the runs of the retention janitor
+
+	// Fact check configs
+	FactCheck           bool    // Check the figures of the rag responses against their market data
+	FactCheckTolerance  float32 // Relative difference allowed between a figure and the market data
+	FactCheckRegenerate bool    // Regenerate a response once when its figures don't match the market data
+
+	// Compliance configs
+	CompliancePolicyPath string  // Json file with the compliance rules and disclaimers, empty uses the default policy
+	InjectionThreshold   float32 // Score from which scraped text is withheld from the prompts as a prompt injection, 0 disables the classifier
+
+	// Prompt configs
+	PromptsDir     string            // Directory with prompt templates that override or add to the embedded ones
+	PromptVersions map[string]string // Pinned prompt versions by prompt name, the others use their latest version
+
+	// Experiment configs
+	ExperimentsPath string // Json file with the prompt and model experiments, empty runs no experiments
+
+	// Answer judge configs
+	JudgeModel         string // Model of the llm provider that grades the rag responses, empty uses the default model
+	JudgeInterval      int    // Seconds between the runs of the answer judge, 0 disables the judge
+	JudgeSampleSize    int    // Number of rag responses graded by every run
+	JudgeLookbackHours int    // Age of the oldest rag response that a run samples
+
+	// Trending faq configs
+	FaqTrendingLimit         int // Maximum number of trending questions in a faq, 0 only returns curated questions
+	FaqTrendingLookbackHours int // Age of the oldest question that the trending questions are mined from
+	FaqTrendingMinAskers     int // Number of sessions that must have asked a question for it to be trending
 
 	// Badger configs
 	BadgerDbPath string
@@ -97,6 +141,11 @@ func LoadConfig() (Config, error) {
 		cacheTtl = 3600
 	}
 
+	sessionTtl, err := strconv.Atoi(getEnv("SESSION_TTL", "2592000"))
+	if err != nil {
+		sessionTtl = 2592000
+	}
+
 	dbProvider := getEnv("DATABASE_PROVIDER", "BADGER")
 
 	sessionStorage := getEnv("SESSION_STORAGE_PROVIDER", "MEMORY")
@@ -116,6 +165,8 @@ func LoadConfig() (Config, error) {
 		GeminiKey:            getEnv("GEMINI_API_KEY", ""),
 		FaqLimit:             faqLimit,
 		ConvMsgLimit:         convMsgLimit,
+		LlmContextTokens:     getEnvInt("LLM_CONTEXT_TOKENS", 0),
+		RegenerateModels:     getEnvList("REGENERATE_MODELS"),
 		LlmProvider:          LlmProvider(llmProvider),
 		OpenAiModelName:      openAI.ModelName(openAiModelName),
 		GeminiModelName:      gemini.ModelName(geminiModelName),
@@ -125,18 +176,67 @@ func LoadConfig() (Config, error) {
 		CacheTtl:             cacheTtl,
 		BadgerDbPath:         getEnv("BADGER_DB_PATH", "badger.db"),
 		MongoDBConf: MongoDBConfig{
-			Uri:                        getEnv("MONGO_DB_URI", ""),
-			DBName:                     getEnv("MONGO_DB_NAME", ""),
-			SessionCollectionName:      getEnv("MONGO_DB_SESSION_COLLECTION_NAME", "session"),
-			UserContextColletionName:   getEnv("MONGO_DB_USER_CONTEXT_COLLECTION_NAME", "user_context"),
-			TopicAndTagsCollectionName: getEnv("MONGO_DB_TOPIC_AND_TAGS_COLLECTION_NAME", "topic_and_tags"),
-			RagResponsesCollectionName: getEnv("MONGO_DB_RAG_RESPONSES_COLLECTION_NAME", "rag_responses"),
+			Uri:                                 getEnv("MONGO_DB_URI", ""),
+			DBName:                              getEnv("MONGO_DB_NAME", ""),
+			SessionCollectionName:               getEnv("MONGO_DB_SESSION_COLLECTION_NAME", "session"),
+			UserContextColletionName:            getEnv("MONGO_DB_USER_CONTEXT_COLLECTION_NAME", "user_context"),
+			TopicAndTagsCollectionName:          getEnv("MONGO_DB_TOPIC_AND_TAGS_COLLECTION_NAME", "topic_and_tags"),
+			RagResponsesCollectionName:          getEnv("MONGO_DB_RAG_RESPONSES_COLLECTION_NAME", "rag_responses"),
+			PortfolioTransactionsCollectionName: getEnv("MONGO_DB_PORTFOLIO_TRANSACTIONS_COLLECTION_NAME", "portfolio_transactions"),
+			ImportPreviewsCollectionName:        getEnv("MONGO_DB_IMPORT_PREVIEWS_COLLECTION_NAME", "portfolio_import_previews"),
+			PolicyDecisionsCollectionName:       getEnv("MONGO_DB_POLICY_DECISIONS_COLLECTION_NAME", "policy_decisions"),
+			ExperimentObservationsCollectionName: getEnv(
+				"MONGO_DB_EXPERIMENT_OBSERVATIONS_COLLECTION_NAME",
+				"experiment_observations",
+			),
+			JudgeScoresCollectionName: getEnv("MONGO_DB_JUDGE_SCORES_COLLECTION_NAME", "judge_scores"),
+			FeedbackCollectionName:    getEnv("MONGO_DB_FEEDBACK_COLLECTION_NAME", "feedback"),
 		},
 		DatabaseProvider:       DatabaseProvider(dbProvider),
 		SessionStorageProvider: SessionStorageProvider(sessionStorage),
+		SessionTtl:             sessionTtl,
+
+		MaxSessionsPerUser:        getEnvInt("MAX_SESSIONS_PER_USER", 0),
+		RagResponsesRetentionDays: getEnvInt("RAG_RESPONSES_RETENTION_DAYS", 0),
+		TopicAndTagsRetentionDays: getEnvInt("TOPIC_AND_TAGS_RETENTION_DAYS", 0),
+		RetentionJanitorInterval:  getEnvInt("RETENTION_JANITOR_INTERVAL", 0),
+
+		FactCheck:           getEnvBool("FACT_CHECK", true),
+		FactCheckTolerance:  getEnvFloat32("FACT_CHECK_TOLERANCE", 0.02),
+		FactCheckRegenerate: getEnvBool("FACT_CHECK_REGENERATE", false),
+
+		CompliancePolicyPath: getEnv("COMPLIANCE_POLICY_PATH", ""),
+		InjectionThreshold:   getEnvFloat32("INJECTION_THRESHOLD", 2),
+
+		PromptsDir:     getEnv("PROMPTS_DIR", ""),
+		PromptVersions: getEnvMap("PROMPT_VERSIONS"),
+
+		ExperimentsPath: getEnv("EXPERIMENTS_PATH", ""),
+
+		JudgeModel:         getEnv("JUDGE_MODEL", ""),
+		JudgeInterval:      getEnvInt("JUDGE_INTERVAL", 0),
+		JudgeSampleSize:    getEnvInt("JUDGE_SAMPLE_SIZE", 20),
+		JudgeLookbackHours: getEnvInt("JUDGE_LOOKBACK_HOURS", 24),
+
+		FaqTrendingLimit:         getEnvInt("FAQ_TRENDING_LIMIT", 2),
+		FaqTrendingLookbackHours: getEnvInt("FAQ_TRENDING_LOOKBACK_HOURS", 168),
+		FaqTrendingMinAskers:     getEnvInt("FAQ_TRENDING_MIN_ASKERS", 3),
 	}, nil
 }
 
+// WithModelName returns a copy of the config that uses the model for the configured llm provider
+func (c Config) WithModelName(model string) Config {
+	switch c.LlmProvider {
+	case OPEN_AI:
+		c.OpenAiModelName = openAI.ModelName(model)
+	case OLLAMA:
+		c.OllamaModelName = model
+	case GEMINI:
+		c.GeminiModelName = gemini.ModelName(model)
+	}
+	return c
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -152,3 +252,43 @@ func getEnvFloat32(key string, fallback float32) float32 {
 	}
 	return fallback
 }
+
+// getEnvList returns the comma separated values of the variable
+func getEnvList(key string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvMap returns the comma separated key=value pairs of the variable
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range getEnvList(key) {
+		if k, v, found := strings.Cut(pair, "="); found {
+			values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return values
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return fallback
+}
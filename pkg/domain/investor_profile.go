@@ -0,0 +1,71 @@
+package domain
+
+import "time"
+
+// InvestorProfileVersion is increased every time a field is added to or removed from InvestorProfile
+const InvestorProfileVersion = 1
+
+type RiskTolerance string
+
+const (
+	Conservative           RiskTolerance = "conservative"
+	ModeratelyConservative RiskTolerance = "moderately_conservative"
+	Moderate               RiskTolerance = "moderate"
+	ModeratelyAggressive   RiskTolerance = "moderately_aggressive"
+	Aggressive             RiskTolerance = "aggressive"
+)
+
+type InvestmentHorizon string
+
+const (
+	ShortTerm  InvestmentHorizon = "short"
+	MediumTerm InvestmentHorizon = "medium"
+	LongTerm   InvestmentHorizon = "long"
+)
+
+type InvestmentGoal string
+
+const (
+	Retirement          InvestmentGoal = "retirement"
+	WealthGrowth        InvestmentGoal = "wealth_growth"
+	Income              InvestmentGoal = "income"
+	CapitalPreservation InvestmentGoal = "capital_preservation"
+	Education           InvestmentGoal = "education"
+	HomePurchase        InvestmentGoal = "home_purchase"
+	Speculation         InvestmentGoal = "speculation"
+)
+
+type ExperienceLevel string
+
+const (
+	Beginner     ExperienceLevel = "beginner"
+	Intermediate ExperienceLevel = "intermediate"
+	Advanced     ExperienceLevel = "advanced"
+)
+
+// InvestorProfile is the typed version of the user profile. Every field is optional.
+type InvestorProfile struct {
+	Version               int
+	RiskTolerance         RiskTolerance
+	RiskScore             int
+	InvestmentHorizon     InvestmentHorizon
+	Goals                 []InvestmentGoal
+	ExperienceLevel       ExperienceLevel
+	AnnualIncome          float64
+	TaxRegion             string
+	ExcludedSectors       []string
+	PreferredAssetClasses []AssetClass
+	UpdatedAt             time.Time
+}
+
+type QuestionnaireOption struct {
+	ID    string
+	Text  string
+	Score int
+}
+
+type QuestionnaireQuestion struct {
+	ID      string
+	Text    string
+	Options []QuestionnaireOption
+}
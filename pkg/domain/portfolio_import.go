@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// PortfolioImportMapping maps the columns of a broker csv export to the fields of a holding.
+// Every field lists the accepted column headers, the first header found in the file is used.
+type PortfolioImportMapping struct {
+	Symbol      []string
+	Name        []string
+	Quantity    []string
+	MarketValue []string
+	Price       []string
+	AssetClass  []string
+}
+
+type PortfolioImportRowIssue struct {
+	Row    int
+	Symbol string
+	Name   string
+	Reason string
+}
+
+type PortfolioImportPreview struct {
+	ImportID      string
+	UserID        string
+	Profile       string
+	Holdings      []UserPortfolioHolding
+	MergedSymbols []string
+	UnknownRows   []PortfolioImportRowIssue
+	SkippedRows   []PortfolioImportRowIssue
+	Warnings      []string
+	CreatedAt     time.Time
+}
@@ -0,0 +1,65 @@
+package domain
+
+import "time"
+
+type TransactionType string
+
+const (
+	Buy      TransactionType = "buy"
+	Sell     TransactionType = "sell"
+	Dividend TransactionType = "dividend"
+	Split    TransactionType = "split"
+	Deposit  TransactionType = "deposit"
+)
+
+// PortfolioTransaction is a single entry of a user's portfolio ledger.
+// Which fields are used depends on the type of the transaction:
+//   - buy/sell: Symbol, AssetClass, Quantity, Price and Fees
+//   - dividend: Symbol and Amount(the total cash received)
+//   - split: Symbol and SplitRatio(for example 4 for a 4-for-1 split)
+//   - deposit: Amount(negative amounts are withdrawals)
+type PortfolioTransaction struct {
+	ID         string
+	UserID     string
+	Type       TransactionType
+	AssetClass AssetClass
+	Symbol     string
+	Quantity   float64
+	Price      float64
+	Amount     float64
+	Fees       float64
+	SplitRatio float64
+	Date       time.Time
+	CreatedAt  time.Time
+}
+
+// LedgerHolding is a position derived from the transactions of a portfolio
+type LedgerHolding struct {
+	AssetClass          AssetClass
+	Symbol              string
+	Quantity            float64
+	AverageCost         float64
+	CostBasis           float64
+	CurrentPrice        float64
+	MarketValue         float64
+	UnrealizedPL        float64
+	UnrealizedPLPct     float64
+	RealizedPL          float64
+	Dividends           float64
+	PortfolioPercentage float64
+}
+
+// PortfolioLedger is the state of a portfolio derived from its transactions and current prices
+type PortfolioLedger struct {
+	UserID                string
+	Holdings              []LedgerHolding
+	Cash                  float64
+	NetDeposits           float64
+	MarketValue           float64
+	TotalValue            float64
+	RealizedPL            float64
+	UnrealizedPL          float64
+	Dividends             float64
+	Fees                  float64
+	TimeWeightedReturnPct float64
+}
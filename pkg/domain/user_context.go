@@ -17,7 +17,9 @@ type UserPortfolioHolding struct {
 }
 
 type UserContext struct {
-	UserID        string
-	UserProfile   map[string]any
-	UserPortfolio []UserPortfolioHolding
+	UserID      string
+	UserProfile map[string]any
+	// InvestorProfile is nil for user contexts that only have the free-form UserProfile
+	InvestorProfile *InvestorProfile
+	UserPortfolio   []UserPortfolioHolding
 }
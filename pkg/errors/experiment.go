@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type ExperimentNotFoundError struct {
+	Experiment string
+}
+
+func (e ExperimentNotFoundError) Error() string {
+	return fmt.Sprintf("experiment %s not found", e.Experiment)
+}
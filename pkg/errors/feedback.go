@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type InvalidFeedbackError struct {
+	Message string
+}
+
+func (e InvalidFeedbackError) Error() string {
+	return fmt.Sprintf("invalid feedback: %s", e.Message)
+}
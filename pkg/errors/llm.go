@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type InvalidModelError struct {
+	Model string
+}
+
+func (e InvalidModelError) Error() string {
+	return fmt.Sprintf("model %s is not available", e.Model)
+}
@@ -9,3 +9,44 @@ type PortfolioNotFoundError struct {
 func (e PortfolioNotFoundError) Error() string {
 	return fmt.Sprintf("portfolio not found for id %s", e.PortfolioID)
 }
+
+// UserIDRequiredError is returned when a question about the portfolio of the user is asked without a user_id
+type UserIDRequiredError struct {
+	Topic string
+}
+
+func (e UserIDRequiredError) Error() string {
+	return fmt.Sprintf("user_id is required for %s questions", e.Topic)
+}
+
+type PortfolioTransactionNotFoundError struct {
+	TransactionID string
+}
+
+func (e PortfolioTransactionNotFoundError) Error() string {
+	return fmt.Sprintf("portfolio transaction not found for id %s", e.TransactionID)
+}
+
+type InvalidPortfolioTransactionError struct {
+	Message string
+}
+
+func (e InvalidPortfolioTransactionError) Error() string {
+	return fmt.Sprintf("invalid portfolio transaction: %s", e.Message)
+}
+
+type PortfolioImportNotFoundError struct {
+	ImportID string
+}
+
+func (e PortfolioImportNotFoundError) Error() string {
+	return fmt.Sprintf("portfolio import not found for id %s", e.ImportID)
+}
+
+type InvalidPortfolioImportError struct {
+	Message string
+}
+
+func (e InvalidPortfolioImportError) Error() string {
+	return fmt.Sprintf("invalid portfolio import: %s", e.Message)
+}
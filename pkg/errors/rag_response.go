@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type RagResponseNotFoundError struct {
+	ID string
+}
+
+func (e RagResponseNotFoundError) Error() string {
+	return fmt.Sprintf("rag response %s not found", e.ID)
+}
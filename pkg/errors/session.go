@@ -9,3 +9,29 @@ type SessionNotFoundError struct {
 func (e SessionNotFoundError) Error() string {
 	return fmt.Sprintf("SessionNotFound error: %s", e.Message)
 }
+
+type SessionForbiddenError struct {
+	SessionID string
+	UserID    string
+}
+
+func (e SessionForbiddenError) Error() string {
+	return fmt.Sprintf("session %s doesn't belong to user %s", e.SessionID, e.UserID)
+}
+
+type InvalidSessionOperationError struct {
+	Message string
+}
+
+func (e InvalidSessionOperationError) Error() string {
+	return fmt.Sprintf("invalid session operation: %s", e.Message)
+}
+
+type MessageNotFoundError struct {
+	SessionID string
+	MessageID string
+}
+
+func (e MessageNotFoundError) Error() string {
+	return fmt.Sprintf("message %s not found in session %s", e.MessageID, e.SessionID)
+}
@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type InvalidTopicAnalyticsQueryError struct {
+	Message string
+}
+
+func (e InvalidTopicAnalyticsQueryError) Error() string {
+	return fmt.Sprintf("invalid topic analytics query: %s", e.Message)
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type UserContextNotFoundError struct {
 	UserID string
@@ -17,3 +20,23 @@ type UserContextAlreadyExistsError struct {
 func (e UserContextAlreadyExistsError) Error() string {
 	return fmt.Sprintf("user context for user_id: %s already exists", e.UserID)
 }
+
+type FieldError struct {
+	Field       string
+	Message     string
+	Suggestions []string
+}
+
+// UserContextValidationError holds every invalid field of a user context so that all
+// of them can be reported at once instead of one per request
+type UserContextValidationError struct {
+	FieldErrors []FieldError
+}
+
+func (e UserContextValidationError) Error() string {
+	messages := make([]string, 0, len(e.FieldErrors))
+	for _, fieldError := range e.FieldErrors {
+		messages = append(messages, fmt.Sprintf("%s: %s", fieldError.Field, fieldError.Message))
+	}
+	return fmt.Sprintf("invalid user context: %s", strings.Join(messages, "; "))
+}
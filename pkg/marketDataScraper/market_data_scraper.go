@@ -1,7 +1,6 @@
 package marketDataScraper
 
 import (
-	"fmt"
 	"investbot/pkg/config"
 	"investbot/pkg/domain"
 	"investbot/pkg/services"
@@ -125,7 +124,7 @@ func (mds MarketDataScraperWithCache) GetSectorStocks(sector string) ([]domain.S
 	// Check if the data is in the cache
 	var sectorStocks []domain.SectorStock
 
-	key := fmt.Sprintf("sector_stocks_%s", sector)
+	key := services.SectorStocksDataKey(sector)
 	err := mds.cache.Get(key, &sectorStocks)
 	if err == nil {
 		return sectorStocks, nil
@@ -145,7 +144,7 @@ func (mds MarketDataScraperWithCache) GetSectors() ([]domain.Sector, error) {
 	// Check if the data is in the cache
 	var sectors []domain.Sector
 
-	key := "sectors"
+	key := services.SectorsDataKey
 	err := mds.cache.Get(key, &sectors)
 	if err == nil {
 		return sectors, nil
@@ -166,7 +165,7 @@ func (mds MarketDataScraperWithCache) GetIndustryStocks(industry string) ([]doma
 	// Check if the data is in the cache
 	var industryStocks []domain.IndustryStock
 
-	key := fmt.Sprintf("industry_stocks_%s", industry)
+	key := services.IndustryStocksDataKey(industry)
 	err := mds.cache.Get(key, &industryStocks)
 	if err == nil {
 		return industryStocks, nil
@@ -186,7 +185,7 @@ func (mds MarketDataScraperWithCache) GetIndustries() ([]domain.Industry, error)
 	// Check if the data is in the cache
 	var industries []domain.Industry
 
-	key := "industries"
+	key := services.IndustriesDataKey
 	err := mds.cache.Get(key, &industries)
 	if err == nil {
 		return industries, nil
@@ -207,7 +206,7 @@ func (mds MarketDataScraperWithCache) GetStockForecast(symbol string) (domain.St
 	// Check if the data is in the cache
 	var stockForecast domain.StockForecast
 
-	key := fmt.Sprintf("stock_forecast_%s", symbol)
+	key := services.StockForecastDataKey(symbol)
 	err := mds.cache.Get(key, &stockForecast)
 	if err == nil {
 		return stockForecast, nil
@@ -228,7 +227,7 @@ func (mds MarketDataScraperWithCache) GetBalanceSheets(symbol string) ([]domain.
 	// Check if the data is in the cache
 	var balanceSheets []domain.BalanceSheet
 
-	key := fmt.Sprintf("balance_sheets_%s", symbol)
+	key := services.BalanceSheetsDataKey(symbol)
 	err := mds.cache.Get(key, &balanceSheets)
 	if err == nil {
 		return balanceSheets, nil
@@ -249,7 +248,7 @@ func (mds MarketDataScraperWithCache) GetIncomeStatements(symbol string) ([]doma
 	// Check if the data is in the cache
 	var incomeStatements []domain.IncomeStatement
 
-	key := fmt.Sprintf("income_statements_%s", symbol)
+	key := services.IncomeStatementsDataKey(symbol)
 	err := mds.cache.Get(key, &incomeStatements)
 	if err == nil {
 		return incomeStatements, nil
@@ -270,7 +269,7 @@ func (mds MarketDataScraperWithCache) GetCashFlows(symbol string) ([]domain.Cash
 	// Check if the data is in the cache
 	var cashFlows []domain.CashFlow
 
-	key := fmt.Sprintf("cash_flows_%s", symbol)
+	key := services.CashFlowsDataKey(symbol)
 	err := mds.cache.Get(key, &cashFlows)
 	if err == nil {
 		return cashFlows, nil
@@ -291,7 +290,7 @@ func (mds MarketDataScraperWithCache) GetFinancialRatios(symbol string) ([]domai
 	// Check if the data is in the cache
 	var financialRatios []domain.FinancialRatios
 
-	key := fmt.Sprintf("financial_ratios_%s", symbol)
+	key := services.FinancialRatiosDataKey(symbol)
 	err := mds.cache.Get(key, &financialRatios)
 	if err == nil {
 		return financialRatios, nil
@@ -311,7 +310,7 @@ func (mds MarketDataScraperWithCache) GetEtfs() ([]domain.Etf, error) {
 	// Check if the data is in the cache
 	var etfs []domain.Etf
 
-	key := "etfs"
+	key := services.EtfsDataKey
 	err := mds.cache.Get(key, &etfs)
 	if err == nil {
 		return etfs, nil
@@ -332,7 +331,7 @@ func (mds MarketDataScraperWithCache) GetEtfOverview(symbol string) (domain.EtfO
 	// Check if the data is in the cache
 	var etfOverview domain.EtfOverview
 
-	key := fmt.Sprintf("etf_overview_%s", symbol)
+	key := services.EtfOverviewDataKey(symbol)
 	err := mds.cache.Get(key, &etfOverview)
 	if err == nil {
 		return etfOverview, nil
@@ -353,7 +352,7 @@ func (mds MarketDataScraperWithCache) GetStockProfile(symbol string) (domain.Sto
 	// Check if the data is in the cache
 	var stockProfile domain.StockProfile
 
-	key := fmt.Sprintf("stock_profile_%s", symbol)
+	key := services.StockProfileDataKey(symbol)
 	err := mds.cache.Get(key, &stockProfile)
 	if err == nil {
 		return stockProfile, nil
@@ -373,7 +372,7 @@ func (mds MarketDataScraperWithCache) GetMarketNews() ([]domain.NewsArticle, err
 	// Check if the data is in the cache
 	var marketNews []domain.NewsArticle
 
-	key := "market_news"
+	key := services.MarketNewsDataKey
 	err := mds.cache.Get(key, &marketNews)
 	if err == nil {
 		return marketNews, nil
@@ -394,7 +393,7 @@ func (mds MarketDataScraperWithCache) GetStockNews(symbol string) ([]domain.News
 	// Check if the data is in the cache
 	var stockNews []domain.NewsArticle
 
-	key := fmt.Sprintf("stock_news_%s", symbol)
+	key := services.StockNewsDataKey(symbol)
 	err := mds.cache.Get(key, &stockNews)
 	if err == nil {
 		return stockNews, nil
@@ -414,7 +413,7 @@ func (mds MarketDataScraperWithCache) GetTickers() ([]domain.Ticker, error) {
 	// Check if the data is in the cache
 	var tickers []domain.Ticker
 
-	key := "tickers"
+	key := services.TickersDataKey
 	err := mds.cache.Get(key, &tickers)
 	if err == nil {
 		return tickers, nil
@@ -434,7 +433,7 @@ func (mds MarketDataScraperWithCache) GetSuperInvestors() ([]domain.SuperInvesto
 	// Check if the data is in the cache
 	var superInvestors []domain.SuperInvestor
 
-	key := "super_investors"
+	key := services.SuperInvestorsDataKey
 	err := mds.cache.Get(key, &superInvestors)
 	if err == nil {
 		return superInvestors, nil
@@ -454,7 +453,7 @@ func (mds MarketDataScraperWithCache) GetSuperInvestorPortfolio(superInvestorNam
 	// Check if the data is in the cache
 	var superInvestorPortfolio domain.SuperInvestorPortfolio
 
-	key := fmt.Sprintf("super_investor_portfolio_%s", superInvestorName)
+	key := services.SuperInvestorPortfolioDataKey(superInvestorName)
 	err := mds.cache.Get(key, &superInvestorPortfolio)
 	if err == nil {
 		return superInvestorPortfolio, nil
@@ -473,7 +472,7 @@ func (mds MarketDataScraperWithCache) GetHistoricalPrices(ticker string, assetCl
 	// Check if the data is in the cache
 	var historicalPrices domain.HistoricalPrices
 
-	key := fmt.Sprintf("historical_prices_%s_%s_%s", ticker, assetClass, period)
+	key := services.HistoricalPricesDataKey(ticker, assetClass, period)
 	err := mds.cache.Get(key, &historicalPrices)
 	if err == nil {
 		return historicalPrices, nil
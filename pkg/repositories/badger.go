@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"github.com/dgraph-io/badger/v4"
+)
+
+// deleteBadgerKeys deletes the keys with the prefix for which shouldDelete returns true
+// and returns the number of deleted keys
+func deleteBadgerKeys(db *badger.DB, prefix []byte, shouldDelete func(key []byte, value []byte) (bool, error)) (int, error) {
+	keys := make([][]byte, 0)
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			ok, err := shouldDelete(item.Key(), value)
+			if err != nil {
+				return err
+			}
+			if ok {
+				keys = append(keys, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// A write batch splits the deletes in several transactions when there are many keys
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
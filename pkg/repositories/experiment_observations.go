@@ -0,0 +1,103 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"investbot/pkg/services"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type ExperimentObservationsBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewExperimentObservationsBadgerRepo(db *badger.DB) (*ExperimentObservationsBadgerRepo, error) {
+	return &ExperimentObservationsBadgerRepo{db: db}, nil
+}
+
+// The keys start with the experiment so that its observations can be read with a prefix scan
+func experimentObservationsPrefix(experiment string) []byte {
+	return []byte(fmt.Sprintf("experiment_observation:%s:", experiment))
+}
+
+func (r *ExperimentObservationsBadgerRepo) StoreExperimentObservation(observation services.ExperimentObservation) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		observationBytes, err := json.Marshal(observation)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s%020d:%s", experimentObservationsPrefix(observation.Experiment), observation.CreatedAt.UnixNano(), uuid.NewString())
+		return txn.Set([]byte(key), observationBytes)
+	})
+}
+
+func (r *ExperimentObservationsBadgerRepo) GetExperimentObservations(experiment string) ([]services.ExperimentObservation, error) {
+	observations := make([]services.ExperimentObservation, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := experimentObservationsPrefix(experiment)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var observation services.ExperimentObservation
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &observation)
+			})
+			if err != nil {
+				return err
+			}
+			observations = append(observations, observation)
+		}
+		return nil
+	})
+
+	return observations, err
+}
+
+type ExperimentObservationsMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+}
+
+func NewExperimentObservationsMongoRepo(client *mongo.Client, dbName, collectionName string) (*ExperimentObservationsMongoRepo, error) {
+	return &ExperimentObservationsMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+func (r *ExperimentObservationsMongoRepo) StoreExperimentObservation(observation services.ExperimentObservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.InsertOne(ctx, observation)
+	return err
+}
+
+func (r *ExperimentObservationsMongoRepo) GetExperimentObservations(experiment string) ([]services.ExperimentObservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, bson.M{"experiment": experiment})
+	if err != nil {
+		return nil, err
+	}
+
+	observations := make([]services.ExperimentObservation, 0)
+	if err := cursor.All(ctx, &observations); err != nil {
+		return nil, err
+	}
+
+	return observations, nil
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"investbot/pkg/services"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type FeedbackBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewFeedbackBadgerRepo(db *badger.DB) (*FeedbackBadgerRepo, error) {
+	return &FeedbackBadgerRepo{db: db}, nil
+}
+
+const feedbackPrefix = "feedback:"
+
+// The key is the id of the feedback, so a new feedback on a message replaces the previous one
+func (r *FeedbackBadgerRepo) StoreFeedback(feedback services.Feedback) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		feedbackBytes, err := json.Marshal(feedback)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set([]byte(feedbackPrefix+feedback.ID), feedbackBytes)
+	})
+}
+
+// QueryFeedback scans all the feedback, the keys are not ordered by time
+func (r *FeedbackBadgerRepo) QueryFeedback(query services.FeedbackQuery) ([]services.Feedback, error) {
+	feedback := make([]services.Feedback, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(feedbackPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var f services.Feedback
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &f)
+			})
+			if err != nil {
+				return err
+			}
+			if query.Matches(f) {
+				feedback = append(feedback, f)
+			}
+		}
+		return nil
+	})
+
+	return feedback, err
+}
+
+type FeedbackMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+}
+
+func NewFeedbackMongoRepo(client *mongo.Client, dbName, collectionName string) (*FeedbackMongoRepo, error) {
+	return &FeedbackMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+func (r *FeedbackMongoRepo) StoreFeedback(feedback services.Feedback) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.ReplaceOne(ctx, bson.M{"id": feedback.ID}, feedback, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *FeedbackMongoRepo) QueryFeedback(query services.FeedbackQuery) ([]services.Feedback, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdat"] = createdAt
+	}
+	if query.Experiment != "" {
+		filter["experiment.experiment"] = query.Experiment
+	}
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	feedback := make([]services.Feedback, 0)
+	if err := cursor.All(ctx, &feedback); err != nil {
+		return nil, err
+	}
+
+	return feedback, nil
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"investbot/pkg/services"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type JudgeScoresBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewJudgeScoresBadgerRepo(db *badger.DB) (*JudgeScoresBadgerRepo, error) {
+	return &JudgeScoresBadgerRepo{db: db}, nil
+}
+
+const judgeScoresPrefix = "judge_score:"
+
+// The keys start with the creation time so that a time range is a range of keys
+func judgeScoreTimeKey(createdAt time.Time) string {
+	return fmt.Sprintf("%s%020d", judgeScoresPrefix, createdAt.UnixNano())
+}
+
+func (r *JudgeScoresBadgerRepo) StoreJudgeScore(score services.JudgeScore) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		scoreBytes, err := json.Marshal(score)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set([]byte(judgeScoreTimeKey(score.CreatedAt)+":"+score.ResponseID), scoreBytes)
+	})
+}
+
+func (r *JudgeScoresBadgerRepo) GetJudgeScores(from time.Time, to time.Time) ([]services.JudgeScore, error) {
+	prefix := []byte(judgeScoresPrefix)
+	end := judgeScoreTimeKey(to)
+
+	scores := make([]services.JudgeScore, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek([]byte(judgeScoreTimeKey(from))); it.ValidForPrefix(prefix); it.Next() {
+			if !to.IsZero() && string(it.Item().Key()) >= end {
+				return nil
+			}
+
+			var score services.JudgeScore
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &score)
+			})
+			if err != nil {
+				return err
+			}
+			scores = append(scores, score)
+		}
+		return nil
+	})
+
+	return scores, err
+}
+
+type JudgeScoresMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+}
+
+func NewJudgeScoresMongoRepo(client *mongo.Client, dbName, collectionName string) (*JudgeScoresMongoRepo, error) {
+	return &JudgeScoresMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+func (r *JudgeScoresMongoRepo) StoreJudgeScore(score services.JudgeScore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.InsertOne(ctx, score)
+	return err
+}
+
+func (r *JudgeScoresMongoRepo) GetJudgeScores(from time.Time, to time.Time) ([]services.JudgeScore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	createdAt := bson.M{"$gte": from}
+	if !to.IsZero() {
+		createdAt["$lt"] = to
+	}
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, bson.M{"createdat": createdAt})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]services.JudgeScore, 0)
+	if err := cursor.All(ctx, &scores); err != nil {
+		return nil, err
+	}
+
+	return scores, nil
+}
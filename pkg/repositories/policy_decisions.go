@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"investbot/pkg/services"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type PolicyDecisionsBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewPolicyDecisionsBadgerRepo(db *badger.DB) (*PolicyDecisionsBadgerRepo, error) {
+	return &PolicyDecisionsBadgerRepo{db: db}, nil
+}
+
+const policyDecisionsPrefix = "policy_decision:"
+
+func (r *PolicyDecisionsBadgerRepo) StorePolicyDecision(decision services.PolicyDecision) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		decisionBytes, err := json.Marshal(decision)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s%020d:%s", policyDecisionsPrefix, decision.CreatedAt.UnixNano(), uuid.NewString())
+		return txn.Set([]byte(key), decisionBytes)
+	})
+}
+
+type PolicyDecisionsMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+}
+
+func NewPolicyDecisionsMongoRepo(client *mongo.Client, dbName, collectionName string) (*PolicyDecisionsMongoRepo, error) {
+	return &PolicyDecisionsMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+func (r *PolicyDecisionsMongoRepo) StorePolicyDecision(decision services.PolicyDecision) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.InsertOne(ctx, decision)
+	return err
+}
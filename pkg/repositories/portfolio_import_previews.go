@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"investbot/pkg/services"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type PortfolioImportPreviewsBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewPortfolioImportPreviewsBadgerRepo(db *badger.DB) (*PortfolioImportPreviewsBadgerRepo, error) {
+	return &PortfolioImportPreviewsBadgerRepo{db: db}, nil
+}
+
+const portfolioImportPreviewPrefix = "portfolio_import_preview:"
+
+// StoreImportPreview stores the preview with a badger ttl, so the previews that are never committed
+// are deleted by badger
+func (r *PortfolioImportPreviewsBadgerRepo) StoreImportPreview(preview domain.PortfolioImportPreview, ttl time.Duration) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		previewBytes, err := json.Marshal(preview)
+		if err != nil {
+			return err
+		}
+
+		entry := badger.NewEntry([]byte(portfolioImportPreviewPrefix+preview.ImportID), previewBytes).WithTTL(ttl)
+		return txn.SetEntry(entry)
+	})
+}
+
+func (r *PortfolioImportPreviewsBadgerRepo) GetImportPreview(importID string) (domain.PortfolioImportPreview, error) {
+	var preview domain.PortfolioImportPreview
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(portfolioImportPreviewPrefix + importID))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return investbotErr.PortfolioImportNotFoundError{ImportID: importID}
+			}
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &preview)
+		})
+	})
+
+	return preview, err
+}
+
+func (r *PortfolioImportPreviewsBadgerRepo) DeleteImportPreview(importID string) error {
+	return r.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(portfolioImportPreviewPrefix + importID))
+	})
+}
+
+type PortfolioImportPreviewsMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+	indexMu        sync.Mutex
+	indexed        bool
+}
+
+func NewPortfolioImportPreviewsMongoRepo(client *mongo.Client, dbName, collectionName string) (*PortfolioImportPreviewsMongoRepo, error) {
+	return &PortfolioImportPreviewsMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+// StoreImportPreview stores the preview. The first preview creates a ttl index on the creation date,
+// so the previews that are never committed are deleted by mongo.
+func (r *PortfolioImportPreviewsMongoRepo) StoreImportPreview(preview domain.PortfolioImportPreview, ttl time.Duration) error {
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	if err := r.ensureTtlIndex(collection, ttl); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, preview)
+	return err
+}
+
+func (r *PortfolioImportPreviewsMongoRepo) ensureTtlIndex(collection *mongo.Collection, ttl time.Duration) error {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+	if r.indexed {
+		return nil
+	}
+
+	if err := services.EnsureMongoTtlIndex(collection, "createdat", ttl); err != nil {
+		return err
+	}
+	r.indexed = true
+	return nil
+}
+
+func (r *PortfolioImportPreviewsMongoRepo) GetImportPreview(importID string) (domain.PortfolioImportPreview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var preview domain.PortfolioImportPreview
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	err := collection.FindOne(ctx, bson.M{"importid": importID}).Decode(&preview)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return domain.PortfolioImportPreview{}, investbotErr.PortfolioImportNotFoundError{ImportID: importID}
+		}
+		return domain.PortfolioImportPreview{}, err
+	}
+
+	return preview, nil
+}
+
+func (r *PortfolioImportPreviewsMongoRepo) DeleteImportPreview(importID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.DeleteOne(ctx, bson.M{"importid": importID})
+	return err
+}
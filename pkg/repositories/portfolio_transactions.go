@@ -0,0 +1,213 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type PortfolioTransactionsBadgerRepo struct {
+	db *badger.DB
+}
+
+func NewPortfolioTransactionsBadgerRepo(db *badger.DB) (*PortfolioTransactionsBadgerRepo, error) {
+	return &PortfolioTransactionsBadgerRepo{db: db}, nil
+}
+
+// The transactions of a user share the same key prefix so that they can be fetched with a prefix scan
+func portfolioTransactionsPrefix(userID string) []byte {
+	return []byte(fmt.Sprintf("portfolio_transaction:%s:", userID))
+}
+
+func portfolioTransactionKey(userID string, transactionID string) []byte {
+	return append(portfolioTransactionsPrefix(userID), []byte(transactionID)...)
+}
+
+func (r *PortfolioTransactionsBadgerRepo) InsertTransaction(transaction domain.PortfolioTransaction) error {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		transactionBytes, err := json.Marshal(transaction)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(portfolioTransactionKey(transaction.UserID, transaction.ID), transactionBytes)
+	})
+
+	return err
+}
+
+func (r *PortfolioTransactionsBadgerRepo) GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error) {
+	var transaction domain.PortfolioTransaction
+	err := r.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(portfolioTransactionKey(userID, transactionID))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return investbotErr.PortfolioTransactionNotFoundError{TransactionID: transactionID}
+			}
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &transaction)
+		})
+	})
+
+	return transaction, err
+}
+
+func (r *PortfolioTransactionsBadgerRepo) GetTransactions(userID string) ([]domain.PortfolioTransaction, error) {
+	transactions := make([]domain.PortfolioTransaction, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := portfolioTransactionsPrefix(userID)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var transaction domain.PortfolioTransaction
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &transaction)
+			})
+			if err != nil {
+				return err
+			}
+			transactions = append(transactions, transaction)
+		}
+		return nil
+	})
+
+	return transactions, err
+}
+
+func (r *PortfolioTransactionsBadgerRepo) UpdateTransaction(transaction domain.PortfolioTransaction) error {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		key := portfolioTransactionKey(transaction.UserID, transaction.ID)
+		if _, err := txn.Get(key); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return investbotErr.PortfolioTransactionNotFoundError{TransactionID: transaction.ID}
+			}
+			return err
+		}
+
+		transactionBytes, err := json.Marshal(transaction)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(key, transactionBytes)
+	})
+
+	return err
+}
+
+func (r *PortfolioTransactionsBadgerRepo) DeleteTransaction(userID string, transactionID string) error {
+	err := r.db.Update(func(txn *badger.Txn) error {
+		key := portfolioTransactionKey(userID, transactionID)
+		if _, err := txn.Get(key); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return investbotErr.PortfolioTransactionNotFoundError{TransactionID: transactionID}
+			}
+			return err
+		}
+
+		return txn.Delete(key)
+	})
+
+	return err
+}
+
+type PortfolioTransactionsMongoRepo struct {
+	client         *mongo.Client
+	dbName         string
+	collectionName string
+}
+
+func NewPortfolioTransactionsMongoRepo(client *mongo.Client, dbName, collectionName string) (*PortfolioTransactionsMongoRepo, error) {
+	return &PortfolioTransactionsMongoRepo{
+		client:         client,
+		dbName:         dbName,
+		collectionName: collectionName,
+	}, nil
+}
+
+func (r *PortfolioTransactionsMongoRepo) InsertTransaction(transaction domain.PortfolioTransaction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	_, err := collection.InsertOne(ctx, transaction)
+	return err
+}
+
+func (r *PortfolioTransactionsMongoRepo) GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error) {
+	var transaction domain.PortfolioTransaction
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	err := collection.FindOne(ctx, bson.M{"userid": userID, "id": transactionID}).Decode(&transaction)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return domain.PortfolioTransaction{}, investbotErr.PortfolioTransactionNotFoundError{TransactionID: transactionID}
+		}
+		return domain.PortfolioTransaction{}, err
+	}
+
+	return transaction, nil
+}
+
+func (r *PortfolioTransactionsMongoRepo) GetTransactions(userID string) ([]domain.PortfolioTransaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, bson.M{"userid": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]domain.PortfolioTransaction, 0)
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+func (r *PortfolioTransactionsMongoRepo) UpdateTransaction(transaction domain.PortfolioTransaction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": transaction}
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	res, err := collection.UpdateOne(ctx, bson.M{"userid": transaction.UserID, "id": transaction.ID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return investbotErr.PortfolioTransactionNotFoundError{TransactionID: transaction.ID}
+	}
+	return nil
+}
+
+func (r *PortfolioTransactionsMongoRepo) DeleteTransaction(userID string, transactionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	res, err := collection.DeleteOne(ctx, bson.M{"userid": userID, "id": transactionID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return investbotErr.PortfolioTransactionNotFoundError{TransactionID: transactionID}
+	}
+	return nil
+}
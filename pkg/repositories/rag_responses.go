@@ -3,19 +3,81 @@ package repositories
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	investbotErr "investbot/pkg/errors"
 	"investbot/pkg/services"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type ragResponseDocument struct {
-	ModelName    string
-	RagTopic     services.Topic
-	Conversation []services.Message
-	Response     string
-	CreatedAt    time.Time
+	ResponseID    string `json:",omitempty" bson:",omitempty"`
+	ModelName     string
+	RagTopic      services.Topic
+	Conversation  []services.Message
+	Response      string
+	PromptName    string              `json:",omitempty" bson:",omitempty"`
+	PromptVersion string              `json:",omitempty" bson:",omitempty"`
+	FactCheck     *services.FactCheck `json:",omitempty" bson:",omitempty"`
+	Experiment    string              `json:",omitempty" bson:",omitempty"`
+	Variant       string              `json:",omitempty" bson:",omitempty"`
+	CreatedAt     time.Time
+}
+
+func newRagResponseDocument(response services.RagResponse) ragResponseDocument {
+	id := response.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+
+	return ragResponseDocument{
+		ResponseID:    id,
+		ModelName:     response.ModelName,
+		RagTopic:      response.Topic,
+		Conversation:  response.Conversation,
+		Response:      response.Response,
+		PromptName:    response.PromptName,
+		PromptVersion: response.PromptVersion,
+		FactCheck:     response.FactCheck,
+		Experiment:    response.Experiment,
+		Variant:       response.Variant,
+		CreatedAt:     time.Now(),
+	}
+}
+
+func (d ragResponseDocument) ragResponse(id string) services.RagResponse {
+	return services.RagResponse{
+		ID:            id,
+		ModelName:     d.ModelName,
+		Topic:         d.RagTopic,
+		Conversation:  d.Conversation,
+		Response:      d.Response,
+		PromptName:    d.PromptName,
+		PromptVersion: d.PromptVersion,
+		FactCheck:     d.FactCheck,
+		Experiment:    d.Experiment,
+		Variant:       d.Variant,
+		CreatedAt:     d.CreatedAt,
+	}
+}
+
+// matches returns whether the document matches the filters of the query that are not part of the key
+func (d ragResponseDocument) matches(query services.RagResponseQuery) bool {
+	if len(query.Topics) > 0 && !slices.Contains(query.Topics, d.RagTopic) {
+		return false
+	}
+	if query.ModelName != "" && d.ModelName != query.ModelName {
+		return false
+	}
+	return query.PromptVersion == "" || d.PromptVersion == query.PromptVersion
 }
 
 type RagResponsesBadgerRepo struct {
@@ -26,32 +88,131 @@ func NewRagResponsesBadgerRepo(db *badger.DB) (*RagResponsesBadgerRepo, error) {
 	return &RagResponsesBadgerRepo{db: db}, nil
 }
 
-func (r *RagResponsesBadgerRepo) StoreRagResponse(
-	modelName string,
-	ragTopic services.Topic,
-	conversation []services.Message,
-	response string,
-) error {
-	document := ragResponseDocument{
-		ModelName:    string(modelName),
-		RagTopic:     ragTopic,
-		Conversation: conversation,
-		Response:     response,
-		CreatedAt:    time.Now(),
+const ragResponsesPrefix = "rag_response:"
+
+// The key starts with the creation time so that a prefix scan returns the responses from the oldest, and
+// ends with the id of the response
+func ragResponseKey(document ragResponseDocument) []byte {
+	return []byte(fmt.Sprintf("%s%020d:%s", ragResponsesPrefix, document.CreatedAt.UnixNano(), document.ResponseID))
+}
+
+// ragResponseID returns the id at the end of the key, responses stored before they had an id have a
+// random one there
+func ragResponseID(key []byte) string {
+	id := strings.TrimPrefix(string(key), ragResponsesPrefix)
+	if _, afterTime, found := strings.Cut(id, ":"); found {
+		return afterTime
 	}
+	return id
+}
+
+func (r *RagResponsesBadgerRepo) StoreRagResponse(response services.RagResponse) error {
+	return r.storeDocument(newRagResponseDocument(response))
+}
 
+func (r *RagResponsesBadgerRepo) storeDocument(document ragResponseDocument) error {
 	err := r.db.Update(func(txn *badger.Txn) error {
 		documentBytes, err := json.Marshal(document)
 		if err != nil {
 			return err
 		}
 
-		return txn.Set([]byte(time.Now().String()), documentBytes)
+		return txn.Set(ragResponseKey(document), documentBytes)
 	})
 
 	return err
 }
 
+// GetRagResponse scans the keys for the one that ends with the id. The values are only read for the
+// matching key, so the scan stays cheap.
+func (r *RagResponsesBadgerRepo) GetRagResponse(id string) (services.RagResponse, error) {
+	var (
+		document ragResponseDocument
+		found    bool
+	)
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(ragResponsesPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if ragResponseID(it.Item().Key()) != id {
+				continue
+			}
+
+			found = true
+			return it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &document)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return services.RagResponse{}, err
+	}
+	if !found {
+		return services.RagResponse{}, investbotErr.RagResponseNotFoundError{ID: id}
+	}
+
+	return document.ragResponse(id), nil
+}
+
+// QueryRagResponses scans the keys of the time range, the other filters are applied on the documents.
+// Responses stored before the keys had a prefix are not returned.
+func (r *RagResponsesBadgerRepo) QueryRagResponses(query services.RagResponseQuery) ([]services.RagResponse, error) {
+	prefix := []byte(ragResponsesPrefix)
+	start := prefix
+	if !query.From.IsZero() {
+		start = []byte(fmt.Sprintf("%s%020d", ragResponsesPrefix, query.From.UnixNano()))
+	}
+	end := []byte(fmt.Sprintf("%s%020d", ragResponsesPrefix, query.To.UnixNano()))
+
+	responses := make([]services.RagResponse, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			if query.Limit > 0 && len(responses) == query.Limit {
+				return nil
+			}
+
+			key := it.Item().Key()
+			if !query.To.IsZero() && string(key) >= string(end) {
+				return nil
+			}
+
+			var document ragResponseDocument
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &document)
+			})
+			if err != nil {
+				return err
+			}
+			if document.matches(query) {
+				responses = append(responses, document.ragResponse(ragResponseID(key)))
+			}
+		}
+		return nil
+	})
+
+	return responses, err
+}
+
+// DeleteRagResponsesBefore deletes the responses created before the given time. Responses stored before
+// the keys had a prefix are not deleted.
+func (r *RagResponsesBadgerRepo) DeleteRagResponsesBefore(before time.Time) (int, error) {
+	prefix := []byte(ragResponsesPrefix)
+	// Keys start with the creation time, so the documents don't need to be decoded
+	end := []byte(fmt.Sprintf("%s%020d", ragResponsesPrefix, before.UnixNano()))
+
+	return deleteBadgerKeys(r.db, prefix, func(key []byte, _ []byte) (bool, error) {
+		return string(key) < string(end), nil
+	})
+}
+
 type RagResponsesMongoRepo struct {
 	client         *mongo.Client
 	dbName         string
@@ -66,24 +227,121 @@ func NewRagResponsesMongoRepo(client *mongo.Client, dbName, collectionName strin
 	}, nil
 }
 
-func (r *RagResponsesMongoRepo) StoreRagResponse(
-	modelName string,
-	ragTopic services.Topic,
-	conversation []services.Message,
-	response string,
-) error {
+func (r *RagResponsesMongoRepo) StoreRagResponse(response services.RagResponse) error {
+	return r.storeDocument(newRagResponseDocument(response))
+}
+
+func (r *RagResponsesMongoRepo) storeDocument(document ragResponseDocument) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	document := ragResponseDocument{
-		ModelName:    modelName,
-		RagTopic:     ragTopic,
-		Conversation: conversation,
-		Response:     response,
-		CreatedAt:    time.Now(),
-	}
-
 	collection := r.client.Database(r.dbName).Collection(r.collectionName)
 	_, err := collection.InsertOne(ctx, document)
 	return err
 }
+
+// decodeRagResponse decodes a stored document, responses stored before they had an id use the ObjectID
+// generated by mongo
+func decodeRagResponse(raw bson.Raw) (services.RagResponse, error) {
+	var document ragResponseDocument
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return services.RagResponse{}, err
+	}
+
+	if document.ResponseID != "" {
+		return document.ragResponse(document.ResponseID), nil
+	}
+	objectID, ok := raw.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return services.RagResponse{}, fmt.Errorf("rag response document has no id")
+	}
+	return document.ragResponse(objectID.Hex()), nil
+}
+
+func (r *RagResponsesMongoRepo) GetRagResponse(id string) (services.RagResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"responseid": id}
+	if objectID, err := bson.ObjectIDFromHex(id); err == nil {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"_id": objectID}}}
+	}
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	raw, err := collection.FindOne(ctx, filter).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return services.RagResponse{}, investbotErr.RagResponseNotFoundError{ID: id}
+	}
+	if err != nil {
+		return services.RagResponse{}, err
+	}
+
+	return decodeRagResponse(raw)
+}
+
+func (r *RagResponsesMongoRepo) QueryRagResponses(query services.RagResponseQuery) ([]services.RagResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if len(query.Topics) > 0 {
+		filter["ragtopic"] = bson.M{"$in": query.Topics}
+	}
+	if query.ModelName != "" {
+		filter["modelname"] = query.ModelName
+	}
+	if query.PromptVersion != "" {
+		filter["promptversion"] = query.PromptVersion
+	}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdat"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(ctx)
+
+	responses := make([]services.RagResponse, 0)
+	for cursor.Next(ctx) {
+		response, err := decodeRagResponse(cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, cursor.Err()
+}
+
+func (r *RagResponsesMongoRepo) DeleteRagResponsesBefore(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	res, err := collection.DeleteMany(ctx, bson.M{"createdat": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+func (r *RagResponsesMongoRepo) EnsureRetentionIndex(retention time.Duration) error {
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	return services.EnsureMongoTtlIndex(collection, "createdat", retention)
+}
@@ -3,11 +3,16 @@ package repositories
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"investbot/pkg/services"
+	"slices"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type topicAndTagsDocument struct {
@@ -16,7 +21,34 @@ type topicAndTagsDocument struct {
 	Question  string
 	SessionID string
 	UserID    string
-	CreatedAt time.Time
+	// Experiments are the experiment variants of the extractors, empty when they were not part of an experiment
+	Experiments []services.Assignment `json:",omitempty" bson:",omitempty"`
+	CreatedAt   time.Time
+}
+
+func (d topicAndTagsDocument) topicAndTags() services.TopicAndTags {
+	return services.TopicAndTags{
+		Topic:       d.Topic,
+		Tags:        d.Tags,
+		Question:    d.Question,
+		SessionID:   d.SessionID,
+		UserID:      d.UserID,
+		Experiments: d.Experiments,
+		CreatedAt:   d.CreatedAt,
+	}
+}
+
+func (d topicAndTagsDocument) matches(query services.TopicAndTagsQuery) bool {
+	if len(query.Topics) > 0 && !slices.Contains(query.Topics, d.Topic) {
+		return false
+	}
+	if query.UserID != "" && d.UserID != query.UserID {
+		return false
+	}
+	if !query.From.IsZero() && d.CreatedAt.Before(query.From) {
+		return false
+	}
+	return query.To.IsZero() || d.CreatedAt.Before(query.To)
 }
 
 type TopicAndTagsBagderRepo struct {
@@ -27,20 +59,29 @@ func NewTopicAndTagsBagderRepo(db *badger.DB) (*TopicAndTagsBagderRepo, error) {
 	return &TopicAndTagsBagderRepo{db: db}, nil
 }
 
+const topicAndTagsPrefix = "topic_and_tags:"
+
+// The keys start with the creation time so that a time range is a range of keys
+func topicAndTagsTimeKey(createdAt time.Time) string {
+	return fmt.Sprintf("%s%020d", topicAndTagsPrefix, createdAt.UnixNano())
+}
+
 func (r *TopicAndTagsBagderRepo) StoreTopicAndTags(
 	topic services.Topic,
 	tags services.Tags,
 	question string,
 	sessionID string,
 	userID string,
+	experiments []services.Assignment,
 ) error {
 	document := topicAndTagsDocument{
-		Topic:     topic,
-		Tags:      tags,
-		Question:  question,
-		SessionID: sessionID,
-		UserID:    userID,
-		CreatedAt: time.Now(),
+		Topic:       topic,
+		Tags:        tags,
+		Question:    question,
+		SessionID:   sessionID,
+		UserID:      userID,
+		Experiments: experiments,
+		CreatedAt:   time.Now(),
 	}
 
 	err := r.db.Update(func(txn *badger.Txn) error {
@@ -49,12 +90,68 @@ func (r *TopicAndTagsBagderRepo) StoreTopicAndTags(
 			return err
 		}
 
-		return txn.Set([]byte(document.Question), documentBytes)
+		return txn.Set([]byte(topicAndTagsTimeKey(document.CreatedAt)+":"+uuid.NewString()), documentBytes)
 	})
 
 	return err
 }
 
+// QueryTopicAndTags scans the keys of the time range. The documents stored when the key was the question
+// sort after the time keys, they are only read by the queries without an end.
+func (r *TopicAndTagsBagderRepo) QueryTopicAndTags(query services.TopicAndTagsQuery) ([]services.TopicAndTags, error) {
+	prefix := []byte(topicAndTagsPrefix)
+	start := prefix
+	if !query.From.IsZero() {
+		start = []byte(topicAndTagsTimeKey(query.From))
+	}
+	end := topicAndTagsTimeKey(query.To)
+
+	extractions := make([]services.TopicAndTags, 0)
+	err := r.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			if !query.To.IsZero() && string(it.Item().Key()) >= end {
+				return nil
+			}
+
+			var document topicAndTagsDocument
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &document)
+			})
+			if err != nil {
+				return err
+			}
+			if document.matches(query) {
+				extractions = append(extractions, document.topicAndTags())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The documents keyed by question are not in time order
+	slices.SortStableFunc(extractions, func(a, b services.TopicAndTags) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return extractions, nil
+}
+
+// DeleteTopicAndTagsBefore deletes the documents created before the given time. Documents stored before
+// the keys had a prefix are not deleted.
+func (r *TopicAndTagsBagderRepo) DeleteTopicAndTagsBefore(before time.Time) (int, error) {
+	return deleteBadgerKeys(r.db, []byte(topicAndTagsPrefix), func(_ []byte, value []byte) (bool, error) {
+		var document topicAndTagsDocument
+		if err := json.Unmarshal(value, &document); err != nil {
+			return false, err
+		}
+		return document.CreatedAt.Before(before), nil
+	})
+}
+
 type TopicAndTagsMongoRepo struct {
 	client         *mongo.Client
 	dbName         string
@@ -75,20 +172,80 @@ func (r *TopicAndTagsMongoRepo) StoreTopicAndTags(
 	question string,
 	sessionID string,
 	userID string,
+	experiments []services.Assignment,
 ) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	document := topicAndTagsDocument{
-		Topic:     topic,
-		Tags:      tags,
-		Question:  question,
-		SessionID: sessionID,
-		UserID:    userID,
-		CreatedAt: time.Now(),
+		Topic:       topic,
+		Tags:        tags,
+		Question:    question,
+		SessionID:   sessionID,
+		UserID:      userID,
+		Experiments: experiments,
+		CreatedAt:   time.Now(),
 	}
 
 	collection := r.client.Database(r.dbName).Collection(r.collectionName)
 	_, err := collection.InsertOne(ctx, document)
 	return err
 }
+
+func (r *TopicAndTagsMongoRepo) QueryTopicAndTags(query services.TopicAndTagsQuery) ([]services.TopicAndTags, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if len(query.Topics) > 0 {
+		filter["topic"] = bson.M{"$in": query.Topics}
+	}
+	if query.UserID != "" {
+		filter["userid"] = query.UserID
+	}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdat"] = createdAt
+	}
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []topicAndTagsDocument
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	extractions := make([]services.TopicAndTags, 0, len(documents))
+	for _, document := range documents {
+		extractions = append(extractions, document.topicAndTags())
+	}
+	return extractions, nil
+}
+
+func (r *TopicAndTagsMongoRepo) DeleteTopicAndTagsBefore(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	res, err := collection.DeleteMany(ctx, bson.M{"createdat": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+func (r *TopicAndTagsMongoRepo) EnsureRetentionIndex(retention time.Duration) error {
+	collection := r.client.Database(r.dbName).Collection(r.collectionName)
+	return services.EnsureMongoTtlIndex(collection, "createdat", retention)
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"investbot/pkg/services/prompts"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JudgeCriterion is a criterion of the rubric that the judge grades the answers with
+type JudgeCriterion string
+
+const (
+	FaithfulnessCriterion JudgeCriterion = "faithfulness"
+	RelevanceCriterion    JudgeCriterion = "relevance"
+	CompletenessCriterion JudgeCriterion = "completeness"
+	ComplianceCriterion   JudgeCriterion = "compliance"
+)
+
+var JudgeCriteria = []JudgeCriterion{FaithfulnessCriterion, RelevanceCriterion, CompletenessCriterion, ComplianceCriterion}
+
+const (
+	minJudgeScore = 1
+	maxJudgeScore = 5
+)
+
+// answerTopics are the topics of the rag answers, the other components store their responses with
+// their own topic
+var answerTopics = []Topic{EDUCATION, SECTORS, INDUSTRIES, STOCK_OVERVIEW, STOCK_FINANCIALS, ETFS, NEWS, PORTFOLIO, SUPER_INVESTORS}
+
+// JudgeScore is the grade of a stored rag response. Topic, ModelName, PromptName and PromptVersion are
+// copied from the response so that the scores can be reported without reading the responses.
+type JudgeScore struct {
+	ResponseID         string
+	Topic              Topic
+	ModelName          string
+	PromptName         string
+	PromptVersion      string
+	JudgeModel         string
+	JudgePromptVersion string
+	Scores             map[JudgeCriterion]int
+	Reasoning          string
+	CreatedAt          time.Time
+}
+
+type JudgeScoreRepository interface {
+	StoreJudgeScore(score JudgeScore) error
+	// GetJudgeScores returns the scores created between from, included, and to, excluded. A zero to doesn't limit.
+	GetJudgeScores(from time.Time, to time.Time) ([]JudgeScore, error)
+}
+
+type AnswerJudgeConf struct {
+	SampleSize int           // Number of responses graded by every run
+	Lookback   time.Duration // Age of the oldest response that a run samples
+}
+
+// AnswerJudge grades samples of the stored rag responses with a judge llm
+type AnswerJudge struct {
+	judge     Llm
+	responses RagResponsesReader
+	scores    JudgeScoreRepository
+	conf      AnswerJudgeConf
+}
+
+func NewAnswerJudge(judge Llm, responses RagResponsesReader, scores JudgeScoreRepository, conf AnswerJudgeConf) (*AnswerJudge, error) {
+	if conf.SampleSize <= 0 {
+		return nil, fmt.Errorf("the sample size of the answer judge must be positive")
+	}
+	if conf.Lookback <= 0 {
+		return nil, fmt.Errorf("the lookback of the answer judge must be positive")
+	}
+
+	return &AnswerJudge{judge: judge, responses: responses, scores: scores, conf: conf}, nil
+}
+
+type llmJudgeResponse struct {
+	Faithfulness int    `json:"faithfulness"`
+	Relevance    int    `json:"relevance"`
+	Completeness int    `json:"completeness"`
+	Compliance   int    `json:"compliance"`
+	Reasoning    string `json:"reasoning"`
+}
+
+// Grade asks the judge to grade the response against the rubric. The first message of the stored
+// conversation is the prompt of the rag, with its context.
+func (j *AnswerJudge) Grade(response RagResponse) (JudgeScore, error) {
+	if len(response.Conversation) == 0 {
+		return JudgeScore{}, fmt.Errorf("rag response %s has no prompt", response.ID)
+	}
+
+	prompt, err := prompts.Render(prompts.AnswerJudge, prompts.Vars{
+		"Prompt":       response.Conversation[0].Content,
+		"Conversation": response.Conversation[1:],
+		"Response":     response.Response,
+	})
+	if err != nil {
+		return JudgeScore{}, err
+	}
+
+	responseMessage, err := streamChunks(
+		func(chunkChan chan<- string) error {
+			return j.judge.GenerateResponse([]Message{{Role: User, Content: prompt.Text}}, chunkChan)
+		},
+		nil, // no need to stream out chunks
+	)
+	if err != nil {
+		return JudgeScore{}, err
+	}
+
+	// Strip formatting artifacts
+	stripped := strings.TrimPrefix(responseMessage, "```json\n")
+	stripped = strings.TrimSuffix(stripped, "\n```")
+
+	var result llmJudgeResponse
+	if err := json.Unmarshal([]byte(stripped), &result); err != nil {
+		return JudgeScore{}, fmt.Errorf("invalid judge response: %w", err)
+	}
+
+	scores := map[JudgeCriterion]int{
+		FaithfulnessCriterion: result.Faithfulness,
+		RelevanceCriterion:    result.Relevance,
+		CompletenessCriterion: result.Completeness,
+		ComplianceCriterion:   result.Compliance,
+	}
+	for criterion, score := range scores {
+		if score < minJudgeScore || score > maxJudgeScore {
+			return JudgeScore{}, fmt.Errorf("invalid judge response: %s score %d is not between %d and %d", criterion, score, minJudgeScore, maxJudgeScore)
+		}
+	}
+
+	return JudgeScore{
+		ResponseID:         response.ID,
+		Topic:              response.Topic,
+		ModelName:          response.ModelName,
+		PromptName:         response.PromptName,
+		PromptVersion:      response.PromptVersion,
+		JudgeModel:         j.judge.GetLlmName(),
+		JudgePromptVersion: prompt.Version,
+		Scores:             scores,
+		Reasoning:          result.Reasoning,
+		CreatedAt:          time.Now(),
+	}, nil
+}
+
+// JudgeRunReport contains what a run of the judge graded
+type JudgeRunReport struct {
+	StartedAt  time.Time
+	Candidates int // Responses of the lookback that were not graded yet
+	Graded     int
+	Failed     int // Responses the judge didn't return a valid grade for
+	Duration   time.Duration
+}
+
+// Run grades a random sample of the answers of the lookback that were not graded yet. A response the
+// judge fails to grade is skipped and can be sampled again by the next runs.
+func (j *AnswerJudge) Run() (JudgeRunReport, error) {
+	report := JudgeRunReport{StartedAt: time.Now()}
+	from := report.StartedAt.Add(-j.conf.Lookback)
+
+	responses, err := j.responses.QueryRagResponses(RagResponseQuery{Topics: answerTopics, From: from, To: report.StartedAt})
+	if err != nil {
+		return report, err
+	}
+
+	// A response is graded after it's created, so its score is in the lookback too
+	scores, err := j.scores.GetJudgeScores(from, time.Time{})
+	if err != nil {
+		return report, err
+	}
+	graded := make(map[string]bool, len(scores))
+	for _, score := range scores {
+		graded[score.ResponseID] = true
+	}
+
+	candidates := make([]RagResponse, 0, len(responses))
+	for _, response := range responses {
+		if !graded[response.ID] {
+			candidates = append(candidates, response)
+		}
+	}
+	report.Candidates = len(candidates)
+
+	rand.Shuffle(len(candidates), func(i, k int) {
+		candidates[i], candidates[k] = candidates[k], candidates[i]
+	})
+	if len(candidates) > j.conf.SampleSize {
+		candidates = candidates[:j.conf.SampleSize]
+	}
+
+	var errs []error
+	for _, response := range candidates {
+		score, err := j.Grade(response)
+		if err != nil {
+			log.Printf("Failed to grade rag response %s: %s", response.ID, err.Error())
+			report.Failed++
+			continue
+		}
+		if err := j.scores.StoreJudgeScore(score); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Graded++
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	return report, errors.Join(errs...)
+}
+
+// Start runs the judge every interval until the context is cancelled and logs what every run graded
+func (j *AnswerJudge) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			report, err := j.Run()
+			if err != nil {
+				log.Printf("Answer judge failed: %s", err.Error())
+			}
+			log.Printf(
+				"Answer judge graded %d of %d ungraded rag responses, %d failed, in %s",
+				report.Graded, report.Candidates, report.Failed, report.Duration,
+			)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// JudgeReportGroup contains the average scores of the responses of a topic, model and prompt version
+type JudgeReportGroup struct {
+	Topic         Topic
+	ModelName     string
+	PromptName    string
+	PromptVersion string
+	Responses     int
+	Averages      map[JudgeCriterion]float64
+	Overall       float64 // Average of the criteria averages
+}
+
+type JudgeReport struct {
+	From   time.Time
+	To     time.Time
+	Groups []JudgeReportGroup
+}
+
+// Report averages the scores created between from and to by topic, model and prompt version
+func (j *AnswerJudge) Report(from time.Time, to time.Time) (JudgeReport, error) {
+	scores, err := j.scores.GetJudgeScores(from, to)
+	if err != nil {
+		return JudgeReport{}, err
+	}
+
+	type groupKey struct {
+		topic         Topic
+		modelName     string
+		promptName    string
+		promptVersion string
+	}
+	totals := map[groupKey]map[JudgeCriterion]int{}
+	counts := map[groupKey]int{}
+	for _, score := range scores {
+		key := groupKey{score.Topic, score.ModelName, score.PromptName, score.PromptVersion}
+		if totals[key] == nil {
+			totals[key] = map[JudgeCriterion]int{}
+		}
+		for criterion, value := range score.Scores {
+			totals[key][criterion] += value
+		}
+		counts[key]++
+	}
+
+	report := JudgeReport{From: from, To: to, Groups: make([]JudgeReportGroup, 0, len(totals))}
+	for key, total := range totals {
+		group := JudgeReportGroup{
+			Topic:         key.topic,
+			ModelName:     key.modelName,
+			PromptName:    key.promptName,
+			PromptVersion: key.promptVersion,
+			Responses:     counts[key],
+			Averages:      make(map[JudgeCriterion]float64, len(JudgeCriteria)),
+		}
+		for _, criterion := range JudgeCriteria {
+			group.Averages[criterion] = float64(total[criterion]) / float64(counts[key])
+			group.Overall += group.Averages[criterion] / float64(len(JudgeCriteria))
+		}
+		report.Groups = append(report.Groups, group)
+	}
+
+	sort.Slice(report.Groups, func(a, b int) bool {
+		ga, gb := report.Groups[a], report.Groups[b]
+		if ga.Topic != gb.Topic {
+			return ga.Topic < gb.Topic
+		}
+		if ga.ModelName != gb.ModelName {
+			return ga.ModelName < gb.ModelName
+		}
+		return ga.PromptVersion < gb.PromptVersion
+	})
+
+	return report, nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	investbotErr "investbot/pkg/errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRagResponsesReader []RagResponse
+
+func (f fakeRagResponsesReader) GetRagResponse(id string) (RagResponse, error) {
+	for _, response := range f {
+		if response.ID == id {
+			return response, nil
+		}
+	}
+	return RagResponse{}, investbotErr.RagResponseNotFoundError{ID: id}
+}
+
+func (f fakeRagResponsesReader) QueryRagResponses(query RagResponseQuery) ([]RagResponse, error) {
+	var responses []RagResponse
+	for _, response := range f {
+		if slices.Contains(query.Topics, response.Topic) {
+			responses = append(responses, response)
+		}
+	}
+	return responses, nil
+}
+
+type fakeJudgeScores []JudgeScore
+
+func (f *fakeJudgeScores) StoreJudgeScore(score JudgeScore) error {
+	*f = append(*f, score)
+	return nil
+}
+
+func (f *fakeJudgeScores) GetJudgeScores(time.Time, time.Time) ([]JudgeScore, error) {
+	return *f, nil
+}
+
+func judgedResponse(id string) RagResponse {
+	return RagResponse{
+		ID:            id,
+		ModelName:     "model",
+		Topic:         STOCK_FINANCIALS,
+		PromptName:    "stock_financials",
+		PromptVersion: "v1",
+		Conversation: []Message{
+			{Role: User, Content: "## CONTEXT:\nAAPL free cash flow: $99.58B"},
+			{Role: User, Content: "What's Apple's free cash flow?"},
+		},
+		Response: "Apple's free cash flow was $99.58B.",
+	}
+}
+
+func TestAnswerJudge_Grade(t *testing.T) {
+	llm := &fakeLlm{response: "```json\n" + `{"faithfulness": 5, "relevance": 4, "completeness": 3, "compliance": 5, "reasoning": "short"}` + "\n```"}
+	judge, err := NewAnswerJudge(llm, fakeRagResponsesReader{}, &fakeJudgeScores{}, AnswerJudgeConf{SampleSize: 1, Lookback: time.Hour})
+	assert.NoError(t, err)
+
+	score, err := judge.Grade(judgedResponse("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", score.ResponseID)
+	assert.Equal(t, STOCK_FINANCIALS, score.Topic)
+	assert.Equal(t, "v1", score.PromptVersion)
+	assert.Equal(t, "fake", score.JudgeModel)
+	assert.Equal(t, map[JudgeCriterion]int{
+		FaithfulnessCriterion: 5,
+		RelevanceCriterion:    4,
+		CompletenessCriterion: 3,
+		ComplianceCriterion:   5,
+	}, score.Scores)
+	assert.Equal(t, "short", score.Reasoning)
+
+	// The judge sees the context of the rag, the question and the answer
+	assert.Contains(t, llm.prompts[0], "AAPL free cash flow: $99.58B")
+	assert.Contains(t, llm.prompts[0], "user: What's Apple's free cash flow?")
+	assert.Contains(t, llm.prompts[0], "## ANSWER\nApple's free cash flow was $99.58B.")
+
+	llm.response = `{"faithfulness": 6, "relevance": 4, "completeness": 3, "compliance": 5}`
+	_, err = judge.Grade(judgedResponse("1"))
+	assert.Error(t, err)
+
+	llm.response = "not json"
+	_, err = judge.Grade(judgedResponse("1"))
+	assert.Error(t, err)
+
+	_, err = judge.Grade(RagResponse{ID: "empty"})
+	assert.Error(t, err)
+
+	_, err = NewAnswerJudge(llm, fakeRagResponsesReader{}, &fakeJudgeScores{}, AnswerJudgeConf{Lookback: time.Hour})
+	assert.Error(t, err)
+}
+
+func TestAnswerJudge_Run(t *testing.T) {
+	llm := &fakeLlm{response: `{"faithfulness": 4, "relevance": 4, "completeness": 4, "compliance": 4}`}
+	title := judgedResponse("title")
+	title.Topic = "SessionTitle"
+	responses := fakeRagResponsesReader{judgedResponse("1"), judgedResponse("2"), judgedResponse("3"), judgedResponse("4"), title}
+	scores := &fakeJudgeScores{{ResponseID: "1"}}
+
+	judge, _ := NewAnswerJudge(llm, responses, scores, AnswerJudgeConf{SampleSize: 2, Lookback: time.Hour})
+	report, err := judge.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Candidates)
+	assert.Equal(t, 2, report.Graded)
+
+	// The next run grades the last ungraded answer, responses of other components are never graded
+	report, err = judge.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Candidates)
+	assert.Equal(t, 1, report.Graded)
+
+	var graded []string
+	for _, score := range *scores {
+		graded = append(graded, score.ResponseID)
+	}
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, graded)
+
+	// Failed grades are counted and retried by the next runs
+	*scores = nil
+	llm.response = "not json"
+	report, err = judge.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Failed)
+	assert.Zero(t, report.Graded)
+}
+
+func TestAnswerJudge_Report(t *testing.T) {
+	score := func(modelName string, promptVersion string, value int) JudgeScore {
+		return JudgeScore{
+			Topic:         NEWS,
+			ModelName:     modelName,
+			PromptName:    "news",
+			PromptVersion: promptVersion,
+			Scores: map[JudgeCriterion]int{
+				FaithfulnessCriterion: value,
+				RelevanceCriterion:    value,
+				CompletenessCriterion: value,
+				ComplianceCriterion:   5,
+			},
+		}
+	}
+	scores := &fakeJudgeScores{score("b", "v1", 4), score("a", "v2", 2), score("a", "v1", 3), score("a", "v1", 4)}
+
+	judge, _ := NewAnswerJudge(&fakeLlm{}, fakeRagResponsesReader{}, scores, AnswerJudgeConf{SampleSize: 1, Lookback: time.Hour})
+	report, err := judge.Report(time.Time{}, time.Now())
+	assert.NoError(t, err)
+
+	assert.Len(t, report.Groups, 3)
+	assert.Equal(t, JudgeReportGroup{
+		Topic:         NEWS,
+		ModelName:     "a",
+		PromptName:    "news",
+		PromptVersion: "v1",
+		Responses:     2,
+		Averages: map[JudgeCriterion]float64{
+			FaithfulnessCriterion: 3.5,
+			RelevanceCriterion:    3.5,
+			CompletenessCriterion: 3.5,
+			ComplianceCriterion:   5,
+		},
+		Overall: 3.875,
+	}, report.Groups[0])
+	assert.Equal(t, "v2", report.Groups[1].PromptVersion)
+	assert.Equal(t, "b", report.Groups[2].ModelName)
+}
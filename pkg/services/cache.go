@@ -13,6 +13,12 @@ type CacheService interface {
 	Delete(key string) error
 }
 
+// cacheEntry wraps the cached values with the time they were stored
+type cacheEntry struct {
+	StoredAt time.Time       `json:"stored_at"`
+	Value    json.RawMessage `json:"value"`
+}
+
 type BadgerCacheService struct {
 	db *badger.DB
 }
@@ -26,6 +32,25 @@ func NewBadgerCacheService() (*BadgerCacheService, error) {
 }
 
 func (c *BadgerCacheService) Get(key string, target interface{}) error {
+	entry, err := c.getEntry(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(entry.Value, target)
+}
+
+// GetStoredAt returns when the value of the key was stored, which for the market data is when it was fetched
+func (c *BadgerCacheService) GetStoredAt(key string) (time.Time, error) {
+	entry, err := c.getEntry(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return entry.StoredAt, nil
+}
+
+func (c *BadgerCacheService) getEntry(key string) (cacheEntry, error) {
 	var data []byte
 	err := c.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(key))
@@ -36,14 +61,21 @@ func (c *BadgerCacheService) Get(key string, target interface{}) error {
 		return err
 	})
 	if err != nil {
-		return err
+		return cacheEntry{}, err
 	}
 
-	return json.Unmarshal(data, target)
+	var entry cacheEntry
+	err = json.Unmarshal(data, &entry)
+	return entry, err
 }
 
 func (c *BadgerCacheService) Set(key string, value interface{}, ttl time.Duration) error {
-	data, err := json.Marshal(value)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cacheEntry{StoredAt: time.Now(), Value: encodedValue})
 	if err != nil {
 		return err
 	}
@@ -3,22 +3,44 @@ package services
 import (
 	"fmt"
 	"investbot/pkg/errors"
+	"investbot/pkg/services/prompts"
 	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type Tags struct {
-	SectorName      string
-	IndustryName    string
-	StockSymbols    []string
-	BalanceSheet    bool
-	IncomeStatement bool
-	CashFlow        bool
-	EtfSymbols      []string
-	UserID          string
+	SectorName        string
+	IndustryName      string
+	StockSymbols      []string
+	BalanceSheet      bool
+	IncomeStatement   bool
+	CashFlow          bool
+	EtfSymbols        []string
+	SuperInvestorName string
+	UserID            string
 }
 
 type Rag interface {
-	GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error
+	// GenerateRagResponse streams the response and returns how it was generated. The topic, tags
+	// and latency of the metadata are set by the caller.
+	GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error)
+}
+
+// DataFetchTimeService returns when the data of a data source key was fetched, for the market
+// data it's the time it was stored in the cache
+type DataFetchTimeService interface {
+	GetStoredAt(key string) (time.Time, error)
+}
+
+// ComplianceService applies the compliance policy to the questions and the responses of the rags
+type ComplianceService interface {
+	CheckQuestion(question string, topic Topic, sessionId string, userID string) PolicyVerdict
+	CheckResponse(response string, ragContext string, topic Topic, sessionId string, userID string) PolicyVerdict
+	Disclaimer(userID string) string
 }
 
 type TopicExtractorService interface {
@@ -30,7 +52,41 @@ type TagExtractorService interface {
 }
 
 type TopicAndTagsRepository interface {
-	StoreTopicAndTags(topic Topic, tags Tags, question string, sessionID string, userID string) error
+	// StoreTopicAndTags stores the extracted topic and tags with the experiment variants that extracted them
+	StoreTopicAndTags(topic Topic, tags Tags, question string, sessionID string, userID string, experiments []Assignment) error
+}
+
+// ExperimentService returns the components of the experiment variant of the session, if there is an
+// experiment on the component
+type ExperimentService interface {
+	TopicExtractor(sessionID string, userID string) (TopicExtractorService, Assignment, bool)
+	TagExtractor(topic Topic, sessionID string, userID string) (TagExtractorService, Assignment, bool)
+	Rag(topic Topic, sessionID string, userID string) (Rag, Assignment, bool)
+}
+
+// ConversationBuilder builds the conversation history that is sent to the rags
+type ConversationBuilder interface {
+	GetConversation(sessionId string) ([]Message, error)
+	// FoldConversation folds the older turns of the conversation into the summary of the session
+	FoldConversation(sessionId string) error
+}
+
+type ChatEventType string
+
+const (
+	ChunkEvent   ChatEventType = "chunk"
+	SourcesEvent ChatEventType = "sources"
+	// CorrectionEvent discards the chunks streamed before it, the response is generated again after it
+	CorrectionEvent ChatEventType = "correction"
+)
+
+// ChatEvent is streamed while a response is generated. All the events are chunks of the response
+// except the last one, which has the sources that the response cites, and a correction event when
+// the response is regenerated because its figures didn't match the market data.
+type ChatEvent struct {
+	Type    ChatEventType
+	Chunk   string
+	Sources []DataSource
 }
 
 type Topic string
@@ -43,14 +99,28 @@ const (
 	STOCK_FINANCIALS Topic = "stock_financials"
 	ETFS             Topic = "etfs"
 	NEWS             Topic = "news"
+	PORTFOLIO        Topic = "portfolio"
+	SUPER_INVESTORS  Topic = "super_investors"
 )
 
 type ChatService struct {
 	topicToRagMap         map[Topic]Rag
+	modelToRagMap         map[string]map[Topic]Rag // The rags of the other models that can regenerate a response
 	sessionService        SessionService
 	topicExtractorService TopicExtractorService
 	tagExtractorService   TagExtractorService
 	topicAndTagsRepo      TopicAndTagsRepository
+	conversationBuilder   ConversationBuilder
+	dataFetchTimes        DataFetchTimeService
+	compliance            ComplianceService
+	experiments           ExperimentService
+	conf                  ChatServiceConf
+}
+
+type ChatServiceConf struct {
+	// RegenerateOnFactCheckMismatch regenerates a response once, with the mismatches in the conversation,
+	// when its figures don't match the market data of the rag
+	RegenerateOnFactCheckMismatch bool
 }
 
 func NewChatService(
@@ -59,6 +129,12 @@ func NewChatService(
 	topicExtractorService TopicExtractorService,
 	tagExtractorService TagExtractorService,
 	topicAndTagsRepo TopicAndTagsRepository,
+	conversationBuilder ConversationBuilder,
+	modelToRagMap map[string]map[Topic]Rag,
+	dataFetchTimes DataFetchTimeService,
+	compliance ComplianceService,
+	experiments ExperimentService,
+	conf ChatServiceConf,
 ) (*ChatService, error) {
 	return &ChatService{
 		topicToRagMap:         topicToRagMap,
@@ -66,24 +142,62 @@ func NewChatService(
 		topicExtractorService: topicExtractorService,
 		tagExtractorService:   tagExtractorService,
 		topicAndTagsRepo:      topicAndTagsRepo,
+		conversationBuilder:   conversationBuilder,
+		modelToRagMap:         modelToRagMap,
+		dataFetchTimes:        dataFetchTimes,
+		compliance:            compliance,
+		experiments:           experiments,
+		conf:                  conf,
 	}, nil
 }
 
+// getRag returns the rag of the topic for the model, or for the default model if model is empty
+func (s *ChatService) getRag(topic Topic, model string) (Rag, error) {
+	topicToRagMap := s.topicToRagMap
+	if model != "" {
+		var found bool
+		topicToRagMap, found = s.modelToRagMap[model]
+		if !found {
+			return nil, errors.InvalidModelError{Model: model}
+		}
+	}
+
+	rag, found := topicToRagMap[topic]
+	if !found {
+		// Use default RAG in this case?
+		return nil, &errors.InvalidTopicError{Message: fmt.Sprintf("Invalid topic %s", topic)}
+	}
+
+	return rag, nil
+}
+
+// sessionRag returns the rag of the experiment variant of the session if there is an experiment on the
+// rag of the topic, otherwise the rag of the topic for the model. Responses regenerated by a chosen model
+// are not part of the experiments.
+func (s *ChatService) sessionRag(topic Topic, model string, sessionId string, userID string) (Rag, *Assignment, error) {
+	if model == "" && s.experiments != nil {
+		if rag, assignment, found := s.experiments.Rag(topic, sessionId, userID); found {
+			return rag, &assignment, nil
+		}
+	}
+
+	rag, err := s.getRag(topic, model)
+	return rag, nil, err
+}
+
 func (s *ChatService) GenerateResponse(
 	topic Topic,
 	tags Tags,
 	sessionId string,
 	question string,
-	responseChannel chan<- string,
+	responseChannel chan<- ChatEvent,
 ) error {
-	rag, found := s.topicToRagMap[topic]
-
-	if !found {
-		// Use default RAG in this case?
-		return &errors.InvalidTopicError{Message: fmt.Sprintf("Invalid topic %s", topic)}
+	rag, experiment, err := s.sessionRag(topic, "", sessionId, tags.UserID)
+	if err != nil {
+		return err
 	}
 
-	conversation, err := s.sessionService.GetConversationBySessionId(sessionId)
+	conversation, err := s.conversationBuilder.GetConversation(sessionId)
 	if err != nil {
 		return &errors.SessionNotFoundError{
 			Message: fmt.Sprintf("Conversation for session id: %s not found", sessionId),
@@ -91,24 +205,349 @@ func (s *ChatService) GenerateResponse(
 	}
 
 	questionMessage := Message{
-		Role: User, Content: question,
+		ID: uuid.NewString(), Role: User, Content: question,
 	}
 	s.sessionService.AddMessage(sessionId, questionMessage)
 	conversation = append(conversation, questionMessage)
 
+	return s.answer(rag, experiment, topic, tags, sessionId, conversation, responseChannel)
+}
+
+// RegenerateResponse replaces the last response of the session with a new one. If model is not empty
+// the response is generated by that model instead of the default one. The session must belong to the user.
+func (s *ChatService) RegenerateResponse(
+	topic Topic,
+	tags Tags,
+	sessionId string,
+	userID string,
+	model string,
+	responseChannel chan<- ChatEvent,
+) error {
+	tags.UserID = userID
+	rag, experiment, err := s.sessionRag(topic, model, sessionId, tags.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.authorizeSession(sessionId, userID); err != nil {
+		return err
+	}
+
+	messages, err := s.sessionService.GetMessages(sessionId)
+	if err != nil {
+		return err
+	}
+
+	if len(messages) == 0 || messages[len(messages)-1].Role != Assistant {
+		return errors.InvalidSessionOperationError{Message: "the last message of the session is not a response"}
+	}
+	lastResponse := messages[len(messages)-1]
+	if lastResponse.ID == "" {
+		return errors.InvalidSessionOperationError{Message: "messages stored before messages had ids can't be regenerated"}
+	}
+
+	restore, err := s.truncateConversation(sessionId, messages, len(messages)-1)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := s.conversationBuilder.GetConversation(sessionId)
+	if err != nil {
+		restore()
+		return err
+	}
+
+	if err := s.answer(rag, experiment, topic, tags, sessionId, conversation, responseChannel); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// EditMessage replaces a question of the session and generates its response again. All the messages
+// after the question are deleted. The session must belong to the user.
+func (s *ChatService) EditMessage(
+	topic Topic,
+	tags Tags,
+	sessionId string,
+	userID string,
+	messageId string,
+	question string,
+	responseChannel chan<- ChatEvent,
+) error {
+	tags.UserID = userID
+	if _, err := s.getRag(topic, ""); err != nil {
+		return err
+	}
+
+	if err := s.authorizeSession(sessionId, userID); err != nil {
+		return err
+	}
+
+	messages, err := s.sessionService.GetMessages(sessionId)
+	if err != nil {
+		return err
+	}
+
+	index := messageIndex(messages, messageId)
+	if messageId == "" || index == -1 {
+		return errors.MessageNotFoundError{SessionID: sessionId, MessageID: messageId}
+	}
+	if messages[index].Role != User {
+		return errors.InvalidSessionOperationError{Message: "only questions of the user can be edited"}
+	}
+
+	restore, err := s.truncateConversation(sessionId, messages, index)
+	if err != nil {
+		return err
+	}
+
+	if err := s.GenerateResponse(topic, tags, sessionId, question, responseChannel); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// authorizeSession checks that the session belongs to the user. Sessions created without
+// a user can be changed by anyone.
+func (s *ChatService) authorizeSession(sessionId string, userID string) error {
+	session, err := s.sessionService.GetSession(sessionId)
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != "" && session.UserID != userID {
+		return errors.SessionForbiddenError{SessionID: sessionId, UserID: userID}
+	}
+
+	return nil
+}
+
+// truncateConversation deletes the messages of the session from index on and returns a function that
+// restores the conversation as it was, so that a failed response doesn't lose the deleted messages
+func (s *ChatService) truncateConversation(sessionId string, messages []Message, index int) (func(), error) {
+	session, err := s.sessionService.GetSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionService.TruncateConversation(sessionId, messages[index].ID); err != nil {
+		return nil, err
+	}
+
+	restore := func() {
+		// The question of an edit is already stored when its response fails
+		current, err := s.sessionService.GetMessages(sessionId)
+		if err == nil && len(current) > index {
+			err = s.sessionService.TruncateConversation(sessionId, current[index].ID)
+		}
+		for _, message := range messages[index:] {
+			if err != nil {
+				break
+			}
+			err = s.sessionService.AddMessage(sessionId, message)
+		}
+		if err == nil && session.Summary != "" {
+			err = s.sessionService.SetSummary(sessionId, session.Summary, session.SummarizedMessages)
+		}
+		if err != nil {
+			log.Printf("Failed to restore the conversation of session %s: %s", sessionId, err.Error())
+		}
+	}
+
+	return restore, nil
+}
+
+// answer generates the response of the rag to the conversation, which ends with the question, and adds it
+// to the session. Questions refused by the compliance policy are answered with the refusal instead.
+// experiment is the experiment variant of the rag, if it's part of an experiment.
+func (s *ChatService) answer(
+	rag Rag,
+	experiment *Assignment,
+	topic Topic,
+	tags Tags,
+	sessionId string,
+	conversation []Message,
+	responseChannel chan<- ChatEvent,
+) error {
+	var questionVerdict PolicyVerdict
+	if s.compliance != nil {
+		question := conversation[len(conversation)-1].Content
+		questionVerdict = s.compliance.CheckQuestion(question, topic, sessionId, tags.UserID)
+		if questionVerdict.Refused {
+			s.refuse(topic, tags, sessionId, questionVerdict, responseChannel)
+			return nil
+		}
+	}
+
+	start := time.Now()
+	responseMessage, metadata, err := s.generate(rag, tags, conversation, responseChannel)
+	if err != nil {
+		return err
+	}
+
+	if s.conf.RegenerateOnFactCheckMismatch && metadata.FactCheck != nil && len(metadata.FactCheck.Mismatches) > 0 {
+		correctionQuestion, err := factCheckCorrection(metadata.FactCheck.Mismatches)
+		if err != nil {
+			return err
+		}
+		if responseChannel != nil {
+			responseChannel <- ChatEvent{Type: CorrectionEvent}
+		}
+
+		correction := append(conversation[:len(conversation):len(conversation)],
+			Message{Role: Assistant, Content: responseMessage},
+			Message{Role: User, Content: correctionQuestion},
+		)
+		responseMessage, metadata, err = s.generate(rag, tags, correction, responseChannel)
+		if err != nil {
+			return err
+		}
+		if metadata.FactCheck != nil {
+			metadata.FactCheck.Regenerated = true
+		}
+	}
+
+	if s.compliance != nil {
+		responseVerdict := s.compliance.CheckResponse(responseMessage, metadata.RagContext, topic, sessionId, tags.UserID)
+		metadata.Compliance = &ComplianceCheck{
+			Rules:      append(questionVerdict.Rules, responseVerdict.Rules...),
+			Disclaimer: s.disclaimer(tags.UserID, append(questionVerdict.Disclaimers, responseVerdict.Disclaimers...)),
+		}
+
+		// The disclaimer is only streamed and kept in the metadata, so that it's not sent back to the llm
+		if metadata.Compliance.Disclaimer != "" && responseChannel != nil {
+			responseChannel <- ChatEvent{Type: ChunkEvent, Chunk: "\n\n" + metadata.Compliance.Disclaimer}
+		}
+	}
+
+	metadata.Topic = topic
+	metadata.Tags = tags
+	metadata.Latency = time.Since(start)
+	metadata.Experiment = experiment
+	s.setFetchTimes(metadata.DataSources)
+
+	s.sessionService.AddMessage(sessionId, Message{
+		ID:       uuid.NewString(),
+		Role:     Assistant,
+		Content:  responseMessage,
+		Metadata: &metadata,
+	})
+
+	if responseChannel != nil {
+		responseChannel <- ChatEvent{Type: SourcesEvent, Sources: citedSources(responseMessage, metadata.DataSources)}
+		close(responseChannel)
+	}
+
+	if err := s.sessionService.AddTopic(sessionId, topic); err != nil {
+		log.Printf("Failed to add topic %s to session %s: %s", topic, sessionId, err.Error())
+	}
+
+	// Summarize the older turns in the background so that the response is not delayed
+	go func() {
+		if err := s.conversationBuilder.FoldConversation(sessionId); err != nil {
+			log.Printf("Failed to fold the conversation of session %s: %s", sessionId, err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// refuse answers the question with the refusal of the compliance policy
+func (s *ChatService) refuse(topic Topic, tags Tags, sessionId string, verdict PolicyVerdict, responseChannel chan<- ChatEvent) {
+	s.sessionService.AddMessage(sessionId, Message{
+		ID:      uuid.NewString(),
+		Role:    Assistant,
+		Content: verdict.Refusal,
+		Metadata: &MessageMetadata{
+			Topic:      topic,
+			Tags:       tags,
+			Compliance: &ComplianceCheck{Refused: true, Rules: verdict.Rules},
+		},
+	})
+
+	if responseChannel != nil {
+		responseChannel <- ChatEvent{Type: ChunkEvent, Chunk: verdict.Refusal}
+		responseChannel <- ChatEvent{Type: SourcesEvent, Sources: []DataSource{}}
+		close(responseChannel)
+	}
+}
+
+// disclaimer joins the messages of the matched disclaim rules and the disclaimer of the jurisdiction of the user
+func (s *ChatService) disclaimer(userID string, ruleMessages []string) string {
+	messages := make([]string, 0, len(ruleMessages)+1)
+	for _, message := range append(ruleMessages, s.compliance.Disclaimer(userID)) {
+		if message != "" && !slices.Contains(messages, message) {
+			messages = append(messages, message)
+		}
+	}
+	return strings.Join(messages, " ")
+}
+
+// generate streams the chunks of the response of the rag as events, without closing the response channel
+func (s *ChatService) generate(
+	rag Rag,
+	tags Tags,
+	conversation []Message,
+	responseChannel chan<- ChatEvent,
+) (string, MessageMetadata, error) {
+	chunkChan := make(chan string)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for chunk := range chunkChan {
+			if responseChannel != nil {
+				responseChannel <- ChatEvent{Type: ChunkEvent, Chunk: chunk}
+			}
+		}
+	}()
+
+	// The rags close the chunk channel before they return, so the metadata is received separately
+	metadataChan := make(chan MessageMetadata, 1)
 	responseMessage, err := streamChunks(
 		func(chunkChan chan<- string) error {
-			return rag.GenerateRagResponse(conversation, tags, chunkChan)
+			metadata, err := rag.GenerateRagResponse(conversation, tags, chunkChan)
+			metadataChan <- metadata
+			return err
 		},
-		responseChannel,
+		chunkChan,
 	)
 	if err != nil {
-		return err
+		// streamChunks only closes the channel when the response is complete
+		close(chunkChan)
+		<-forwarded
+		return "", MessageMetadata{}, err
 	}
+	<-forwarded
 
-	s.sessionService.AddMessage(sessionId, Message{Role: Assistant, Content: responseMessage})
+	return responseMessage, <-metadataChan, nil
+}
 
-	return nil
+// factCheckCorrection is the question that asks the rag to answer again without the mismatched figures
+func factCheckCorrection(mismatches []FactMismatch) (string, error) {
+	prompt, err := prompts.Render(prompts.FactCheckCorrection, prompts.Vars{"Mismatches": mismatches})
+	if err != nil {
+		return "", err
+	}
+	return prompt.Text, nil
+}
+
+// setFetchTimes replaces the fetch times of the data sources with the time their oldest data was
+// cached. Data that is not cached keeps the time the rag fetched it.
+func (s *ChatService) setFetchTimes(sources []DataSource) {
+	if s.dataFetchTimes == nil {
+		return
+	}
+
+	for i, source := range sources {
+		for _, key := range source.Keys {
+			storedAt, err := s.dataFetchTimes.GetStoredAt(key)
+			if err == nil && storedAt.Before(sources[i].FetchedAt) {
+				sources[i].FetchedAt = storedAt
+			}
+		}
+	}
 }
 
 func (s *ChatService) ExtractTopicAndTags(question string, sessionId string, userID string) (Topic, Tags, error) {
@@ -124,12 +563,28 @@ func (s *ChatService) ExtractTopicAndTags(question string, sessionId string, use
 	}
 	conversation = append(conversation, questionMessage)
 
-	topic, err := s.topicExtractorService.ExtractTopic(conversation, userID)
+	var experiments []Assignment
+	topicExtractor, tagExtractor := s.topicExtractorService, s.tagExtractorService
+	if s.experiments != nil {
+		if extractor, assignment, found := s.experiments.TopicExtractor(sessionId, userID); found {
+			topicExtractor = extractor
+			experiments = append(experiments, assignment)
+		}
+	}
+
+	topic, err := topicExtractor.ExtractTopic(conversation, userID)
 	if err != nil {
 		return "", Tags{}, err
 	}
 
-	tags, err := s.tagExtractorService.ExtractTags(topic, conversation, userID)
+	if s.experiments != nil {
+		if extractor, assignment, found := s.experiments.TagExtractor(topic, sessionId, userID); found {
+			tagExtractor = extractor
+			experiments = append(experiments, assignment)
+		}
+	}
+
+	tags, err := tagExtractor.ExtractTags(topic, conversation, userID)
 	if err != nil {
 		return "", Tags{}, err
 	}
@@ -142,6 +597,7 @@ func (s *ChatService) ExtractTopicAndTags(question string, sessionId string, use
 			question,
 			sessionId,
 			userID,
+			experiments,
 		)
 		if storeErr != nil {
 			log.Printf("StoreTopicAndTags failed with err: %s", storeErr.Error())
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/services/prompts"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stockAnalysisUrl = "https://stockanalysis.com"
+	dataromaUrl      = "https://www.dataroma.com"
+)
+
+// citationPattern matches the citations of the sources in a response, like [2] or [1, 3]
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// ragSources numbers the data sources of a rag context so that the llm can cite them. It's safe
+// to use from the go routines that build the context.
+type ragSources struct {
+	mu      sync.Mutex
+	sources []DataSource
+	data    []any // The market data of the blocks, for the fact check of the response
+}
+
+// block adds a data source and returns its numbered block for the rag context, for example
+//
+//	[2] AAPL quarterly income statements up to FY2024 Q4, stockanalysis.com
+//	| Datekey | FiscalYear | FiscalQuarter | Revenue | ...
+//
+// The data is rendered with the partial of the prompt templates, like prompts.FinancialStatementsData.
+// keys are the cache keys of the market data of the block.
+// The fetch time of the source is the current time until it's replaced with the time the data was cached.
+func (s *ragSources) block(label string, sourceUrl string, partial string, data any, keys ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := len(s.sources) + 1
+	s.sources = append(s.sources, DataSource{
+		ID:        id,
+		Label:     label,
+		Url:       sourceUrl,
+		Keys:      keys,
+		FetchedAt: time.Now(),
+	})
+	s.data = append(s.data, data)
+
+	return fmt.Sprintf("[%d] %s, %s\n%s\n\n", id, label, sourceSite(sourceUrl), renderBlockData(partial, data))
+}
+
+// renderBlockData renders the data of a block with its partial. A partial that fails, like a broken
+// override, falls back to the raw data so that the question can still be answered.
+func renderBlockData(partial string, data any) string {
+	text, err := prompts.RenderPartial(partial, data)
+	if err != nil {
+		log.Printf("Failed to render the block data: %s", err.Error())
+		return fmt.Sprintf("%+v", data)
+	}
+	return strings.TrimSpace(text)
+}
+
+func (s *ragSources) dataSources() []DataSource {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DataSource(nil), s.sources...)
+}
+
+// facts returns the numeric fields of the market data of the blocks
+func (s *ragSources) facts() map[string][]float64 {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return marketDataFacts(s.data...)
+}
+
+// sourceSite returns the host of the url without www, like stockanalysis.com
+func sourceSite(sourceUrl string) string {
+	u, err := url.Parse(sourceUrl)
+	if err != nil || u.Host == "" {
+		return sourceUrl
+	}
+	return strings.TrimPrefix(u.Host, "www.")
+}
+
+// fiscalPeriod formats the fiscal period of a financial statement, like FY2024 Q4
+func fiscalPeriod(fiscalYear string, fiscalQuarter string) string {
+	if fiscalYear == "" {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("FY%s %s", fiscalYear, fiscalQuarter))
+}
+
+// appendFiscalPeriod appends the most recent fiscal period of the statements to the label of their source
+func appendFiscalPeriod(label string, period string) string {
+	if period == "" {
+		return label
+	}
+	return fmt.Sprintf("%s up to %s", label, period)
+}
+
+// citedSources returns the sources that the response cites, ordered by their number
+func citedSources(response string, sources []DataSource) []DataSource {
+	cited := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(response, -1) {
+		for _, number := range strings.Split(match[1], ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(number))
+			if err == nil {
+				cited[id] = true
+			}
+		}
+	}
+
+	citations := make([]DataSource, 0, len(cited))
+	for _, source := range sources {
+		if cited[source.ID] {
+			citations = append(citations, source)
+		}
+	}
+	sort.Slice(citations, func(i, j int) bool { return citations[i].ID < citations[j].ID })
+
+	return citations
+}
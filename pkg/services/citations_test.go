@@ -0,0 +1,35 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"investbot/pkg/services/prompts"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRagSources_Block(t *testing.T) {
+	sources := &ragSources{}
+
+	performance := []stockHistoricalPerformance{{Period: domain.Period1Y, PercentageChange: 12.345}}
+	block := sources.block("AAPL price performance", "https://stockanalysis.com/stocks/aapl/history/", prompts.PricePerformanceData, performance, "stock_prices_aapl")
+	assert.Equal(t, "[1] AAPL price performance, stockanalysis.com\n| Period | PercentageChange |\n| --- | --- |\n| 1y | 12.35% |\n\n", block)
+
+	article := newsArticleContext{Title: "Apple beats estimates", Time: "2 hours ago", Text: "Revenue grew 6%."}
+	block = sources.block("Apple beats estimates (Reuters)", "https://www.reuters.com/apple", prompts.NewsArticleData, article, "stock_news_aapl")
+	assert.True(t, strings.HasPrefix(block, "[2] Apple beats estimates (Reuters), reuters.com\nTitle: Apple beats estimates\n"))
+
+	dataSources := sources.dataSources()
+	assert.Len(t, dataSources, 2)
+	assert.Equal(t, []string{"stock_news_aapl"}, dataSources[1].Keys)
+}
+
+func TestCitedSources(t *testing.T) {
+	sources := []DataSource{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+
+	cited := citedSources("Revenue grew 6% [3] while margins fell [1, 3]. See also [7] and [x].", sources)
+	assert.Equal(t, []DataSource{{ID: 1}, {ID: 3}}, cited)
+
+	assert.Empty(t, citedSources("No citations here.", sources))
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PolicyStage string
+
+const (
+	InputStage   PolicyStage = "input"   // The question of the user
+	ContextStage PolicyStage = "context" // The market data and news of the rag context
+	OutputStage  PolicyStage = "output"  // The generated response
+)
+
+type PolicyAction string
+
+const (
+	RefuseAction   PolicyAction = "refuse"   // The question is answered with the message of the rule
+	DisclaimAction PolicyAction = "disclaim" // The message of the rule is added to the disclaimer of the response
+	FlagAction     PolicyAction = "flag"     // The decision is only recorded
+)
+
+type PolicyCategory string
+
+const (
+	PersonalizedAdvice    PolicyCategory = "personalized_advice"
+	OffTopic              PolicyCategory = "off_topic"
+	PromptInjection       PolicyCategory = "prompt_injection"
+	UnsupportedInstrument PolicyCategory = "unsupported_instrument"
+)
+
+// PolicyRule matches the text of its stage with case insensitive regular expressions
+type PolicyRule struct {
+	Name     string         `json:"name"`
+	Category PolicyCategory `json:"category"`
+	Stage    PolicyStage    `json:"stage"`
+	Action   PolicyAction   `json:"action"`
+	Patterns []string       `json:"patterns"`
+	Message  string         `json:"message"`
+
+	patterns []*regexp.Regexp
+}
+
+// CompliancePolicy are the rules of the compliance guard and the disclaimers of the responses by
+// jurisdiction. The jurisdiction of a user is the tax region of their investor profile, like US-CA,
+// falling back to its country and then to the default jurisdiction.
+type CompliancePolicy struct {
+	DefaultJurisdiction string            `json:"default_jurisdiction"`
+	Disclaimers         map[string]string `json:"disclaimers"`
+	Rules               []PolicyRule      `json:"rules"`
+}
+
+//go:embed compliance_policy.json
+var defaultCompliancePolicy []byte
+
+// LoadCompliancePolicy reads the policy from the json file at path, or returns the default policy
+// if path is empty
+func LoadCompliancePolicy(path string) (CompliancePolicy, error) {
+	policyJson := defaultCompliancePolicy
+	if path != "" {
+		var err error
+		if policyJson, err = os.ReadFile(path); err != nil {
+			return CompliancePolicy{}, err
+		}
+	}
+
+	var policy CompliancePolicy
+	if err := json.Unmarshal(policyJson, &policy); err != nil {
+		return CompliancePolicy{}, fmt.Errorf("invalid compliance policy: %w", err)
+	}
+	return policy, nil
+}
+
+// PolicyDecision records that a rule matched
+type PolicyDecision struct {
+	Rule         string
+	Category     PolicyCategory
+	Stage        PolicyStage
+	Action       PolicyAction
+	Match        string // The matched text
+	Topic        Topic
+	SessionID    string
+	UserID       string
+	Jurisdiction string
+	CreatedAt    time.Time
+}
+
+type PolicyDecisionRepository interface {
+	StorePolicyDecision(decision PolicyDecision) error
+}
+
+// PolicyVerdict is the outcome of the rules of a stage
+type PolicyVerdict struct {
+	Refused     bool
+	Refusal     string   // The answer of a refused question
+	Disclaimers []string // Messages of the matched disclaim rules
+	Rules       []string // Names of the matched rules
+}
+
+// ComplianceGuard applies the compliance policy to the questions, the rag contexts and the responses
+type ComplianceGuard struct {
+	policy       CompliancePolicy
+	userContexts UserContextDataService
+	decisions    PolicyDecisionRepository
+}
+
+func NewComplianceGuard(
+	policy CompliancePolicy,
+	userContexts UserContextDataService,
+	decisions PolicyDecisionRepository,
+) (*ComplianceGuard, error) {
+	for i, rule := range policy.Rules {
+		switch rule.Stage {
+		case InputStage, ContextStage, OutputStage:
+		default:
+			return nil, fmt.Errorf("rule %s has an invalid stage %q", rule.Name, rule.Stage)
+		}
+		switch rule.Action {
+		case RefuseAction, DisclaimAction, FlagAction:
+		default:
+			return nil, fmt.Errorf("rule %s has an invalid action %q", rule.Name, rule.Action)
+		}
+
+		for _, pattern := range rule.Patterns {
+			compiled, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s has an invalid pattern: %w", rule.Name, err)
+			}
+			policy.Rules[i].patterns = append(policy.Rules[i].patterns, compiled)
+		}
+	}
+
+	return &ComplianceGuard{policy: policy, userContexts: userContexts, decisions: decisions}, nil
+}
+
+// CheckQuestion applies the input rules to the question. A question is refused with the message of
+// the first refuse rule that matches.
+func (g *ComplianceGuard) CheckQuestion(question string, topic Topic, sessionId string, userID string) PolicyVerdict {
+	return g.check(InputStage, question, topic, sessionId, userID, g.lazyJurisdiction(userID))
+}
+
+// CheckResponse applies the context rules to the rag context and the output rules to the response
+func (g *ComplianceGuard) CheckResponse(response string, ragContext string, topic Topic, sessionId string, userID string) PolicyVerdict {
+	jurisdiction := g.lazyJurisdiction(userID)
+	verdict := g.check(ContextStage, ragContext, topic, sessionId, userID, jurisdiction)
+	outputVerdict := g.check(OutputStage, response, topic, sessionId, userID, jurisdiction)
+
+	verdict.Disclaimers = append(verdict.Disclaimers, outputVerdict.Disclaimers...)
+	verdict.Rules = append(verdict.Rules, outputVerdict.Rules...)
+	return verdict
+}
+
+// Disclaimer returns the disclaimer of the jurisdiction of the user
+func (g *ComplianceGuard) Disclaimer(userID string) string {
+	return g.policy.Disclaimers[g.jurisdiction(userID)]
+}
+
+func (g *ComplianceGuard) check(
+	stage PolicyStage,
+	text string,
+	topic Topic,
+	sessionId string,
+	userID string,
+	jurisdiction func() string,
+) PolicyVerdict {
+	var verdict PolicyVerdict
+	for _, rule := range g.policy.Rules {
+		if rule.Stage != stage {
+			continue
+		}
+
+		match := rule.match(text)
+		if match == "" {
+			continue
+		}
+
+		verdict.Rules = append(verdict.Rules, rule.Name)
+		switch rule.Action {
+		case RefuseAction:
+			if !verdict.Refused {
+				verdict.Refused = true
+				verdict.Refusal = rule.Message
+			}
+		case DisclaimAction:
+			if rule.Message != "" {
+				verdict.Disclaimers = append(verdict.Disclaimers, rule.Message)
+			}
+		}
+
+		g.record(PolicyDecision{
+			Rule:         rule.Name,
+			Category:     rule.Category,
+			Stage:        stage,
+			Action:       rule.Action,
+			Match:        match,
+			Topic:        topic,
+			SessionID:    sessionId,
+			UserID:       userID,
+			Jurisdiction: jurisdiction(),
+			CreatedAt:    time.Now(),
+		})
+	}
+
+	return verdict
+}
+
+// record stores the decision in the background so that the response is not delayed
+func (g *ComplianceGuard) record(decision PolicyDecision) {
+	log.Printf("Policy rule %s (%s) matched %q in session %s", decision.Rule, decision.Action, decision.Match, decision.SessionID)
+	if g.decisions == nil {
+		return
+	}
+
+	go func() {
+		if err := g.decisions.StorePolicyDecision(decision); err != nil {
+			log.Printf("StorePolicyDecision failed with err: %s", err.Error())
+		}
+	}()
+}
+
+// lazyJurisdiction resolves the jurisdiction of the user when a rule matches, once for all the matched rules
+func (g *ComplianceGuard) lazyJurisdiction(userID string) func() string {
+	return sync.OnceValue(func() string {
+		return g.jurisdiction(userID)
+	})
+}
+
+// jurisdiction returns the tax region of the user if it has a disclaimer, then its country, then the
+// default jurisdiction
+func (g *ComplianceGuard) jurisdiction(userID string) string {
+	if userID == "" || g.userContexts == nil {
+		return g.policy.DefaultJurisdiction
+	}
+
+	userContext, err := g.userContexts.GetUserContext(userID)
+	if err != nil || userContext.InvestorProfile == nil {
+		return g.policy.DefaultJurisdiction
+	}
+
+	region := strings.ToUpper(userContext.InvestorProfile.TaxRegion)
+	country, _, _ := strings.Cut(region, "-")
+	for _, jurisdiction := range []string{region, country} {
+		if _, found := g.policy.Disclaimers[jurisdiction]; found && jurisdiction != "" {
+			return jurisdiction
+		}
+	}
+	return g.policy.DefaultJurisdiction
+}
+
+// match returns the first text matched by the patterns of the rule, shortened for the records
+func (r PolicyRule) match(text string) string {
+	for _, pattern := range r.patterns {
+		if match := pattern.FindString(text); match != "" {
+			if len(match) > 200 {
+				match = match[:200]
+			}
+			return match
+		}
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"investbot/pkg/services/prompts"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// Context window sizes in tokens of the models we use. Models that are not listed use defaultModelContextTokens.
+var modelContextTokens = map[string]int{
+	"gpt-4o-mini":      128000,
+	"gpt-4o":           128000,
+	"gemini-2.0-flash": 1048576,
+	"llama3.2":         4096,
+}
+
+const (
+	defaultModelContextTokens = 8192
+	maxHistoryTokens          = 16000
+	maxResponseTokens         = 2048
+	// Tokens added by the chat format to every message
+	messageOverheadTokens = 4
+)
+
+// ContextBudget splits the context window of the model between the prompt, the conversation history and the response
+type ContextBudget struct {
+	ContextTokens  int // Size of the context window of the model, 0 disables the budget
+	ResponseTokens int // Tokens reserved for the response
+	HistoryTokens  int // Maximum tokens of conversation history, older turns are folded into the session summary
+}
+
+// NewContextBudget returns the budget of the model. If contextTokens is not positive the known
+// context window of the model is used.
+func NewContextBudget(modelName string, contextTokens int) ContextBudget {
+	if contextTokens <= 0 {
+		var found bool
+		contextTokens, found = modelContextTokens[modelName]
+		if !found {
+			contextTokens = defaultModelContextTokens
+		}
+	}
+
+	return ContextBudget{
+		ContextTokens:  contextTokens,
+		ResponseTokens: min(contextTokens/4, maxResponseTokens),
+		HistoryTokens:  min(contextTokens/4, maxHistoryTokens),
+	}
+}
+
+// estimateTokens estimates the tokens of the text with the usual approximation of 4 characters per token.
+// It's not exact but it doesn't depend on the tokenizer of every model.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func estimateMessageTokens(msg Message) int {
+	return estimateTokens(msg.Content) + messageOverheadTokens
+}
+
+func estimateConversationTokens(conversation []Message) int {
+	tokens := 0
+	for _, msg := range conversation {
+		tokens += estimateMessageTokens(msg)
+	}
+	return tokens
+}
+
+// truncateToTokens cuts the text to about the given number of tokens
+func truncateToTokens(text string, tokens int) string {
+	runes := []rune(text)
+	if len(runes) <= tokens*4 {
+		return text
+	}
+	return string(runes[:max(tokens, 0)*4])
+}
+
+const truncatedContextNote = "\n...[context truncated to fit the context window of the model]\n"
+
+// fit returns the prompt and the conversation that fit in the budget. The prompt instructions and the
+// last message of the conversation are always kept. Then the newest history messages, and a leading
+// summary message, are kept up to the history budget, and the rag context, which must be part of the prompt,
+// is truncated to the tokens that are left.
+func (b ContextBudget) fit(prompt string, ragContext string, conversation []Message) (string, []Message) {
+	if b.ContextTokens <= 0 || len(conversation) == 0 {
+		return prompt, conversation
+	}
+
+	question := conversation[len(conversation)-1]
+	history := conversation[:len(conversation)-1]
+
+	available := b.ContextTokens - b.ResponseTokens -
+		(estimateTokens(prompt) - estimateTokens(ragContext) + messageOverheadTokens) -
+		estimateMessageTokens(question)
+	historyBudget := min(b.HistoryTokens, available)
+
+	var summary []Message
+	if len(history) > 0 && history[0].Role == System && estimateMessageTokens(history[0]) <= historyBudget {
+		summary = history[:1]
+		history = history[1:]
+		historyBudget -= estimateMessageTokens(summary[0])
+	}
+
+	historyTokens := 0
+	start := len(history)
+	for start > 0 {
+		tokens := estimateMessageTokens(history[start-1])
+		if historyTokens+tokens > historyBudget {
+			break
+		}
+		historyTokens += tokens
+		start--
+	}
+
+	fitted := make([]Message, 0, len(summary)+len(history)-start+1)
+	fitted = append(fitted, summary...)
+	fitted = append(fitted, history[start:]...)
+	fitted = append(fitted, question)
+
+	ragBudget := available - estimateConversationTokens(fitted[:len(fitted)-1])
+	if ragContext != "" && estimateTokens(ragContext) > ragBudget {
+		truncated := truncateToTokens(ragContext, ragBudget-estimateTokens(truncatedContextNote)) + truncatedContextNote
+		prompt = strings.Replace(prompt, ragContext, truncated, 1)
+	}
+
+	return prompt, fitted
+}
+
+// ConversationSummarizer keeps the conversation history of the sessions inside the budget by folding
+// the older turns into a running summary that is stored with the session
+type ConversationSummarizer struct {
+	sessionService SessionService
+	llm            Llm
+	responseStore  RagResponsesRepository
+	budget         ContextBudget
+	convMsgLimit   int
+}
+
+func NewConversationSummarizer(
+	sessionService SessionService,
+	llm Llm,
+	responseStore RagResponsesRepository,
+	budget ContextBudget,
+	convMsgLimit int,
+) (*ConversationSummarizer, error) {
+	return &ConversationSummarizer{
+		sessionService: sessionService,
+		llm:            llm,
+		responseStore:  responseStore,
+		budget:         budget,
+		convMsgLimit:   convMsgLimit,
+	}, nil
+}
+
+// unsummarizedMessages returns the messages of the conversation window that are not part of the summary
+func (s *ConversationSummarizer) unsummarizedMessages(sessionId string) (Session, []Message, int, error) {
+	session, err := s.sessionService.GetSession(sessionId)
+	if err != nil {
+		return Session{}, nil, 0, err
+	}
+
+	conversation, err := s.sessionService.GetConversationBySessionId(sessionId)
+	if err != nil {
+		return Session{}, nil, 0, err
+	}
+
+	// Position of the first message of the window in the whole conversation
+	firstIndex := max(session.MessageCount-len(conversation), 0)
+	skip := min(max(session.SummarizedMessages-firstIndex, 0), len(conversation))
+
+	return session, conversation[skip:], firstIndex + skip, nil
+}
+
+// GetConversation returns the summary of the older turns, as a system message, followed by the turns
+// that are not summarized yet
+func (s *ConversationSummarizer) GetConversation(sessionId string) ([]Message, error) {
+	session, messages, _, err := s.unsummarizedMessages(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Summary == "" {
+		return messages, nil
+	}
+
+	summaryMsg := Message{Role: System, Content: "Summary of the earlier conversation:\n" + session.Summary}
+	return append([]Message{summaryMsg}, messages...), nil
+}
+
+// FoldConversation summarizes the oldest turns when the history is about to exceed the message limit,
+// since every turn adds two messages, or the history budget. The newest half of the limit and the
+// budget is kept as is.
+func (s *ConversationSummarizer) FoldConversation(sessionId string) error {
+	session, messages, firstIndex, err := s.unsummarizedMessages(sessionId)
+	if err != nil {
+		return err
+	}
+
+	overLimit := s.convMsgLimit > 0 && len(messages)+2 > s.convMsgLimit
+	overBudget := s.budget.HistoryTokens > 0 && estimateConversationTokens(messages) > s.budget.HistoryTokens
+	if !overLimit && !overBudget {
+		return nil
+	}
+
+	keep, keptTokens := 0, 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		tokens := estimateMessageTokens(messages[i])
+		if s.convMsgLimit > 0 && keep+1 > s.convMsgLimit/2 {
+			break
+		}
+		if s.budget.HistoryTokens > 0 && keptTokens+tokens > s.budget.HistoryTokens/2 {
+			break
+		}
+		keep++
+		keptTokens += tokens
+	}
+
+	toFold := messages[:len(messages)-keep]
+	if len(toFold) == 0 {
+		return nil
+	}
+
+	summary, err := s.summarize(session.Summary, toFold)
+	if err != nil {
+		return err
+	}
+
+	return s.sessionService.SetSummary(sessionId, summary, firstIndex+len(toFold))
+}
+
+func (s *ConversationSummarizer) summarize(currentSummary string, messages []Message) (string, error) {
+	prompt, err := prompts.Render(prompts.ConversationSummary, prompts.Vars{
+		"Summary":  currentSummary,
+		"Messages": messages,
+	})
+	if err != nil {
+		return "", err
+	}
+	promptMsg := Message{
+		Role:    System,
+		Content: prompt.Text,
+	}
+
+	responseMessage, err := streamChunks(
+		func(chunkChan chan<- string) error {
+			return s.llm.GenerateResponse([]Message{promptMsg}, chunkChan)
+		},
+		nil, // no need to stream the summary
+	)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		storeErr := s.responseStore.StoreRagResponse(RagResponse{
+			ModelName:     s.llm.GetLlmName(),
+			Topic:         "ConversationSummary",
+			Conversation:  []Message{promptMsg},
+			Response:      responseMessage,
+			PromptName:    prompt.Name,
+			PromptVersion: prompt.Version,
+		})
+		if storeErr != nil {
+			log.Printf("Failed to store conversation summary rag response: %s", storeErr.Error())
+		}
+	}()
+
+	return strings.TrimSpace(responseMessage), nil
+}
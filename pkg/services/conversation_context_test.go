@@ -0,0 +1,93 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLlm struct {
+	response string
+	prompts  []string
+}
+
+func (l *fakeLlm) GenerateResponse(conversation []Message, responseChannel chan<- string) error {
+	l.prompts = append(l.prompts, conversation[0].Content)
+	responseChannel <- l.response
+	close(responseChannel)
+	return nil
+}
+
+func (l *fakeLlm) GetLlmName() string {
+	return "fake"
+}
+
+type fakeRagResponsesRepository struct{}
+
+func (fakeRagResponsesRepository) StoreRagResponse(RagResponse) error {
+	return nil
+}
+
+func TestContextBudget_Fit(t *testing.T) {
+	budget := ContextBudget{ContextTokens: 200, ResponseTokens: 50, HistoryTokens: 40}
+	ragContext := strings.Repeat("x", 400) // 100 tokens
+	prompt := "## CONTEXT:\n" + ragContext + "\nAnswer the question"
+
+	conversation := []Message{
+		{Role: System, Content: "Summary of the earlier conversation:\nuser owns AAPL"},
+		{Role: User, Content: strings.Repeat("a", 60)},
+		{Role: Assistant, Content: strings.Repeat("b", 60)},
+		{Role: User, Content: "Should I buy more?"},
+	}
+
+	fittedPrompt, fitted := budget.fit(prompt, ragContext, conversation)
+
+	// The summary and the newest history message fit in the 40 tokens of history
+	assert.Equal(t, []Message{conversation[0], conversation[2], conversation[3]}, fitted)
+	assert.Contains(t, fittedPrompt, truncatedContextNote)
+	assert.True(t, strings.HasSuffix(fittedPrompt, "Answer the question"))
+
+	total := estimateTokens(fittedPrompt) + messageOverheadTokens + estimateConversationTokens(fitted)
+	assert.LessOrEqual(t, total, budget.ContextTokens-budget.ResponseTokens)
+
+	// Without a budget nothing changes
+	unchangedPrompt, unchanged := ContextBudget{}.fit(prompt, ragContext, conversation)
+	assert.Equal(t, prompt, unchangedPrompt)
+	assert.Equal(t, conversation, unchanged)
+}
+
+func TestConversationSummarizer_FoldConversation(t *testing.T) {
+	sessionService, _ := NewInMemorySession(6)
+	llm := &fakeLlm{response: " The user asked about AAPL and MSFT. "}
+	summarizer, _ := NewConversationSummarizer(sessionService, llm, fakeRagResponsesRepository{}, ContextBudget{}, 6)
+
+	sessionID, _ := sessionService.CreateNewSession("user")
+	for _, content := range []string{"q1", "a1", "q2", "a2"} {
+		assert.NoError(t, sessionService.AddMessage(sessionID, Message{Role: User, Content: content}))
+	}
+
+	// 4 messages and the next turn fit in the limit of 6
+	assert.NoError(t, summarizer.FoldConversation(sessionID))
+	assert.Empty(t, llm.prompts)
+
+	for _, content := range []string{"q3", "a3"} {
+		assert.NoError(t, sessionService.AddMessage(sessionID, Message{Role: User, Content: content}))
+	}
+	assert.NoError(t, summarizer.FoldConversation(sessionID))
+	assert.Len(t, llm.prompts, 1)
+	assert.Contains(t, llm.prompts[0], "user: q1\nuser: a1\nuser: q2\n")
+
+	session, _ := sessionService.GetSession(sessionID)
+	assert.Equal(t, "The user asked about AAPL and MSFT.", session.Summary)
+	assert.Equal(t, 3, session.SummarizedMessages)
+
+	conversation, err := summarizer.GetConversation(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{
+		{Role: System, Content: "Summary of the earlier conversation:\nThe user asked about AAPL and MSFT."},
+		{Role: User, Content: "a2"},
+		{Role: User, Content: "q3"},
+		{Role: User, Content: "a3"},
+	}, conversation)
+}
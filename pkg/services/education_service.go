@@ -1,7 +1,6 @@
 package services
 
 import (
-	"fmt"
 	"investbot/pkg/domain"
 	"investbot/pkg/services/prompts"
 )
@@ -24,19 +23,21 @@ func NewEducationRag(
 	return &rag, nil
 }
 
-func (rag EducationRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
-	var prompt string
+func (rag EducationRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	var userContext domain.UserContext
 	var err error
 
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt = fmt.Sprintf(prompts.EducationPrompt, userContext)
+	prompt, err := rag.renderPrompt(prompts.Education, prompts.Vars{"UserContext": renderUserContext(userContext)})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, "", nil, conversation, responseChannel)
 }
@@ -12,14 +12,6 @@ type EtfDataService interface {
 	GetEtfOverview(symbol string) (domain.EtfOverview, error)
 }
 
-type etfContext struct {
-	etf domain.Etf
-}
-
-type etfOverviewContext struct {
-	etfOverview domain.EtfOverview
-}
-
 type EtfRag struct {
 	BaseRag
 	dataService        EtfDataService
@@ -43,7 +35,7 @@ func NewEtfRag(
 	return &rag, nil
 }
 
-func (rag EtfRag) createRagContext(etfSymbols []string) (string, error) {
+func (rag EtfRag) createRagContext(etfSymbols []string, sources *ragSources) (string, error) {
 	var ragContext string
 
 	if len(etfSymbols) > 0 {
@@ -52,8 +44,14 @@ func (rag EtfRag) createRagContext(etfSymbols []string) (string, error) {
 			if err != nil {
 				return ragContext, &DataServiceError{Message: fmt.Sprintf("GetEtfOverview failed: %s", err)}
 			}
-			context := etfOverviewContext{etfOverview: etfOverview}
-			ragContext += fmt.Sprintf("%+v\n", context)
+			etfOverview.Description = rag.untrustedText(etfOverview.Description, fmt.Sprintf("the %s ETF overview", etfSymbol))
+			ragContext += sources.block(
+				fmt.Sprintf("%s ETF overview", strings.ToUpper(etfSymbol)),
+				fmt.Sprintf("%s/etf/%s/", stockAnalysisUrl, strings.ToLower(etfSymbol)),
+				prompts.EtfOverviewData,
+				etfOverview,
+				EtfOverviewDataKey(etfSymbol),
+			)
 		}
 		return ragContext, nil
 	}
@@ -63,37 +61,42 @@ func (rag EtfRag) createRagContext(etfSymbols []string) (string, error) {
 		return ragContext, &DataServiceError{Message: fmt.Sprintf("GetEtfs failed: %s", err)}
 	}
 
-	var context etfContext
+	largeEtfs := make([]domain.Etf, 0)
 	for _, etf := range etfs {
-		// Because of the huge number of ETFs we keep only the ones
-		// with aum higher than 2.5B
 		if etf.Aum > 2500000000 {
-			context.etf = etf
-			ragContext += fmt.Sprintf("%+v\n", context)
+			largeEtfs = append(largeEtfs, etf)
 		}
 	}
+	ragContext += sources.block("ETFs with more than $2.5B of assets", stockAnalysisUrl+"/etf/", prompts.EtfsData, largeEtfs, EtfsDataKey)
 
 	return ragContext, nil
 }
 
-func (rag EtfRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+func (rag EtfRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags.EtfSymbols)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.EtfSymbols, sources)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
 	var userContext domain.UserContext
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.EtfsPrompt, ragContext, userContext)
+	prompt, err := rag.renderPrompt(prompts.Etfs, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
 
 type EtfService struct {
@@ -0,0 +1,511 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"investbot/pkg/errors"
+	"investbot/pkg/services/prompts"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+type ExperimentTarget string
+
+const (
+	TopicExtractorTarget    ExperimentTarget = "topic_extractor"
+	TagExtractorTarget      ExperimentTarget = "tag_extractor"
+	RagTarget               ExperimentTarget = "rag"
+	FollowUpQuestionsTarget ExperimentTarget = "follow_up_questions"
+)
+
+// AssignmentUnit is what is assigned to a variant, all the requests of a user or a session get the same variant
+type AssignmentUnit string
+
+const (
+	UserUnit    AssignmentUnit = "user" // Requests without a user are assigned by their session
+	SessionUnit AssignmentUnit = "session"
+)
+
+type ExperimentVariant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"` // Share of the users or sessions, relative to the weights of the other variants
+	Model  string `json:"model"`  // Model of the llm provider, empty uses the default model
+	// PromptVersions are the versions of the prompts by prompt name, the other prompts use their configured version
+	PromptVersions map[string]string `json:"prompt_versions"`
+	// Dollars per million tokens, to compare the token cost of the variants
+	PromptTokenPrice   float64 `json:"prompt_token_price"`
+	ResponseTokenPrice float64 `json:"response_token_price"`
+}
+
+// Experiment splits the requests of a component between variants of its model and prompts
+type Experiment struct {
+	Name   string           `json:"name"`
+	Target ExperimentTarget `json:"target"`
+	// Topic limits rag and tag extractor experiments to a topic, empty applies to all the topics
+	Topic    Topic               `json:"topic"`
+	Unit     AssignmentUnit      `json:"unit"`
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+// LoadExperiments reads the experiments from the json file at path, there are no experiments if path is empty
+func LoadExperiments(path string) ([]Experiment, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	experimentsJson, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var experiments []Experiment
+	if err := json.Unmarshal(experimentsJson, &experiments); err != nil {
+		return nil, fmt.Errorf("invalid experiments: %w", err)
+	}
+	return experiments, nil
+}
+
+// Assign returns the variant of the user or the session. The assignment only depends on the name of the
+// experiment and the id, so it's stable across requests and restarts as long as the variants don't change.
+func (e Experiment) Assign(sessionID string, userID string) ExperimentVariant {
+	id := sessionID
+	if e.Unit == UserUnit && userID != "" {
+		id = userID
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(e.Name + ":" + id))
+
+	totalWeight := 0
+	for _, variant := range e.Variants {
+		totalWeight += variant.Weight
+	}
+
+	bucket := int(hash.Sum32() % uint32(totalWeight))
+	for _, variant := range e.Variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return e.Variants[len(e.Variants)-1]
+}
+
+func (e Experiment) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("experiments must have a name")
+	}
+	switch e.Target {
+	case TopicExtractorTarget, FollowUpQuestionsTarget:
+		if e.Topic != "" {
+			return fmt.Errorf("experiment %s: %s experiments can't have a topic", e.Name, e.Target)
+		}
+	case TagExtractorTarget, RagTarget:
+	default:
+		return fmt.Errorf("experiment %s has an invalid target %q", e.Name, e.Target)
+	}
+	if e.Unit != UserUnit && e.Unit != SessionUnit {
+		return fmt.Errorf("experiment %s has an invalid unit %q", e.Name, e.Unit)
+	}
+
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("experiment %s has no variants", e.Name)
+	}
+	names := make([]string, 0, len(e.Variants))
+	for _, variant := range e.Variants {
+		if variant.Name == "" || slices.Contains(names, variant.Name) {
+			return fmt.Errorf("experiment %s: variants must have unique names", e.Name)
+		}
+		names = append(names, variant.Name)
+
+		if variant.Weight <= 0 {
+			return fmt.Errorf("experiment %s: variant %s must have a positive weight", e.Name, variant.Name)
+		}
+		for prompt, version := range variant.PromptVersions {
+			if !prompts.HasVersion(prompt, version) {
+				return fmt.Errorf("experiment %s: variant %s uses prompt %s %s that doesn't exist", e.Name, variant.Name, prompt, version)
+			}
+		}
+	}
+	return nil
+}
+
+// Assignment is the variant of an experiment that handled a request
+type Assignment struct {
+	Experiment string
+	Variant    string
+}
+
+// ExperimentObservation records a request handled by a variant
+type ExperimentObservation struct {
+	Experiment     string
+	Variant        string
+	Target         ExperimentTarget
+	Topic          Topic
+	Latency        time.Duration
+	PromptTokens   int // Estimated, the llms don't report their usage
+	ResponseTokens int // Estimated, the llms don't report their usage
+	// Success is false when the llm failed or, for the extractors and the follow up questions, when its
+	// response couldn't be parsed
+	Success   bool
+	CreatedAt time.Time
+}
+
+type ExperimentObservationRepository interface {
+	StoreExperimentObservation(observation ExperimentObservation) error
+	GetExperimentObservations(experiment string) ([]ExperimentObservation, error)
+}
+
+// VariantFeedback counts the ratings of the responses of a variant
+type VariantFeedback struct {
+	Positive int
+	Negative int
+}
+
+type VariantFeedbackRepository interface {
+	// GetVariantFeedback returns the feedback of the experiment by variant name
+	GetVariantFeedback(experiment string) (map[string]VariantFeedback, error)
+}
+
+// ExperimentVariantSetter is implemented by the components that can be experimented on
+type ExperimentVariantSetter interface {
+	SetExperimentVariant(assignment Assignment, promptVersions map[string]string, observations ExperimentObservationRepository)
+}
+
+// experimentVariant is embedded by the components that can be experimented on. The components of a
+// variant render the prompt versions of the variant, record the variant with their rag responses and
+// record an observation for every llm call.
+type experimentVariant struct {
+	assignment     Assignment
+	promptVersions map[string]string
+	observations   ExperimentObservationRepository
+}
+
+func (v *experimentVariant) SetExperimentVariant(
+	assignment Assignment,
+	promptVersions map[string]string,
+	observations ExperimentObservationRepository,
+) {
+	v.assignment = assignment
+	v.promptVersions = promptVersions
+	v.observations = observations
+}
+
+// renderPrompt renders the version of the prompt of the variant, or the configured version
+func (v experimentVariant) renderPrompt(name string, vars prompts.Vars) (prompts.Prompt, error) {
+	if version, found := v.promptVersions[name]; found {
+		return prompts.RenderVersion(name, version, vars)
+	}
+	return prompts.Render(name, vars)
+}
+
+// observe records the llm call in the background, components that are not part of an experiment don't record it
+func (v experimentVariant) observe(
+	target ExperimentTarget,
+	topic Topic,
+	prompt []Message,
+	response string,
+	latency time.Duration,
+	success bool,
+) {
+	if v.observations == nil || v.assignment.Experiment == "" {
+		return
+	}
+
+	observation := ExperimentObservation{
+		Experiment:     v.assignment.Experiment,
+		Variant:        v.assignment.Variant,
+		Target:         target,
+		Topic:          topic,
+		Latency:        latency,
+		PromptTokens:   estimateConversationTokens(prompt),
+		ResponseTokens: estimateTokens(response),
+		Success:        success,
+		CreatedAt:      time.Now(),
+	}
+	go func() {
+		if err := v.observations.StoreExperimentObservation(observation); err != nil {
+			log.Printf("StoreExperimentObservation failed with err: %s", err.Error())
+		}
+	}()
+}
+
+// VariantComponents are the components built for a variant. Only the component of the target of the
+// experiment is used, for rag experiments the rags of all the topics of the experiment.
+type VariantComponents struct {
+	TopicExtractor    TopicExtractorService
+	TagExtractor      TagExtractorService
+	Rags              map[Topic]Rag
+	FollowUpQuestions FollowUpQuestionsRag
+}
+
+// ExperimentRouter routes the requests of the experimented components to the components of their variant
+type ExperimentRouter struct {
+	experiments  []Experiment
+	components   map[Assignment]VariantComponents
+	observations ExperimentObservationRepository
+	feedback     VariantFeedbackRepository
+}
+
+// NewExperimentRouter validates the experiments and builds the components of every variant with build.
+// There can be one experiment per target, and per topic for rag and tag extractor experiments.
+func NewExperimentRouter(
+	experiments []Experiment,
+	build func(experiment Experiment, variant ExperimentVariant) (VariantComponents, error),
+	observations ExperimentObservationRepository,
+) (*ExperimentRouter, error) {
+	router := &ExperimentRouter{
+		experiments:  experiments,
+		components:   make(map[Assignment]VariantComponents),
+		observations: observations,
+	}
+
+	for i, experiment := range experiments {
+		if err := experiment.validate(); err != nil {
+			return nil, err
+		}
+		for _, other := range experiments[:i] {
+			if other.Name == experiment.Name {
+				return nil, fmt.Errorf("there is more than one experiment named %s", experiment.Name)
+			}
+			if other.Target == experiment.Target && other.Topic == experiment.Topic {
+				return nil, fmt.Errorf("experiments %s and %s have the same target and topic", other.Name, experiment.Name)
+			}
+		}
+
+		for _, variant := range experiment.Variants {
+			components, err := build(experiment, variant)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build variant %s of experiment %s: %w", variant.Name, experiment.Name, err)
+			}
+
+			assignment := Assignment{Experiment: experiment.Name, Variant: variant.Name}
+			components, err = router.setVariant(experiment, assignment, variant.PromptVersions, components)
+			if err != nil {
+				return nil, err
+			}
+			router.components[assignment] = components
+		}
+	}
+
+	return router, nil
+}
+
+// setVariant sets the variant on the component of the target of the experiment and drops the others
+func (r *ExperimentRouter) setVariant(
+	experiment Experiment,
+	assignment Assignment,
+	promptVersions map[string]string,
+	components VariantComponents,
+) (VariantComponents, error) {
+	set := func(component any) bool {
+		setter, ok := component.(ExperimentVariantSetter)
+		if ok {
+			setter.SetExperimentVariant(assignment, promptVersions, r.observations)
+		}
+		return ok
+	}
+
+	var variantComponents VariantComponents
+	switch experiment.Target {
+	case TopicExtractorTarget:
+		if !set(components.TopicExtractor) {
+			return VariantComponents{}, fmt.Errorf("experiment %s has no topic extractor that can be experimented on", experiment.Name)
+		}
+		variantComponents.TopicExtractor = components.TopicExtractor
+	case TagExtractorTarget:
+		if !set(components.TagExtractor) {
+			return VariantComponents{}, fmt.Errorf("experiment %s has no tag extractor that can be experimented on", experiment.Name)
+		}
+		variantComponents.TagExtractor = components.TagExtractor
+	case FollowUpQuestionsTarget:
+		if !set(components.FollowUpQuestions) {
+			return VariantComponents{}, fmt.Errorf("experiment %s has no follow up questions rag that can be experimented on", experiment.Name)
+		}
+		variantComponents.FollowUpQuestions = components.FollowUpQuestions
+	case RagTarget:
+		// Rags that can't be experimented on keep answering with the default rag of their topic
+		variantComponents.Rags = make(map[Topic]Rag)
+		for topic, rag := range components.Rags {
+			if (experiment.Topic == "" || experiment.Topic == topic) && set(rag) {
+				variantComponents.Rags[topic] = rag
+			}
+		}
+		if len(variantComponents.Rags) == 0 {
+			return VariantComponents{}, fmt.Errorf("experiment %s has no rag that can be experimented on", experiment.Name)
+		}
+	}
+	return variantComponents, nil
+}
+
+// SetVariantFeedback sets the feedback that the reports compare the variants by
+func (r *ExperimentRouter) SetVariantFeedback(feedback VariantFeedbackRepository) {
+	r.feedback = feedback
+}
+
+// find returns the experiment of the target for the topic, or the one for all the topics
+func (r *ExperimentRouter) find(target ExperimentTarget, topic Topic) (Experiment, bool) {
+	var found *Experiment
+	for i, experiment := range r.experiments {
+		if experiment.Target != target {
+			continue
+		}
+		if experiment.Topic == topic {
+			return experiment, true
+		}
+		if experiment.Topic == "" {
+			found = &r.experiments[i]
+		}
+	}
+	if found == nil {
+		return Experiment{}, false
+	}
+	return *found, true
+}
+
+func (r *ExperimentRouter) assign(target ExperimentTarget, topic Topic, sessionID string, userID string) (VariantComponents, Assignment, bool) {
+	experiment, found := r.find(target, topic)
+	if !found {
+		return VariantComponents{}, Assignment{}, false
+	}
+
+	assignment := Assignment{Experiment: experiment.Name, Variant: experiment.Assign(sessionID, userID).Name}
+	return r.components[assignment], assignment, true
+}
+
+// TopicExtractor returns the topic extractor of the variant of the session, if there is an experiment on it
+func (r *ExperimentRouter) TopicExtractor(sessionID string, userID string) (TopicExtractorService, Assignment, bool) {
+	components, assignment, found := r.assign(TopicExtractorTarget, "", sessionID, userID)
+	return components.TopicExtractor, assignment, found
+}
+
+// TagExtractor returns the tag extractor of the variant of the session for the topic
+func (r *ExperimentRouter) TagExtractor(topic Topic, sessionID string, userID string) (TagExtractorService, Assignment, bool) {
+	components, assignment, found := r.assign(TagExtractorTarget, topic, sessionID, userID)
+	return components.TagExtractor, assignment, found
+}
+
+// Rag returns the rag of the variant of the session for the topic
+func (r *ExperimentRouter) Rag(topic Topic, sessionID string, userID string) (Rag, Assignment, bool) {
+	components, assignment, found := r.assign(RagTarget, topic, sessionID, userID)
+	if !found {
+		return nil, Assignment{}, false
+	}
+
+	rag, found := components.Rags[topic]
+	return rag, assignment, found
+}
+
+// FollowUpQuestions returns the follow up questions rag of the variant of the session
+func (r *ExperimentRouter) FollowUpQuestions(sessionID string, userID string) (FollowUpQuestionsRag, Assignment, bool) {
+	components, assignment, found := r.assign(FollowUpQuestionsTarget, "", sessionID, userID)
+	return components.FollowUpQuestions, assignment, found
+}
+
+func (r *ExperimentRouter) Experiments() []Experiment {
+	return r.experiments
+}
+
+// VariantReport compares a variant with the other variants of its experiment
+type VariantReport struct {
+	Variant             string
+	Model               string
+	PromptVersions      map[string]string
+	Observations        int
+	SuccessRate         float64
+	AvgLatency          time.Duration
+	P95Latency          time.Duration
+	AvgPromptTokens     float64
+	AvgResponseTokens   float64
+	AvgCost             float64 // Dollars per request, from the token prices of the variant
+	Feedback            VariantFeedback
+	PositiveFeedbackPct float64 // Percentage of the rated responses that were rated positively
+}
+
+type ExperimentReport struct {
+	Experiment Experiment
+	Variants   []VariantReport
+}
+
+// Report aggregates the observations and the feedback of the experiment by variant
+func (r *ExperimentRouter) Report(name string) (ExperimentReport, error) {
+	index := slices.IndexFunc(r.experiments, func(e Experiment) bool { return e.Name == name })
+	if index == -1 {
+		return ExperimentReport{}, errors.ExperimentNotFoundError{Experiment: name}
+	}
+	experiment := r.experiments[index]
+
+	var observations []ExperimentObservation
+	if r.observations != nil {
+		var err error
+		if observations, err = r.observations.GetExperimentObservations(name); err != nil {
+			return ExperimentReport{}, err
+		}
+	}
+
+	feedback := map[string]VariantFeedback{}
+	if r.feedback != nil {
+		var err error
+		if feedback, err = r.feedback.GetVariantFeedback(name); err != nil {
+			return ExperimentReport{}, err
+		}
+	}
+
+	report := ExperimentReport{Experiment: experiment, Variants: make([]VariantReport, 0, len(experiment.Variants))}
+	for _, variant := range experiment.Variants {
+		var variantObservations []ExperimentObservation
+		for _, observation := range observations {
+			if observation.Variant == variant.Name {
+				variantObservations = append(variantObservations, observation)
+			}
+		}
+		report.Variants = append(report.Variants, newVariantReport(variant, variantObservations, feedback[variant.Name]))
+	}
+
+	return report, nil
+}
+
+func newVariantReport(variant ExperimentVariant, observations []ExperimentObservation, feedback VariantFeedback) VariantReport {
+	report := VariantReport{
+		Variant:        variant.Name,
+		Model:          variant.Model,
+		PromptVersions: variant.PromptVersions,
+		Observations:   len(observations),
+		Feedback:       feedback,
+	}
+	if rated := feedback.Positive + feedback.Negative; rated > 0 {
+		report.PositiveFeedbackPct = 100 * float64(feedback.Positive) / float64(rated)
+	}
+	if len(observations) == 0 {
+		return report
+	}
+
+	var successes, promptTokens, responseTokens int
+	var totalLatency time.Duration
+	latencies := make([]time.Duration, 0, len(observations))
+	for _, observation := range observations {
+		if observation.Success {
+			successes++
+		}
+		promptTokens += observation.PromptTokens
+		responseTokens += observation.ResponseTokens
+		totalLatency += observation.Latency
+		latencies = append(latencies, observation.Latency)
+	}
+
+	count := float64(len(observations))
+	report.SuccessRate = float64(successes) / count
+	report.AvgLatency = totalLatency / time.Duration(len(observations))
+	report.AvgPromptTokens = float64(promptTokens) / count
+	report.AvgResponseTokens = float64(responseTokens) / count
+	report.AvgCost = (report.AvgPromptTokens*variant.PromptTokenPrice + report.AvgResponseTokens*variant.ResponseTokenPrice) / 1e6
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P95Latency = latencies[(len(latencies)*95+99)/100-1]
+
+	return report
+}
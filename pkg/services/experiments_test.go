@@ -0,0 +1,247 @@
+package services
+
+import (
+	"fmt"
+	investbotErr "investbot/pkg/errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeObservations struct {
+	mu           sync.Mutex
+	observations []ExperimentObservation
+}
+
+func (f *fakeObservations) StoreExperimentObservation(observation ExperimentObservation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.observations = append(f.observations, observation)
+	return nil
+}
+
+func (f *fakeObservations) GetExperimentObservations(experiment string) ([]ExperimentObservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var observations []ExperimentObservation
+	for _, observation := range f.observations {
+		if observation.Experiment == experiment {
+			observations = append(observations, observation)
+		}
+	}
+	return observations, nil
+}
+
+type fakeVariantFeedback map[string]VariantFeedback
+
+func (f fakeVariantFeedback) GetVariantFeedback(string) (map[string]VariantFeedback, error) {
+	return f, nil
+}
+
+// fakeExperiments assigns every session to the same rag variant
+type fakeExperiments struct {
+	rag        Rag
+	assignment Assignment
+}
+
+func (f fakeExperiments) TopicExtractor(string, string) (TopicExtractorService, Assignment, bool) {
+	return nil, Assignment{}, false
+}
+
+func (f fakeExperiments) TagExtractor(Topic, string, string) (TagExtractorService, Assignment, bool) {
+	return nil, Assignment{}, false
+}
+
+func (f fakeExperiments) Rag(Topic, string, string) (Rag, Assignment, bool) {
+	return f.rag, f.assignment, true
+}
+
+func topicExtractorExperiment() Experiment {
+	return Experiment{
+		Name:   "topic_model",
+		Target: TopicExtractorTarget,
+		Unit:   SessionUnit,
+		Variants: []ExperimentVariant{
+			{Name: "control", Weight: 1},
+			{Name: "candidate", Weight: 1, Model: "candidate-model", PromptVersions: map[string]string{"topic_extractor": "v1"}},
+		},
+	}
+}
+
+func TestExperiment_Assign(t *testing.T) {
+	experiment := Experiment{
+		Name:     "weights",
+		Unit:     UserUnit,
+		Variants: []ExperimentVariant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}},
+	}
+
+	// The variant only depends on the user, all the sessions of a user get the same variant
+	variant := experiment.Assign("session-1", "user-1")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, variant, experiment.Assign(fmt.Sprintf("session-%d", i), "user-1"))
+	}
+
+	// Requests without a user are assigned by their session
+	assert.Equal(t, experiment.Assign("session-1", ""), experiment.Assign("session-1", ""))
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[experiment.Assign("", fmt.Sprintf("user-%d", i)).Name]++
+	}
+	assert.InDelta(t, 7500, counts["a"], 300)
+	assert.InDelta(t, 2500, counts["b"], 300)
+}
+
+func TestNewExperimentRouter_Validation(t *testing.T) {
+	build := func(Experiment, ExperimentVariant) (VariantComponents, error) {
+		extractor, _ := NewTopicExtractor(&fakeLlm{}, nil, fakeRagResponsesRepository{})
+		return VariantComponents{TopicExtractor: extractor}, nil
+	}
+
+	_, err := NewExperimentRouter([]Experiment{topicExtractorExperiment()}, build, nil)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(e *Experiment)
+	}{
+		{name: "Invalid target", modify: func(e *Experiment) { e.Target = "summary" }},
+		{name: "Invalid unit", modify: func(e *Experiment) { e.Unit = "" }},
+		{name: "Topic of a topic extractor experiment", modify: func(e *Experiment) { e.Topic = NEWS }},
+		{name: "Duplicated variant", modify: func(e *Experiment) { e.Variants[1].Name = "control" }},
+		{name: "Zero weight", modify: func(e *Experiment) { e.Variants[0].Weight = 0 }},
+		{name: "Unknown prompt version", modify: func(e *Experiment) { e.Variants[1].PromptVersions["topic_extractor"] = "v99" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			experiment := topicExtractorExperiment()
+			tt.modify(&experiment)
+			_, err := NewExperimentRouter([]Experiment{experiment}, build, nil)
+			assert.Error(t, err)
+		})
+	}
+
+	// There can only be one experiment per target and topic
+	other := topicExtractorExperiment()
+	other.Name = "other"
+	_, err = NewExperimentRouter([]Experiment{topicExtractorExperiment(), other}, build, nil)
+	assert.Error(t, err)
+
+	// The components of the target must be able to record their variant
+	_, err = NewExperimentRouter([]Experiment{topicExtractorExperiment()}, func(Experiment, ExperimentVariant) (VariantComponents, error) {
+		return VariantComponents{}, nil
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestExperimentRouter_TopicExtractor(t *testing.T) {
+	observations := &fakeObservations{}
+	llms := map[string]*fakeLlm{
+		"control":   {response: `{"topic": "news"}`},
+		"candidate": {response: "not json"},
+	}
+	build := func(_ Experiment, variant ExperimentVariant) (VariantComponents, error) {
+		extractor, _ := NewTopicExtractor(llms[variant.Name], nil, fakeRagResponsesRepository{})
+		return VariantComponents{TopicExtractor: extractor}, nil
+	}
+	router, err := NewExperimentRouter([]Experiment{topicExtractorExperiment()}, build, observations)
+	assert.NoError(t, err)
+
+	_, _, found := router.TagExtractor(NEWS, "session", "")
+	assert.False(t, found)
+
+	conversation := []Message{{Role: User, Content: "What happened in the markets today?"}}
+	for i := 0; i < 20; i++ {
+		sessionID := fmt.Sprintf("session-%d", i)
+		extractor, assignment, found := router.TopicExtractor(sessionID, "")
+		assert.True(t, found)
+		assert.Equal(t, topicExtractorExperiment().Assign(sessionID, "").Name, assignment.Variant)
+
+		topic, err := extractor.ExtractTopic(conversation, "")
+		if assignment.Variant == "control" {
+			assert.NoError(t, err)
+			assert.Equal(t, NEWS, topic)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+
+	// Every extraction is observed, the failed ones as unsuccessful
+	assert.Eventually(t, func() bool {
+		recorded, _ := observations.GetExperimentObservations("topic_model")
+		return len(recorded) == 20
+	}, time.Second, 10*time.Millisecond)
+
+	report, err := router.Report("topic_model")
+	assert.NoError(t, err)
+	assert.Len(t, report.Variants, 2)
+	assert.Equal(t, 20, report.Variants[0].Observations+report.Variants[1].Observations)
+	assert.Equal(t, 1.0, report.Variants[0].SuccessRate)
+	assert.Equal(t, 0.0, report.Variants[1].SuccessRate)
+	assert.Greater(t, report.Variants[0].AvgPromptTokens, 0.0)
+
+	_, err = router.Report("unknown")
+	assert.ErrorAs(t, err, &investbotErr.ExperimentNotFoundError{})
+}
+
+func TestExperimentRouter_Report(t *testing.T) {
+	experiment := topicExtractorExperiment()
+	experiment.Variants[1].PromptTokenPrice = 2
+	experiment.Variants[1].ResponseTokenPrice = 10
+
+	observations := &fakeObservations{}
+	for i := 1; i <= 20; i++ {
+		observations.StoreExperimentObservation(ExperimentObservation{
+			Experiment:     experiment.Name,
+			Variant:        "candidate",
+			Latency:        time.Duration(i) * time.Millisecond,
+			PromptTokens:   1000,
+			ResponseTokens: 100,
+			Success:        i%4 != 0,
+		})
+	}
+
+	build := func(Experiment, ExperimentVariant) (VariantComponents, error) {
+		extractor, _ := NewTopicExtractor(&fakeLlm{}, nil, fakeRagResponsesRepository{})
+		return VariantComponents{TopicExtractor: extractor}, nil
+	}
+	router, _ := NewExperimentRouter([]Experiment{experiment}, build, observations)
+	router.SetVariantFeedback(fakeVariantFeedback{"candidate": {Positive: 3, Negative: 1}})
+
+	report, err := router.Report(experiment.Name)
+	assert.NoError(t, err)
+
+	assert.Equal(t, VariantReport{Variant: "control", Feedback: VariantFeedback{}}, report.Variants[0])
+
+	candidate := report.Variants[1]
+	assert.Equal(t, 20, candidate.Observations)
+	assert.Equal(t, 0.75, candidate.SuccessRate)
+	assert.Equal(t, 10500*time.Microsecond, candidate.AvgLatency)
+	assert.Equal(t, 19*time.Millisecond, candidate.P95Latency)
+	assert.InDelta(t, 0.003, candidate.AvgCost, 1e-9)
+	assert.Equal(t, 75.0, candidate.PositiveFeedbackPct)
+}
+
+func TestChatService_ExperimentRag(t *testing.T) {
+	chatService, sessionService, defaultRag, _ := newTestChatService(t)
+	variantRag := &fakeRag{response: "variant answer"}
+	assignment := Assignment{Experiment: "education_prompt", Variant: "candidate"}
+	chatService.experiments = fakeExperiments{rag: variantRag, assignment: assignment}
+	sessionID, _ := sessionService.CreateNewSession("user")
+
+	assert.NoError(t, chatService.GenerateResponse(EDUCATION, Tags{}, sessionID, "what is an etf?", nil))
+
+	messages, _ := sessionService.GetMessages(sessionID)
+	assert.Equal(t, "variant answer", messages[1].Content)
+	assert.Equal(t, &assignment, messages[1].Metadata.Experiment)
+	assert.Empty(t, defaultRag.conversations)
+
+	// Responses regenerated by a chosen model are not part of the experiment
+	assert.NoError(t, chatService.RegenerateResponse(EDUCATION, Tags{}, sessionID, "user", "other-model", nil))
+	messages, _ = sessionService.GetMessages(sessionID)
+	assert.Equal(t, "other answer", messages[1].Content)
+	assert.Nil(t, messages[1].Metadata.Experiment)
+}
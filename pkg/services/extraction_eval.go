@@ -0,0 +1,442 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	sectorNameTag      = "sector_name"
+	industryNameTag    = "industry_name"
+	stockSymbolsTag    = "stock_symbols"
+	balanceSheetTag    = "balance_sheet"
+	incomeStatementTag = "income_statement"
+	cashFlowTag        = "cash_flow"
+	etfSymbolsTag      = "etf_symbols"
+	superInvestorTag   = "super_investor_name"
+)
+
+// EvalMessage is a message of the conversation that precedes the question of an evaluation case
+type EvalMessage struct {
+	Role    ActorRole `json:"role"`
+	Content string    `json:"content"`
+}
+
+// ExpectedTags are the labelled tags of an evaluation case. Only the tags that are set are checked, an
+// empty list of symbols expects no symbols.
+type ExpectedTags struct {
+	SectorName        *string  `json:"sector_name"`
+	IndustryName      *string  `json:"industry_name"`
+	StockSymbols      []string `json:"stock_symbols"`
+	BalanceSheet      *bool    `json:"balance_sheet"`
+	IncomeStatement   *bool    `json:"income_statement"`
+	CashFlow          *bool    `json:"cash_flow"`
+	EtfSymbols        []string `json:"etf_symbols"`
+	SuperInvestorName *string  `json:"super_investor_name"`
+}
+
+// ExtractionCase is a labelled question of the topic and tag extraction dataset
+type ExtractionCase struct {
+	ID            string        `json:"id"` // Identifies the case in the baseline, defaults to the question
+	Question      string        `json:"question"`
+	Conversation  []EvalMessage `json:"conversation"`
+	ExpectedTopic Topic         `json:"expected_topic"`
+	ExpectedTags  ExpectedTags  `json:"expected_tags"`
+}
+
+// LoadExtractionCases reads the JSONL dataset at path, one case per line. Unknown fields are rejected
+// so that a typo in a label doesn't silently skip the check.
+func LoadExtractionCases(path string) ([]ExtractionCase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cases []ExtractionCase
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var c ExtractionCase
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c); err != nil {
+			return nil, fmt.Errorf("invalid case on line %d: %w", line, err)
+		}
+		if c.ID == "" {
+			c.ID = c.Question
+		}
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("invalid case on line %d: %w", line, err)
+		}
+		if ids[c.ID] {
+			return nil, fmt.Errorf("invalid case on line %d: duplicated id %s", line, c.ID)
+		}
+		ids[c.ID] = true
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cases, nil
+}
+
+func (c ExtractionCase) validate() error {
+	if c.Question == "" {
+		return fmt.Errorf("question is required")
+	}
+	if c.ExpectedTopic == "" {
+		return fmt.Errorf("expected_topic is required")
+	}
+	for _, message := range c.Conversation {
+		if message.Role != User && message.Role != Assistant {
+			return fmt.Errorf("invalid conversation role %s", message.Role)
+		}
+	}
+	return nil
+}
+
+// check returns the labelled tags and the ones that the extracted tags don't match
+func (e ExpectedTags) check(tags Tags) (labelled []string, wrong []string) {
+	checkTag := func(name string, isLabelled bool, matches func() bool) {
+		if !isLabelled {
+			return
+		}
+		labelled = append(labelled, name)
+		if !matches() {
+			wrong = append(wrong, name)
+		}
+	}
+
+	checkTag(sectorNameTag, e.SectorName != nil, func() bool {
+		return strings.EqualFold(*e.SectorName, tags.SectorName)
+	})
+	checkTag(industryNameTag, e.IndustryName != nil, func() bool {
+		return strings.EqualFold(*e.IndustryName, tags.IndustryName)
+	})
+	checkTag(stockSymbolsTag, e.StockSymbols != nil, func() bool {
+		return sameSymbols(e.StockSymbols, tags.StockSymbols)
+	})
+	checkTag(balanceSheetTag, e.BalanceSheet != nil, func() bool {
+		return *e.BalanceSheet == tags.BalanceSheet
+	})
+	checkTag(incomeStatementTag, e.IncomeStatement != nil, func() bool {
+		return *e.IncomeStatement == tags.IncomeStatement
+	})
+	checkTag(cashFlowTag, e.CashFlow != nil, func() bool {
+		return *e.CashFlow == tags.CashFlow
+	})
+	checkTag(etfSymbolsTag, e.EtfSymbols != nil, func() bool {
+		return sameSymbols(e.EtfSymbols, tags.EtfSymbols)
+	})
+	checkTag(superInvestorTag, e.SuperInvestorName != nil, func() bool {
+		return strings.EqualFold(*e.SuperInvestorName, tags.SuperInvestorName)
+	})
+
+	return labelled, wrong
+}
+
+// sameSymbols compares the symbols as case insensitive sets
+func sameSymbols(expected []string, extracted []string) bool {
+	normalize := func(symbols []string) []string {
+		normalized := make([]string, 0, len(symbols))
+		for _, symbol := range symbols {
+			normalized = append(normalized, strings.ToUpper(strings.TrimSpace(symbol)))
+		}
+		slices.Sort(normalized)
+		return slices.Compact(normalized)
+	}
+	return slices.Equal(normalize(expected), normalize(extracted))
+}
+
+// ExtractionCaseResult is the extraction of an evaluation case. The tags are only checked when the topic
+// is correct, a wrong topic extracts the tags of another topic.
+type ExtractionCaseResult struct {
+	ID            string   `json:"id"`
+	Question      string   `json:"question"`
+	ExpectedTopic Topic    `json:"expected_topic"`
+	Topic         Topic    `json:"topic"`
+	Tags          Tags     `json:"tags"`
+	TopicCorrect  bool     `json:"topic_correct"`
+	LabelledTags  []string `json:"labelled_tags"`
+	WrongTags     []string `json:"wrong_tags"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// TagsCorrect returns whether the topic and all the labelled tags are correct
+func (r ExtractionCaseResult) TagsCorrect() bool {
+	return r.TopicCorrect && len(r.WrongTags) == 0
+}
+
+type TopicMetrics struct {
+	Expected  int     `json:"expected"`  // Cases labelled with the topic
+	Extracted int     `json:"extracted"` // Cases extracted as the topic
+	Correct   int     `json:"correct"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+}
+
+type TagMetrics struct {
+	Labelled int     `json:"labelled"` // Cases with a correct topic that label the tag
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// ExtractionEvalReport is the result of an evaluation run, it's saved as the baseline of the next runs
+type ExtractionEvalReport struct {
+	Cases         int                    `json:"cases"`
+	Errors        int                    `json:"errors"`
+	TopicAccuracy float64                `json:"topic_accuracy"`
+	TagAccuracy   float64                `json:"tag_accuracy"` // Share of the cases with labelled tags that match all of them
+	Topics        map[Topic]TopicMetrics `json:"topics"`
+	Tags          map[string]TagMetrics  `json:"tags"`
+	Results       []ExtractionCaseResult `json:"results"`
+}
+
+// LoadExtractionEvalReport reads a report saved as json
+func LoadExtractionEvalReport(path string) (ExtractionEvalReport, error) {
+	reportJson, err := os.ReadFile(path)
+	if err != nil {
+		return ExtractionEvalReport{}, err
+	}
+
+	var report ExtractionEvalReport
+	if err := json.Unmarshal(reportJson, &report); err != nil {
+		return ExtractionEvalReport{}, fmt.Errorf("invalid report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+func newExtractionEvalReport(results []ExtractionCaseResult) ExtractionEvalReport {
+	report := ExtractionEvalReport{
+		Cases:   len(results),
+		Topics:  map[Topic]TopicMetrics{},
+		Tags:    map[string]TagMetrics{},
+		Results: results,
+	}
+
+	correctTopics, casesWithTags, correctTags := 0, 0, 0
+	for _, result := range results {
+		if result.Error != "" {
+			report.Errors++
+		}
+
+		expected := report.Topics[result.ExpectedTopic]
+		expected.Expected++
+		report.Topics[result.ExpectedTopic] = expected
+		if result.Topic != "" {
+			extracted := report.Topics[result.Topic]
+			extracted.Extracted++
+			report.Topics[result.Topic] = extracted
+		}
+
+		if !result.TopicCorrect {
+			if len(result.LabelledTags) > 0 {
+				casesWithTags++
+			}
+			continue
+		}
+		correctTopics++
+		metrics := report.Topics[result.Topic]
+		metrics.Correct++
+		report.Topics[result.Topic] = metrics
+
+		if len(result.LabelledTags) > 0 {
+			casesWithTags++
+			if len(result.WrongTags) == 0 {
+				correctTags++
+			}
+		}
+		for _, tag := range result.LabelledTags {
+			tagMetrics := report.Tags[tag]
+			tagMetrics.Labelled++
+			if !slices.Contains(result.WrongTags, tag) {
+				tagMetrics.Correct++
+			}
+			report.Tags[tag] = tagMetrics
+		}
+	}
+
+	report.TopicAccuracy = ratio(correctTopics, len(results))
+	report.TagAccuracy = ratio(correctTags, casesWithTags)
+	for topic, metrics := range report.Topics {
+		metrics.Precision = ratio(metrics.Correct, metrics.Extracted)
+		metrics.Recall = ratio(metrics.Correct, metrics.Expected)
+		report.Topics[topic] = metrics
+	}
+	for tag, metrics := range report.Tags {
+		metrics.Accuracy = ratio(metrics.Correct, metrics.Labelled)
+		report.Tags[tag] = metrics
+	}
+
+	return report
+}
+
+func ratio(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+// ExtractionRegression is a case or a metric that is worse than in the baseline
+type ExtractionRegression struct {
+	Case     string // ID of the case, empty for the regressions of a metric
+	Metric   string // topic or tags for a case, like recall:news or accuracy:stock_symbols for a metric
+	Baseline string
+	Current  string
+}
+
+// CompareExtractionReports returns the cases that were correct in the baseline and are wrong in the
+// current report, and the metrics that dropped more than tolerance. Cases that are not part of the
+// baseline are skipped.
+func CompareExtractionReports(baseline ExtractionEvalReport, current ExtractionEvalReport, tolerance float64) []ExtractionRegression {
+	var regressions []ExtractionRegression
+
+	baselineResults := map[string]ExtractionCaseResult{}
+	for _, result := range baseline.Results {
+		baselineResults[result.ID] = result
+	}
+	for _, result := range current.Results {
+		baselineResult, found := baselineResults[result.ID]
+		if !found {
+			continue
+		}
+
+		switch {
+		case baselineResult.TopicCorrect && !result.TopicCorrect:
+			extracted := string(result.Topic)
+			if result.Error != "" {
+				extracted = "error: " + result.Error
+			}
+			regressions = append(regressions, ExtractionRegression{
+				Case:     result.ID,
+				Metric:   "topic",
+				Baseline: string(baselineResult.Topic),
+				Current:  extracted,
+			})
+		case baselineResult.TagsCorrect() && !result.TagsCorrect():
+			regressions = append(regressions, ExtractionRegression{
+				Case:     result.ID,
+				Metric:   "tags",
+				Baseline: "correct",
+				Current:  "wrong " + strings.Join(result.WrongTags, ", "),
+			})
+		}
+	}
+
+	var metricRegressions []ExtractionRegression
+	compareMetric := func(metric string, baselineValue float64, currentValue float64) {
+		if currentValue < baselineValue-tolerance {
+			metricRegressions = append(metricRegressions, ExtractionRegression{
+				Metric:   metric,
+				Baseline: fmt.Sprintf("%.3f", baselineValue),
+				Current:  fmt.Sprintf("%.3f", currentValue),
+			})
+		}
+	}
+	compareMetric("topic_accuracy", baseline.TopicAccuracy, current.TopicAccuracy)
+	compareMetric("tag_accuracy", baseline.TagAccuracy, current.TagAccuracy)
+	for topic, metrics := range baseline.Topics {
+		compareMetric("precision:"+string(topic), metrics.Precision, current.Topics[topic].Precision)
+		compareMetric("recall:"+string(topic), metrics.Recall, current.Topics[topic].Recall)
+	}
+	for tag, metrics := range baseline.Tags {
+		compareMetric("accuracy:"+tag, metrics.Accuracy, current.Tags[tag].Accuracy)
+	}
+	sort.Slice(metricRegressions, func(i, j int) bool {
+		return metricRegressions[i].Metric < metricRegressions[j].Metric
+	})
+
+	return append(regressions, metricRegressions...)
+}
+
+// TopicAndTagsExtractor is the extraction that is evaluated, like the ChatService
+type TopicAndTagsExtractor interface {
+	ExtractTopicAndTags(question string, sessionId string, userID string) (Topic, Tags, error)
+}
+
+// ExtractionEvaluator runs the evaluation cases through the extractor, every case in a new session
+// with the conversation of the case
+type ExtractionEvaluator struct {
+	extractor      TopicAndTagsExtractor
+	sessionService SessionService
+	concurrency    int
+}
+
+func NewExtractionEvaluator(extractor TopicAndTagsExtractor, sessionService SessionService, concurrency int) (*ExtractionEvaluator, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+
+	return &ExtractionEvaluator{
+		extractor:      extractor,
+		sessionService: sessionService,
+		concurrency:    concurrency,
+	}, nil
+}
+
+// Evaluate extracts the topic and tags of every case, the results are in the order of the cases
+func (e *ExtractionEvaluator) Evaluate(cases []ExtractionCase) ExtractionEvalReport {
+	results := make([]ExtractionCaseResult, len(cases))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, e.concurrency)
+	for i, c := range cases {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = e.evaluateCase(c)
+		}()
+	}
+	wg.Wait()
+
+	return newExtractionEvalReport(results)
+}
+
+func (e *ExtractionEvaluator) evaluateCase(c ExtractionCase) ExtractionCaseResult {
+	result := ExtractionCaseResult{ID: c.ID, Question: c.Question, ExpectedTopic: c.ExpectedTopic}
+	result.LabelledTags, _ = c.ExpectedTags.check(Tags{})
+
+	sessionID, err := e.sessionService.CreateNewSession("")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer e.sessionService.DeleteSession(sessionID)
+
+	for _, message := range c.Conversation {
+		if err := e.sessionService.AddMessage(sessionID, Message{Role: message.Role, Content: message.Content}); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	topic, tags, err := e.extractor.ExtractTopicAndTags(c.Question, sessionID, "")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Topic, result.Tags = topic, tags
+	result.TopicCorrect = topic == c.ExpectedTopic
+	if result.TopicCorrect {
+		_, result.WrongTags = c.ExpectedTags.check(tags)
+	}
+	return result
+}
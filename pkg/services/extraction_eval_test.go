@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeExtraction struct {
+	topic Topic
+	tags  Tags
+	err   error
+}
+
+// fakeExtractor returns the extraction of the question and records the conversation of its session
+type fakeExtractor struct {
+	mu             sync.Mutex
+	sessionService SessionService
+	extractions    map[string]fakeExtraction
+	conversations  map[string][]Message
+}
+
+func (f *fakeExtractor) ExtractTopicAndTags(question string, sessionId string, _ string) (Topic, Tags, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conversation, _ := f.sessionService.GetConversationBySessionId(sessionId)
+	f.conversations[question] = conversation
+
+	extraction := f.extractions[question]
+	return extraction.topic, extraction.tags, extraction.err
+}
+
+func writeDataset(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "dataset.jsonl")
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadExtractionCases(t *testing.T) {
+	path := writeDataset(t,
+		`{"question": "What's Apple's free cash flow?", "expected_topic": "stock_financials", "expected_tags": {"stock_symbols": ["AAPL"], "cash_flow": true}}`,
+		``,
+		`{"id": "follow-up", "question": "And its debt?", "conversation": [{"role": "user", "content": "Tell me about Apple"}], "expected_topic": "stock_financials"}`,
+	)
+
+	cases, err := LoadExtractionCases(path)
+	assert.NoError(t, err)
+	assert.Len(t, cases, 2)
+	assert.Equal(t, "What's Apple's free cash flow?", cases[0].ID)
+	assert.Equal(t, []string{"AAPL"}, cases[0].ExpectedTags.StockSymbols)
+	assert.True(t, *cases[0].ExpectedTags.CashFlow)
+	assert.Nil(t, cases[0].ExpectedTags.BalanceSheet)
+	assert.Equal(t, "follow-up", cases[1].ID)
+	assert.Equal(t, User, cases[1].Conversation[0].Role)
+
+	invalid := []string{
+		`{"question": "q", "expected_topic": "news", "expected_tag": {}}`,
+		`{"question": "", "expected_topic": "news"}`,
+		`{"question": "q"}`,
+		`{"question": "q", "expected_topic": "news", "conversation": [{"role": "system", "content": "c"}]}`,
+		`{"question": "q", "expected_topic": "news"}` + "\n" + `{"question": "q", "expected_topic": "sectors"}`,
+	}
+	for _, dataset := range invalid {
+		_, err := LoadExtractionCases(writeDataset(t, dataset))
+		assert.Error(t, err, dataset)
+	}
+}
+
+func TestExtractionEvaluator_Evaluate(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	extractor := &fakeExtractor{
+		sessionService: sessionService,
+		conversations:  map[string][]Message{},
+		extractions: map[string]fakeExtraction{
+			"free cash flow of apple": {topic: STOCK_FINANCIALS, tags: Tags{StockSymbols: []string{"aapl"}, CashFlow: true}},
+			"apple debt":              {topic: STOCK_FINANCIALS, tags: Tags{StockSymbols: []string{"AAPL", "MSFT"}, BalanceSheet: true}},
+			"tech sector":             {topic: STOCK_OVERVIEW},
+			"what is an etf":          {topic: EDUCATION},
+			"market news":             {err: fmt.Errorf("llm failed")},
+		},
+	}
+	cashFlow, balanceSheet, sector := true, true, "Technology"
+	cases := []ExtractionCase{
+		{ID: "1", Question: "free cash flow of apple", ExpectedTopic: STOCK_FINANCIALS, ExpectedTags: ExpectedTags{StockSymbols: []string{"AAPL"}, CashFlow: &cashFlow}},
+		{ID: "2", Question: "apple debt", ExpectedTopic: STOCK_FINANCIALS, ExpectedTags: ExpectedTags{StockSymbols: []string{"AAPL"}, BalanceSheet: &balanceSheet},
+			Conversation: []EvalMessage{{Role: User, Content: "Tell me about Apple"}, {Role: Assistant, Content: "Apple makes phones"}}},
+		{ID: "3", Question: "tech sector", ExpectedTopic: SECTORS, ExpectedTags: ExpectedTags{SectorName: &sector}},
+		{ID: "4", Question: "what is an etf", ExpectedTopic: EDUCATION},
+		{ID: "5", Question: "market news", ExpectedTopic: NEWS},
+	}
+
+	evaluator, err := NewExtractionEvaluator(extractor, sessionService, 2)
+	assert.NoError(t, err)
+	report := evaluator.Evaluate(cases)
+
+	// The question is asked after the conversation of the case
+	assert.Equal(t, []string{"Tell me about Apple", "Apple makes phones"}, []string{
+		extractor.conversations["apple debt"][0].Content,
+		extractor.conversations["apple debt"][1].Content,
+	})
+
+	assert.Equal(t, 5, report.Cases)
+	assert.Equal(t, 1, report.Errors)
+	assert.Equal(t, 0.6, report.TopicAccuracy)
+	assert.Equal(t, "market news", report.Results[4].Question)
+	assert.Equal(t, "llm failed", report.Results[4].Error)
+
+	assert.Equal(t, TopicMetrics{Expected: 2, Extracted: 2, Correct: 2, Precision: 1, Recall: 1}, report.Topics[STOCK_FINANCIALS])
+	assert.Equal(t, TopicMetrics{Expected: 1, Recall: 0}, report.Topics[SECTORS])
+	assert.Equal(t, TopicMetrics{Extracted: 1}, report.Topics[STOCK_OVERVIEW])
+	assert.Equal(t, TopicMetrics{Expected: 1}, report.Topics[NEWS])
+
+	// The tags of the wrong sector topic are not checked but the case counts as wrong
+	assert.Equal(t, []string{stockSymbolsTag}, report.Results[1].WrongTags)
+	assert.Equal(t, TagMetrics{Labelled: 2, Correct: 1, Accuracy: 0.5}, report.Tags[stockSymbolsTag])
+	assert.Equal(t, TagMetrics{Labelled: 1, Correct: 1, Accuracy: 1}, report.Tags[cashFlowTag])
+	assert.NotContains(t, report.Tags, sectorNameTag)
+	assert.InDelta(t, 1.0/3, report.TagAccuracy, 1e-9)
+
+	// The sessions of the cases are deleted
+	sessions, total, _ := sessionService.ListSessions("", true, 0, 10)
+	assert.Empty(t, sessions)
+	assert.Zero(t, total)
+}
+
+func TestCompareExtractionReports(t *testing.T) {
+	baseline := newExtractionEvalReport([]ExtractionCaseResult{
+		{ID: "1", ExpectedTopic: NEWS, Topic: NEWS, TopicCorrect: true},
+		{ID: "2", ExpectedTopic: STOCK_OVERVIEW, Topic: STOCK_OVERVIEW, TopicCorrect: true, LabelledTags: []string{stockSymbolsTag}},
+		{ID: "3", ExpectedTopic: SECTORS, Topic: NEWS},
+		{ID: "4", ExpectedTopic: EDUCATION, Topic: EDUCATION, TopicCorrect: true},
+	})
+	current := newExtractionEvalReport([]ExtractionCaseResult{
+		{ID: "1", ExpectedTopic: NEWS, Error: "timeout"},
+		{ID: "2", ExpectedTopic: STOCK_OVERVIEW, Topic: STOCK_OVERVIEW, TopicCorrect: true, LabelledTags: []string{stockSymbolsTag}, WrongTags: []string{stockSymbolsTag}},
+		{ID: "3", ExpectedTopic: SECTORS, Topic: SECTORS, TopicCorrect: true},
+		{ID: "4", ExpectedTopic: EDUCATION, Topic: EDUCATION, TopicCorrect: true},
+		{ID: "5", ExpectedTopic: EDUCATION, Topic: NEWS},
+	})
+
+	regressions := CompareExtractionReports(baseline, current, 0.1)
+	assert.Equal(t, []ExtractionRegression{
+		{Case: "1", Metric: "topic", Baseline: "news", Current: "error: timeout"},
+		{Case: "2", Metric: "tags", Baseline: "correct", Current: "wrong stock_symbols"},
+		{Metric: "accuracy:stock_symbols", Baseline: "1.000", Current: "0.000"},
+		{Metric: "precision:news", Baseline: "0.500", Current: "0.000"},
+		{Metric: "recall:education", Baseline: "1.000", Current: "0.500"},
+		{Metric: "recall:news", Baseline: "1.000", Current: "0.000"},
+		{Metric: "tag_accuracy", Baseline: "1.000", Current: "0.000"},
+		{Metric: "topic_accuracy", Baseline: "0.750", Current: "0.600"},
+	}, regressions)
+
+	assert.Empty(t, CompareExtractionReports(baseline, baseline, 0))
+}
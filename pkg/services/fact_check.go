@@ -0,0 +1,304 @@
+package services
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FactCheck is the result of checking the figures of a response against the market data of its rag context
+type FactCheck struct {
+	Checked     int // Figures compared with the market data
+	Unverified  int // Figures of metrics that are not in the market data
+	Mismatches  []FactMismatch
+	Regenerated bool // The response was regenerated because the first one had mismatches
+}
+
+// FactMismatch is a figure of a response that doesn't match any value of its metric in the market data
+type FactMismatch struct {
+	Metric  string
+	Claim   string  // The figure as written in the response, like $94.9 billion
+	Value   float64 // The value of the figure, like 94900000000
+	Closest float64 // The closest value of the metric in the market data
+}
+
+// factCheckMetric is a metric that figures of the responses are checked for. The figures are matched to
+// the metric with the nearest keyword before them in the sentence. Percentages are checked against the
+// percent fields of the market data and the other figures against the value fields.
+type factCheckMetric struct {
+	name     string
+	keywords *regexp.Regexp
+	values   []string // Lowercased field names of the market data
+	percents []string
+}
+
+var factCheckMetrics = []factCheckMetric{
+	{
+		name:     "revenue",
+		keywords: regexp.MustCompile(`(?i)\b(revenues?|sales)\b`),
+		values:   []string{"revenue", "revenueasreported"},
+		percents: []string{"revenuegrowth"},
+	},
+	{
+		name:     "net income",
+		keywords: regexp.MustCompile(`(?i)\b(net income|net profit|profits?)\b`),
+		values:   []string{"netinc", "netinccmn"},
+		percents: []string{"netincomegrowth"},
+	},
+	{
+		name:     "eps",
+		keywords: regexp.MustCompile(`(?i)\b(eps|earnings per share)\b`),
+		values:   []string{"epsbasic", "epsdil", "eps"},
+		percents: []string{"epsgrowth"},
+	},
+	{
+		name:     "p/e",
+		keywords: regexp.MustCompile(`(?i)(\bp/e\b|\bpe ratio\b|\bprice[- ]to[- ]earnings\b)`),
+		values:   []string{"pe", "peratio", "weightedperatio"},
+	},
+	{
+		name:     "market cap",
+		keywords: regexp.MustCompile(`(?i)\bmarket cap(italization)?\b`),
+		values:   []string{"marketcap"},
+		percents: []string{"marketcapgrowth"},
+	},
+	{
+		name:     "free cash flow",
+		keywords: regexp.MustCompile(`(?i)\b(free cash flow|fcf)\b`),
+		values:   []string{"fcf"},
+		percents: []string{"fcfmargin", "fcfyield"},
+	},
+	{
+		name:     "gross margin",
+		keywords: regexp.MustCompile(`(?i)\bgross margins?\b`),
+		percents: []string{"grossmargin"},
+	},
+	{
+		name:     "operating margin",
+		keywords: regexp.MustCompile(`(?i)\boperating margins?\b`),
+		percents: []string{"operatingmargin"},
+	},
+	{
+		name:     "profit margin",
+		keywords: regexp.MustCompile(`(?i)\b(profit|net) margins?\b`),
+		percents: []string{"profitmargin"},
+	},
+	{
+		name:     "dividend yield",
+		keywords: regexp.MustCompile(`(?i)\bdividend yield\b`),
+		percents: []string{"dividendyield", "dividendyieldpct", "weighteddividendyieldpct"},
+	},
+	{
+		name:     "return",
+		keywords: regexp.MustCompile(`(?i)\b(returns?|returned|performance|price change|gained|lost)\b`),
+		percents: []string{
+			"percentagechange", "onemonthreturn", "oneyearreturn", "yeartodatereturn", "fiveyearreturn",
+			"onemonthreturnpct", "sixmonthreturnpct", "oneyearreturnpct",
+		},
+	},
+}
+
+// figurePattern matches the figures of a response, like $94.9 billion, 28.5, 1,234 or 6%. The first
+// group is the character before the figure, so that figures that are part of words like Q3 are skipped.
+var figurePattern = regexp.MustCompile(
+	`(^|[^\w.,/])(\$?)(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?\s*(%|percent\b|trillion\b|billion\b|million\b|thousand\b|[TBMK]\b)?`,
+)
+
+var figureUnits = map[string]float64{
+	"trillion": 1e12, "T": 1e12,
+	"billion": 1e9, "B": 1e9,
+	"million": 1e6, "M": 1e6,
+	"thousand": 1e3, "K": 1e3,
+}
+
+// keywordWindow is how many characters before a figure are searched for the keyword of its metric
+const keywordWindow = 80
+
+type figure struct {
+	text      string
+	value     float64
+	percent   bool
+	precision float64 // Half of the last digit of the figure, so that rounded figures still match
+	start     int
+}
+
+// FactChecker checks the figures of the responses against the market data of their rag context
+type FactChecker struct {
+	tolerance float64 // Relative difference allowed between a figure and the market data
+}
+
+func NewFactChecker(tolerance float64) (*FactChecker, error) {
+	return &FactChecker{tolerance: tolerance}, nil
+}
+
+// Check compares the figures of the response with the market data. Figures without a metric keyword
+// before them, like years, are not checked.
+func (c *FactChecker) Check(response string, facts map[string][]float64) FactCheck {
+	var check FactCheck
+
+	// Citations like [2] are not figures
+	response = citationPattern.ReplaceAllStringFunc(response, func(citation string) string {
+		return strings.Repeat(" ", len(citation))
+	})
+
+	for _, f := range responseFigures(response) {
+		metric, found := figureMetric(response, f)
+		if !found {
+			continue
+		}
+
+		fields := metric.values
+		if f.percent {
+			fields = metric.percents
+		}
+
+		var candidates []float64
+		for _, field := range fields {
+			for _, value := range facts[field] {
+				candidates = append(candidates, value)
+				// Percentages are stored as fractions in some of the market data, and decreases
+				// are written without the sign, like "fell 5%"
+				if f.percent {
+					candidates = append(candidates, value*100, math.Abs(value), math.Abs(value*100))
+				}
+			}
+		}
+
+		if len(candidates) == 0 {
+			check.Unverified++
+			continue
+		}
+
+		check.Checked++
+		closest, matched := c.closest(f, candidates)
+		if !matched {
+			check.Mismatches = append(check.Mismatches, FactMismatch{
+				Metric:  metric.name,
+				Claim:   strings.TrimSpace(f.text),
+				Value:   f.value,
+				Closest: closest,
+			})
+		}
+	}
+
+	return check
+}
+
+// closest returns the candidate closest to the figure and whether it's within the tolerance
+func (c *FactChecker) closest(f figure, candidates []float64) (float64, bool) {
+	closest := candidates[0]
+	for _, candidate := range candidates {
+		if math.Abs(candidate-f.value) < math.Abs(closest-f.value) {
+			closest = candidate
+		}
+	}
+
+	difference := math.Abs(closest - f.value)
+	return closest, difference <= f.precision || difference <= c.tolerance*math.Abs(closest)
+}
+
+func responseFigures(response string) []figure {
+	var figures []figure
+
+	for _, match := range figurePattern.FindAllStringSubmatchIndex(response, -1) {
+		group := func(i int) string {
+			if match[2*i] < 0 {
+				return ""
+			}
+			return response[match[2*i]:match[2*i+1]]
+		}
+
+		integer, decimals, unit := group(3), group(4), group(5)
+		value, err := strconv.ParseFloat(strings.ReplaceAll(integer, ",", "")+"."+decimals+"0", 64)
+		if err != nil {
+			continue
+		}
+
+		// Years are not figures of the metrics
+		if group(2) == "" && decimals == "" && unit == "" && value >= 1900 && value <= 2100 {
+			continue
+		}
+
+		f := figure{
+			text:      response[match[4]:match[1]],
+			percent:   unit == "%" || unit == "percent",
+			precision: 0.5 * math.Pow(10, -float64(len(decimals))),
+			start:     match[4],
+		}
+		if scale, found := figureUnits[unit]; found {
+			value *= scale
+			f.precision *= scale
+		}
+		f.value = value
+
+		figures = append(figures, f)
+	}
+
+	return figures
+}
+
+// figureMetric returns the metric with the nearest keyword before the figure, in the same sentence
+func figureMetric(response string, f figure) (factCheckMetric, bool) {
+	windowStart := max(0, f.start-keywordWindow)
+	window := response[windowStart:f.start]
+	if end := max(strings.LastIndex(window, ". "), strings.LastIndex(window, "\n")); end >= 0 {
+		window = window[end+1:]
+	}
+
+	var metric factCheckMetric
+	nearest := -1
+	for _, m := range factCheckMetrics {
+		matches := m.keywords.FindAllStringIndex(window, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		if end := matches[len(matches)-1][1]; end > nearest {
+			metric, nearest = m, end
+		}
+	}
+
+	return metric, nearest >= 0
+}
+
+// marketDataFacts returns the numeric fields of the market data by their lowercased name, including the
+// fields of nested structs, slices and maps and the numeric string fields like "28.5" or "1.3%"
+func marketDataFacts(data ...any) map[string][]float64 {
+	facts := make(map[string][]float64)
+	for _, d := range data {
+		collectFacts(reflect.ValueOf(d), "", facts)
+	}
+	return facts
+}
+
+func collectFacts(v reflect.Value, name string, facts map[string][]float64) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collectFacts(v.Elem(), name, facts)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			collectFacts(v.Field(i), strings.ToLower(v.Type().Field(i).Name), facts)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			collectFacts(v.Index(i), name, facts)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			collectFacts(iter.Value(), name, facts)
+		}
+	case reflect.Float32, reflect.Float64:
+		facts[name] = append(facts[name], v.Float())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		facts[name] = append(facts[name], float64(v.Int()))
+	case reflect.String:
+		cleaned := strings.ReplaceAll(strings.TrimSuffix(strings.TrimSpace(v.String()), "%"), ",", "")
+		if value, err := strconv.ParseFloat(cleaned, 64); err == nil {
+			facts[name] = append(facts[name], value)
+		}
+	}
+}
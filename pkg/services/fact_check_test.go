@@ -0,0 +1,70 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFactChecker_Check(t *testing.T) {
+	checker, _ := NewFactChecker(0.02)
+	facts := marketDataFacts(struct {
+		Revenue       string
+		EpsDil        float64
+		PeRatio       string
+		GrossMargin   float64
+		DividendYield string
+	}{
+		Revenue:       "94,930,000,000",
+		EpsDil:        1.64,
+		PeRatio:       "28.51",
+		GrossMargin:   0.462,
+		DividendYield: "0.45%",
+	})
+
+	tests := []struct {
+		name       string
+		response   string
+		checked    int
+		unverified int
+		mismatches []FactMismatch
+	}{
+		{
+			name:     "Rounded figures match",
+			response: "In 2024 revenue was $94.9 billion [1] and EPS was $1.64, a P/E of 28.5.",
+			checked:  3,
+		},
+		{
+			name:     "Percentages stored as fractions match",
+			response: "The gross margin is 46.2% [2] and the dividend yield is 0.45%.",
+			checked:  2,
+		},
+		{
+			name:       "Figures of metrics without market data are unverified",
+			response:   "Operating margin was 30% in Q3.",
+			unverified: 1,
+		},
+		{
+			name:     "Figures without a metric are not checked",
+			response: "Apple was founded in 1976 and has 164,000 employees.",
+		},
+		{
+			name:     "Mismatched figures",
+			response: "Revenue reached $90 billion [1], while EPS was 1.64.",
+			checked:  2,
+			mismatches: []FactMismatch{
+				{Metric: "revenue", Claim: "$90 billion", Value: 90e9, Closest: 94.93e9},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checker.Check(tt.response, facts)
+
+			assert.Equal(t, tt.checked, check.Checked)
+			assert.Equal(t, tt.unverified, check.Unverified)
+			assert.Equal(t, tt.mismatches, check.Mismatches)
+		})
+	}
+}
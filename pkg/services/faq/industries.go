@@ -0,0 +1,19 @@
+package faq
+
+var IndustriesFaq = []string{
+	"What is the difference between a sector and an industry?",
+	"Which are the largest industries by market cap?",
+	"Which industries have performed best over the last year?",
+	"Which industries pay the highest dividends?",
+	"Which industries have the highest profit margins?",
+	"What are the biggest companies in the semiconductors industry?",
+	"How does the P/E ratio differ between industries?",
+	"Which industries tend to be defensive during a recession?",
+	"How should a beginner compare companies within the same industry?",
+	"What are the main risks of investing in a single industry?",
+	"Which industries are most sensitive to interest rate changes?",
+	"How do commodity prices affect the oil and gas industry?",
+	"What drives the growth of the software industry?",
+	"How does the banking industry make money?",
+	"Are there ETFs that track a specific industry?",
+}
@@ -4,14 +4,27 @@ import (
 	"fmt"
 	"investbot/pkg/errors"
 	"investbot/pkg/services/faq"
-	"math/rand"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
+// trendingFaqTtl is how long the trending questions of a topic are cached before they are mined again
+const trendingFaqTtl = 10 * time.Minute
+
+// trendingFaqErrorTtl is how long a failure to mine the trending questions is cached, so that an unavailable
+// store isn't queried by every faq request
+const trendingFaqErrorTtl = time.Minute
+
 type FaqTopic string
 
 const (
 	EDUCATION_FAQ_TOPIC        FaqTopic = "education"
 	SECTORS_FAQ_TOPIC          FaqTopic = "sectors"
+	INDUSTRIES_FAQ_TOPIC       FaqTopic = "industries"
 	STOCK_OVERVIEW_FAQ_TOPIC   FaqTopic = "stock_overview"
 	BALANCE_SHEET_FAQ_TOPIC    FaqTopic = "balance_sheet"
 	INCOME_STATEMENT_FAQ_TOPIC FaqTopic = "income_statement"
@@ -19,15 +32,60 @@ const (
 	ETF_FAQ_TOPIC              FaqTopic = "etfs"
 )
 
+// matchesFaqTopic returns whether the extracted question belongs to the faq topic. The statements
+// topics are the financials questions about the statement.
+func matchesFaqTopic(topic FaqTopic, extraction TopicAndTags) bool {
+	switch topic {
+	case BALANCE_SHEET_FAQ_TOPIC:
+		return extraction.Topic == STOCK_FINANCIALS && extraction.Tags.BalanceSheet
+	case INCOME_STATEMENT_FAQ_TOPIC:
+		return extraction.Topic == STOCK_FINANCIALS && extraction.Tags.IncomeStatement
+	case CASH_FLOW_FAQ_TOPIC:
+		return extraction.Topic == STOCK_FINANCIALS && extraction.Tags.CashFlow
+	default:
+		return string(extraction.Topic) == string(topic)
+	}
+}
+
+type FaqConf struct {
+	Limit             int           // Number of questions of a faq
+	TrendingLimit     int           // Maximum number of trending questions of a faq, 0 only returns curated questions
+	TrendingLookback  time.Duration // Age of the oldest question that the trending questions are mined from
+	TrendingMinAskers int           // Number of sessions that must have asked a question for it to be trending
+}
+
+// FaqQuestion is a question of a faq, either curated or trending
+type FaqQuestion struct {
+	Question string
+	Trending bool
+}
+
+// trendingFaq is the cache of the trending questions of a topic. Its mutex is held while they are mined, so
+// that the requests of the topic wait for a single query and the other topics are not blocked.
+type trendingFaq struct {
+	mutex     sync.Mutex
+	clusters  []faqCluster
+	err       error
+	expiresAt time.Time
+}
+
+// FaqService combines the curated questions of a topic with the questions that were recently asked the
+// most. The trending questions are mined from the stored topic and tags extractions.
 type FaqService struct {
-	topicToFaq map[FaqTopic][]string
-	faqLimit   int
+	topicToFaq         map[FaqTopic][]string
+	extractions        TopicAndTagsReader
+	userContextService UserContextDataService
+	conf               FaqConf
+
+	mutex    sync.Mutex
+	trending map[FaqTopic]*trendingFaq
 }
 
-func NewFaqService(faqLimit int) (*FaqService, error) {
+func NewFaqService(extractions TopicAndTagsReader, userContextService UserContextDataService, conf FaqConf) (*FaqService, error) {
 	topicToFaq := map[FaqTopic][]string{
 		EDUCATION_FAQ_TOPIC:        faq.EduationFaq,
 		SECTORS_FAQ_TOPIC:          faq.SectorsFaq,
+		INDUSTRIES_FAQ_TOPIC:       faq.IndustriesFaq,
 		STOCK_OVERVIEW_FAQ_TOPIC:   faq.StockOverviewFaq,
 		BALANCE_SHEET_FAQ_TOPIC:    faq.BalanceSheetFaq,
 		INCOME_STATEMENT_FAQ_TOPIC: faq.IncomeStatementFaq,
@@ -35,35 +93,169 @@ func NewFaqService(faqLimit int) (*FaqService, error) {
 		ETF_FAQ_TOPIC:              faq.EtfFaq,
 	}
 
-	return &FaqService{topicToFaq: topicToFaq, faqLimit: faqLimit}, nil
+	return &FaqService{
+		topicToFaq:         topicToFaq,
+		extractions:        extractions,
+		userContextService: userContextService,
+		conf:               conf,
+		trending:           make(map[FaqTopic]*trendingFaq),
+	}, nil
 }
 
-func (s FaqService) GetFaqForTopic(topic FaqTopic) ([]string, error) {
-	faqSlice, found := s.topicToFaq[topic]
+// GetFaqForTopic returns the trending questions of the topic followed by random curated questions. With a
+// user, the trending questions about the symbols of the portfolio of the user come first.
+func (s *FaqService) GetFaqForTopic(topic FaqTopic, userID string) ([]FaqQuestion, error) {
+	curated, found := s.topicToFaq[topic]
 	if !found {
 		return nil, &errors.FaqTopicNotFoundError{Message: fmt.Sprintf("FaqTopic for %s not found", topic)}
 	}
 
-	randomFaq := s.pickRandomStrings(faqSlice, s.faqLimit)
+	questions := make([]FaqQuestion, 0, s.conf.Limit)
+	for _, question := range s.trendingQuestions(topic, userID) {
+		questions = append(questions, FaqQuestion{Question: question, Trending: true})
+	}
+	for _, question := range pickRandomStrings(curated, s.conf.Limit-len(questions)) {
+		questions = append(questions, FaqQuestion{Question: question})
+	}
+
+	return questions, nil
+}
+
+// trendingQuestions returns up to TrendingLimit trending questions of the topic. The faq falls back to
+// the curated questions when the extractions can't be read.
+func (s *FaqService) trendingQuestions(topic FaqTopic, userID string) []string {
+	limit := min(s.conf.TrendingLimit, s.conf.Limit)
+	if limit <= 0 || s.extractions == nil {
+		return nil
+	}
+
+	clusters, err := s.trendingClusters(topic)
+	if err != nil {
+		log.Printf("Failed to mine the trending questions of %s: %s", topic, err.Error())
+		return nil
+	}
+
+	// The clusters are sorted from the most asked, the stable sort keeps that order within the questions
+	// about the portfolio and the others
+	portfolioSymbols := s.portfolioSymbols(userID)
+	if len(portfolioSymbols) > 0 {
+		clusters = slices.Clone(clusters)
+		slices.SortStableFunc(clusters, func(a, b faqCluster) int {
+			return boolRank(mentionsAny(b.Symbols, portfolioSymbols)) - boolRank(mentionsAny(a.Symbols, portfolioSymbols))
+		})
+	}
+
+	questions := make([]string, 0, limit)
+	for _, cluster := range clusters[:min(limit, len(clusters))] {
+		questions = append(questions, cluster.Question)
+	}
+	return questions
+}
+
+// trendingClusters returns the clusters of the questions of the topic asked by enough sessions that are
+// not near-duplicates of a curated question. They are cached for trendingFaqTtl, and a failure for
+// trendingFaqErrorTtl.
+func (s *FaqService) trendingClusters(topic FaqTopic) ([]faqCluster, error) {
+	s.mutex.Lock()
+	cached, ok := s.trending[topic]
+	if !ok {
+		cached = &trendingFaq{}
+		s.trending[topic] = cached
+	}
+	s.mutex.Unlock()
+
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(cached.expiresAt) {
+		return cached.clusters, cached.err
+	}
+
+	clusters, err := s.mineTrendingClusters(topic, now)
+	if err != nil {
+		cached.clusters, cached.err, cached.expiresAt = nil, err, now.Add(trendingFaqErrorTtl)
+		return nil, err
+	}
+
+	cached.clusters, cached.err, cached.expiresAt = clusters, nil, now.Add(trendingFaqTtl)
+	return clusters, nil
+}
+
+// mineTrendingClusters clusters the questions of the topic asked in the lookback before now
+func (s *FaqService) mineTrendingClusters(topic FaqTopic, now time.Time) ([]faqCluster, error) {
+	extractions, err := s.extractions.QueryTopicAndTags(TopicAndTagsQuery{From: now.Add(-s.conf.TrendingLookback), To: now})
+	if err != nil {
+		return nil, err
+	}
+
+	var topicExtractions []TopicAndTags
+	for _, extraction := range extractions {
+		if matchesFaqTopic(topic, extraction) {
+			topicExtractions = append(topicExtractions, extraction)
+		}
+	}
+
+	curatedTokens := make([]map[string]bool, 0, len(s.topicToFaq[topic]))
+	for _, question := range s.topicToFaq[topic] {
+		curatedTokens = append(curatedTokens, faqTokens(question))
+	}
+
+	var clusters []faqCluster
+	for _, cluster := range clusterQuestions(topicExtractions) {
+		if cluster.Askers() < s.conf.TrendingMinAskers {
+			continue
+		}
+		curated := slices.ContainsFunc(curatedTokens, func(tokens map[string]bool) bool {
+			return faqSimilarity(tokens, cluster.tokens) >= faqSimilarityThreshold
+		})
+		if !curated {
+			clusters = append(clusters, cluster)
+		}
+	}
 
-	return randomFaq, nil
+	return clusters, nil
 }
 
-// pickRandomStrings selects k random strings from the given slice without modifying the original.
-func (s FaqService) pickRandomStrings(original []string, k int) []string {
-	n := len(original)
-	if k > n {
-		k = n // Ensure k does not exceed the length of original
+// portfolioSymbols returns the symbols of the portfolio of the user, none when the user has no context
+func (s *FaqService) portfolioSymbols(userID string) []string {
+	if userID == "" || s.userContextService == nil {
+		return nil
+	}
+
+	userContext, err := s.userContextService.GetUserContext(userID)
+	if err != nil {
+		return nil
+	}
+
+	symbols := make([]string, 0, len(userContext.UserPortfolio))
+	for _, holding := range userContext.UserPortfolio {
+		symbols = append(symbols, strings.ToUpper(holding.Symbol))
 	}
+	return symbols
+}
+
+func mentionsAny(symbols []string, portfolioSymbols []string) bool {
+	return slices.ContainsFunc(symbols, func(symbol string) bool {
+		return slices.Contains(portfolioSymbols, symbol)
+	})
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
 
-	// Create a new slice of length k to hold the result
-	result := make([]string, k)
+// pickRandomStrings selects k random strings from the given slice without modifying it, so that it's
+// safe to call concurrently on the shared faq slices
+func pickRandomStrings(original []string, k int) []string {
+	k = max(min(k, len(original)), 0)
 
-	// Shuffle only the necessary part
-	for i := 0; i < k; i++ {
-		j := rand.Intn(n-i) + i                             // Pick a random index from i to n-1
-		original[i], original[j] = original[j], original[i] // Swap
-		result[i] = original[i]                             // Copy the selected element into result
+	result := make([]string, 0, k)
+	for _, i := range rand.Perm(len(original))[:k] {
+		result = append(result, original[i])
 	}
 
 	return result
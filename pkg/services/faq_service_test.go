@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"investbot/pkg/domain"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func askedQuestion(question string, sessionID string, topic Topic, tags Tags) TopicAndTags {
+	return TopicAndTags{Topic: topic, Tags: tags, Question: question, SessionID: sessionID, CreatedAt: time.Now().Add(-time.Hour)}
+}
+
+func TestClusterQuestions(t *testing.T) {
+	clusters := clusterQuestions([]TopicAndTags{
+		askedQuestion("What is the P/E ratio of NVDA?", "1", STOCK_OVERVIEW, Tags{StockSymbols: []string{"NVDA"}}),
+		askedQuestion("what is the p/e ratio of nvda", "2", STOCK_OVERVIEW, Tags{StockSymbols: []string{"nvda"}}),
+		askedQuestion("What's NVDA's P/E ratio?", "3", STOCK_OVERVIEW, Tags{StockSymbols: []string{"NVDA"}}),
+		askedQuestion("What is the P/E ratio of NVDA?", "1", STOCK_OVERVIEW, Tags{StockSymbols: []string{"NVDA"}}),
+		askedQuestion("How big is Apple's market cap?", "4", STOCK_OVERVIEW, Tags{StockSymbols: []string{"AAPL"}}),
+		// Low quality questions are filtered out
+		askedQuestion("NVDA", "5", STOCK_OVERVIEW, Tags{}),
+		askedQuestion("Should I sell my NVDA shares now?", "6", STOCK_OVERVIEW, Tags{}),
+		askedQuestion("Summarize https://example.com/nvda for me please", "7", STOCK_OVERVIEW, Tags{}),
+	})
+
+	assert.Len(t, clusters, 2)
+	assert.Equal(t, "What is the P/E ratio of NVDA?", clusters[0].Question)
+	assert.Equal(t, 3, clusters[0].Askers())
+	assert.Equal(t, []string{"NVDA"}, clusters[0].Symbols)
+	assert.Equal(t, "How big is Apple's market cap?", clusters[1].Question)
+}
+
+func TestFaqService_GetFaqForTopic(t *testing.T) {
+	var extractions fakeTopicAndTagsReader
+	for _, session := range []string{"1", "2", "3"} {
+		extractions = append(extractions,
+			askedQuestion("What is the P/E ratio of NVDA?", session, STOCK_OVERVIEW, Tags{StockSymbols: []string{"NVDA"}}),
+			askedQuestion("How did Tesla's deliveries change this year?", session, STOCK_OVERVIEW, Tags{StockSymbols: []string{"TSLA"}}),
+			askedQuestion("How much cash does Apple generate from operations?", session, STOCK_FINANCIALS, Tags{StockSymbols: []string{"AAPL"}, CashFlow: true}),
+		)
+	}
+	extractions = append(extractions,
+		askedQuestion("How did Tesla's deliveries change this year?", "4", STOCK_OVERVIEW, Tags{StockSymbols: []string{"TSLA"}}),
+		// Asked by a single session
+		askedQuestion("Who is the CEO of Microsoft?", "1", STOCK_OVERVIEW, Tags{StockSymbols: []string{"MSFT"}}),
+	)
+	userContexts := fakeUserContexts{"user": {UserID: "user", UserPortfolio: []domain.UserPortfolioHolding{{Symbol: "nvda"}}}}
+
+	faqService, _ := NewFaqService(extractions, userContexts, FaqConf{
+		Limit:             4,
+		TrendingLimit:     1,
+		TrendingLookback:  24 * time.Hour,
+		TrendingMinAskers: 3,
+	})
+
+	faq, err := faqService.GetFaqForTopic(STOCK_OVERVIEW_FAQ_TOPIC, "")
+	assert.NoError(t, err)
+	assert.Len(t, faq, 4)
+	assert.Equal(t, FaqQuestion{Question: "How did Tesla's deliveries change this year?", Trending: true}, faq[0])
+	for _, question := range faq[1:] {
+		assert.False(t, question.Trending)
+	}
+
+	// The trending questions about the portfolio of the user come first
+	faq, err = faqService.GetFaqForTopic(STOCK_OVERVIEW_FAQ_TOPIC, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, FaqQuestion{Question: "What is the P/E ratio of NVDA?", Trending: true}, faq[0])
+
+	faq, err = faqService.GetFaqForTopic(CASH_FLOW_FAQ_TOPIC, "")
+	assert.NoError(t, err)
+	assert.Equal(t, FaqQuestion{Question: "How much cash does Apple generate from operations?", Trending: true}, faq[0])
+
+	_, err = faqService.GetFaqForTopic("crypto", "")
+	assert.Error(t, err)
+}
+
+func TestFaqService_CuratedDuplicatesAreNotTrending(t *testing.T) {
+	var extractions fakeTopicAndTagsReader
+	for _, session := range []string{"1", "2", "3"} {
+		extractions = append(extractions, askedQuestion("Can you give me an overview of the cash flow", session, STOCK_FINANCIALS, Tags{CashFlow: true}))
+	}
+
+	faqService, _ := NewFaqService(extractions, nil, FaqConf{Limit: 3, TrendingLimit: 2, TrendingLookback: time.Hour * 24, TrendingMinAskers: 1})
+	faq, err := faqService.GetFaqForTopic(CASH_FLOW_FAQ_TOPIC, "")
+	assert.NoError(t, err)
+	assert.Len(t, faq, 3)
+	assert.False(t, slices.ContainsFunc(faq, func(question FaqQuestion) bool { return question.Trending }))
+}
+
+// blockingTopicAndTagsReader blocks its first query until release is closed and fails with err
+type blockingTopicAndTagsReader struct {
+	mutex   sync.Mutex
+	queries int
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (r *blockingTopicAndTagsReader) QueryTopicAndTags(query TopicAndTagsQuery) ([]TopicAndTags, error) {
+	r.mutex.Lock()
+	r.queries++
+	first := r.queries == 1
+	r.mutex.Unlock()
+
+	if first && r.started != nil {
+		close(r.started)
+		<-r.release
+	}
+	return nil, r.err
+}
+
+func TestFaqService_TrendingFailureIsCached(t *testing.T) {
+	reader := &blockingTopicAndTagsReader{err: errors.New("mongo is down")}
+	faqService, _ := NewFaqService(reader, nil, FaqConf{Limit: 3, TrendingLimit: 2, TrendingLookback: time.Hour, TrendingMinAskers: 1})
+
+	for range 3 {
+		faq, err := faqService.GetFaqForTopic(EDUCATION_FAQ_TOPIC, "")
+		assert.NoError(t, err)
+		assert.Len(t, faq, 3)
+	}
+	assert.Equal(t, 1, reader.queries)
+}
+
+func TestFaqService_TrendingTopicsAreMinedIndependently(t *testing.T) {
+	reader := &blockingTopicAndTagsReader{started: make(chan struct{}), release: make(chan struct{})}
+	faqService, _ := NewFaqService(reader, nil, FaqConf{Limit: 3, TrendingLimit: 2, TrendingLookback: time.Hour, TrendingMinAskers: 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = faqService.GetFaqForTopic(EDUCATION_FAQ_TOPIC, "")
+	}()
+	<-reader.started
+
+	// The slow query of the education topic doesn't block the other topics
+	faq, err := faqService.GetFaqForTopic(ETF_FAQ_TOPIC, "")
+	assert.NoError(t, err)
+	assert.Len(t, faq, 3)
+
+	close(reader.release)
+	<-done
+}
+
+func TestPickRandomStrings(t *testing.T) {
+	original := []string{"a", "b", "c", "d", "e"}
+	snapshot := slices.Clone(original)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			picked := pickRandomStrings(original, 3)
+			assert.Len(t, picked, 3)
+			slices.Sort(picked)
+			assert.Len(t, slices.Compact(picked), 3)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, snapshot, original)
+	assert.Len(t, pickRandomStrings(original, 10), 5)
+	assert.Empty(t, pickRandomStrings(original, -1))
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	minFaqQuestionLength = 15
+	maxFaqQuestionLength = 200
+	minFaqQuestionWords  = 3
+	// Questions whose words overlap more than this are near-duplicates
+	faqSimilarityThreshold = 0.6
+)
+
+// faqStopWords are ignored when comparing questions, so that "What is the P/E of NVDA?" and "what's
+// NVDA's P/E" are near-duplicates
+var faqStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "was": true, "of": true, "for": true,
+	"to": true, "in": true, "on": true, "and": true, "or": true, "what": true, "s": true, "does": true,
+	"do": true, "can": true, "you": true, "tell": true, "about": true, "please": true, "how": true,
+}
+
+// personalWords mark the questions about the situation of the user, that are not shown to the other users
+var personalWords = map[string]bool{"i": true, "i'm": true, "me": true, "my": true, "mine": true, "myself": true}
+
+// isFaqQuality returns whether the question can be shown in a faq: a short question of a few words,
+// without links, emails or details about the user that asked it
+func isFaqQuality(question string) bool {
+	length := utf8.RuneCountInString(question)
+	if length < minFaqQuestionLength || length > maxFaqQuestionLength {
+		return false
+	}
+	if strings.ContainsAny(question, "\n@") || strings.Contains(strings.ToLower(question), "http") {
+		return false
+	}
+
+	words := strings.Fields(strings.ToLower(question))
+	if len(words) < minFaqQuestionWords {
+		return false
+	}
+	for _, word := range words {
+		if personalWords[strings.TrimFunc(word, unicode.IsPunct)] {
+			return false
+		}
+	}
+	return true
+}
+
+// faqTokens returns the words of the question that are compared to find near-duplicates
+func faqTokens(question string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := make(map[string]bool, len(words))
+	for _, word := range words {
+		if !faqStopWords[word] {
+			tokens[word] = true
+		}
+	}
+	return tokens
+}
+
+// faqSimilarity is the Jaccard similarity of the tokens of two questions
+func faqSimilarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	intersection := 0
+	for token := range a {
+		if b[token] {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// faqCluster is a group of near-duplicate questions, Question is the most asked phrasing of the group
+type faqCluster struct {
+	Question string
+	tokens   map[string]bool
+	sessions map[string]bool
+	Symbols  []string // Stock and ETF symbols of the questions of the cluster
+}
+
+// Askers is the number of sessions that asked a question of the cluster
+func (c faqCluster) Askers() int {
+	return len(c.sessions)
+}
+
+// clusterQuestions groups the near-duplicate questions, the clusters are sorted from the most asked
+func clusterQuestions(extractions []TopicAndTags) []faqCluster {
+	type phrasing struct {
+		question string
+		tokens   map[string]bool
+		sessions map[string]bool
+		symbols  []string
+	}
+
+	// The questions that only differ by case, spaces and punctuation are the same phrasing
+	phrasings := map[string]*phrasing{}
+	for _, extraction := range extractions {
+		question := strings.TrimSpace(extraction.Question)
+		if !isFaqQuality(question) {
+			continue
+		}
+
+		tokens := faqTokens(question)
+		keyTokens := make([]string, 0, len(tokens))
+		for token := range tokens {
+			keyTokens = append(keyTokens, token)
+		}
+		sort.Strings(keyTokens)
+		key := strings.Join(keyTokens, " ")
+		if key == "" {
+			continue
+		}
+
+		p, ok := phrasings[key]
+		if !ok {
+			p = &phrasing{question: question, tokens: tokens, sessions: map[string]bool{}}
+			phrasings[key] = p
+		}
+		// Questions stored without a session count as their own asker
+		asker := extraction.SessionID
+		if asker == "" {
+			asker = "question:" + strings.ToLower(question)
+		}
+		p.sessions[asker] = true
+		p.symbols = append(p.symbols, upperSymbols(extraction.Tags.StockSymbols)...)
+		p.symbols = append(p.symbols, upperSymbols(extraction.Tags.EtfSymbols)...)
+	}
+
+	sorted := make([]*phrasing, 0, len(phrasings))
+	for _, p := range phrasings {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		if len(sorted[a].sessions) != len(sorted[b].sessions) {
+			return len(sorted[a].sessions) > len(sorted[b].sessions)
+		}
+		return sorted[a].question < sorted[b].question
+	})
+
+	// The most asked phrasings start the clusters, the others join the first cluster they are similar to
+	var clusters []faqCluster
+	for _, p := range sorted {
+		index := slices.IndexFunc(clusters, func(cluster faqCluster) bool {
+			return faqSimilarity(cluster.tokens, p.tokens) >= faqSimilarityThreshold
+		})
+		if index < 0 {
+			clusters = append(clusters, faqCluster{Question: p.question, tokens: p.tokens, sessions: map[string]bool{}})
+			index = len(clusters) - 1
+		}
+		for session := range p.sessions {
+			clusters[index].sessions[session] = true
+		}
+		clusters[index].Symbols = append(clusters[index].Symbols, p.symbols...)
+	}
+
+	for i := range clusters {
+		slices.Sort(clusters[i].Symbols)
+		clusters[i].Symbols = slices.Compact(clusters[i].Symbols)
+	}
+	sort.SliceStable(clusters, func(a, b int) bool {
+		return clusters[a].Askers() > clusters[b].Askers()
+	})
+	return clusters
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/errors"
+	"slices"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+const maxFeedbackCommentLength = 2000
+
+// FeedbackRating is the thumbs up or down of a response
+type FeedbackRating string
+
+const (
+	PositiveRating FeedbackRating = "up"
+	NegativeRating FeedbackRating = "down"
+)
+
+// FeedbackCategory is the optional reason of a feedback
+type FeedbackCategory string
+
+const (
+	WrongNumbersCategory FeedbackCategory = "wrong_numbers"
+	IrrelevantCategory   FeedbackCategory = "irrelevant"
+	TooLongCategory      FeedbackCategory = "too_long"
+)
+
+var FeedbackCategories = []FeedbackCategory{WrongNumbersCategory, IrrelevantCategory, TooLongCategory}
+
+// Feedback is the rating of an assistant message by the user. Topic, Tags, Model, PromptName, PromptVersion,
+// Experiment and RagResponseID are copied from the metadata of the message, so that the feedback can be
+// reported without reading the sessions. The feedback joins the topic and tags store by SessionID and
+// Question, and the rag responses store by RagResponseID.
+type Feedback struct {
+	ID            string // <session id>:<message id>, a message has a single feedback
+	SessionID     string
+	MessageID     string
+	UserID        string
+	Rating        FeedbackRating
+	Category      FeedbackCategory `json:",omitempty" bson:",omitempty"`
+	Comment       string           `json:",omitempty" bson:",omitempty"`
+	Question      string           // The user message that the rated message answers
+	Topic         Topic
+	Tags          Tags
+	Model         string
+	PromptName    string
+	PromptVersion string
+	Experiment    *Assignment `json:",omitempty" bson:",omitempty"`
+	RagResponseID string      `json:",omitempty" bson:",omitempty"`
+	CreatedAt     time.Time
+}
+
+func feedbackID(sessionID string, messageID string) string {
+	return sessionID + ":" + messageID
+}
+
+// FeedbackQuery filters the feedback. A zero From or To doesn't limit, an empty Experiment matches all the feedback.
+type FeedbackQuery struct {
+	From       time.Time
+	To         time.Time
+	Experiment string
+}
+
+func (q FeedbackQuery) Matches(feedback Feedback) bool {
+	if !q.From.IsZero() && feedback.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !feedback.CreatedAt.Before(q.To) {
+		return false
+	}
+	return q.Experiment == "" || (feedback.Experiment != nil && feedback.Experiment.Experiment == q.Experiment)
+}
+
+type FeedbackRepository interface {
+	// StoreFeedback stores the feedback, replacing the previous feedback of the message
+	StoreFeedback(feedback Feedback) error
+	QueryFeedback(query FeedbackQuery) ([]Feedback, error)
+}
+
+// FeedbackRequest is the feedback of a user on a message of a session
+type FeedbackRequest struct {
+	SessionID string
+	MessageID string
+	UserID    string
+	Rating    FeedbackRating
+	Category  FeedbackCategory
+	Comment   string
+}
+
+func (r FeedbackRequest) validate() error {
+	if r.SessionID == "" || r.MessageID == "" {
+		return errors.InvalidFeedbackError{Message: "the session and the message are required"}
+	}
+	if r.Rating != PositiveRating && r.Rating != NegativeRating {
+		return errors.InvalidFeedbackError{Message: fmt.Sprintf("rating must be %s or %s", PositiveRating, NegativeRating)}
+	}
+	if r.Category != "" && !slices.Contains(FeedbackCategories, r.Category) {
+		return errors.InvalidFeedbackError{Message: fmt.Sprintf("unknown category %s", r.Category)}
+	}
+	if utf8.RuneCountInString(r.Comment) > maxFeedbackCommentLength {
+		return errors.InvalidFeedbackError{Message: fmt.Sprintf("comment can't be longer than %d characters", maxFeedbackCommentLength)}
+	}
+	return nil
+}
+
+type FeedbackService struct {
+	sessionService SessionService
+	repository     FeedbackRepository
+}
+
+func NewFeedbackService(sessionService SessionService, repository FeedbackRepository) (*FeedbackService, error) {
+	return &FeedbackService{sessionService: sessionService, repository: repository}, nil
+}
+
+// SubmitFeedback records the feedback on an assistant message. Like the other session operations, the
+// messages of a session that belongs to a user can only be rated by that user.
+func (s *FeedbackService) SubmitFeedback(request FeedbackRequest) (Feedback, error) {
+	if err := request.validate(); err != nil {
+		return Feedback{}, err
+	}
+
+	session, err := s.sessionService.GetSession(request.SessionID)
+	if err != nil {
+		return Feedback{}, err
+	}
+	if session.UserID != "" && session.UserID != request.UserID {
+		return Feedback{}, errors.SessionForbiddenError{SessionID: request.SessionID, UserID: request.UserID}
+	}
+
+	conversation, err := s.sessionService.GetMessages(request.SessionID)
+	if err != nil {
+		return Feedback{}, err
+	}
+	index := slices.IndexFunc(conversation, func(message Message) bool { return message.ID == request.MessageID })
+	if index < 0 {
+		return Feedback{}, errors.MessageNotFoundError{SessionID: request.SessionID, MessageID: request.MessageID}
+	}
+	message := conversation[index]
+	if message.Role != Assistant {
+		return Feedback{}, errors.InvalidFeedbackError{Message: "only the responses of the assistant can be rated"}
+	}
+
+	feedback := Feedback{
+		ID:        feedbackID(request.SessionID, request.MessageID),
+		SessionID: request.SessionID,
+		MessageID: request.MessageID,
+		UserID:    request.UserID,
+		Rating:    request.Rating,
+		Category:  request.Category,
+		Comment:   request.Comment,
+		CreatedAt: time.Now(),
+	}
+	for i := index - 1; i >= 0; i-- {
+		if conversation[i].Role == User {
+			feedback.Question = conversation[i].Content
+			break
+		}
+	}
+	if metadata := message.Metadata; metadata != nil {
+		feedback.Topic = metadata.Topic
+		feedback.Tags = metadata.Tags
+		feedback.Model = metadata.Model
+		feedback.PromptName = metadata.PromptName
+		feedback.PromptVersion = metadata.PromptVersion
+		feedback.Experiment = metadata.Experiment
+		feedback.RagResponseID = metadata.RagResponseID
+	}
+
+	if err := s.repository.StoreFeedback(feedback); err != nil {
+		return Feedback{}, err
+	}
+	return feedback, nil
+}
+
+// FeedbackReportGroup counts the feedback on the responses of a topic, model and prompt version
+type FeedbackReportGroup struct {
+	Topic         Topic
+	Model         string
+	PromptName    string
+	PromptVersion string
+	Positive      int
+	Negative      int
+	PositiveRatio float64
+	Categories    map[FeedbackCategory]int
+}
+
+type FeedbackReport struct {
+	From   time.Time
+	To     time.Time
+	Groups []FeedbackReportGroup
+}
+
+// Report counts the feedback created between from and to by topic, model and prompt version
+func (s *FeedbackService) Report(from time.Time, to time.Time) (FeedbackReport, error) {
+	feedback, err := s.repository.QueryFeedback(FeedbackQuery{From: from, To: to})
+	if err != nil {
+		return FeedbackReport{}, err
+	}
+
+	type groupKey struct {
+		topic         Topic
+		model         string
+		promptName    string
+		promptVersion string
+	}
+	groups := map[groupKey]*FeedbackReportGroup{}
+	for _, f := range feedback {
+		key := groupKey{f.Topic, f.Model, f.PromptName, f.PromptVersion}
+		group, ok := groups[key]
+		if !ok {
+			group = &FeedbackReportGroup{
+				Topic:         f.Topic,
+				Model:         f.Model,
+				PromptName:    f.PromptName,
+				PromptVersion: f.PromptVersion,
+				Categories:    map[FeedbackCategory]int{},
+			}
+			groups[key] = group
+		}
+
+		if f.Rating == PositiveRating {
+			group.Positive++
+		} else {
+			group.Negative++
+		}
+		if f.Category != "" {
+			group.Categories[f.Category]++
+		}
+	}
+
+	report := FeedbackReport{From: from, To: to, Groups: make([]FeedbackReportGroup, 0, len(groups))}
+	for _, group := range groups {
+		group.PositiveRatio = float64(group.Positive) / float64(group.Positive+group.Negative)
+		report.Groups = append(report.Groups, *group)
+	}
+
+	sort.Slice(report.Groups, func(a, b int) bool {
+		ga, gb := report.Groups[a], report.Groups[b]
+		if ga.Topic != gb.Topic {
+			return ga.Topic < gb.Topic
+		}
+		if ga.Model != gb.Model {
+			return ga.Model < gb.Model
+		}
+		return ga.PromptVersion < gb.PromptVersion
+	})
+
+	return report, nil
+}
+
+// GetVariantFeedback counts the ratings of the responses of the experiment by variant, so that the
+// experiment reports can compare the variants by feedback
+func (s *FeedbackService) GetVariantFeedback(experiment string) (map[string]VariantFeedback, error) {
+	feedback, err := s.repository.QueryFeedback(FeedbackQuery{Experiment: experiment})
+	if err != nil {
+		return nil, err
+	}
+
+	variants := map[string]VariantFeedback{}
+	for _, f := range feedback {
+		if f.Experiment == nil {
+			continue
+		}
+		variant := variants[f.Experiment.Variant]
+		if f.Rating == PositiveRating {
+			variant.Positive++
+		} else {
+			variant.Negative++
+		}
+		variants[f.Experiment.Variant] = variant
+	}
+	return variants, nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	investbotErr "investbot/pkg/errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFeedbackRepository map[string]Feedback
+
+func (f fakeFeedbackRepository) StoreFeedback(feedback Feedback) error {
+	f[feedback.ID] = feedback
+	return nil
+}
+
+func (f fakeFeedbackRepository) QueryFeedback(query FeedbackQuery) ([]Feedback, error) {
+	var feedback []Feedback
+	for _, stored := range f {
+		if query.Matches(stored) {
+			feedback = append(feedback, stored)
+		}
+	}
+	return feedback, nil
+}
+
+func TestFeedbackService_SubmitFeedback(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	sessionID, _ := sessionService.CreateNewSession("user")
+	_ = sessionService.AddMessage(sessionID, Message{ID: "question", Role: User, Content: "What's Apple's free cash flow?"})
+	_ = sessionService.AddMessage(sessionID, Message{
+		ID:      "answer",
+		Role:    Assistant,
+		Content: "Apple's free cash flow was $99.58B.",
+		Metadata: &MessageMetadata{
+			Topic:         STOCK_FINANCIALS,
+			Tags:          Tags{StockSymbols: []string{"AAPL"}},
+			Model:         "model",
+			PromptName:    "stock_financials",
+			PromptVersion: "v2",
+			Experiment:    &Assignment{Experiment: "prompts", Variant: "candidate"},
+			RagResponseID: "response",
+		},
+	})
+
+	repository := fakeFeedbackRepository{}
+	feedbackService, _ := NewFeedbackService(sessionService, repository)
+
+	feedback, err := feedbackService.SubmitFeedback(FeedbackRequest{
+		SessionID: sessionID,
+		MessageID: "answer",
+		UserID:    "user",
+		Rating:    NegativeRating,
+		Category:  WrongNumbersCategory,
+		Comment:   "it was $108B",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "What's Apple's free cash flow?", feedback.Question)
+	assert.Equal(t, STOCK_FINANCIALS, feedback.Topic)
+	assert.Equal(t, []string{"AAPL"}, feedback.Tags.StockSymbols)
+	assert.Equal(t, "v2", feedback.PromptVersion)
+	assert.Equal(t, "response", feedback.RagResponseID)
+
+	// The last feedback on a message replaces the previous one
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "answer", UserID: "user", Rating: PositiveRating})
+	assert.NoError(t, err)
+	assert.Len(t, repository, 1)
+	assert.Equal(t, PositiveRating, repository[feedbackID(sessionID, "answer")].Rating)
+
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "question", UserID: "user", Rating: PositiveRating})
+	assert.ErrorAs(t, err, &investbotErr.InvalidFeedbackError{})
+
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "answer", UserID: "user", Rating: "meh"})
+	assert.ErrorAs(t, err, &investbotErr.InvalidFeedbackError{})
+
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "answer", UserID: "user", Rating: NegativeRating, Category: "rude"})
+	assert.ErrorAs(t, err, &investbotErr.InvalidFeedbackError{})
+
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "missing", UserID: "user", Rating: PositiveRating})
+	assert.ErrorAs(t, err, &investbotErr.MessageNotFoundError{})
+
+	_, err = feedbackService.SubmitFeedback(FeedbackRequest{SessionID: sessionID, MessageID: "answer", UserID: "other_user", Rating: PositiveRating})
+	assert.ErrorAs(t, err, &investbotErr.SessionForbiddenError{})
+}
+
+func TestFeedbackService_Report(t *testing.T) {
+	now := time.Now()
+	feedback := func(id string, promptVersion string, rating FeedbackRating, category FeedbackCategory, experiment *Assignment) Feedback {
+		return Feedback{
+			ID:            id,
+			Rating:        rating,
+			Category:      category,
+			Topic:         NEWS,
+			Model:         "model",
+			PromptName:    "news",
+			PromptVersion: promptVersion,
+			Experiment:    experiment,
+			CreatedAt:     now,
+		}
+	}
+	control := &Assignment{Experiment: "prompts", Variant: "control"}
+	candidate := &Assignment{Experiment: "prompts", Variant: "candidate"}
+	repository := fakeFeedbackRepository{
+		"1": feedback("1", "v1", PositiveRating, "", control),
+		"2": feedback("2", "v1", NegativeRating, TooLongCategory, control),
+		"3": feedback("3", "v1", NegativeRating, TooLongCategory, nil),
+		"4": feedback("4", "v2", PositiveRating, "", candidate),
+	}
+	old := feedback("5", "v1", PositiveRating, "", nil)
+	old.CreatedAt = now.AddDate(0, 0, -30)
+	repository["5"] = old
+
+	feedbackService, _ := NewFeedbackService(nil, repository)
+	report, err := feedbackService.Report(now.AddDate(0, 0, -7), now.Add(time.Minute))
+	assert.NoError(t, err)
+
+	assert.Len(t, report.Groups, 2)
+	assert.Equal(t, FeedbackReportGroup{
+		Topic:         NEWS,
+		Model:         "model",
+		PromptName:    "news",
+		PromptVersion: "v1",
+		Positive:      1,
+		Negative:      2,
+		PositiveRatio: 1.0 / 3,
+		Categories:    map[FeedbackCategory]int{TooLongCategory: 2},
+	}, report.Groups[0])
+	assert.Equal(t, "v2", report.Groups[1].PromptVersion)
+
+	variants, err := feedbackService.GetVariantFeedback("prompts")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]VariantFeedback{
+		"control":   {Positive: 1, Negative: 1},
+		"candidate": {Positive: 1},
+	}, variants)
+}
@@ -3,23 +3,135 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"investbot/pkg/domain"
 	"investbot/pkg/errors"
 	"investbot/pkg/services/prompts"
 	"log"
+	"slices"
 	"strings"
+	"time"
 )
 
+// FollowUpQuestion is a suggested question with the topic and tags that answer it, so that it can be
+// sent to the chat without extracting them. The topic is empty when the prompt version doesn't classify
+// the questions.
+type FollowUpQuestion struct {
+	Question string
+	Topic    Topic
+	Tags     Tags
+}
+
 type FollowUpQuestionsRag interface {
-	GenerateFollowUpQuestions(conversation []Message, followUpQuestionsNum int) ([]string, error)
+	// GenerateFollowUpQuestions suggests questions that the rags can answer. The user context is nil for
+	// the sessions without a user.
+	GenerateFollowUpQuestions(conversation []Message, userContext *domain.UserContext, followUpQuestionsNum int) ([]FollowUpQuestion, error)
 }
 
 type FollowUpQuestionsRagImpl struct {
 	llm           Llm
 	responseStore RagResponsesRepository
+	experimentVariant
+}
+
+type llmFollowUpQuestion struct {
+	Question          string   `json:"question"`
+	Topic             string   `json:"topic"`
+	StockSymbols      []string `json:"stock_symbols"`
+	EtfSymbols        []string `json:"etf_symbols"`
+	SectorName        string   `json:"sector_name"`
+	IndustryName      string   `json:"industry_name"`
+	SuperInvestorName string   `json:"super_investor_name"`
+	BalanceSheet      bool     `json:"balance_sheet"`
+	IncomeStatement   bool     `json:"income_statement"`
+	CashFlow          bool     `json:"cash_flow"`
+}
+
+// UnmarshalJSON also accepts the plain questions of the prompt versions that don't classify them
+func (q *llmFollowUpQuestion) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &q.Question); err == nil {
+		return nil
+	}
+
+	type question llmFollowUpQuestion
+	return json.Unmarshal(data, (*question)(q))
 }
 
 type llmFollowUpQuestionsResponse struct {
-	FollowUpQuestions []string `json:"follow_up_questions"`
+	FollowUpQuestions []llmFollowUpQuestion `json:"follow_up_questions"`
+}
+
+// followUpTopics are the topics that the follow up questions can be about, portfolio questions need a
+// portfolio
+var followUpTopics = []Topic{EDUCATION, SECTORS, INDUSTRIES, STOCK_OVERVIEW, STOCK_FINANCIALS, ETFS, NEWS, SUPER_INVESTORS, PORTFOLIO}
+
+// followUpQuestion returns the suggestion of the llm with its topic and tags, false when the topic
+// can't answer it. The super investor of the conversation is the only one whose scraped name is known, so
+// the questions about another super investor are dropped.
+func (q llmFollowUpQuestion) followUpQuestion(userContext *domain.UserContext, superInvestorName string) (FollowUpQuestion, bool) {
+	question := strings.TrimSpace(q.Question)
+	if question == "" {
+		return FollowUpQuestion{}, false
+	}
+	if q.Topic == "" {
+		return FollowUpQuestion{Question: question}, true
+	}
+
+	topic := Topic(strings.ToLower(strings.TrimSpace(q.Topic)))
+	tags := Tags{
+		SectorName:        strings.TrimSpace(q.SectorName),
+		IndustryName:      strings.TrimSpace(q.IndustryName),
+		SuperInvestorName: strings.TrimSpace(q.SuperInvestorName),
+		StockSymbols:      upperSymbols(q.StockSymbols),
+		EtfSymbols:        upperSymbols(q.EtfSymbols),
+		BalanceSheet:      q.BalanceSheet,
+		IncomeStatement:   q.IncomeStatement,
+		CashFlow:          q.CashFlow,
+	}
+	if userContext != nil {
+		tags.UserID = userContext.UserID
+	}
+
+	switch topic {
+	case STOCK_OVERVIEW:
+		if len(tags.StockSymbols) == 0 {
+			return FollowUpQuestion{}, false
+		}
+	case STOCK_FINANCIALS:
+		if len(tags.StockSymbols) == 0 || !(tags.BalanceSheet || tags.IncomeStatement || tags.CashFlow) {
+			return FollowUpQuestion{}, false
+		}
+	case ETFS:
+		if len(tags.EtfSymbols) == 0 {
+			return FollowUpQuestion{}, false
+		}
+	case SUPER_INVESTORS:
+		if tags.SuperInvestorName != "" {
+			if !strings.EqualFold(tags.SuperInvestorName, superInvestorName) {
+				return FollowUpQuestion{}, false
+			}
+			tags.SuperInvestorName = superInvestorName
+		}
+	case PORTFOLIO:
+		if userContext == nil || len(userContext.UserPortfolio) == 0 {
+			return FollowUpQuestion{}, false
+		}
+	default:
+		if !slices.Contains(followUpTopics, topic) {
+			return FollowUpQuestion{}, false
+		}
+	}
+
+	return FollowUpQuestion{Question: question, Topic: topic, Tags: tags}, true
+}
+
+// lastTopicAndTags returns the topic and tags of the last response of a rag in the conversation
+func lastTopicAndTags(conversation []Message) (Topic, Tags) {
+	for i := len(conversation) - 1; i >= 0; i-- {
+		if metadata := conversation[i].Metadata; metadata != nil && metadata.Topic != "" {
+			return metadata.Topic, metadata.Tags
+		}
+	}
+	return "", Tags{}
 }
 
 func NewFollowUpQuestionsRag(llm Llm, responsesStore RagResponsesRepository) (*FollowUpQuestionsRagImpl, error) {
@@ -28,17 +140,41 @@ func NewFollowUpQuestionsRag(llm Llm, responsesStore RagResponsesRepository) (*F
 
 func (rag FollowUpQuestionsRagImpl) GenerateFollowUpQuestions(
 	conversation []Message,
+	userContext *domain.UserContext,
 	followUpQuestionsNum int,
-) ([]string, error) {
-	prompt := fmt.Sprintf(prompts.FollowUpQuestionsPrompt, followUpQuestionsNum, conversation)
+) ([]FollowUpQuestion, error) {
+	var portfolio []domain.UserPortfolioHolding
+	if userContext != nil {
+		portfolio = userContext.UserPortfolio
+	}
+	topic, tags := lastTopicAndTags(conversation)
+	if topic == "" {
+		topic = "unknown"
+	}
+
+	prompt, err := rag.renderPrompt(prompts.FollowUpQuestions, prompts.Vars{
+		"Number":            followUpQuestionsNum,
+		"Conversation":      conversation,
+		"Topic":             topic,
+		"StockSymbols":      tags.StockSymbols,
+		"EtfSymbols":        tags.EtfSymbols,
+		"SectorName":        tags.SectorName,
+		"IndustryName":      tags.IndustryName,
+		"SuperInvestorName": tags.SuperInvestorName,
+		"Portfolio":         portfolio,
+	})
+	if err != nil {
+		return nil, err
+	}
 	promptMsg := Message{
 		Role:    System,
-		Content: prompt,
+		Content: prompt.Text,
 	}
 
 	// Add the prompt as the first message in the existing conversation
 	conversationWithPrompt := append([]Message{promptMsg}, conversation...)
 
+	start := time.Now()
 	responseMessage, err := streamChunks(
 		func(chunkChan chan<- string) error {
 			return rag.llm.GenerateResponse(conversationWithPrompt, chunkChan)
@@ -46,16 +182,22 @@ func (rag FollowUpQuestionsRagImpl) GenerateFollowUpQuestions(
 		nil, // no need to stream follow-up questions
 	)
 	if err != nil {
+		rag.observe(FollowUpQuestionsTarget, "", conversationWithPrompt, "", time.Since(start), false)
 		return nil, err
 	}
+	latency := time.Since(start)
 
 	go func() {
-		storeErr := rag.responseStore.StoreRagResponse(
-			rag.llm.GetLlmName(),
-			"FollowUpQuestions",
-			conversationWithPrompt,
-			responseMessage,
-		)
+		storeErr := rag.responseStore.StoreRagResponse(RagResponse{
+			ModelName:     rag.llm.GetLlmName(),
+			Topic:         "FollowUpQuestions",
+			Conversation:  conversationWithPrompt,
+			Response:      responseMessage,
+			PromptName:    prompt.Name,
+			PromptVersion: prompt.Version,
+			Experiment:    rag.assignment.Experiment,
+			Variant:       rag.assignment.Variant,
+		})
 		if storeErr != nil {
 			log.Printf("Failed to store follow up questions rag response: %s", storeErr.Error())
 		}
@@ -67,29 +209,77 @@ func (rag FollowUpQuestionsRagImpl) GenerateFollowUpQuestions(
 
 	var followUpsResponse llmFollowUpQuestionsResponse
 	err = json.Unmarshal([]byte(strippedLlmResponse), &followUpsResponse)
+	rag.observe(FollowUpQuestionsTarget, "", conversationWithPrompt, responseMessage, latency, err == nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return followUpsResponse.FollowUpQuestions, nil
+	followUpQuestions := make([]FollowUpQuestion, 0, len(followUpsResponse.FollowUpQuestions))
+	for _, llmQuestion := range followUpsResponse.FollowUpQuestions {
+		if followUpQuestion, ok := llmQuestion.followUpQuestion(userContext, tags.SuperInvestorName); ok {
+			followUpQuestions = append(followUpQuestions, followUpQuestion)
+		}
+	}
+
+	return followUpQuestions, nil
+}
+
+// FollowUpQuestionsExperimentService returns the follow up questions rag of the experiment variant of the
+// session, if there is an experiment on it
+type FollowUpQuestionsExperimentService interface {
+	FollowUpQuestions(sessionID string, userID string) (FollowUpQuestionsRag, Assignment, bool)
 }
 
 type FollowUpQuestionsService struct {
-	sessionService SessionService
-	rag            FollowUpQuestionsRag
+	sessionService     SessionService
+	rag                FollowUpQuestionsRag
+	experiments        FollowUpQuestionsExperimentService
+	userContextService UserContextDataService
 }
 
-func NewFollowUpQuestionsService(sessionService SessionService, followUpQuestionsRag FollowUpQuestionsRag) (*FollowUpQuestionsService, error) {
-	return &FollowUpQuestionsService{sessionService: sessionService, rag: followUpQuestionsRag}, nil
+func NewFollowUpQuestionsService(
+	sessionService SessionService,
+	followUpQuestionsRag FollowUpQuestionsRag,
+	experiments FollowUpQuestionsExperimentService,
+	userContextService UserContextDataService,
+) (*FollowUpQuestionsService, error) {
+	return &FollowUpQuestionsService{
+		sessionService:     sessionService,
+		rag:                followUpQuestionsRag,
+		experiments:        experiments,
+		userContextService: userContextService,
+	}, nil
 }
 
-func (s FollowUpQuestionsService) GenerateFollowUpQuestions(sessionId string, followUpQuestionsNum int) ([]string, error) {
+// GenerateFollowUpQuestions suggests questions about the conversation of the session. The questions of
+// the sessions of a user with a user context are personalized to the portfolio of the user.
+func (s FollowUpQuestionsService) GenerateFollowUpQuestions(sessionId string, followUpQuestionsNum int) ([]FollowUpQuestion, error) {
 	conversation, err := s.sessionService.GetConversationBySessionId(sessionId)
 	if err != nil {
-		return []string{}, &errors.SessionNotFoundError{
+		return []FollowUpQuestion{}, &errors.SessionNotFoundError{
 			Message: fmt.Sprintf("Conversation for session id: %s not found", sessionId),
 		}
 	}
 
-	return s.rag.GenerateFollowUpQuestions(conversation, followUpQuestionsNum)
+	session, err := s.sessionService.GetSession(sessionId)
+	if err != nil {
+		return []FollowUpQuestion{}, err
+	}
+
+	var userContext *domain.UserContext
+	if session.UserID != "" && s.userContextService != nil {
+		// Sessions of users without a context get the questions that are not personalized
+		if storedContext, err := s.userContextService.GetUserContext(session.UserID); err == nil {
+			userContext = &storedContext
+		}
+	}
+
+	rag := s.rag
+	if s.experiments != nil {
+		if variantRag, _, found := s.experiments.FollowUpQuestions(sessionId, session.UserID); found {
+			rag = variantRag
+		}
+	}
+
+	return rag.GenerateFollowUpQuestions(conversation, userContext, followUpQuestionsNum)
 }
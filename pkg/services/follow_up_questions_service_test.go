@@ -0,0 +1,136 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowUpQuestionsService_GenerateFollowUpQuestions(t *testing.T) {
+	llm := &fakeLlm{response: "```json\n" + `{"follow_up_questions": [
+		{"question": "How has Apple's free cash flow changed?", "topic": "stock_financials", "stock_symbols": ["aapl"], "cash_flow": true},
+		{"question": "How does Apple compare to Microsoft, which I own?", "topic": "stock_overview", "stock_symbols": ["AAPL", "MSFT"]},
+		{"question": "How diversified is my portfolio?", "topic": "portfolio"},
+		{"question": "What is Apple's options volume?", "topic": "options"},
+		{"question": "What about the balance sheet?", "topic": "stock_financials", "stock_symbols": []}
+	]}` + "\n```"}
+	rag, _ := NewFollowUpQuestionsRag(llm, fakeRagResponsesRepository{})
+
+	sessionService, _ := NewInMemorySession(10)
+	sessionID, _ := sessionService.CreateNewSession("user")
+	_ = sessionService.AddMessage(sessionID, Message{Role: User, Content: "What's Apple's revenue?"})
+	_ = sessionService.AddMessage(sessionID, Message{
+		Role:     Assistant,
+		Content:  "Apple's revenue was $391B.",
+		Metadata: &MessageMetadata{Topic: STOCK_FINANCIALS, Tags: Tags{StockSymbols: []string{"AAPL"}, IncomeStatement: true}},
+	})
+	userContexts := fakeUserContexts{"user": {
+		UserID:        "user",
+		UserPortfolio: []domain.UserPortfolioHolding{{Symbol: "MSFT", Name: "Microsoft", AssetClass: domain.Stock, PortfolioPercentage: 40}},
+	}}
+
+	followUpService, _ := NewFollowUpQuestionsService(sessionService, rag, nil, userContexts)
+	questions, err := followUpService.GenerateFollowUpQuestions(sessionID, 5)
+	assert.NoError(t, err)
+
+	// The questions the rags can't answer are dropped
+	assert.Equal(t, []FollowUpQuestion{
+		{
+			Question: "How has Apple's free cash flow changed?",
+			Topic:    STOCK_FINANCIALS,
+			Tags:     Tags{StockSymbols: []string{"AAPL"}, EtfSymbols: []string{}, CashFlow: true, UserID: "user"},
+		},
+		{
+			Question: "How does Apple compare to Microsoft, which I own?",
+			Topic:    STOCK_OVERVIEW,
+			Tags:     Tags{StockSymbols: []string{"AAPL", "MSFT"}, EtfSymbols: []string{}, UserID: "user"},
+		},
+		{
+			Question: "How diversified is my portfolio?",
+			Topic:    PORTFOLIO,
+			Tags:     Tags{StockSymbols: []string{}, EtfSymbols: []string{}, UserID: "user"},
+		},
+	}, questions)
+
+	// The prompt has the topic of the conversation and the portfolio of the user
+	assert.Contains(t, llm.prompts[0], "Topic: stock_financials\nStocks: AAPL")
+	assert.Contains(t, llm.prompts[0], "| MSFT | Microsoft |")
+
+	// Without a portfolio the portfolio questions are dropped
+	anonymousID, _ := sessionService.CreateNewSession("")
+	questions, err = followUpService.GenerateFollowUpQuestions(anonymousID, 5)
+	assert.NoError(t, err)
+	assert.Len(t, questions, 2)
+	assert.Empty(t, questions[0].Tags.UserID)
+	assert.NotContains(t, llm.prompts[1], "Portfolio of the user")
+}
+
+func TestFollowUpQuestionsRag_PlainQuestions(t *testing.T) {
+	// The prompt versions that don't classify the questions return plain strings
+	llm := &fakeLlm{response: `{"follow_up_questions": ["What is an ETF?", ""]}`}
+	rag, _ := NewFollowUpQuestionsRag(llm, fakeRagResponsesRepository{})
+
+	questions, err := rag.GenerateFollowUpQuestions([]Message{{Role: User, Content: "hi"}}, nil, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []FollowUpQuestion{{Question: "What is an ETF?"}}, questions)
+}
+
+func TestFollowUpQuestionsRag_IndustryQuestions(t *testing.T) {
+	llm := &fakeLlm{response: `{"follow_up_questions": [
+		{"question": "Which semiconductors companies are the largest?", "topic": "industries", "industry_name": " Semiconductors "},
+		{"question": "Which industries pay the highest dividends?", "topic": "industries", "industry_name": ""}
+	]}`}
+	rag, _ := NewFollowUpQuestionsRag(llm, fakeRagResponsesRepository{})
+
+	conversation := []Message{
+		{Role: User, Content: "Tell me about the semiconductors industry"},
+		{Role: Assistant, Content: "It has 70 stocks.", Metadata: &MessageMetadata{Topic: INDUSTRIES, Tags: Tags{IndustryName: "Semiconductors"}}},
+	}
+	questions, err := rag.GenerateFollowUpQuestions(conversation, nil, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []FollowUpQuestion{
+		{
+			Question: "Which semiconductors companies are the largest?",
+			Topic:    INDUSTRIES,
+			Tags:     Tags{IndustryName: "Semiconductors", StockSymbols: []string{}, EtfSymbols: []string{}},
+		},
+		{
+			Question: "Which industries pay the highest dividends?",
+			Topic:    INDUSTRIES,
+			Tags:     Tags{StockSymbols: []string{}, EtfSymbols: []string{}},
+		},
+	}, questions)
+	assert.Contains(t, llm.prompts[0], "Topic: industries\nIndustry: Semiconductors")
+}
+
+func TestFollowUpQuestionsRag_SuperInvestorQuestions(t *testing.T) {
+	llm := &fakeLlm{response: `{"follow_up_questions": [
+		{"question": "What did Buffett sell last quarter?", "topic": "super_investors", "super_investor_name": "warren buffett - berkshire hathaway"},
+		{"question": "What does Bill Ackman own?", "topic": "super_investors", "super_investor_name": "Bill Ackman"},
+		{"question": "Which super investors can I follow?", "topic": "super_investors", "super_investor_name": ""}
+	]}`}
+	rag, _ := NewFollowUpQuestionsRag(llm, fakeRagResponsesRepository{})
+
+	conversation := []Message{
+		{Role: User, Content: "What did Buffett buy?"},
+		{Role: Assistant, Content: "He added to OXY.", Metadata: &MessageMetadata{Topic: SUPER_INVESTORS, Tags: Tags{SuperInvestorName: "Warren Buffett - Berkshire Hathaway"}}},
+	}
+	questions, err := rag.GenerateFollowUpQuestions(conversation, nil, 3)
+	assert.NoError(t, err)
+
+	// The other super investors are dropped since their scraped name is unknown
+	assert.Equal(t, []FollowUpQuestion{
+		{
+			Question: "What did Buffett sell last quarter?",
+			Topic:    SUPER_INVESTORS,
+			Tags:     Tags{SuperInvestorName: "Warren Buffett - Berkshire Hathaway", StockSymbols: []string{}, EtfSymbols: []string{}},
+		},
+		{
+			Question: "Which super investors can I follow?",
+			Topic:    SUPER_INVESTORS,
+			Tags:     Tags{StockSymbols: []string{}, EtfSymbols: []string{}},
+		},
+	}, questions)
+	assert.Contains(t, llm.prompts[0], "Topic: super_investors\nSuper investor: Warren Buffett - Berkshire Hathaway")
+}
@@ -12,44 +12,63 @@ type IndustryDataService interface {
 }
 
 type industryContext struct {
-	industry       domain.Industry
-	industryStocks []domain.IndustryStock
+	Industry       domain.Industry
+	IndustryStocks []domain.IndustryStock
 }
 
 type IndustryRag struct {
-	dataService IndustryDataService
-	llm         Llm
+	BaseRag
+	dataService        IndustryDataService
+	userContextService UserContextDataService
 }
 
-func NewIndustryRag(llm Llm, industryDataService IndustryDataService) (*IndustryRag, error) {
-	return &IndustryRag{llm: llm, dataService: industryDataService}, nil
+func NewIndustryRag(
+	llm Llm,
+	industryDataService IndustryDataService,
+	userContextService UserContextDataService,
+	responsesStore RagResponsesRepository,
+) (*IndustryRag, error) {
+	rag := IndustryRag{
+		dataService:        industryDataService,
+		userContextService: userContextService,
+	}
+	rag.llm = llm
+	rag.topic = INDUSTRIES
+	rag.responseStore = responsesStore
+
+	return &rag, nil
 }
 
-func (rag IndustryRag) createRagContext(industryName string) (string, error) {
+func (rag IndustryRag) createRagContext(industryName string, sources *ragSources) (string, error) {
 	var ragContext string
 	industries, err := rag.dataService.GetIndustries()
 	if err != nil {
 		return ragContext, &DataServiceError{Message: fmt.Sprintf("GetIndustries failed: %s", err)}
 	}
 
+	if industryName == "" {
+		ragContext += sources.block("Industries", stockAnalysisUrl+"/stocks/industry/all/", prompts.IndustriesData, industries, IndustriesDataKey)
+		return ragContext, nil
+	}
+
 	for i := 0; i < len(industries); i++ {
 		industry := industries[i]
-		if industryName == "" {
-			// We don't fetch industry stocks to avoid making 150+ http calls
-			context := industryContext{
-				industry: industry,
-			}
-			ragContext += fmt.Sprintf("%+v\n", context)
-		} else if industryName == industry.Name {
+		if industryName == industry.Name {
 			industryStocks, err := rag.dataService.GetIndustryStocks(industry.UrlName)
 			if err != nil {
 				return ragContext, &DataServiceError{Message: fmt.Sprintf("GetIndustryStocks failed: %s", err)}
 			}
 			context := industryContext{
-				industry:       industry,
-				industryStocks: industryStocks,
+				Industry:       industry,
+				IndustryStocks: industryStocks,
 			}
-			ragContext += fmt.Sprintf("%+v\n", context)
+			ragContext += sources.block(
+				fmt.Sprintf("%s industry and its stocks", industry.Name),
+				fmt.Sprintf("%s/stocks/industry/%s/", stockAnalysisUrl, industry.UrlName),
+				prompts.IndustryData,
+				context,
+				IndustriesDataKey, IndustryStocksDataKey(industry.UrlName),
+			)
 			return ragContext, nil
 		}
 	}
@@ -57,24 +76,29 @@ func (rag IndustryRag) createRagContext(industryName string) (string, error) {
 	return ragContext, nil
 }
 
-func (rag IndustryRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+func (rag IndustryRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags.IndustryName)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.IndustryName, sources)
 	if err != nil {
-		return err
-	}
-	prompt := fmt.Sprintf(prompts.IndustriesPrompt, ragContext)
-	prompt_msg := Message{
-		Role:    System,
-		Content: prompt,
+		return MessageMetadata{}, err
 	}
 
-	// Add the prompt as the first message in the existing conversation
-	conversation_with_prompt := append([]Message{prompt_msg}, conversation...)
+	var userContext domain.UserContext
+	if tags.UserID != "" {
+		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
+		if err != nil {
+			return MessageMetadata{}, err
+		}
+	}
 
-	if err := rag.llm.GenerateResponse(conversation_with_prompt, responseChannel); err != nil {
-		return &RagError{Message: fmt.Sprintf("GenerateResponse failed: %s", err)}
+	prompt, err := rag.renderPrompt(prompts.Industries, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
 	}
 
-	return nil
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
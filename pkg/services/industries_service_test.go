@@ -0,0 +1,95 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIndustryData struct {
+	industries []domain.Industry
+	stocks     map[string][]domain.IndustryStock
+}
+
+func (f fakeIndustryData) GetIndustries() ([]domain.Industry, error) {
+	return f.industries, nil
+}
+
+func (f fakeIndustryData) GetIndustryStocks(industry string) ([]domain.IndustryStock, error) {
+	return f.stocks[industry], nil
+}
+
+func (f fakeIndustryData) GetSectors() ([]domain.Sector, error) {
+	return nil, nil
+}
+
+func (f fakeIndustryData) GetTickers() ([]domain.Ticker, error) {
+	return nil, nil
+}
+
+func (f fakeIndustryData) GetEtfs() ([]domain.Etf, error) {
+	return nil, nil
+}
+
+func (f fakeIndustryData) GetSuperInvestors() ([]domain.SuperInvestor, error) {
+	return nil, nil
+}
+
+var testIndustryData = fakeIndustryData{
+	industries: []domain.Industry{
+		{Name: "Semiconductors", UrlName: "semiconductors", NumberOfStocks: 70},
+		{Name: "Banks - Regional", UrlName: "banks-regional", NumberOfStocks: 300},
+	},
+	stocks: map[string][]domain.IndustryStock{
+		"semiconductors": {{Symbol: "NVDA", CompanyName: "NVIDIA Corporation", MarketCap: 4.2e12}},
+	},
+}
+
+func TestIndustryRag_GenerateRagResponse(t *testing.T) {
+	llm := &fakeLlm{response: "NVIDIA is the largest semiconductors company."}
+	userContexts := fakeUserContexts{"user": {
+		UserID:        "user",
+		UserPortfolio: []domain.UserPortfolioHolding{{Symbol: "AMD", AssetClass: domain.Stock, PortfolioPercentage: 100}},
+	}}
+	rag, _ := NewIndustryRag(llm, testIndustryData, userContexts, fakeRagResponsesRepository{})
+
+	responseChannel := make(chan string, 10)
+	metadata, err := rag.GenerateRagResponse(
+		[]Message{{Role: User, Content: "What are the largest semiconductors companies?"}},
+		Tags{IndustryName: "Semiconductors", UserID: "user"},
+		responseChannel,
+	)
+	assert.NoError(t, err)
+
+	// The response is stored and the prompt is personalized with the user context
+	assert.NotEmpty(t, metadata.RagResponseID)
+	assert.Equal(t, "v2", metadata.PromptVersion)
+	assert.Contains(t, metadata.RagContext, "NVIDIA Corporation")
+	assert.Contains(t, llm.prompts[0], "AMD")
+}
+
+func TestTagExtractor_ExtractIndustryTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected Tags
+	}{
+		{name: "industry", response: `{"industry_name": "Semiconductors"}`, expected: Tags{IndustryName: "Semiconductors"}},
+		{name: "different case", response: `{"industry_name": "banks - regional"}`, expected: Tags{IndustryName: "Banks - Regional"}},
+		{name: "unknown industry", response: `{"industry_name": "Time Travel"}`, expected: Tags{}},
+		{name: "industry generic", response: `{"industry_name": ""}`, expected: Tags{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeLlm{response: tt.response}
+			extractor, _ := NewTagExtractor(llm, testIndustryData, nil, fakeRagResponsesRepository{})
+
+			tags, err := extractor.ExtractTags(INDUSTRIES, []Message{{Role: User, Content: "Tell me about chip makers"}}, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tags)
+			assert.Contains(t, llm.prompts[0], "Banks - Regional\n")
+		})
+	}
+}
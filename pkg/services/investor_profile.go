@@ -0,0 +1,276 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RiskQuestionnaire is the guided questionnaire used to score the risk tolerance of a user.
+// Every option is scored from 0 (lowest risk) to 4 (highest risk).
+var RiskQuestionnaire = []domain.QuestionnaireQuestion{
+	{
+		ID:   "horizon",
+		Text: "When do you expect to need most of the money you are investing?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "less_than_3_years", Text: "In less than 3 years", Score: 0},
+			{ID: "3_to_10_years", Text: "In 3 to 10 years", Score: 2},
+			{ID: "more_than_10_years", Text: "In more than 10 years", Score: 4},
+		},
+	},
+	{
+		ID:   "drawdown_reaction",
+		Text: "If your portfolio lost 20% of its value in a month, what would you do?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "sell_all", Text: "Sell everything", Score: 0},
+			{ID: "sell_some", Text: "Sell part of it", Score: 1},
+			{ID: "hold", Text: "Do nothing", Score: 3},
+			{ID: "buy_more", Text: "Buy more", Score: 4},
+		},
+	},
+	{
+		ID:   "max_annual_loss",
+		Text: "What is the largest loss in a single year you could accept?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "5_percent", Text: "5%", Score: 0},
+			{ID: "10_percent", Text: "10%", Score: 1},
+			{ID: "20_percent", Text: "20%", Score: 2},
+			{ID: "30_percent", Text: "30%", Score: 3},
+			{ID: "more_than_30_percent", Text: "More than 30%", Score: 4},
+		},
+	},
+	{
+		ID:   "main_goal",
+		Text: "Which statement describes your main goal best?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "preserve", Text: "Preserve my capital", Score: 0},
+			{ID: "income", Text: "Generate a steady income", Score: 1},
+			{ID: "balanced", Text: "Balance growth and stability", Score: 2},
+			{ID: "growth", Text: "Grow my wealth", Score: 3},
+			{ID: "maximum_growth", Text: "Maximize growth, even with large swings", Score: 4},
+		},
+	},
+	{
+		ID:   "experience",
+		Text: "How would you describe your investing experience?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "none", Text: "I am new to investing", Score: 0},
+			{ID: "some", Text: "I have invested in funds or a few stocks", Score: 2},
+			{ID: "extensive", Text: "I actively invest in different asset classes", Score: 4},
+		},
+	},
+	{
+		ID:   "income_stability",
+		Text: "How stable is your income?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "unstable", Text: "Unstable or no income", Score: 0},
+			{ID: "somewhat_stable", Text: "Somewhat stable", Score: 2},
+			{ID: "very_stable", Text: "Very stable", Score: 4},
+		},
+	},
+	{
+		ID:   "emergency_fund",
+		Text: "Do you have savings covering at least 6 months of expenses outside of your investments?",
+		Options: []domain.QuestionnaireOption{
+			{ID: "no", Text: "No", Score: 0},
+			{ID: "yes", Text: "Yes", Score: 4},
+		},
+	},
+}
+
+// Some answers of the questionnaire also define fields of the investor profile
+var (
+	horizonAnswers = map[string]domain.InvestmentHorizon{
+		"less_than_3_years":  domain.ShortTerm,
+		"3_to_10_years":      domain.MediumTerm,
+		"more_than_10_years": domain.LongTerm,
+	}
+	experienceAnswers = map[string]domain.ExperienceLevel{
+		"none":      domain.Beginner,
+		"some":      domain.Intermediate,
+		"extensive": domain.Advanced,
+	}
+)
+
+// scoreRiskQuestionnaire returns the risk score from 0 to 100 and the matching risk tolerance.
+// All the questions must be answered.
+func scoreRiskQuestionnaire(answers map[string]string) (int, domain.RiskTolerance, error) {
+	fieldErrors := make([]investbotErr.FieldError, 0)
+	score, maxScore := 0, 0
+
+	for _, question := range RiskQuestionnaire {
+		field := "answers." + question.ID
+		answer, found := answers[question.ID]
+		if !found {
+			fieldErrors = append(fieldErrors, investbotErr.FieldError{Field: field, Message: "question is not answered"})
+			continue
+		}
+
+		optionIDs := make([]string, 0, len(question.Options))
+		questionMaxScore, optionScore := 0, -1
+		for _, option := range question.Options {
+			optionIDs = append(optionIDs, option.ID)
+			questionMaxScore = max(questionMaxScore, option.Score)
+			if option.ID == answer {
+				optionScore = option.Score
+			}
+		}
+
+		if optionScore == -1 {
+			fieldErrors = append(fieldErrors, investbotErr.FieldError{
+				Field:       field,
+				Message:     fmt.Sprintf("%s is not a valid answer", answer),
+				Suggestions: optionIDs,
+			})
+			continue
+		}
+
+		score += optionScore
+		maxScore += questionMaxScore
+	}
+
+	if len(fieldErrors) > 0 {
+		return 0, "", investbotErr.UserContextValidationError{FieldErrors: fieldErrors}
+	}
+
+	riskScore := int(math.Round(float64(score) / float64(maxScore) * 100))
+
+	switch {
+	case riskScore < 20:
+		return riskScore, domain.Conservative, nil
+	case riskScore < 40:
+		return riskScore, domain.ModeratelyConservative, nil
+	case riskScore < 60:
+		return riskScore, domain.Moderate, nil
+	case riskScore < 80:
+		return riskScore, domain.ModeratelyAggressive, nil
+	default:
+		return riskScore, domain.Aggressive, nil
+	}
+}
+
+// applyQuestionnaire updates the risk tolerance, horizon and experience of the profile from the answers.
+// The other fields of the profile are kept.
+func applyQuestionnaire(profile *domain.InvestorProfile, answers map[string]string) (*domain.InvestorProfile, error) {
+	riskScore, riskTolerance, err := scoreRiskQuestionnaire(answers)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := domain.InvestorProfile{}
+	if profile != nil {
+		updated = *profile
+	}
+	updated.RiskScore = riskScore
+	updated.RiskTolerance = riskTolerance
+	updated.InvestmentHorizon = horizonAnswers[answers["horizon"]]
+	updated.ExperienceLevel = experienceAnswers[answers["experience"]]
+
+	return normalizeInvestorProfile(&updated), nil
+}
+
+// normalizeInvestorProfile stamps the profile with the current schema version and lowercases
+// the free text lists so that they are rendered the same way in every prompt
+func normalizeInvestorProfile(profile *domain.InvestorProfile) *domain.InvestorProfile {
+	if profile == nil {
+		return nil
+	}
+
+	normalized := *profile
+	normalized.Version = domain.InvestorProfileVersion
+	normalized.UpdatedAt = time.Now()
+	normalized.TaxRegion = strings.ToUpper(strings.TrimSpace(profile.TaxRegion))
+
+	normalized.ExcludedSectors = make([]string, 0, len(profile.ExcludedSectors))
+	for _, sector := range profile.ExcludedSectors {
+		normalized.ExcludedSectors = append(normalized.ExcludedSectors, strings.ToLower(strings.TrimSpace(sector)))
+	}
+
+	return &normalized
+}
+
+var horizonDescriptions = map[domain.InvestmentHorizon]string{
+	domain.ShortTerm:  "short (less than 3 years)",
+	domain.MediumTerm: "medium (3 to 10 years)",
+	domain.LongTerm:   "long (more than 10 years)",
+}
+
+// renderUserContext renders the user context as the block that is added to the rag prompts,
+// so that every prompt gets the profile and portfolio of the user in the same format
+func renderUserContext(userContext domain.UserContext) string {
+	if userContext.UserID == "" {
+		return "No information about the user is available.\n"
+	}
+
+	var b strings.Builder
+
+	b.WriteString("### Investor profile\n")
+	if profile := userContext.InvestorProfile; profile != nil {
+		writeProfileLine(&b, "Risk tolerance", string(profile.RiskTolerance))
+		if profile.RiskScore > 0 {
+			writeProfileLine(&b, "Risk score", fmt.Sprintf("%d/100", profile.RiskScore))
+		}
+		writeProfileLine(&b, "Investment horizon", horizonDescriptions[profile.InvestmentHorizon])
+		writeProfileLine(&b, "Goals", joinValues(profile.Goals))
+		writeProfileLine(&b, "Experience level", string(profile.ExperienceLevel))
+		if profile.AnnualIncome > 0 {
+			writeProfileLine(&b, "Annual income", fmt.Sprintf("%.0f", profile.AnnualIncome))
+		}
+		writeProfileLine(&b, "Tax region", profile.TaxRegion)
+		writeProfileLine(&b, "Excluded sectors, never recommend investments in them", strings.Join(profile.ExcludedSectors, ", "))
+		writeProfileLine(&b, "Preferred asset classes", joinValues(profile.PreferredAssetClasses))
+	} else {
+		b.WriteString("Not provided\n")
+	}
+
+	if len(userContext.UserProfile) > 0 {
+		b.WriteString("### Additional profile information\n")
+		keys := make([]string, 0, len(userContext.UserProfile))
+		for key := range userContext.UserProfile {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeProfileLine(&b, key, fmt.Sprintf("%v", userContext.UserProfile[key]))
+		}
+	}
+
+	b.WriteString("### Portfolio\n")
+	if len(userContext.UserPortfolio) == 0 {
+		b.WriteString("No holdings\n")
+	}
+	for _, h := range userContext.UserPortfolio {
+		line := fmt.Sprintf("- %s", h.Symbol)
+		if h.Symbol == "" {
+			line = fmt.Sprintf("- %s", h.Name)
+		} else if h.Name != "" {
+			line += fmt.Sprintf(" (%s)", h.Name)
+		}
+		line += fmt.Sprintf(", %s, quantity %g", h.AssetClass, h.Quantity)
+		if h.PortfolioPercentage > 0 {
+			line += fmt.Sprintf(", %g%% of the portfolio", h.PortfolioPercentage)
+		}
+		b.WriteString(line + "\n")
+	}
+
+	return b.String()
+}
+
+func writeProfileLine(b *strings.Builder, label string, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(b, "- %s: %s\n", label, value)
+}
+
+func joinValues[T ~string](values []T) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, string(v))
+	}
+	return strings.Join(strs, ", ")
+}
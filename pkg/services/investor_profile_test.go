@@ -0,0 +1,76 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyQuestionnaire(t *testing.T) {
+	answers := map[string]string{
+		"horizon":           "more_than_10_years",
+		"drawdown_reaction": "hold",
+		"max_annual_loss":   "20_percent",
+		"main_goal":         "growth",
+		"experience":        "some",
+		"income_stability":  "very_stable",
+		"emergency_fund":    "yes",
+	}
+	existing := &domain.InvestorProfile{TaxRegion: "us-ca", Goals: []domain.InvestmentGoal{domain.Retirement}}
+
+	profile, err := applyQuestionnaire(existing, answers)
+	assert.NoError(t, err)
+	// 22 out of 28 points
+	assert.Equal(t, 79, profile.RiskScore)
+	assert.Equal(t, domain.ModeratelyAggressive, profile.RiskTolerance)
+	assert.Equal(t, domain.LongTerm, profile.InvestmentHorizon)
+	assert.Equal(t, domain.Intermediate, profile.ExperienceLevel)
+	assert.Equal(t, domain.InvestorProfileVersion, profile.Version)
+	// Fields that are not part of the questionnaire are kept
+	assert.Equal(t, "US-CA", profile.TaxRegion)
+	assert.Equal(t, []domain.InvestmentGoal{domain.Retirement}, profile.Goals)
+}
+
+func TestApplyQuestionnaire_InvalidAnswers(t *testing.T) {
+	_, err := applyQuestionnaire(nil, map[string]string{"horizon": "tomorrow"})
+	validationError := investbotErr.UserContextValidationError{}
+	assert.ErrorAs(t, err, &validationError)
+	assert.Len(t, validationError.FieldErrors, len(RiskQuestionnaire))
+	assert.Equal(t, "answers.horizon", validationError.FieldErrors[0].Field)
+}
+
+func TestRenderUserContext(t *testing.T) {
+	userContext := domain.UserContext{
+		UserID:      "user",
+		UserProfile: map[string]any{"name": "Alex", "age": 35},
+		InvestorProfile: &domain.InvestorProfile{
+			RiskTolerance:     domain.Moderate,
+			RiskScore:         55,
+			InvestmentHorizon: domain.LongTerm,
+			Goals:             []domain.InvestmentGoal{domain.Retirement, domain.Income},
+			ExcludedSectors:   []string{"energy"},
+		},
+		UserPortfolio: []domain.UserPortfolioHolding{
+			{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 10, PortfolioPercentage: 60},
+			{AssetClass: domain.Crypto, Name: "Bitcoin", Quantity: 0.5},
+		},
+	}
+
+	expected := `### Investor profile
+- Risk tolerance: moderate
+- Risk score: 55/100
+- Investment horizon: long (more than 10 years)
+- Goals: retirement, income
+- Excluded sectors, never recommend investments in them: energy
+### Additional profile information
+- age: 35
+- name: Alex
+### Portfolio
+- AAPL (Apple Inc.), stock, quantity 10, 60% of the portfolio
+- Bitcoin, crypto, quantity 0.5
+`
+	assert.Equal(t, expected, renderUserContext(userContext))
+	assert.Equal(t, "No information about the user is available.\n", renderUserContext(domain.UserContext{}))
+}
@@ -1,5 +1,7 @@
 package services
 
+import "time"
+
 type ActorRole string
 
 const (
@@ -9,8 +11,45 @@ const (
 )
 
 type Message struct {
-	Content string
-	Role    ActorRole
+	ID       string
+	Content  string
+	Role     ActorRole
+	Metadata *MessageMetadata `json:",omitempty" bson:",omitempty"` // Only set on the responses of the rags
+}
+
+// MessageMetadata records how a response was generated, so that it can be audited and replayed
+type MessageMetadata struct {
+	Topic          Topic
+	Tags           Tags
+	Model          string
+	PromptName     string
+	PromptVersion  string
+	Latency        time.Duration
+	PromptTokens   int // Estimated, the llms don't report their usage
+	ResponseTokens int // Estimated, the llms don't report their usage
+	RagContext     string
+	DataSources    []DataSource
+	FactCheck      *FactCheck       `json:",omitempty" bson:",omitempty"` // Only set when the rag checks its responses
+	Compliance     *ComplianceCheck `json:",omitempty" bson:",omitempty"`
+	Experiment     *Assignment      `json:",omitempty" bson:",omitempty"` // Only set for the responses of experiment variants
+	RagResponseID  string           `json:",omitempty" bson:",omitempty"` // ID of the stored RagResponse
+}
+
+// ComplianceCheck is how the compliance policy applied to a response
+type ComplianceCheck struct {
+	Refused    bool     // The question was answered with the refusal of the policy instead of the rag
+	Rules      []string // Names of the matched rules
+	Disclaimer string   // Streamed after the response, it's not part of its content
+}
+
+// DataSource is a numbered source block of a rag context, that the response can cite with its ID.
+// Keys are the cache keys of the market data of the block.
+type DataSource struct {
+	ID        int
+	Label     string
+	Url       string
+	Keys      []string
+	FetchedAt time.Time
 }
 
 type Llm interface {
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/domain"
+)
+
+// The market data scraper caches the data under these keys and the rags cite the data by the same keys,
+// so the data sources of a response can be traced back to the cached data
+
+const (
+	SectorsDataKey        = "sectors"
+	IndustriesDataKey     = "industries"
+	EtfsDataKey           = "etfs"
+	MarketNewsDataKey     = "market_news"
+	TickersDataKey        = "tickers"
+	SuperInvestorsDataKey = "super_investors"
+)
+
+// SectorStocksDataKey takes the domain.Sector.UrlName of the sector
+func SectorStocksDataKey(sector string) string {
+	return fmt.Sprintf("sector_stocks_%s", sector)
+}
+
+// IndustryStocksDataKey takes the domain.Industry.UrlName of the industry
+func IndustryStocksDataKey(industry string) string {
+	return fmt.Sprintf("industry_stocks_%s", industry)
+}
+
+func StockForecastDataKey(symbol string) string {
+	return fmt.Sprintf("stock_forecast_%s", symbol)
+}
+
+func BalanceSheetsDataKey(symbol string) string {
+	return fmt.Sprintf("balance_sheets_%s", symbol)
+}
+
+func IncomeStatementsDataKey(symbol string) string {
+	return fmt.Sprintf("income_statements_%s", symbol)
+}
+
+func CashFlowsDataKey(symbol string) string {
+	return fmt.Sprintf("cash_flows_%s", symbol)
+}
+
+func FinancialRatiosDataKey(symbol string) string {
+	return fmt.Sprintf("financial_ratios_%s", symbol)
+}
+
+func EtfOverviewDataKey(symbol string) string {
+	return fmt.Sprintf("etf_overview_%s", symbol)
+}
+
+func StockProfileDataKey(symbol string) string {
+	return fmt.Sprintf("stock_profile_%s", symbol)
+}
+
+func StockNewsDataKey(symbol string) string {
+	return fmt.Sprintf("stock_news_%s", symbol)
+}
+
+func SuperInvestorPortfolioDataKey(superInvestorName string) string {
+	return fmt.Sprintf("super_investor_portfolio_%s", superInvestorName)
+}
+
+func HistoricalPricesDataKey(ticker string, assetClass domain.AssetClass, period domain.Period) string {
+	return fmt.Sprintf("historical_prices_%s_%s_%s", ticker, assetClass, period)
+}
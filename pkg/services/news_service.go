@@ -18,9 +18,12 @@ type MarketNewsRag struct {
 	userContextService UserContextDataService
 }
 
-type ragNewsContext struct {
-	currentDate string
-	news        []domain.NewsArticle
+// newsArticleContext is the part of an article that goes in the rag context, the url and the source are in the header of its block.
+// The text is scraped from the page of the article, so it's pasted in a data section.
+type newsArticleContext struct {
+	Title string
+	Time  string
+	Text  string
 }
 
 func NewMarketNewsRag(
@@ -40,61 +43,73 @@ func NewMarketNewsRag(
 	return &rag, nil
 }
 
-func (rag MarketNewsRag) createRagContext(stockSymbols []string) (string, error) {
-	ragContext := make([]ragNewsContext, 0, len(stockSymbols))
-	var err error
-	context := ragNewsContext{}
-	// Keep only the last 20 news
+func (rag MarketNewsRag) createRagContext(stockSymbols []string, sources *ragSources) (string, error) {
+	ragContext := fmt.Sprintf("Current date: %s\n\n", time.Now().Format("2006-01-02"))
 	limit := 20
 
 	if len(stockSymbols) > 0 {
 		for _, symbol := range stockSymbols {
-			var news []domain.NewsArticle
-
-			news, err = rag.dataService.GetStockNews(symbol)
+			news, err := rag.dataService.GetStockNews(symbol)
 			if err != nil {
 				return "", &DataServiceError{Message: fmt.Sprintf("GetStockNews failed: %s", err)}
 			}
 
-			if len(news) < limit {
-				limit = len(news)
+			for _, article := range news[:min(limit, len(news))] {
+				ragContext += rag.newsArticleBlock(sources, article, StockNewsDataKey(symbol))
 			}
-
-			context.currentDate = time.Now().Format("2006-01-02")
-			context.news = news[:limit]
-
-			ragContext = append(ragContext, context)
 		}
 	} else {
 		news, err := rag.dataService.GetMarketNews()
 		if err != nil {
 			return "", &DataServiceError{Message: fmt.Sprintf("GetMarketNews failed: %s", err)}
 		}
-		context.currentDate = time.Now().Format("2006-01-02")
-		context.news = news[:limit]
 
-		ragContext = append(ragContext, context)
+		for _, article := range news[:min(limit, len(news))] {
+			ragContext += rag.newsArticleBlock(sources, article, MarketNewsDataKey)
+		}
 	}
 
-	return fmt.Sprintf("%+v\n", ragContext), nil
+	return ragContext, nil
 }
 
-func (rag MarketNewsRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+func (rag MarketNewsRag) newsArticleBlock(sources *ragSources, article domain.NewsArticle, key string) string {
+	title := sanitizeScrapedLabel(article.Title)
+	label := title
+	if article.Source != "" {
+		label = fmt.Sprintf("%s (%s)", title, sanitizeScrapedLabel(article.Source))
+	}
+
+	context := newsArticleContext{
+		Title: title,
+		Time:  sanitizeScrapedLabel(article.Time),
+		Text:  rag.untrustedText(article.Text, article.Url),
+	}
+	return sources.block(label, article.Url, prompts.NewsArticleData, context, key)
+}
+
+func (rag MarketNewsRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags.StockSymbols)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.StockSymbols, sources)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
 	var userContext domain.UserContext
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.NewsPrompt, ragContext, userContext)
+	prompt, err := rag.renderPrompt(prompts.News, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"math"
+	"sort"
+	"time"
+)
+
+// tradingDaysPerYear is used to annualize the volatility of daily returns
+const tradingDaysPerYear = 252
+
+// normalizeWeights converts the given weights to fractions that add up to 1.
+// If all the weights are zero(for example portfolio percentages were not provided)
+// every entry gets the same weight.
+func normalizeWeights(weights []float64) []float64 {
+	normalized := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return normalized
+	}
+
+	var total float64
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+
+	for i, w := range weights {
+		if total == 0 {
+			normalized[i] = 1 / float64(len(weights))
+		} else if w > 0 {
+			normalized[i] = w / total
+		}
+	}
+
+	return normalized
+}
+
+// holdingWeights returns the weights of the holdings of a portfolio. The portfolio percentages are the
+// part of the portfolio the user gave to the holdings, and the holdings with a quantity but no percentage,
+// like the ones traded in the ledger, share the rest of the portfolio by their market value. A holding
+// without a percentage or a market value(for example crypto that has no prices) gets no weight instead of
+// a made up one. Only a portfolio without any percentage or quantity is weighted equally.
+func holdingWeights(portfolio []domain.UserPortfolioHolding, marketValues []float64) []float64 {
+	var totalPercentage, totalMarketValue float64
+	hasQuantities := false
+	for i, holding := range portfolio {
+		hasQuantities = hasQuantities || holding.Quantity > 0
+		if holding.PortfolioPercentage > 0 {
+			totalPercentage += holding.PortfolioPercentage
+		} else if marketValues[i] > 0 {
+			totalMarketValue += marketValues[i]
+		}
+	}
+
+	weights := make([]float64, len(portfolio))
+	if totalPercentage == 0 && !hasQuantities {
+		return normalizeWeights(weights)
+	}
+
+	remainingPercentage := math.Max(100-totalPercentage, 0)
+	for i, holding := range portfolio {
+		if holding.PortfolioPercentage > 0 {
+			weights[i] = holding.PortfolioPercentage
+		} else if marketValues[i] > 0 {
+			weights[i] = remainingPercentage * marketValues[i] / totalMarketValue
+		}
+	}
+
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return weights
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+	return weights
+}
+
+// weightedAverage returns the weighted average of the values for which include is true.
+// The weights of the excluded values are not taken into account, so the result is
+// the average over the part of the portfolio for which we have data.
+// The second return value is the fraction of the total weight that was covered.
+func weightedAverage(values []float64, weights []float64, include []bool) (float64, float64) {
+	var sum, coveredWeight float64
+	for i := range values {
+		if !include[i] {
+			continue
+		}
+		sum += values[i] * weights[i]
+		coveredWeight += weights[i]
+	}
+
+	if coveredWeight == 0 {
+		return 0, 0
+	}
+
+	return sum / coveredWeight, coveredWeight
+}
+
+// dailyReturns returns the close-to-close returns keyed by the day of the later close
+func dailyReturns(dates []time.Time, closePrices []float64) map[string]float64 {
+	returns := make(map[string]float64, len(closePrices))
+	for i := 1; i < len(closePrices); i++ {
+		if closePrices[i-1] == 0 {
+			continue
+		}
+		day := dates[i].Format("2006-01-02")
+		returns[day] = (closePrices[i] - closePrices[i-1]) / closePrices[i-1]
+	}
+	return returns
+}
+
+// alignReturns returns the days that exist in both return series in chronological order
+func alignReturns(a, b map[string]float64) []string {
+	days := make([]string, 0, len(a))
+	for day := range a {
+		if _, found := b[day]; found {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// sampleStdDev returns the sample standard deviation of the values
+func sampleStdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	var sumSquares float64
+	for _, v := range values {
+		sumSquares += (v - m) * (v - m)
+	}
+	return math.Sqrt(sumSquares / float64(len(values)-1))
+}
+
+// annualizedVolatility returns the annualized volatility of the daily returns as a percentage
+func annualizedVolatility(returns []float64) float64 {
+	return sampleStdDev(returns) * math.Sqrt(tradingDaysPerYear) * 100
+}
+
+// pearsonCorrelation returns the correlation coefficient of two equally sized series.
+// Zero is returned when one of the series has no variance.
+func pearsonCorrelation(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) < 2 {
+		return 0
+	}
+
+	meanA, meanB := mean(a), mean(b)
+	var covariance, varianceA, varianceB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		covariance += da * db
+		varianceA += da * da
+		varianceB += db * db
+	}
+
+	if varianceA == 0 || varianceB == 0 {
+		return 0
+	}
+
+	return covariance / math.Sqrt(varianceA*varianceB)
+}
+
+// portfolioDailyReturns combines the daily returns of the holdings into the daily returns of
+// the portfolio. Only the days for which every holding has a return are used and the weights
+// are the current portfolio weights(no rebalancing or drift is taken into account).
+func portfolioDailyReturns(holdingReturns []map[string]float64, weights []float64) []float64 {
+	if len(holdingReturns) == 0 {
+		return nil
+	}
+
+	days := make([]string, 0, len(holdingReturns[0]))
+	for day := range holdingReturns[0] {
+		inAll := true
+		for _, returns := range holdingReturns[1:] {
+			if _, found := returns[day]; !found {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	normalized := normalizeWeights(weights)
+	portfolioReturns := make([]float64, 0, len(days))
+	for _, day := range days {
+		var r float64
+		for i, returns := range holdingReturns {
+			r += returns[day] * normalized[i]
+		}
+		portfolioReturns = append(portfolioReturns, r)
+	}
+
+	return portfolioReturns
+}
+
+// roundTo rounds the value to the given number of decimals
+func roundTo(value float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(value*pow) / pow
+}
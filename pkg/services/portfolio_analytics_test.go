@@ -0,0 +1,74 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"investbot/pkg/errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeWeights(t *testing.T) {
+	assert.Equal(t, []float64{0.25, 0.75}, normalizeWeights([]float64{25, 75}))
+	assert.Equal(t, []float64{0.5, 0.5}, normalizeWeights([]float64{0, 0}))
+	assert.Equal(t, []float64{}, normalizeWeights([]float64{}))
+}
+
+func TestWeightedAverage(t *testing.T) {
+	value, coverage := weightedAverage(
+		[]float64{10, 30, 100},
+		[]float64{0.25, 0.25, 0.5},
+		[]bool{true, true, false},
+	)
+	assert.Equal(t, 20.0, value)
+	assert.Equal(t, 0.5, coverage)
+}
+
+func TestPearsonCorrelation(t *testing.T) {
+	a := []float64{0.01, -0.02, 0.03, 0.00}
+	b := []float64{0.02, -0.04, 0.06, 0.00}
+	c := []float64{-0.01, 0.02, -0.03, 0.00}
+
+	assert.InDelta(t, 1.0, pearsonCorrelation(a, b), 1e-9)
+	assert.InDelta(t, -1.0, pearsonCorrelation(a, c), 1e-9)
+	assert.Equal(t, 0.0, pearsonCorrelation(a, []float64{1, 1, 1, 1}))
+}
+
+func TestPortfolioDailyReturns(t *testing.T) {
+	holdingReturns := []map[string]float64{
+		{"2024-01-02": 0.02, "2024-01-03": 0.01},
+		{"2024-01-02": -0.02, "2024-01-04": 0.05},
+	}
+
+	// Only the days that exist for every holding are used
+	returns := portfolioDailyReturns(holdingReturns, []float64{50, 50})
+	assert.Len(t, returns, 1)
+	assert.InDelta(t, 0.0, returns[0], 1e-9)
+}
+
+func TestPercentageChangeSince(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prices := []domain.Price{
+		{Date: start, ClosePrice: 100},
+		{Date: start.AddDate(0, 6, 0), ClosePrice: 80},
+		{Date: start.AddDate(1, 0, 0), ClosePrice: 120},
+	}
+
+	assert.InDelta(t, 20.0, percentageChangeSince(prices, start), 1e-9)
+	assert.InDelta(t, 50.0, percentageChangeSince(prices, start.AddDate(0, 7, 0)), 1e-9)
+}
+
+func TestParseNumber(t *testing.T) {
+	assert.Equal(t, 3.85, parseNumber("3.85%"))
+	assert.Equal(t, 6.92, parseNumber(" 6.92 "))
+	assert.Equal(t, 0.0, parseNumber("n/a"))
+}
+
+func TestPortfolioRag_UserIDRequired(t *testing.T) {
+	rag, _ := NewPortfolioRag(&fakeLlm{}, nil, fakeUserContexts{}, fakeRagResponsesRepository{})
+
+	_, err := rag.GenerateRagResponse([]Message{{Role: User, Content: "How diversified is my portfolio?"}}, Tags{}, make(chan string, 1))
+	assert.ErrorAs(t, err, &errors.UserIDRequiredError{})
+	assert.EqualError(t, err, "user_id is required for portfolio questions")
+}
@@ -0,0 +1,468 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	GenericImportProfile = "generic"
+	CustomImportProfile  = "custom"
+
+	// Brokers add account information above the header row, the header is searched in the first rows only
+	maxImportHeaderRow = 20
+	importPreviewTTL   = time.Hour
+)
+
+// PortfolioImportProfiles maps the supported broker profiles to the column headers of their csv exports
+var PortfolioImportProfiles = map[string]domain.PortfolioImportMapping{
+	GenericImportProfile: {
+		Symbol:      []string{"symbol", "ticker", "instrument"},
+		Name:        []string{"name", "description", "security", "security name", "investment name"},
+		Quantity:    []string{"quantity", "qty", "shares", "units", "position"},
+		MarketValue: []string{"market value", "current value", "total value", "position value", "value"},
+		Price:       []string{"price", "last price", "current price", "share price", "close price"},
+		AssetClass:  []string{"asset class", "asset type", "security type", "type"},
+	},
+	"fidelity": {
+		Symbol:      []string{"symbol"},
+		Name:        []string{"description"},
+		Quantity:    []string{"quantity"},
+		MarketValue: []string{"current value"},
+		Price:       []string{"last price"},
+	},
+	"schwab": {
+		Symbol:      []string{"symbol"},
+		Name:        []string{"description"},
+		Quantity:    []string{"qty (quantity)", "quantity"},
+		MarketValue: []string{"mkt val (market value)", "market value"},
+		Price:       []string{"price"},
+		AssetClass:  []string{"security type", "asset type"},
+	},
+	"interactive_brokers": {
+		Symbol:      []string{"symbol"},
+		Name:        []string{"description"},
+		Quantity:    []string{"quantity", "position"},
+		MarketValue: []string{"value", "position value", "market value"},
+		Price:       []string{"close price", "mark price"},
+		AssetClass:  []string{"asset class"},
+	},
+	"vanguard": {
+		Symbol:      []string{"symbol"},
+		Name:        []string{"investment name"},
+		Quantity:    []string{"shares"},
+		MarketValue: []string{"total value"},
+		Price:       []string{"share price"},
+	},
+}
+
+// PortfolioImportPreviewRepository keeps the previews until they are committed or expire
+type PortfolioImportPreviewRepository interface {
+	// StoreImportPreview stores the preview, it's deleted ttl after it's stored
+	StoreImportPreview(preview domain.PortfolioImportPreview, ttl time.Duration) error
+	// GetImportPreview returns a PortfolioImportNotFoundError if the preview doesn't exist
+	GetImportPreview(importID string) (domain.PortfolioImportPreview, error)
+	DeleteImportPreview(importID string) error
+}
+
+type PortfolioImportService struct {
+	dataService           SymbolUniverseDataService
+	userContextRepository UserContextRepository
+	previewRepository     PortfolioImportPreviewRepository
+}
+
+func NewPortfolioImportService(
+	dataService SymbolUniverseDataService,
+	userContextRepository UserContextRepository,
+	previewRepository PortfolioImportPreviewRepository,
+) (*PortfolioImportService, error) {
+	return &PortfolioImportService{
+		dataService:           dataService,
+		userContextRepository: userContextRepository,
+		previewRepository:     previewRepository,
+	}, nil
+}
+
+func (s *PortfolioImportService) GetProfiles() []string {
+	profiles := make([]string, 0, len(PortfolioImportProfiles))
+	for profile := range PortfolioImportProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// Preview parses the csv export and resolves the symbols of the holdings without changing the user context.
+// The preview is kept for an hour so that it can be committed with CommitImport. If mapping is not nil
+// it is used instead of the mapping of the profile.
+func (s *PortfolioImportService) Preview(
+	userID string,
+	profile string,
+	mapping *domain.PortfolioImportMapping,
+	csvData io.Reader,
+) (domain.PortfolioImportPreview, error) {
+	if mapping == nil {
+		profileMapping, found := PortfolioImportProfiles[profile]
+		if !found {
+			return domain.PortfolioImportPreview{}, investbotErr.InvalidPortfolioImportError{
+				Message: fmt.Sprintf("unknown profile %s, valid profiles are: %s", profile, strings.Join(s.GetProfiles(), ", ")),
+			}
+		}
+		mapping = &profileMapping
+	} else {
+		profile = CustomImportProfile
+	}
+
+	resolver, err := newSymbolResolver(s.dataService)
+	if err != nil {
+		return domain.PortfolioImportPreview{}, err
+	}
+
+	preview, err := buildImportPreview(csvData, *mapping, resolver)
+	if err != nil {
+		return domain.PortfolioImportPreview{}, err
+	}
+	preview.ImportID = uuid.NewString()
+	preview.UserID = userID
+	preview.Profile = profile
+	preview.CreatedAt = time.Now()
+
+	if err := s.previewRepository.StoreImportPreview(preview, importPreviewTTL); err != nil {
+		return domain.PortfolioImportPreview{}, err
+	}
+
+	return preview, nil
+}
+
+// CommitImport merges the holdings of the preview into the portfolio of the user context by symbol.
+// The user profile is kept and a user context is created if the user doesn't have one yet.
+func (s *PortfolioImportService) CommitImport(userID string, importID string) (domain.UserContext, error) {
+	preview, err := s.previewRepository.GetImportPreview(importID)
+	if err != nil {
+		return domain.UserContext{}, err
+	}
+	// The databases delete the expired previews in the background, so they can still be found for a while
+	if preview.UserID != userID || time.Since(preview.CreatedAt) > importPreviewTTL {
+		return domain.UserContext{}, investbotErr.PortfolioImportNotFoundError{ImportID: importID}
+	}
+
+	if len(preview.Holdings) == 0 {
+		return domain.UserContext{}, investbotErr.InvalidPortfolioImportError{Message: "no holdings to import"}
+	}
+
+	userContext, err := s.userContextRepository.GetUserContext(userID)
+	isNewUserContext := false
+	if err != nil {
+		notFoundError := investbotErr.UserContextNotFoundError{}
+		if !errors.As(err, &notFoundError) {
+			return domain.UserContext{}, err
+		}
+		userContext = domain.UserContext{UserID: userID}
+		isNewUserContext = true
+	}
+	userContext.UserPortfolio = mergeImportedHoldings(userContext.UserPortfolio, preview.Holdings)
+
+	if isNewUserContext {
+		err = s.userContextRepository.InsertUserContext(userContext)
+	} else {
+		err = s.userContextRepository.UpdateUserContext(userContext)
+	}
+	if err != nil {
+		return domain.UserContext{}, err
+	}
+
+	if err := s.previewRepository.DeleteImportPreview(importID); err != nil {
+		return domain.UserContext{}, err
+	}
+
+	return userContext, nil
+}
+
+// mergeImportedHoldings replaces the holdings of the portfolio that have the symbol of an imported holding
+// and adds the others, the holdings that are not in the import (entered by hand or traded in the ledger)
+// are kept with their portfolio percentages. The percentages of the import only cover the imported holdings,
+// so when other holdings are kept the imported holdings with a quantity are weighted by their market value
+// in the rest of the portfolio, and the percentages of the others are scaled to the rest of the portfolio.
+func mergeImportedHoldings(portfolio []domain.UserPortfolioHolding, imported []domain.UserPortfolioHolding) []domain.UserPortfolioHolding {
+	var kept []domain.UserPortfolioHolding
+	keptPercentage := 0.0
+	for _, holding := range portfolio {
+		isImported := slices.ContainsFunc(imported, func(importedHolding domain.UserPortfolioHolding) bool {
+			return strings.EqualFold(holding.Symbol, importedHolding.Symbol)
+		})
+		if !isImported {
+			kept = append(kept, holding)
+			keptPercentage += holding.PortfolioPercentage
+		}
+	}
+
+	merged := make([]domain.UserPortfolioHolding, 0, len(kept)+len(imported))
+	merged = append(merged, kept...)
+	for _, holding := range imported {
+		if len(kept) > 0 {
+			if holding.Quantity > 0 {
+				holding.PortfolioPercentage = 0
+			} else {
+				holding.PortfolioPercentage = roundTo(holding.PortfolioPercentage*max(0, 100-keptPercentage)/100, 2)
+			}
+		}
+		merged = append(merged, holding)
+	}
+
+	return merged
+}
+
+type importColumns struct {
+	symbol      int
+	name        int
+	quantity    int
+	marketValue int
+	price       int
+	assetClass  int
+}
+
+// findColumn returns the index of the first header of the candidates found in the header row or -1
+func findColumn(header []string, candidates []string) int {
+	for _, candidate := range candidates {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), candidate) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func newImportColumns(header []string, mapping domain.PortfolioImportMapping) importColumns {
+	return importColumns{
+		symbol:      findColumn(header, mapping.Symbol),
+		name:        findColumn(header, mapping.Name),
+		quantity:    findColumn(header, mapping.Quantity),
+		marketValue: findColumn(header, mapping.MarketValue),
+		price:       findColumn(header, mapping.Price),
+		assetClass:  findColumn(header, mapping.AssetClass),
+	}
+}
+
+func (c importColumns) isValid() bool {
+	return (c.symbol != -1 || c.name != -1) && (c.quantity != -1 || c.marketValue != -1)
+}
+
+func cell(record []string, column int) string {
+	if column == -1 || column >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[column])
+}
+
+// parseCsvNumber parses numbers formatted like "$1,234.50", "(12.5)" or "--".
+// The second value is false if the cell doesn't contain a number.
+func parseCsvNumber(value string) (float64, bool) {
+	cleaned := strings.TrimSpace(value)
+	negative := strings.HasPrefix(cleaned, "(") && strings.HasSuffix(cleaned, ")")
+	cleaned = strings.Trim(cleaned, "()")
+	cleaned = strings.NewReplacer("$", "", "€", "", "£", "", "+", "").Replace(cleaned)
+	if strings.Trim(cleaned, " -") == "" {
+		return 0, false
+	}
+
+	number := parseNumber(cleaned)
+	if number == 0 && strings.Trim(cleaned, "0., %") != "" {
+		return 0, false
+	}
+	if negative {
+		number = -number
+	}
+	return number, true
+}
+
+// isSummaryRow reports whether the row contains account totals, cash or pending activity
+// instead of a position. Money market funds are marked with a trailing "**" by some brokers.
+func isSummaryRow(symbol string, name string) bool {
+	for _, value := range []string{strings.ToLower(symbol), strings.ToLower(name)} {
+		if strings.HasPrefix(value, "total") || strings.HasPrefix(value, "account total") ||
+			strings.HasPrefix(value, "cash") || strings.HasPrefix(value, "pending") {
+			return true
+		}
+	}
+	return strings.HasSuffix(symbol, "**")
+}
+
+func parseImportAssetClass(value string) (domain.AssetClass, bool) {
+	value = strings.ToLower(value)
+	switch {
+	case value == "":
+		return "", false
+	case strings.Contains(value, "crypto"):
+		return domain.Crypto, true
+	case strings.Contains(value, "etf") || strings.Contains(value, "exchange traded"):
+		return domain.ETF, true
+	case strings.Contains(value, "stock") || strings.Contains(value, "equit") || value == "stk":
+		return domain.Stock, true
+	}
+	return "", false
+}
+
+type importHolding struct {
+	holding     domain.UserPortfolioHolding
+	marketValue float64
+	hasValue    bool
+}
+
+func buildImportPreview(
+	csvData io.Reader,
+	mapping domain.PortfolioImportMapping,
+	resolver *symbolResolver,
+) (domain.PortfolioImportPreview, error) {
+	reader := csv.NewReader(csvData)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	// The reader skips empty lines so the line of every record is kept to report the rows of the file
+	records := make([][]string, 0)
+	lines := make([]int, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return domain.PortfolioImportPreview{}, investbotErr.InvalidPortfolioImportError{Message: err.Error()}
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	headerRow := -1
+	var columns importColumns
+	for i := 0; i < len(records) && i < maxImportHeaderRow; i++ {
+		columns = newImportColumns(records[i], mapping)
+		if columns.isValid() {
+			headerRow = i
+			break
+		}
+	}
+	if headerRow == -1 {
+		return domain.PortfolioImportPreview{}, investbotErr.InvalidPortfolioImportError{
+			Message: "header row not found, the file must contain a symbol or name column and a quantity or market value column",
+		}
+	}
+
+	preview := domain.PortfolioImportPreview{
+		Holdings:      make([]domain.UserPortfolioHolding, 0),
+		MergedSymbols: make([]string, 0),
+		UnknownRows:   make([]domain.PortfolioImportRowIssue, 0),
+		SkippedRows:   make([]domain.PortfolioImportRowIssue, 0),
+		Warnings:      make([]string, 0),
+	}
+
+	holdings := make([]*importHolding, 0)
+	bySymbol := make(map[string]*importHolding)
+	for i, record := range records[headerRow+1:] {
+		row := lines[headerRow+i+1]
+		symbol := strings.TrimSpace(strings.TrimPrefix(cell(record, columns.symbol), "$"))
+		name := cell(record, columns.name)
+		issue := domain.PortfolioImportRowIssue{Row: row, Symbol: symbol, Name: name}
+
+		if symbol == "" && name == "" {
+			continue
+		}
+
+		if isSummaryRow(symbol, name) {
+			issue.Reason = "not a position"
+			preview.SkippedRows = append(preview.SkippedRows, issue)
+			continue
+		}
+
+		quantity, hasQuantity := parseCsvNumber(cell(record, columns.quantity))
+		marketValue, hasValue := parseCsvNumber(cell(record, columns.marketValue))
+		if !hasValue && hasQuantity {
+			if price, hasPrice := parseCsvNumber(cell(record, columns.price)); hasPrice {
+				marketValue, hasValue = quantity*price, true
+			}
+		}
+
+		if !hasQuantity && !hasValue {
+			issue.Reason = "missing quantity and market value"
+			preview.SkippedRows = append(preview.SkippedRows, issue)
+			continue
+		}
+
+		if quantity < 0 || marketValue < 0 {
+			issue.Reason = "short positions are not supported"
+			preview.SkippedRows = append(preview.SkippedRows, issue)
+			continue
+		}
+
+		holding := domain.UserPortfolioHolding{Quantity: quantity}
+		assetClass, hasAssetClass := parseImportAssetClass(cell(record, columns.assetClass))
+		if hasAssetClass && assetClass == domain.Crypto {
+			// Crypto can't be resolved against the market data universes
+			holding.AssetClass = domain.Crypto
+			holding.Symbol = strings.ToUpper(symbol)
+			holding.Name = name
+		} else {
+			resolved, found := resolver.resolve(symbol, name)
+			if !found {
+				issue.Reason = "symbol not found in stocks or etfs"
+				preview.UnknownRows = append(preview.UnknownRows, issue)
+				continue
+			}
+			holding.AssetClass = resolved.assetClass
+			holding.Symbol = resolved.symbol
+			holding.Name = resolved.name
+		}
+
+		if holding.Symbol == "" {
+			issue.Reason = "missing symbol"
+			preview.UnknownRows = append(preview.UnknownRows, issue)
+			continue
+		}
+
+		// The same position can be held in several lots or accounts
+		if existing, found := bySymbol[holding.Symbol]; found {
+			existing.holding.Quantity += holding.Quantity
+			existing.marketValue += marketValue
+			existing.hasValue = existing.hasValue && hasValue
+			if !slices.Contains(preview.MergedSymbols, holding.Symbol) {
+				preview.MergedSymbols = append(preview.MergedSymbols, holding.Symbol)
+			}
+			continue
+		}
+
+		h := &importHolding{holding: holding, marketValue: marketValue, hasValue: hasValue}
+		holdings = append(holdings, h)
+		bySymbol[holding.Symbol] = h
+	}
+
+	totalValue := 0.0
+	allValued := true
+	for _, h := range holdings {
+		totalValue += h.marketValue
+		allValued = allValued && h.hasValue
+	}
+	if len(holdings) > 0 && (!allValued || totalValue == 0) {
+		preview.Warnings = append(preview.Warnings, "market values are missing for some holdings, portfolio percentages were not computed")
+	}
+
+	for _, h := range holdings {
+		if allValued && totalValue > 0 {
+			h.holding.PortfolioPercentage = roundTo(h.marketValue/totalValue*100, 2)
+		}
+		preview.Holdings = append(preview.Holdings, h.holding)
+	}
+
+	return preview, nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSymbolResolver() *symbolResolver {
+	resolver := &symbolResolver{bySymbol: map[string]resolvedSymbol{}, byName: map[string]resolvedSymbol{}}
+	for _, entry := range []resolvedSymbol{
+		{symbol: "AAPL", name: "Apple Inc.", assetClass: domain.Stock},
+		{symbol: "MSFT", name: "Microsoft Corporation", assetClass: domain.Stock},
+		{symbol: "VOO", name: "Vanguard S&P 500 ETF", assetClass: domain.ETF},
+	} {
+		resolver.bySymbol[entry.symbol] = entry
+		resolver.byName[normalizeAssetName(entry.name)] = entry
+	}
+	return resolver
+}
+
+func TestBuildImportPreview_SchwabExport(t *testing.T) {
+	csvData := `"Positions for account Individual ...123 as of 10:00 AM ET, 2024/03/01"
+
+"Symbol","Description","Qty (Quantity)","Price","Mkt Val (Market Value)","Security Type"
+"AAPL","APPLE INC","10","$180.00","$1,800.00","Equity"
+"voo","VANGUARD S&P 500 ETF","2","$400.00","$800.00","ETFs & Closed End Funds"
+"AAPL","APPLE INC","5","$180.00","$900.00","Equity"
+"XYZQ","UNKNOWN CORP","3","$10.00","$30.00","Equity"
+"","Microsoft Corporation","1","$500.00","$500.00","Equity"
+"Cash & Cash Investments","--","--","--","$100.00","Cash and Money Market"
+"Account Total","--","--","--","$4,130.00","--"
+`
+
+	preview, err := buildImportPreview(strings.NewReader(csvData), PortfolioImportProfiles["schwab"], testSymbolResolver())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []domain.UserPortfolioHolding{
+		{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 15, PortfolioPercentage: 67.5},
+		{AssetClass: domain.ETF, Symbol: "VOO", Name: "Vanguard S&P 500 ETF", Quantity: 2, PortfolioPercentage: 20},
+		{AssetClass: domain.Stock, Symbol: "MSFT", Name: "Microsoft Corporation", Quantity: 1, PortfolioPercentage: 12.5},
+	}, preview.Holdings)
+	assert.Equal(t, []string{"AAPL"}, preview.MergedSymbols)
+	assert.Len(t, preview.UnknownRows, 1)
+	assert.Equal(t, 7, preview.UnknownRows[0].Row)
+	assert.Len(t, preview.SkippedRows, 2)
+	assert.Empty(t, preview.Warnings)
+}
+
+func TestBuildImportPreview_MissingHeader(t *testing.T) {
+	_, err := buildImportPreview(strings.NewReader("a,b\n1,2\n"), PortfolioImportProfiles[GenericImportProfile], testSymbolResolver())
+	assert.ErrorAs(t, err, &investbotErr.InvalidPortfolioImportError{})
+}
+
+type fakeImportPreviewRepository map[string]domain.PortfolioImportPreview
+
+func (r fakeImportPreviewRepository) StoreImportPreview(preview domain.PortfolioImportPreview, _ time.Duration) error {
+	r[preview.ImportID] = preview
+	return nil
+}
+
+func (r fakeImportPreviewRepository) GetImportPreview(importID string) (domain.PortfolioImportPreview, error) {
+	preview, found := r[importID]
+	if !found {
+		return domain.PortfolioImportPreview{}, investbotErr.PortfolioImportNotFoundError{ImportID: importID}
+	}
+	return preview, nil
+}
+
+func (r fakeImportPreviewRepository) DeleteImportPreview(importID string) error {
+	delete(r, importID)
+	return nil
+}
+
+func TestPortfolioImportService_CommitImportKeepsOtherHoldings(t *testing.T) {
+	userContexts := fakeUserContextRepository{"user": {
+		UserID:      "user",
+		UserProfile: map[string]any{"horizon": "long term"},
+		UserPortfolio: []domain.UserPortfolioHolding{
+			{AssetClass: domain.Crypto, Name: "Bitcoin", Quantity: 0.5, PortfolioPercentage: 30},
+			{AssetClass: domain.Stock, Symbol: "aapl", Name: "Apple Inc.", Quantity: 3, PortfolioPercentage: 70},
+		},
+	}}
+	previews := fakeImportPreviewRepository{"import": {
+		ImportID:  "import",
+		UserID:    "user",
+		CreatedAt: time.Now(),
+		Holdings: []domain.UserPortfolioHolding{
+			{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 15, PortfolioPercentage: 80},
+			{AssetClass: domain.ETF, Symbol: "VOO", Name: "Vanguard S&P 500 ETF", Quantity: 2, PortfolioPercentage: 20},
+		},
+	}}
+	importService, _ := NewPortfolioImportService(nil, userContexts, previews)
+
+	_, err := importService.CommitImport("other_user", "import")
+	assert.ErrorAs(t, err, &investbotErr.PortfolioImportNotFoundError{})
+
+	userContext, err := importService.CommitImport("user", "import")
+	assert.NoError(t, err)
+
+	// The imported holdings replace the holdings with the same symbol and the others are kept with their
+	// percentages, the imported holdings are weighted by their market value in the rest of the portfolio
+	assert.Equal(t, map[string]any{"horizon": "long term"}, userContext.UserProfile)
+	assert.Equal(t, []domain.UserPortfolioHolding{
+		{AssetClass: domain.Crypto, Name: "Bitcoin", Quantity: 0.5, PortfolioPercentage: 30},
+		{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 15},
+		{AssetClass: domain.ETF, Symbol: "VOO", Name: "Vanguard S&P 500 ETF", Quantity: 2},
+	}, userContexts["user"].UserPortfolio)
+
+	assert.Empty(t, previews)
+
+	_, err = importService.CommitImport("user", "import")
+	assert.ErrorAs(t, err, &investbotErr.PortfolioImportNotFoundError{})
+}
+
+func TestMergeImportedHoldings_ScalesPercentagesWithoutQuantity(t *testing.T) {
+	portfolio := []domain.UserPortfolioHolding{{AssetClass: domain.Crypto, Name: "Bitcoin", PortfolioPercentage: 40}}
+	imported := []domain.UserPortfolioHolding{
+		{AssetClass: domain.Stock, Symbol: "AAPL", PortfolioPercentage: 75},
+		{AssetClass: domain.ETF, Symbol: "VOO", PortfolioPercentage: 25},
+	}
+
+	merged := mergeImportedHoldings(portfolio, imported)
+	assert.Equal(t, []domain.UserPortfolioHolding{
+		{AssetClass: domain.Crypto, Name: "Bitcoin", PortfolioPercentage: 40},
+		{AssetClass: domain.Stock, Symbol: "AAPL", PortfolioPercentage: 45},
+		{AssetClass: domain.ETF, Symbol: "VOO", PortfolioPercentage: 15},
+	}, merged)
+}
+
+func TestMergeImportedHoldings_KeepsPercentagesOfFullImport(t *testing.T) {
+	imported := []domain.UserPortfolioHolding{{AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 15, PortfolioPercentage: 100}}
+
+	merged := mergeImportedHoldings([]domain.UserPortfolioHolding{{Symbol: "AAPL", Quantity: 3}}, imported)
+	assert.Equal(t, imported, merged)
+}
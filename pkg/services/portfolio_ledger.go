@@ -0,0 +1,265 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/domain"
+	"investbot/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// quantityEpsilon is used to ignore floating point leftovers when a position is fully sold
+const quantityEpsilon = 1e-9
+
+type ledgerPosition struct {
+	assetClass     domain.AssetClass
+	quantity       float64
+	costBasis      float64
+	realizedPL     float64
+	dividends      float64
+	lastTradePrice float64
+}
+
+// ledgerState is the state of a portfolio while replaying its transactions in chronological order
+type ledgerState struct {
+	cash        float64
+	netDeposits float64
+	fees        float64
+	positions   map[string]*ledgerPosition
+	symbols     []string // symbols in the order they first appeared in the ledger
+}
+
+func newLedgerState() *ledgerState {
+	return &ledgerState{positions: make(map[string]*ledgerPosition)}
+}
+
+func (s *ledgerState) position(symbol string, assetClass domain.AssetClass) *ledgerPosition {
+	p, found := s.positions[symbol]
+	if !found {
+		p = &ledgerPosition{assetClass: assetClass}
+		s.positions[symbol] = p
+		s.symbols = append(s.symbols, symbol)
+	}
+	if p.assetClass == "" {
+		p.assetClass = assetClass
+	}
+	return p
+}
+
+// externalFlow returns the cash that enters(or leaves) the portfolio from outside because of the transaction.
+// Deposits are explicit flows, buys that cost more than the available cash are treated as if the
+// missing cash was deposited right before the buy so that ledgers without deposits still work.
+func (s *ledgerState) externalFlow(t domain.PortfolioTransaction) float64 {
+	switch t.Type {
+	case domain.Deposit:
+		return t.Amount
+	case domain.Buy:
+		cost := t.Quantity*t.Price + t.Fees
+		if cost > s.cash {
+			return cost - s.cash
+		}
+	}
+	return 0
+}
+
+func (s *ledgerState) apply(t domain.PortfolioTransaction) error {
+	symbol := strings.ToUpper(t.Symbol)
+
+	if flow := s.externalFlow(t); flow != 0 {
+		s.cash += flow
+		s.netDeposits += flow
+	}
+
+	switch t.Type {
+	case domain.Deposit:
+		// already applied as an external flow
+	case domain.Buy:
+		cost := t.Quantity*t.Price + t.Fees
+		p := s.position(symbol, t.AssetClass)
+		p.quantity += t.Quantity
+		p.costBasis += cost
+		p.lastTradePrice = t.Price
+		s.cash -= cost
+		s.fees += t.Fees
+	case domain.Sell:
+		p, found := s.positions[symbol]
+		if !found || t.Quantity > p.quantity+quantityEpsilon {
+			return errors.InvalidPortfolioTransactionError{
+				Message: fmt.Sprintf("cannot sell %g %s on %s, position is smaller", t.Quantity, symbol, t.Date.Format("2006-01-02")),
+			}
+		}
+		averageCost := p.costBasis / p.quantity
+		proceeds := t.Quantity*t.Price - t.Fees
+		p.realizedPL += proceeds - averageCost*t.Quantity
+		p.costBasis -= averageCost * t.Quantity
+		p.quantity -= t.Quantity
+		if p.quantity < quantityEpsilon {
+			p.quantity = 0
+			p.costBasis = 0
+		}
+		p.lastTradePrice = t.Price
+		s.cash += proceeds
+		s.fees += t.Fees
+	case domain.Dividend:
+		p := s.position(symbol, t.AssetClass)
+		p.dividends += t.Amount
+		s.cash += t.Amount
+	case domain.Split:
+		if t.SplitRatio <= 0 {
+			return errors.InvalidPortfolioTransactionError{Message: "split_ratio must be greater than zero"}
+		}
+		p, found := s.positions[symbol]
+		if !found {
+			return errors.InvalidPortfolioTransactionError{
+				Message: fmt.Sprintf("cannot split %s on %s, there is no position", symbol, t.Date.Format("2006-01-02")),
+			}
+		}
+		// The cost basis doesn't change, only the number of shares
+		p.quantity *= t.SplitRatio
+		if p.lastTradePrice > 0 {
+			p.lastTradePrice /= t.SplitRatio
+		}
+	default:
+		return errors.InvalidPortfolioTransactionError{Message: fmt.Sprintf("unknown transaction type %s", t.Type)}
+	}
+
+	return nil
+}
+
+// value returns the value of the portfolio(cash and positions) at the given date.
+// Positions without a known price are valued at the price of their last trade.
+func (s *ledgerState) value(priceHistory map[string][]domain.Price, date time.Time) float64 {
+	total := s.cash
+	for symbol, p := range s.positions {
+		if p.quantity == 0 {
+			continue
+		}
+		price, found := priceOnOrBefore(priceHistory[symbol], date)
+		if !found {
+			price = p.lastTradePrice
+		}
+		total += p.quantity * price
+	}
+	return total
+}
+
+// priceOnOrBefore returns the close price of the last day that is not after the given date
+func priceOnOrBefore(prices []domain.Price, date time.Time) (float64, bool) {
+	i := sort.Search(len(prices), func(i int) bool { return prices[i].Date.After(date) })
+	if i == 0 {
+		return 0, false
+	}
+	return prices[i-1].ClosePrice, true
+}
+
+// sortTransactions returns a copy of the transactions sorted by date
+func sortTransactions(transactions []domain.PortfolioTransaction) []domain.PortfolioTransaction {
+	sorted := make([]domain.PortfolioTransaction, len(transactions))
+	copy(sorted, transactions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	return sorted
+}
+
+// buildPortfolioLedger replays the transactions and derives the holdings, the profit and loss and
+// the time-weighted return of the portfolio.
+//
+// Parameters:
+//   - priceHistory: The historical prices of each symbol(upper case) sorted by date. It is used to value the
+//     portfolio at the dates of the external cash flows, which is needed for the time-weighted return.
+//   - currentPrices: The most recent price of each symbol(upper case).
+//
+// The cost basis is computed with the average cost method.
+func buildPortfolioLedger(
+	userID string,
+	transactions []domain.PortfolioTransaction,
+	priceHistory map[string][]domain.Price,
+	currentPrices map[string]float64,
+) (domain.PortfolioLedger, error) {
+	state := newLedgerState()
+
+	// The time-weighted return is the product of the returns of the sub periods between external cash flows
+	growthFactor := 1.0
+	var periodStartValue float64
+	for _, t := range sortTransactions(transactions) {
+		if flow := state.externalFlow(t); flow != 0 {
+			valueBeforeFlow := state.value(priceHistory, t.Date)
+			if periodStartValue > 0 {
+				growthFactor *= valueBeforeFlow / periodStartValue
+			}
+			periodStartValue = valueBeforeFlow + flow
+		}
+		if err := state.apply(t); err != nil {
+			return domain.PortfolioLedger{}, err
+		}
+	}
+
+	ledger := domain.PortfolioLedger{
+		UserID:      userID,
+		Holdings:    make([]domain.LedgerHolding, 0, len(state.symbols)),
+		Cash:        roundTo(state.cash, 2),
+		NetDeposits: roundTo(state.netDeposits, 2),
+		Fees:        roundTo(state.fees, 2),
+	}
+
+	var marketValue, realizedPL, unrealizedPL, dividends float64
+	for _, symbol := range state.symbols {
+		p := state.positions[symbol]
+		realizedPL += p.realizedPL
+		dividends += p.dividends
+		if p.quantity == 0 {
+			continue
+		}
+
+		currentPrice, found := currentPrices[symbol]
+		if !found || currentPrice == 0 {
+			currentPrice = p.lastTradePrice
+		}
+
+		holding := domain.LedgerHolding{
+			AssetClass:   p.assetClass,
+			Symbol:       symbol,
+			Quantity:     p.quantity,
+			AverageCost:  roundTo(p.costBasis/p.quantity, 4),
+			CostBasis:    roundTo(p.costBasis, 2),
+			CurrentPrice: currentPrice,
+			MarketValue:  roundTo(p.quantity*currentPrice, 2),
+			RealizedPL:   roundTo(p.realizedPL, 2),
+			Dividends:    roundTo(p.dividends, 2),
+		}
+		holding.UnrealizedPL = roundTo(p.quantity*currentPrice-p.costBasis, 2)
+		if p.costBasis > 0 {
+			holding.UnrealizedPLPct = roundTo((p.quantity*currentPrice-p.costBasis)/p.costBasis*100, 2)
+		}
+
+		marketValue += p.quantity * currentPrice
+		unrealizedPL += p.quantity*currentPrice - p.costBasis
+		ledger.Holdings = append(ledger.Holdings, holding)
+	}
+
+	// Portfolio percentages are computed on the invested part of the portfolio(cash is excluded)
+	for i := range ledger.Holdings {
+		if marketValue > 0 {
+			ledger.Holdings[i].PortfolioPercentage = roundTo(ledger.Holdings[i].MarketValue/marketValue*100, 2)
+		}
+	}
+
+	totalValue := state.cash + marketValue
+	if periodStartValue > 0 {
+		growthFactor *= totalValue / periodStartValue
+	}
+
+	ledger.MarketValue = roundTo(marketValue, 2)
+	ledger.TotalValue = roundTo(totalValue, 2)
+	ledger.RealizedPL = roundTo(realizedPL, 2)
+	ledger.UnrealizedPL = roundTo(unrealizedPL, 2)
+	ledger.Dividends = roundTo(dividends, 2)
+	ledger.TimeWeightedReturnPct = roundTo((growthFactor-1)*100, 2)
+
+	return ledger, nil
+}
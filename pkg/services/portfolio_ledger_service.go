@@ -0,0 +1,306 @@
+package services
+
+import (
+	"errors"
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PortfolioTransactionRepository interface {
+	InsertTransaction(transaction domain.PortfolioTransaction) error
+	GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error)
+	GetTransactions(userID string) ([]domain.PortfolioTransaction, error)
+	UpdateTransaction(transaction domain.PortfolioTransaction) error
+	DeleteTransaction(userID string, transactionID string) error
+}
+
+type PortfolioPriceDataService interface {
+	GetHistoricalPrices(ticker string, assetClass domain.AssetClass, period domain.Period) (domain.HistoricalPrices, error)
+}
+
+// LedgerUserContextService stores the holdings synced from the ledger in the user contexts, with the same
+// normalization and validation as the other writes of the user contexts
+type LedgerUserContextService interface {
+	GetUserContext(userID string) (domain.UserContext, error)
+	CreateUserContext(userContext domain.UserContext) (domain.UserContext, error)
+	UpdateUserContext(userContext domain.UserContext) (domain.UserContext, error)
+}
+
+type PortfolioLedgerService struct {
+	transactionRepository PortfolioTransactionRepository
+	userContextService    LedgerUserContextService
+	dataService           PortfolioPriceDataService
+}
+
+func NewPortfolioLedgerService(
+	transactionRepository PortfolioTransactionRepository,
+	userContextService LedgerUserContextService,
+	dataService PortfolioPriceDataService,
+) (*PortfolioLedgerService, error) {
+	return &PortfolioLedgerService{
+		transactionRepository: transactionRepository,
+		userContextService:    userContextService,
+		dataService:           dataService,
+	}, nil
+}
+
+func (s *PortfolioLedgerService) GetTransactions(userID string) ([]domain.PortfolioTransaction, error) {
+	transactions, err := s.transactionRepository.GetTransactions(userID)
+	if err != nil {
+		return nil, err
+	}
+	return sortTransactions(transactions), nil
+}
+
+func (s *PortfolioLedgerService) GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error) {
+	return s.transactionRepository.GetTransaction(userID, transactionID)
+}
+
+// AddTransaction stores a new transaction and updates the holdings of the user context.
+// The transaction is rejected if the ledger becomes inconsistent, for example when selling
+// more shares than the position holds at the date of the sale.
+func (s *PortfolioLedgerService) AddTransaction(transaction domain.PortfolioTransaction) (domain.PortfolioTransaction, error) {
+	transaction.ID = uuid.NewString()
+	transaction.Symbol = strings.ToUpper(transaction.Symbol)
+	transaction.CreatedAt = time.Now()
+
+	transactions, err := s.transactionRepository.GetTransactions(transaction.UserID)
+	if err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	if err := validateLedger(append(transactions, transaction)); err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	if err := s.transactionRepository.InsertTransaction(transaction); err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	s.syncUserPortfolio(transaction.UserID, transaction.Symbol)
+
+	return transaction, nil
+}
+
+func (s *PortfolioLedgerService) UpdateTransaction(transaction domain.PortfolioTransaction) (domain.PortfolioTransaction, error) {
+	existing, err := s.transactionRepository.GetTransaction(transaction.UserID, transaction.ID)
+	if err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+	transaction.Symbol = strings.ToUpper(transaction.Symbol)
+	transaction.CreatedAt = existing.CreatedAt
+
+	transactions, err := s.transactionRepository.GetTransactions(transaction.UserID)
+	if err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	for i, t := range transactions {
+		if t.ID == transaction.ID {
+			transactions[i] = transaction
+		}
+	}
+
+	if err := validateLedger(transactions); err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	if err := s.transactionRepository.UpdateTransaction(transaction); err != nil {
+		return domain.PortfolioTransaction{}, err
+	}
+
+	// The symbol of the transaction can change, both positions are updated
+	s.syncUserPortfolio(transaction.UserID, existing.Symbol, transaction.Symbol)
+
+	return transaction, nil
+}
+
+func (s *PortfolioLedgerService) DeleteTransaction(userID string, transactionID string) error {
+	deleted, err := s.transactionRepository.GetTransaction(userID, transactionID)
+	if err != nil {
+		return err
+	}
+
+	transactions, err := s.transactionRepository.GetTransactions(userID)
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]domain.PortfolioTransaction, 0, len(transactions))
+	for _, t := range transactions {
+		if t.ID != transactionID {
+			remaining = append(remaining, t)
+		}
+	}
+
+	// For example deleting a buy could leave a later sell without shares
+	if err := validateLedger(remaining); err != nil {
+		return err
+	}
+
+	if err := s.transactionRepository.DeleteTransaction(userID, transactionID); err != nil {
+		return err
+	}
+
+	s.syncUserPortfolio(userID, deleted.Symbol)
+
+	return nil
+}
+
+// GetLedger returns the holdings, profit and loss and time-weighted return of the user's
+// portfolio derived from the stored transactions and the current prices.
+func (s *PortfolioLedgerService) GetLedger(userID string) (domain.PortfolioLedger, error) {
+	transactions, err := s.transactionRepository.GetTransactions(userID)
+	if err != nil {
+		return domain.PortfolioLedger{}, err
+	}
+
+	priceHistory, currentPrices := s.fetchPrices(transactions)
+
+	return buildPortfolioLedger(userID, transactions, priceHistory, currentPrices)
+}
+
+// validateLedger checks that the transactions can be replayed without errors.
+// Prices are not needed for this so no data is fetched.
+func validateLedger(transactions []domain.PortfolioTransaction) error {
+	_, err := buildPortfolioLedger("", transactions, nil, nil)
+	return err
+}
+
+// fetchPrices returns the price history and the most recent price of each traded symbol.
+// Symbols for which the prices can't be fetched(for example crypto) are valued using
+// the price of their last trade.
+func (s *PortfolioLedgerService) fetchPrices(transactions []domain.PortfolioTransaction) (map[string][]domain.Price, map[string]float64) {
+	assetClasses := make(map[string]domain.AssetClass)
+	for _, t := range transactions {
+		if t.Symbol == "" || (t.AssetClass != domain.Stock && t.AssetClass != domain.ETF) {
+			continue
+		}
+		assetClasses[strings.ToUpper(t.Symbol)] = t.AssetClass
+	}
+
+	priceHistory := make(map[string][]domain.Price, len(assetClasses))
+	currentPrices := make(map[string]float64, len(assetClasses))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for symbol, assetClass := range assetClasses {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			history, err := s.dataService.GetHistoricalPrices(strings.ToLower(symbol), assetClass, domain.Period5Y)
+			if err != nil {
+				log.Printf("GetHistoricalPrices for %s failed: %s", symbol, err)
+				return
+			}
+			mu.Lock()
+			priceHistory[symbol] = history.Prices
+			mu.Unlock()
+		}()
+
+		go func() {
+			defer wg.Done()
+			recent, err := s.dataService.GetHistoricalPrices(strings.ToLower(symbol), assetClass, domain.Period5D)
+			if err != nil || len(recent.Prices) == 0 {
+				return
+			}
+			mu.Lock()
+			currentPrices[symbol] = recent.Prices[len(recent.Prices)-1].ClosePrice
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return priceHistory, currentPrices
+}
+
+// syncUserPortfolio updates the holdings of the given symbols in the user context with their
+// quantities in the ledger, so that they don't have to be entered by hand. A holding is added for a
+// new position and removed when the position is closed, the holdings of the other symbols, like the
+// ones entered with the user context or imported, are kept with their percentages. The quantities
+// don't need prices so none are fetched. The user context is stored through the user context service
+// so the symbols are resolved and the portfolio is validated, and it's created if the user doesn't
+// have one yet. Failures are only logged since the transactions were already stored.
+func (s *PortfolioLedgerService) syncUserPortfolio(userID string, symbols ...string) {
+	transactions, err := s.transactionRepository.GetTransactions(userID)
+	if err != nil {
+		log.Printf("GetTransactions for user %s failed: %s", userID, err)
+		return
+	}
+	ledger, err := buildPortfolioLedger(userID, transactions, nil, nil)
+	if err != nil {
+		log.Printf("Failed to build the ledger of user %s: %s", userID, err)
+		return
+	}
+
+	userContext, err := s.userContextService.GetUserContext(userID)
+	isNewUserContext := false
+	if err != nil {
+		notFoundError := investbotErr.UserContextNotFoundError{}
+		if !errors.As(err, &notFoundError) {
+			log.Printf("GetUserContext for user %s failed: %s", userID, err)
+			return
+		}
+		userContext = domain.UserContext{UserID: userID}
+		isNewUserContext = true
+	}
+
+	userContext.UserPortfolio = mergeLedgerHoldings(userContext.UserPortfolio, ledger.Holdings, symbols)
+
+	if isNewUserContext {
+		_, err = s.userContextService.CreateUserContext(userContext)
+	} else {
+		_, err = s.userContextService.UpdateUserContext(userContext)
+	}
+	if err != nil {
+		log.Printf("Failed to sync portfolio of user %s: %s", userID, err)
+	}
+}
+
+// mergeLedgerHoldings returns the portfolio with the holdings of the symbols set to their quantities
+// in the ledger, the holdings of the other symbols are kept as they are. The holdings of the symbols are
+// weighted by the market value of their quantity, so a percentage entered for them before is cleared.
+func mergeLedgerHoldings(portfolio []domain.UserPortfolioHolding, ledgerHoldings []domain.LedgerHolding, symbols []string) []domain.UserPortfolioHolding {
+	merged := slices.Clone(portfolio)
+
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		if symbol == "" {
+			continue
+		}
+
+		index := slices.IndexFunc(merged, func(holding domain.UserPortfolioHolding) bool {
+			return strings.EqualFold(holding.Symbol, symbol)
+		})
+		ledgerIndex := slices.IndexFunc(ledgerHoldings, func(holding domain.LedgerHolding) bool {
+			return holding.Symbol == symbol
+		})
+
+		switch {
+		case ledgerIndex < 0 && index >= 0:
+			// The position was closed
+			merged = slices.Delete(merged, index, index+1)
+		case ledgerIndex >= 0 && index >= 0:
+			merged[index].Symbol = symbol
+			merged[index].AssetClass = ledgerHoldings[ledgerIndex].AssetClass
+			merged[index].Quantity = ledgerHoldings[ledgerIndex].Quantity
+			merged[index].PortfolioPercentage = 0
+		case ledgerIndex >= 0:
+			merged = append(merged, domain.UserPortfolioHolding{
+				AssetClass: ledgerHoldings[ledgerIndex].AssetClass,
+				Symbol:     symbol,
+				Quantity:   ledgerHoldings[ledgerIndex].Quantity,
+			})
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func day(n int) time.Time {
+	return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+}
+
+func TestBuildPortfolioLedger_AverageCostAndProfitAndLoss(t *testing.T) {
+	transactions := []domain.PortfolioTransaction{
+		// Transactions are not stored in chronological order
+		{Type: domain.Sell, AssetClass: domain.Stock, Symbol: "aapl", Quantity: 5, Price: 130, Fees: 1, Date: day(3)},
+		{Type: domain.Buy, AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 10, Price: 100, Date: day(1)},
+		{Type: domain.Buy, AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 10, Price: 120, Date: day(2)},
+		{Type: domain.Dividend, AssetClass: domain.Stock, Symbol: "AAPL", Amount: 7.5, Date: day(4)},
+		{Type: domain.Buy, AssetClass: domain.ETF, Symbol: "VOO", Quantity: 1, Price: 400, Date: day(4)},
+	}
+
+	ledger, err := buildPortfolioLedger("user", transactions, nil, map[string]float64{"AAPL": 140, "VOO": 420})
+	assert.NoError(t, err)
+	assert.Len(t, ledger.Holdings, 2)
+
+	aapl := ledger.Holdings[0]
+	assert.Equal(t, "AAPL", aapl.Symbol)
+	assert.Equal(t, 15.0, aapl.Quantity)
+	assert.Equal(t, 110.0, aapl.AverageCost)
+	assert.Equal(t, 1650.0, aapl.CostBasis)
+	assert.Equal(t, 450.0, aapl.UnrealizedPL)
+	// (130 - 110) * 5 - 1 fees
+	assert.Equal(t, 99.0, aapl.RealizedPL)
+	assert.Equal(t, 7.5, aapl.Dividends)
+
+	// 2100 for AAPL and 420 for VOO
+	assert.Equal(t, 83.33, aapl.PortfolioPercentage)
+	assert.Equal(t, 16.67, ledger.Holdings[1].PortfolioPercentage)
+	assert.Equal(t, 1.0, ledger.Fees)
+	assert.Equal(t, 470.0, ledger.UnrealizedPL)
+}
+
+func TestBuildPortfolioLedger_Split(t *testing.T) {
+	transactions := []domain.PortfolioTransaction{
+		{Type: domain.Buy, AssetClass: domain.Stock, Symbol: "NVDA", Quantity: 2, Price: 1000, Date: day(1)},
+		{Type: domain.Split, Symbol: "NVDA", SplitRatio: 10, Date: day(2)},
+	}
+
+	ledger, err := buildPortfolioLedger("user", transactions, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, ledger.Holdings[0].Quantity)
+	assert.Equal(t, 100.0, ledger.Holdings[0].AverageCost)
+	// Without a current price the holding is valued at the split adjusted price of the last trade
+	assert.Equal(t, 100.0, ledger.Holdings[0].CurrentPrice)
+}
+
+func TestBuildPortfolioLedger_SellMoreThanHeld(t *testing.T) {
+	transactions := []domain.PortfolioTransaction{
+		{Type: domain.Buy, AssetClass: domain.Stock, Symbol: "MSFT", Quantity: 1, Price: 300, Date: day(2)},
+		{Type: domain.Sell, AssetClass: domain.Stock, Symbol: "MSFT", Quantity: 1, Price: 310, Date: day(1)},
+	}
+
+	_, err := buildPortfolioLedger("user", transactions, nil, nil)
+	assert.ErrorAs(t, err, &investbotErr.InvalidPortfolioTransactionError{})
+}
+
+func TestBuildPortfolioLedger_TimeWeightedReturn(t *testing.T) {
+	transactions := []domain.PortfolioTransaction{
+		{Type: domain.Deposit, Amount: 1000, Date: day(0)},
+		{Type: domain.Buy, AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 10, Price: 100, Date: day(0)},
+		{Type: domain.Deposit, Amount: 1000, Date: day(1)},
+	}
+	priceHistory := map[string][]domain.Price{
+		"AAPL": {{Date: day(0), ClosePrice: 100}, {Date: day(1), ClosePrice: 120}},
+	}
+
+	ledger, err := buildPortfolioLedger("user", transactions, priceHistory, map[string]float64{"AAPL": 150})
+	assert.NoError(t, err)
+	assert.Equal(t, 1000.0, ledger.Cash)
+	assert.Equal(t, 2000.0, ledger.NetDeposits)
+	assert.Equal(t, 2500.0, ledger.TotalValue)
+	// 1200/1000 * 2500/2200
+	assert.Equal(t, 36.36, ledger.TimeWeightedReturnPct)
+}
+
+type fakeTransactionRepository map[string]domain.PortfolioTransaction
+
+func (f fakeTransactionRepository) InsertTransaction(transaction domain.PortfolioTransaction) error {
+	f[transaction.ID] = transaction
+	return nil
+}
+
+func (f fakeTransactionRepository) GetTransaction(userID string, transactionID string) (domain.PortfolioTransaction, error) {
+	transaction, found := f[transactionID]
+	if !found || transaction.UserID != userID {
+		return domain.PortfolioTransaction{}, investbotErr.PortfolioTransactionNotFoundError{TransactionID: transactionID}
+	}
+	return transaction, nil
+}
+
+func (f fakeTransactionRepository) GetTransactions(userID string) ([]domain.PortfolioTransaction, error) {
+	var transactions []domain.PortfolioTransaction
+	for _, transaction := range f {
+		if transaction.UserID == userID {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+func (f fakeTransactionRepository) UpdateTransaction(transaction domain.PortfolioTransaction) error {
+	f[transaction.ID] = transaction
+	return nil
+}
+
+func (f fakeTransactionRepository) DeleteTransaction(userID string, transactionID string) error {
+	delete(f, transactionID)
+	return nil
+}
+
+type fakeUserContextRepository map[string]domain.UserContext
+
+func (f fakeUserContextRepository) GetUserContext(userID string) (domain.UserContext, error) {
+	userContext, found := f[userID]
+	if !found {
+		return domain.UserContext{}, investbotErr.UserContextNotFoundError{UserID: userID}
+	}
+	return userContext, nil
+}
+
+func (f fakeUserContextRepository) InsertUserContext(userContext domain.UserContext) error {
+	f[userContext.UserID] = userContext
+	return nil
+}
+
+func (f fakeUserContextRepository) UpdateUserContext(userContext domain.UserContext) error {
+	f[userContext.UserID] = userContext
+	return nil
+}
+
+// fakePriceData counts the price requests
+type fakePriceData struct {
+	requests int
+}
+
+func (f *fakePriceData) GetHistoricalPrices(string, domain.AssetClass, domain.Period) (domain.HistoricalPrices, error) {
+	f.requests++
+	return domain.HistoricalPrices{}, nil
+}
+
+// fakeSymbolUniverse is the stock and etf universe that the symbols of the user contexts are resolved against
+type fakeSymbolUniverse struct{}
+
+func (fakeSymbolUniverse) GetTickers() ([]domain.Ticker, error) {
+	return []domain.Ticker{{Symbol: "AAPL", CompanyName: "Apple Inc."}, {Symbol: "MSFT", CompanyName: "Microsoft Corporation"}}, nil
+}
+
+func (fakeSymbolUniverse) GetEtfs() ([]domain.Etf, error) {
+	return []domain.Etf{{Symbol: "VOO", Name: "Vanguard S&P 500 ETF"}}, nil
+}
+
+func TestPortfolioLedgerService_SyncKeepsOtherHoldings(t *testing.T) {
+	userContexts := fakeUserContextRepository{"user": {
+		UserID: "user",
+		UserPortfolio: []domain.UserPortfolioHolding{
+			{AssetClass: domain.Crypto, Name: "Bitcoin", Quantity: 0.5, PortfolioPercentage: 40},
+			{AssetClass: domain.Stock, Symbol: "MSFT", Name: "Microsoft", Quantity: 3, PortfolioPercentage: 60},
+		},
+	}}
+	userContextService, _ := NewUserContextService(userContexts, fakeSymbolUniverse{})
+	prices := &fakePriceData{}
+	ledgerService, _ := NewPortfolioLedgerService(fakeTransactionRepository{}, userContextService, prices)
+
+	buy, err := ledgerService.AddTransaction(domain.PortfolioTransaction{
+		UserID: "user", Type: domain.Buy, AssetClass: domain.Stock, Symbol: "aapl", Quantity: 10, Price: 100, Date: day(1),
+	})
+	assert.NoError(t, err)
+	_, err = ledgerService.AddTransaction(domain.PortfolioTransaction{
+		UserID: "user", Type: domain.Buy, AssetClass: domain.Stock, Symbol: "MSFT", Quantity: 2, Price: 300, Date: day(1),
+	})
+	assert.NoError(t, err)
+
+	// The holdings that are not traded in the ledger keep their percentages, the traded ones are weighted
+	// by their quantity, the names are resolved and the writes don't fetch prices
+	assert.Equal(t, []domain.UserPortfolioHolding{
+		{AssetClass: domain.Crypto, Name: "Bitcoin", Quantity: 0.5, PortfolioPercentage: 40},
+		{AssetClass: domain.Stock, Symbol: "MSFT", Name: "Microsoft", Quantity: 2},
+		{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 10},
+	}, userContexts["user"].UserPortfolio)
+	assert.Zero(t, prices.requests)
+
+	// A symbol that is not in the universe doesn't pass the validation of the user context
+	_, err = ledgerService.AddTransaction(domain.PortfolioTransaction{
+		UserID: "user", Type: domain.Buy, AssetClass: domain.Stock, Symbol: "XYZQ", Quantity: 1, Price: 10, Date: day(1),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, userContexts["user"].UserPortfolio, 3)
+
+	// Deleting the only buy closes the position
+	assert.NoError(t, ledgerService.DeleteTransaction("user", buy.ID))
+	assert.Len(t, userContexts["user"].UserPortfolio, 2)
+	assert.Zero(t, prices.requests)
+}
+
+func TestHoldingWeights(t *testing.T) {
+	quantities := []domain.UserPortfolioHolding{{Quantity: 1}, {Quantity: 3}, {Quantity: 2}}
+	assert.Equal(t, []float64{0.25, 0.75, 0}, holdingWeights(quantities, []float64{100, 300, 0}))
+	// The holdings without a price get no weight
+	assert.Equal(t, []float64{0, 0, 0}, holdingWeights(quantities, []float64{0, 0, 0}))
+
+	// The holdings with a quantity share the part of the portfolio that the percentages don't cover
+	mixed := []domain.UserPortfolioHolding{{PortfolioPercentage: 40}, {Quantity: 1}, {Quantity: 3}}
+	assert.InDeltaSlice(t, []float64{0.4, 0.15, 0.45}, holdingWeights(mixed, []float64{0, 100, 300}), 1e-9)
+
+	percentages := []domain.UserPortfolioHolding{{PortfolioPercentage: 60}, {PortfolioPercentage: 40}}
+	assert.Equal(t, []float64{0.6, 0.4}, holdingWeights(percentages, []float64{0, 0}))
+	assert.Equal(t, []float64{0.5, 0.5}, holdingWeights([]domain.UserPortfolioHolding{{}, {}}, []float64{0, 0}))
+}
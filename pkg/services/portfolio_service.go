@@ -0,0 +1,437 @@
+package services
+
+import (
+	"fmt"
+	"investbot/pkg/domain"
+	"investbot/pkg/errors"
+	"investbot/pkg/services/prompts"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PortfolioDataService interface {
+	GetStockProfile(symbol string) (domain.StockProfile, error)
+	GetFinancialRatios(symbol string) ([]domain.FinancialRatios, error)
+	GetEtfOverview(symbol string) (domain.EtfOverview, error)
+	GetHistoricalPrices(ticker string, assetClass domain.AssetClass, period domain.Period) (domain.HistoricalPrices, error)
+}
+
+// holdingMarketData holds the market data we managed to fetch for a single holding
+type holdingMarketData struct {
+	sector        string
+	country       string
+	peRatio       float64
+	dividendYield float64
+	// hasFundamentals is true when the pe ratio and dividend yield were fetched
+	hasFundamentals bool
+	prices          []domain.Price
+	missingData     []string
+	dataKeys        []string // The cache keys of the fetched data
+}
+
+type holdingAnalytics struct {
+	Symbol              string
+	Name                string
+	AssetClass          domain.AssetClass
+	PortfolioPercentage float64
+	Sector              string
+	Country             string
+	PeRatio             float64
+	DividendYieldPct    float64
+	OneMonthReturnPct   float64
+	SixMonthReturnPct   float64
+	OneYearReturnPct    float64
+	VolatilityPct       float64
+}
+
+type holdingsCorrelation struct {
+	SymbolA     string
+	SymbolB     string
+	Correlation float64
+}
+
+type portfolioAnalyticsContext struct {
+	CurrentDate                string
+	NumberOfHoldings           int
+	AssetClassAllocationPct    map[string]float64
+	SectorAllocationPct        map[string]float64
+	CountryAllocationPct       map[string]float64
+	LargestHoldingPct          float64
+	WeightedPeRatio            float64
+	PeRatioCoveragePct         float64
+	WeightedDividendYieldPct   float64
+	DividendYieldCoveragePct   float64
+	OneMonthReturnPct          float64
+	SixMonthReturnPct          float64
+	OneYearReturnPct           float64
+	OneYearVolatilityPct       float64
+	Holdings                   []holdingAnalytics
+	Correlations               []holdingsCorrelation
+	HoldingsWithoutMarketData  []string
+	FocusSymbols               []string
+	AveragePairwiseCorrelation float64
+	HighlyCorrelatedPairs      int // pairs of holdings with correlation above 0.7
+}
+
+type PortfolioRag struct {
+	BaseRag
+	dataService        PortfolioDataService
+	userContextService UserContextDataService
+}
+
+func NewPortfolioRag(
+	llm Llm,
+	portfolioDataService PortfolioDataService,
+	userContextService UserContextDataService,
+	responsesStore RagResponsesRepository,
+) (*PortfolioRag, error) {
+	rag := PortfolioRag{
+		dataService:        portfolioDataService,
+		userContextService: userContextService,
+	}
+	rag.llm = llm
+	rag.topic = PORTFOLIO
+	rag.responseStore = responsesStore
+
+	return &rag, nil
+}
+
+// fetchHoldingMarketData fetches the data needed for the analytics of a single holding.
+// Failures are not fatal, we keep track of the data that is missing so that the llm knows
+// which parts of the analytics don't cover the whole portfolio.
+func (rag PortfolioRag) fetchHoldingMarketData(holding domain.UserPortfolioHolding) holdingMarketData {
+	data := holdingMarketData{sector: "Unknown", country: "Unknown"}
+	symbol := strings.ToLower(holding.Symbol)
+
+	switch holding.AssetClass {
+	case domain.Crypto:
+		data.sector = "Crypto"
+		data.missingData = append(data.missingData, "fundamentals", "historical prices")
+		return data
+	case domain.Stock, domain.ETF:
+	default:
+		data.missingData = append(data.missingData, "fundamentals", "historical prices")
+		return data
+	}
+
+	if symbol == "" {
+		data.missingData = append(data.missingData, "symbol")
+		return data
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(2)
+
+	// Fetch fundamentals
+	go func() {
+		defer wg.Done()
+		if holding.AssetClass == domain.ETF {
+			etfOverview, err := rag.dataService.GetEtfOverview(symbol)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				data.missingData = append(data.missingData, "etf overview")
+				return
+			}
+			data.dataKeys = append(data.dataKeys, EtfOverviewDataKey(symbol))
+			data.sector = fmt.Sprintf("ETF - %s", etfOverview.Category)
+			data.country = "Diversified (ETF)"
+			data.peRatio = parseNumber(etfOverview.PeRatio)
+			data.dividendYield = parseNumber(etfOverview.DividendYield)
+			data.hasFundamentals = true
+			return
+		}
+
+		stockProfile, profileErr := rag.dataService.GetStockProfile(symbol)
+		financialRatios, ratiosErr := rag.dataService.GetFinancialRatios(symbol)
+		mu.Lock()
+		defer mu.Unlock()
+		if profileErr != nil {
+			data.missingData = append(data.missingData, "stock profile")
+		} else {
+			data.dataKeys = append(data.dataKeys, StockProfileDataKey(symbol))
+			data.sector = stockProfile.Sector
+			data.country = stockProfile.Country
+		}
+		if ratiosErr != nil || len(financialRatios) == 0 {
+			data.missingData = append(data.missingData, "financial ratios")
+		} else {
+			data.dataKeys = append(data.dataKeys, FinancialRatiosDataKey(symbol))
+			// The first record contains the most recent ratios
+			data.peRatio = financialRatios[0].Pe
+			data.dividendYield = financialRatios[0].DividendYield * 100
+			data.hasFundamentals = true
+		}
+	}()
+
+	// Fetch one year of prices, shorter periods are derived from it
+	go func() {
+		defer wg.Done()
+		historicalPrices, err := rag.dataService.GetHistoricalPrices(symbol, holding.AssetClass, domain.Period1Y)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			data.missingData = append(data.missingData, "historical prices")
+			return
+		}
+		data.dataKeys = append(data.dataKeys, HistoricalPricesDataKey(symbol, holding.AssetClass, domain.Period1Y))
+		data.prices = historicalPrices.Prices
+	}()
+
+	wg.Wait()
+
+	return data
+}
+
+// percentageChangeSince returns the percentage change from the last price on or before
+// the given date until the most recent price
+func percentageChangeSince(prices []domain.Price, since time.Time) float64 {
+	if len(prices) < 2 {
+		return 0
+	}
+
+	startPrice := prices[0].ClosePrice
+	for _, p := range prices {
+		if p.Date.After(since) {
+			break
+		}
+		startPrice = p.ClosePrice
+	}
+
+	if startPrice == 0 {
+		return 0
+	}
+
+	lastPrice := prices[len(prices)-1].ClosePrice
+	return (lastPrice - startPrice) / startPrice * 100
+}
+
+// parseNumber parses numbers like "6.92" or "3.85%" that are returned as strings from the data service
+func parseNumber(value string) float64 {
+	cleaned := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	cleaned = strings.ReplaceAll(cleaned, ",", "")
+	number, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+func (rag PortfolioRag) createRagContext(portfolio []domain.UserPortfolioHolding, focusSymbols []string, sources *ragSources) (string, error) {
+	now := time.Now()
+	ragContext := portfolioAnalyticsContext{
+		CurrentDate:             now.Format("2006-01-02"),
+		NumberOfHoldings:        len(portfolio),
+		AssetClassAllocationPct: make(map[string]float64),
+		SectorAllocationPct:     make(map[string]float64),
+		CountryAllocationPct:    make(map[string]float64),
+		FocusSymbols:            focusSymbols,
+	}
+
+	marketData := make([]holdingMarketData, len(portfolio))
+	var wg sync.WaitGroup
+	for i, holding := range portfolio {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			marketData[i] = rag.fetchHoldingMarketData(holding)
+		}()
+	}
+	wg.Wait()
+
+	// The holdings traded in the ledger have quantities but no percentages, they are weighted by the
+	// market value of the quantity at the last price
+	marketValues := make([]float64, len(portfolio))
+	for i, h := range portfolio {
+		if prices := marketData[i].prices; len(prices) > 0 {
+			marketValues[i] = h.Quantity * prices[len(prices)-1].ClosePrice
+		}
+	}
+	weights := holdingWeights(portfolio, marketValues)
+
+	peRatios := make([]float64, len(portfolio))
+	hasPe := make([]bool, len(portfolio))
+	dividendYields := make([]float64, len(portfolio))
+	hasDividendYield := make([]bool, len(portfolio))
+
+	// Holdings that have price history are used for the performance, volatility and correlation analytics
+	var pricedSymbols []string
+	var pricedReturns []map[string]float64
+	var pricedWeights []float64
+	var oneMonthReturns, sixMonthReturns, oneYearReturns []float64
+
+	for i, holding := range portfolio {
+		data := marketData[i]
+		weightPct := weights[i] * 100
+		label := holding.Symbol
+		if label == "" {
+			label = holding.Name
+		}
+
+		ragContext.AssetClassAllocationPct[string(holding.AssetClass)] += weightPct
+		ragContext.SectorAllocationPct[data.sector] += weightPct
+		ragContext.CountryAllocationPct[data.country] += weightPct
+		if weightPct > ragContext.LargestHoldingPct {
+			ragContext.LargestHoldingPct = weightPct
+		}
+
+		peRatios[i], hasPe[i] = data.peRatio, data.peRatio > 0
+		dividendYields[i], hasDividendYield[i] = data.dividendYield, data.hasFundamentals
+
+		analytics := holdingAnalytics{
+			Symbol:              holding.Symbol,
+			Name:                holding.Name,
+			AssetClass:          holding.AssetClass,
+			PortfolioPercentage: roundTo(weightPct, 2),
+			Sector:              data.sector,
+			Country:             data.country,
+			PeRatio:             roundTo(data.peRatio, 2),
+			DividendYieldPct:    roundTo(data.dividendYield, 2),
+		}
+
+		if len(data.prices) > 1 {
+			dates := make([]time.Time, len(data.prices))
+			closePrices := make([]float64, len(data.prices))
+			for j, p := range data.prices {
+				dates[j] = p.Date
+				closePrices[j] = p.ClosePrice
+			}
+			returns := dailyReturns(dates, closePrices)
+			returnValues := make([]float64, 0, len(returns))
+			for _, r := range returns {
+				returnValues = append(returnValues, r)
+			}
+
+			analytics.OneMonthReturnPct = roundTo(percentageChangeSince(data.prices, now.AddDate(0, -1, 0)), 2)
+			analytics.SixMonthReturnPct = roundTo(percentageChangeSince(data.prices, now.AddDate(0, -6, 0)), 2)
+			analytics.OneYearReturnPct = roundTo(percentageChangeSince(data.prices, now.AddDate(-1, 0, 0)), 2)
+			analytics.VolatilityPct = roundTo(annualizedVolatility(returnValues), 2)
+
+			pricedSymbols = append(pricedSymbols, label)
+			pricedReturns = append(pricedReturns, returns)
+			pricedWeights = append(pricedWeights, weights[i])
+			oneMonthReturns = append(oneMonthReturns, analytics.OneMonthReturnPct)
+			sixMonthReturns = append(sixMonthReturns, analytics.SixMonthReturnPct)
+			oneYearReturns = append(oneYearReturns, analytics.OneYearReturnPct)
+		}
+
+		if weights[i] == 0 {
+			data.missingData = append(data.missingData, "portfolio weight")
+		}
+		if len(data.missingData) > 0 {
+			ragContext.HoldingsWithoutMarketData = append(
+				ragContext.HoldingsWithoutMarketData,
+				fmt.Sprintf("%s (missing: %s)", label, strings.Join(data.missingData, ", ")),
+			)
+		}
+
+		ragContext.Holdings = append(ragContext.Holdings, analytics)
+	}
+
+	for k, v := range ragContext.AssetClassAllocationPct {
+		ragContext.AssetClassAllocationPct[k] = roundTo(v, 2)
+	}
+	for k, v := range ragContext.SectorAllocationPct {
+		ragContext.SectorAllocationPct[k] = roundTo(v, 2)
+	}
+	for k, v := range ragContext.CountryAllocationPct {
+		ragContext.CountryAllocationPct[k] = roundTo(v, 2)
+	}
+	ragContext.LargestHoldingPct = roundTo(ragContext.LargestHoldingPct, 2)
+
+	weightedPe, peCoverage := weightedAverage(peRatios, weights, hasPe)
+	ragContext.WeightedPeRatio = roundTo(weightedPe, 2)
+	ragContext.PeRatioCoveragePct = roundTo(peCoverage*100, 2)
+
+	weightedDividendYield, dividendYieldCoverage := weightedAverage(dividendYields, weights, hasDividendYield)
+	ragContext.WeightedDividendYieldPct = roundTo(weightedDividendYield, 2)
+	ragContext.DividendYieldCoveragePct = roundTo(dividendYieldCoverage*100, 2)
+
+	if len(pricedReturns) > 0 {
+		allPriced := make([]bool, len(pricedWeights))
+		for i := range allPriced {
+			allPriced[i] = true
+		}
+		oneMonth, _ := weightedAverage(oneMonthReturns, pricedWeights, allPriced)
+		sixMonth, _ := weightedAverage(sixMonthReturns, pricedWeights, allPriced)
+		oneYear, _ := weightedAverage(oneYearReturns, pricedWeights, allPriced)
+		ragContext.OneMonthReturnPct = roundTo(oneMonth, 2)
+		ragContext.SixMonthReturnPct = roundTo(sixMonth, 2)
+		ragContext.OneYearReturnPct = roundTo(oneYear, 2)
+		ragContext.OneYearVolatilityPct = roundTo(
+			annualizedVolatility(portfolioDailyReturns(pricedReturns, pricedWeights)),
+			2,
+		)
+	}
+
+	var correlationSum float64
+	for i := 0; i < len(pricedReturns); i++ {
+		for j := i + 1; j < len(pricedReturns); j++ {
+			days := alignReturns(pricedReturns[i], pricedReturns[j])
+			a := make([]float64, 0, len(days))
+			b := make([]float64, 0, len(days))
+			for _, day := range days {
+				a = append(a, pricedReturns[i][day])
+				b = append(b, pricedReturns[j][day])
+			}
+			correlation := roundTo(pearsonCorrelation(a, b), 2)
+			ragContext.Correlations = append(ragContext.Correlations, holdingsCorrelation{
+				SymbolA:     pricedSymbols[i],
+				SymbolB:     pricedSymbols[j],
+				Correlation: correlation,
+			})
+			correlationSum += correlation
+			if correlation > 0.7 {
+				ragContext.HighlyCorrelatedPairs++
+			}
+		}
+	}
+	if len(ragContext.Correlations) > 0 {
+		ragContext.AveragePairwiseCorrelation = roundTo(correlationSum/float64(len(ragContext.Correlations)), 2)
+	}
+
+	var dataKeys []string
+	for _, data := range marketData {
+		dataKeys = append(dataKeys, data.dataKeys...)
+	}
+
+	// The analytics are computed from the market data of all the holdings, so they are a single source
+	return sources.block("Portfolio analytics computed from the market data of the holdings", stockAnalysisUrl, prompts.PortfolioAnalyticsData, ragContext, dataKeys...), nil
+}
+
+func (rag PortfolioRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
+	if tags.UserID == "" {
+		return MessageMetadata{}, errors.UserIDRequiredError{Topic: string(PORTFOLIO)}
+	}
+
+	userContext, err := rag.userContextService.GetUserContext(tags.UserID)
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+
+	if len(userContext.UserPortfolio) == 0 {
+		return MessageMetadata{}, &errors.PortfolioNotFoundError{PortfolioID: tags.UserID}
+	}
+
+	focusSymbols := append(append([]string{}, tags.StockSymbols...), tags.EtfSymbols...)
+
+	// Format the prompt to contain the neccessary context
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(userContext.UserPortfolio, focusSymbols, sources)
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+
+	prompt, err := rag.renderPrompt(prompts.Portfolio, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
+}
@@ -0,0 +1,182 @@
+package prompts
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// funcs are the helper functions of the templates
+var funcs = template.FuncMap{
+	"money":   money,
+	"percent": percent,
+	"number":  number,
+	"table":   table,
+	"join":    strings.Join,
+}
+
+// money formats an amount in dollars with a T, B or M suffix for large amounts, like $1.23B
+func money(value any) string {
+	amount, ok := toFloat(value)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	for _, unit := range []struct {
+		suffix string
+		size   float64
+	}{{"T", 1e12}, {"B", 1e9}, {"M", 1e6}} {
+		if amount >= unit.size {
+			return fmt.Sprintf("%s$%.2f%s", sign, amount/unit.size, unit.suffix)
+		}
+	}
+	return sign + "$" + number(amount)
+}
+
+// percent formats a percentage value, like 12.3%
+func percent(value any) string {
+	amount, ok := toFloat(value)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	return strconv.FormatFloat(roundTo(amount, 2), 'f', -1, 64) + "%"
+}
+
+// number formats a number with thousands separators and at most two decimals, like 1,234.5
+func number(value any) string {
+	amount, ok := toFloat(value)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	text := strconv.FormatFloat(roundTo(math.Abs(amount), 2), 'f', -1, 64)
+	integer, decimals, hasDecimals := strings.Cut(text, ".")
+
+	var b strings.Builder
+	if amount < 0 && text != "0" {
+		b.WriteString("-")
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(",")
+		}
+		b.WriteRune(digit)
+	}
+	if hasDecimals {
+		b.WriteString("." + decimals)
+	}
+	return b.String()
+}
+
+// table renders a slice of structs or maps as a markdown table with the given columns, which are the
+// names of the struct fields or the map keys. The numbers of a column are formatted with number unless
+// the column names another helper after a colon, like "MarketCap:money" or "ChangePct:percent".
+// Without columns the exported fields of the structs are used.
+func table(rows any, columns ...string) (string, error) {
+	values := reflect.ValueOf(rows)
+	if values.Kind() != reflect.Slice && values.Kind() != reflect.Array {
+		return "", fmt.Errorf("table expects a slice, got %T", rows)
+	}
+
+	if len(columns) == 0 && values.Len() > 0 {
+		row := reflect.Indirect(values.Index(0))
+		if row.Kind() != reflect.Struct {
+			return "", fmt.Errorf("table needs the columns of %T", rows)
+		}
+		for _, field := range reflect.VisibleFields(row.Type()) {
+			if field.IsExported() && !field.Anonymous {
+				columns = append(columns, field.Name)
+			}
+		}
+	}
+
+	names := make([]string, len(columns))
+	formats := make([]func(any) string, len(columns))
+	for i, column := range columns {
+		name, format, _ := strings.Cut(column, ":")
+		names[i], formats[i] = name, number
+		if format != "" {
+			var found bool
+			if formats[i], found = numberFormats[format]; !found {
+				return "", fmt.Errorf("unknown format %s of the table column %s", format, name)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("| " + strings.Join(names, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(names)) + "\n")
+	for i := 0; i < values.Len(); i++ {
+		row := reflect.Indirect(values.Index(i))
+		cells := make([]string, len(names))
+		for j, name := range names {
+			cell, err := tableCell(row, name, formats[j])
+			if err != nil {
+				return "", err
+			}
+			cells[j] = strings.ReplaceAll(cell, "|", "\\|")
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	return b.String(), nil
+}
+
+// numberFormats are the helpers that can format the numbers of a table column
+var numberFormats = map[string]func(any) string{
+	"money":   money,
+	"percent": percent,
+	"number":  number,
+}
+
+func tableCell(row reflect.Value, column string, format func(any) string) (string, error) {
+	var cell reflect.Value
+	switch row.Kind() {
+	case reflect.Struct:
+		cell = row.FieldByName(column)
+	case reflect.Map:
+		cell = row.MapIndex(reflect.ValueOf(column))
+		if !cell.IsValid() {
+			return "", nil
+		}
+	default:
+		return "", fmt.Errorf("table rows must be structs or maps, got %s", row.Kind())
+	}
+	if !cell.IsValid() {
+		return "", fmt.Errorf("table rows of %s have no field %s", row.Type(), column)
+	}
+
+	cell = reflect.Indirect(cell)
+	if !cell.IsValid() {
+		return "", nil
+	}
+	if amount, ok := toFloat(cell.Interface()); ok && cell.Kind() != reflect.String {
+		return format(amount), nil
+	}
+	return fmt.Sprint(cell.Interface()), nil
+}
+
+func toFloat(value any) (float64, bool) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
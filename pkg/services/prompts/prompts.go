@@ -0,0 +1,287 @@
+// Package prompts renders the llm prompts from versioned text/template files. Every prompt has a
+// directory under templates with one file per version, like templates/news/v2.tmpl, and the shared
+// partials are defined in templates/partials. A deployment can override or add versions with a
+// directory of the same layout and pin the version of each prompt.
+package prompts
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+const (
+	AnswerJudge                 = "answer_judge"
+	ConversationSummary         = "conversation_summary"
+	Education                   = "education"
+	EtfTagExtractor             = "etf_tag_extractor"
+	Etfs                        = "etfs"
+	FactCheckCorrection         = "fact_check_correction"
+	FollowUpQuestions           = "follow_up_questions"
+	Industries                  = "industries"
+	IndustryTagExtractor        = "industry_tag_extractor"
+	News                        = "news"
+	NewsTagExtractor            = "news_tag_extractor"
+	Portfolio                   = "portfolio"
+	PortfolioTagExtractor       = "portfolio_tag_extractor"
+	SectorTagExtractor          = "sector_tag_extractor"
+	Sectors                     = "sectors"
+	SessionTitle                = "session_title"
+	StockFinancials             = "stock_financials"
+	StockFinancialsTagExtractor = "stock_financials_tag_extractor"
+	StockOverview               = "stock_overview"
+	StockOverviewTagExtractor   = "stock_overview_tag_extractor"
+	SuperInvestorTagExtractor   = "super_investor_tag_extractor"
+	SuperInvestors              = "super_investors"
+	TopicExtractor              = "topic_extractor"
+)
+
+// The partials that render the market data of the numbered blocks of the rag contexts
+const (
+	EtfOverviewData            = "etf_overview_data"
+	EtfsData                   = "etfs_data"
+	FinancialRatiosData        = "financial_ratios_data"
+	FinancialStatementsData    = "financial_statements_data"
+	IndustriesData             = "industries_data"
+	IndustryData               = "industry_data"
+	NewsArticleData            = "news_article_data"
+	PortfolioAnalyticsData     = "portfolio_analytics_data"
+	PortfolioOverlapData       = "portfolio_overlap_data"
+	PricePerformanceData       = "price_performance_data"
+	SectorData                 = "sector_data"
+	StockForecastData          = "stock_forecast_data"
+	StockProfileData           = "stock_profile_data"
+	SuperInvestorActivityData  = "super_investor_activity_data"
+	SuperInvestorPortfolioData = "super_investor_portfolio_data"
+	SuperInvestorsData         = "super_investors_data"
+)
+
+const (
+	partialsDir       = "partials"
+	templateExtension = ".tmpl"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Vars are the named variables of a prompt template
+type Vars map[string]any
+
+// Prompt is a rendered prompt with the name and version of its template
+type Prompt struct {
+	Name    string
+	Version string
+	Text    string
+}
+
+// Registry holds the parsed versions of every prompt
+type Registry struct {
+	templates map[string]map[string]*template.Template // name -> version -> template
+	versions  map[string]string                        // name -> version rendered by Render
+	partials  *template.Template
+}
+
+// NewRegistry parses the embedded templates and the templates of overrides, which replace the embedded
+// versions with the same name and can add new ones. versions pins the version of a prompt, the others
+// use their latest version.
+func NewRegistry(overrides fs.FS, versions map[string]string) (*Registry, error) {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := templateSources(embedded)
+	if err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		overrideSources, err := templateSources(overrides)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt overrides: %w", err)
+		}
+		for file, text := range overrideSources {
+			sources[file] = text
+		}
+	}
+
+	partials := template.New("partials").Funcs(funcs).Option("missingkey=error")
+	for file, text := range sources {
+		if path.Dir(file) != partialsDir {
+			continue
+		}
+		if _, err := partials.New(file).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid prompt partial %s: %w", file, err)
+		}
+	}
+
+	registry := &Registry{templates: map[string]map[string]*template.Template{}, versions: map[string]string{}, partials: partials}
+	for file, text := range sources {
+		name, version := path.Dir(file), strings.TrimSuffix(path.Base(file), templateExtension)
+		if name == partialsDir {
+			continue
+		}
+
+		tmpl, err := partials.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if tmpl, err = tmpl.New(file).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid prompt %s %s: %w", name, version, err)
+		}
+		if registry.templates[name] == nil {
+			registry.templates[name] = map[string]*template.Template{}
+		}
+		registry.templates[name][version] = tmpl
+	}
+
+	for name, templates := range registry.templates {
+		registry.versions[name] = latestVersion(templates)
+	}
+	for name, version := range versions {
+		if _, found := registry.templates[name][version]; !found {
+			return nil, fmt.Errorf("prompt %s has no version %s", name, version)
+		}
+		registry.versions[name] = version
+	}
+
+	return registry, nil
+}
+
+// Render renders the selected version of the prompt
+func (r *Registry) Render(name string, vars Vars) (Prompt, error) {
+	version, found := r.versions[name]
+	if !found {
+		return Prompt{}, fmt.Errorf("unknown prompt %s", name)
+	}
+	return r.RenderVersion(name, version, vars)
+}
+
+// RenderVersion renders a specific version of the prompt
+func (r *Registry) RenderVersion(name string, version string, vars Vars) (Prompt, error) {
+	tmpl, found := r.templates[name][version]
+	if !found {
+		return Prompt{}, fmt.Errorf("prompt %s has no version %s", name, version)
+	}
+
+	var text strings.Builder
+	if err := tmpl.Execute(&text, vars); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render prompt %s %s: %w", name, version, err)
+	}
+	return Prompt{Name: name, Version: version, Text: text.String()}, nil
+}
+
+// RenderPartial renders a partial with data as its dot, like the market data of a block of a rag context
+func (r *Registry) RenderPartial(name string, data any) (string, error) {
+	var text strings.Builder
+	if err := r.partials.ExecuteTemplate(&text, name, data); err != nil {
+		return "", fmt.Errorf("failed to render partial %s: %w", name, err)
+	}
+	return text.String(), nil
+}
+
+// Version returns the version of the prompt rendered by Render
+func (r *Registry) Version(name string) string {
+	return r.versions[name]
+}
+
+// templateSources returns the text of the template files of fsys by their name/version.tmpl path
+func templateSources(fsys fs.FS) (map[string]string, error) {
+	sources := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(file) != templateExtension {
+			return err
+		}
+		if strings.Count(file, "/") != 1 {
+			return fmt.Errorf("template %s is not in a prompt directory", file)
+		}
+
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		sources[file] = string(text)
+		return nil
+	})
+	return sources, err
+}
+
+// latestVersion returns the highest vN version, versions that don't follow the vN format are only
+// rendered when pinned
+func latestVersion(templates map[string]*template.Template) string {
+	var versions []string
+	for version := range templates {
+		if versionNumber(version) > 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versionNumber(versions[i]) > versionNumber(versions[j])
+	})
+	return versions[0]
+}
+
+func versionNumber(version string) int {
+	number, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil || !strings.HasPrefix(version, "v") {
+		return 0
+	}
+	return number
+}
+
+var defaultRegistry atomic.Pointer[Registry]
+
+func init() {
+	registry, err := NewRegistry(nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	defaultRegistry.Store(registry)
+}
+
+// Configure replaces the default registry with one that uses the templates of dir, if it's not empty,
+// and the pinned versions
+func Configure(dir string, versions map[string]string) error {
+	var overrides fs.FS
+	if dir != "" {
+		overrides = os.DirFS(dir)
+	}
+
+	registry, err := NewRegistry(overrides, versions)
+	if err != nil {
+		return err
+	}
+	defaultRegistry.Store(registry)
+	return nil
+}
+
+// Render renders the prompt with the default registry
+func Render(name string, vars Vars) (Prompt, error) {
+	return defaultRegistry.Load().Render(name, vars)
+}
+
+// RenderVersion renders a specific version of the prompt with the default registry
+func RenderVersion(name string, version string, vars Vars) (Prompt, error) {
+	return defaultRegistry.Load().RenderVersion(name, version, vars)
+}
+
+// RenderPartial renders a partial with the default registry
+func RenderPartial(name string, data any) (string, error) {
+	return defaultRegistry.Load().RenderPartial(name, data)
+}
+
+// HasVersion returns whether the default registry has the version of the prompt
+func HasVersion(name string, version string) bool {
+	_, found := defaultRegistry.Load().templates[name][version]
+	return found
+}
@@ -0,0 +1,113 @@
+package prompts
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Overrides(t *testing.T) {
+	overrides := fstest.MapFS{
+		"news/v2.tmpl":  {Data: []byte("news v2 {{.Context}}")},
+		"news/v10.tmpl": {Data: []byte("news v10 {{.Context}} {{template \"greeting\"}}")},
+		"partials/greeting.tmpl": {
+			Data: []byte(`{{define "greeting"}}hello{{end}}`),
+		},
+		"education/v1.tmpl": {Data: []byte("education override")},
+	}
+
+	registry, err := NewRegistry(overrides, nil)
+	assert.NoError(t, err)
+
+	// The highest version is the default, v10 is higher than v2
+	prompt, err := registry.Render(News, Vars{"Context": "ctx"})
+	assert.NoError(t, err)
+	assert.Equal(t, Prompt{Name: News, Version: "v10", Text: "news v10 ctx hello"}, prompt)
+
+	// The override replaces the embedded version with the same name
+	prompt, err = registry.Render(Education, Vars{})
+	assert.NoError(t, err)
+	assert.Equal(t, "education override", prompt.Text)
+
+	// The embedded versions that are not overridden are still available
+	prompt, err = registry.RenderVersion(News, "v1", Vars{"Context": "ctx", "UserContext": ""})
+	assert.NoError(t, err)
+	assert.Contains(t, prompt.Text, "## CONTEXT:\nctx")
+}
+
+func TestRegistry_PinnedVersions(t *testing.T) {
+	overrides := fstest.MapFS{"news/v2.tmpl": {Data: []byte("news v2")}}
+
+	registry, err := NewRegistry(overrides, map[string]string{News: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", registry.Version(News))
+	assert.Equal(t, "v3", registry.Version(TopicExtractor))
+
+	_, err = NewRegistry(overrides, map[string]string{News: "v3"})
+	assert.Error(t, err)
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	_, err := NewRegistry(fstest.MapFS{"news/v2.tmpl": {Data: []byte("{{.Context")}}, nil)
+	assert.Error(t, err)
+
+	registry, err := NewRegistry(nil, nil)
+	assert.NoError(t, err)
+
+	_, err = registry.Render("unknown", Vars{})
+	assert.Error(t, err)
+
+	// Missing variables fail instead of rendering <no value>
+	_, err = registry.Render(News, Vars{"Context": "ctx"})
+	assert.Error(t, err)
+}
+
+func TestFuncs(t *testing.T) {
+	assert.Equal(t, "$1.23B", money(1_234_000_000))
+	assert.Equal(t, "-$2.50M", money(-2_500_000.0))
+	assert.Equal(t, "$3.10T", money(float32(3.1e12)))
+	assert.Equal(t, "$999.5", money(999.5))
+	assert.Equal(t, "12.35%", percent(12.345))
+	assert.Equal(t, "1,234,567.89", number(1234567.891))
+	assert.Equal(t, "-1,000", number(-1000))
+	assert.Equal(t, "n/a", number("n/a"))
+
+	type holding struct {
+		Symbol string
+		Weight float64
+	}
+	rendered, err := table([]holding{{"AAPL", 1234.5}, {"A|B", 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, "| Symbol | Weight |\n| --- | --- |\n| AAPL | 1,234.5 |\n| A\\|B | 2 |\n", rendered)
+
+	rendered, err = table([]map[string]any{{"Symbol": "VOO"}}, "Symbol", "Name")
+	assert.NoError(t, err)
+	assert.Equal(t, "| Symbol | Name |\n| --- | --- |\n| VOO |  |\n", rendered)
+
+	rendered, err = table([]holding{{"AAPL", 2.5e9}}, "Symbol", "Weight:money")
+	assert.NoError(t, err)
+	assert.Equal(t, "| Symbol | Weight |\n| --- | --- |\n| AAPL | $2.50B |\n", rendered)
+
+	_, err = table([]holding{{"AAPL", 1}}, "Missing")
+	assert.Error(t, err)
+
+	_, err = table([]holding{{"AAPL", 1}}, "Weight:unknown")
+	assert.Error(t, err)
+}
+
+func TestRegistry_RenderPartial(t *testing.T) {
+	registry, err := NewRegistry(nil, nil)
+	assert.NoError(t, err)
+
+	type performance struct {
+		Period           string
+		PercentageChange float64
+	}
+	rendered, err := registry.RenderPartial(PricePerformanceData, []performance{{"1y", 12.345}})
+	assert.NoError(t, err)
+	assert.Equal(t, "| Period | PercentageChange |\n| --- | --- |\n| 1y | 12.35% |\n", rendered)
+
+	_, err = registry.RenderPartial("unknown", nil)
+	assert.Error(t, err)
+}
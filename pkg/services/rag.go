@@ -1,37 +1,103 @@
 package services
 
+import (
+	"investbot/pkg/services/prompts"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RagResponse is an llm response with the prompt that generated it
+type RagResponse struct {
+	ID            string // Generated by the repositories when it's empty
+	ModelName     string
+	Topic         Topic
+	Conversation  []Message
+	Response      string
+	PromptName    string
+	PromptVersion string
+	FactCheck     *FactCheck // Only set for the responses that were fact checked
+	// Experiment and Variant are only set for the responses of experiment variants
+	Experiment string
+	Variant    string
+	CreatedAt  time.Time // Set by the repositories when the response is read
+}
+
 type RagResponsesRepository interface {
-	StoreRagResponse(
-		modelName string,
-		ragTopic Topic,
-		conversation []Message,
-		response string,
-	) error
+	StoreRagResponse(response RagResponse) error
+}
+
+// RagResponseQuery filters the stored responses, the zero value of a field doesn't filter
+type RagResponseQuery struct {
+	Topics        []Topic
+	ModelName     string
+	PromptVersion string
+	From          time.Time // Included
+	To            time.Time // Excluded
+	Limit         int
+}
+
+// RagResponsesReader reads back the stored responses, sorted from the oldest
+type RagResponsesReader interface {
+	GetRagResponse(id string) (RagResponse, error)
+	QueryRagResponses(query RagResponseQuery) ([]RagResponse, error)
 }
 
 type BaseRag struct {
 	topic         Topic
 	llm           Llm
 	responseStore RagResponsesRepository
+	budget        ContextBudget
+	factChecker   *FactChecker
+	// Scraped text is checked for prompt injections before it's pasted in the prompt
+	injectionClassifier *InjectionClassifier
+	experimentVariant
+}
+
+// ContextBudgetSetter is implemented by the rags that fit their prompt in the context window of the model
+type ContextBudgetSetter interface {
+	SetContextBudget(budget ContextBudget)
+}
+
+// FactCheckerSetter is implemented by the rags that check the figures of their responses against their market data
+type FactCheckerSetter interface {
+	SetFactChecker(factChecker *FactChecker)
+}
+
+// SetFactChecker sets the checker of the figures of the responses. Without a checker the responses are not checked.
+func (r *BaseRag) SetFactChecker(factChecker *FactChecker) {
+	r.factChecker = factChecker
+}
+
+// SetContextBudget sets the budget the prompt and the conversation are fitted in. Without a budget
+// they are sent as they are.
+func (r *BaseRag) SetContextBudget(budget ContextBudget) {
+	r.budget = budget
 }
 
 // GenerateLllmResponse streams an LLM-generated response for the given prompt and conversation.
 //
 // This method performs the following steps:
-//  1. Prepends the user prompt to the provided conversation history.
-//  2. Asynchronously calls the underlying LLM to generate a response in chunks.
-//  3. Sends each response chunk to the provided responseChannel as it becomes available.
-//  4. Accumulates all chunks into a complete response message.
-//  5. Stores the full conversation and response in the configured RagResponsesStore.
+//  1. Fits the prompt and the conversation in the context budget, truncating the rag context
+//     and dropping the oldest history messages if needed.
+//  2. Prepends the user prompt to the provided conversation history.
+//  3. Asynchronously calls the underlying LLM to generate a response in chunks.
+//  4. Sends each response chunk to the provided responseChannel as it becomes available.
+//  5. Accumulates all chunks into a complete response message.
+//  6. Checks the figures of the response against the market data of the sources, if a fact checker is set.
+//  7. Stores the full conversation and response, with the fact check, in the configured RagResponsesStore.
 //
 // Parameters:
 //
 //	prompt           - The user prompt to send to the LLM.
+//	ragContext       - The rag context that is part of the prompt, it's truncated first when the prompt doesn't fit.
+//	sources          - The data sources of the rag context, nil if the prompt has no market data.
 //	conversation     - The conversation history prior to this request.
 //	responseChannel  - A channel to stream partial LLM response chunks back to the caller.
 //
 // Returns:
 //
+//	MessageMetadata - The model, the estimated token usage, the rag context, its data sources and the fact check.
 //	error - Any error encountered during response generation or storage.
 //
 // Notes:
@@ -39,28 +105,56 @@ type BaseRag struct {
 //   - If the LLM returns an error mid-stream, generation stops and the error is returned.
 //   - The complete response (not just streamed chunks) is persisted via the RagResponsesStore.
 func (r *BaseRag) GenerateLllmResponse(
-	prompt string,
+	prompt prompts.Prompt,
+	ragContext string,
+	sources *ragSources,
 	conversation []Message,
 	responseChannel chan<- string,
-) error {
-	conversation = append([]Message{{Content: prompt, Role: User}}, conversation...)
+) (MessageMetadata, error) {
+	promptText, conversation := r.budget.fit(prompt.Text, ragContext, conversation)
+	conversation = append([]Message{{Content: promptText, Role: User}}, conversation...)
 
+	start := time.Now()
 	responseMessage, err := streamChunks(
 		func(chunkChan chan<- string) error {
 			return r.llm.GenerateResponse(conversation, chunkChan)
 		},
 		responseChannel,
 	)
+	r.observe(RagTarget, r.topic, conversation, responseMessage, time.Since(start), err == nil)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
-	return r.responseStore.StoreRagResponse(
-		r.llm.GetLlmName(),
-		r.topic,
-		conversation,
-		responseMessage,
-	)
+	metadata := MessageMetadata{
+		Model:          r.llm.GetLlmName(),
+		PromptTokens:   estimateConversationTokens(conversation),
+		ResponseTokens: estimateTokens(responseMessage),
+		RagContext:     ragContext,
+		DataSources:    sources.dataSources(),
+		PromptName:     prompt.Name,
+		PromptVersion:  prompt.Version,
+	}
+
+	response := RagResponse{
+		ID:            uuid.NewString(),
+		ModelName:     r.llm.GetLlmName(),
+		Topic:         r.topic,
+		Conversation:  conversation,
+		Response:      responseMessage,
+		PromptName:    prompt.Name,
+		PromptVersion: prompt.Version,
+		Experiment:    r.assignment.Experiment,
+		Variant:       r.assignment.Variant,
+	}
+	metadata.RagResponseID = response.ID
+	if r.factChecker != nil && sources != nil {
+		factCheck := r.factChecker.Check(responseMessage, sources.facts())
+		metadata.FactCheck = &factCheck
+		response.FactCheck = &factCheck
+	}
+
+	return metadata, r.responseStore.StoreRagResponse(response)
 }
 
 // streamChunks handles the common logic for streaming text responses from a generator function
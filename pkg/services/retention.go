@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SessionRetention is implemented by the session services to enforce the retention policy
+type SessionRetention interface {
+	// DeleteIdleSessions deletes the sessions that were not updated since idleSince
+	DeleteIdleSessions(idleSince time.Time) (int, error)
+	// TrimUserSessions deletes the least recently updated sessions of every user that has more than maxSessions
+	TrimUserSessions(maxSessions int) (int, error)
+}
+
+type RagResponsesRetention interface {
+	DeleteRagResponsesBefore(before time.Time) (int, error)
+}
+
+type TopicAndTagsRetention interface {
+	DeleteTopicAndTagsBefore(before time.Time) (int, error)
+}
+
+// RetentionIndexer is implemented by the stores that can let the database expire the documents,
+// like the mongo stores with ttl indexes
+type RetentionIndexer interface {
+	// EnsureRetentionIndex expires the documents after the retention, a zero retention removes the index
+	// so that the documents are kept forever
+	EnsureRetentionIndex(retention time.Duration) error
+}
+
+// RetentionPolicy defines how long every type of data is kept. A zero value disables the rule.
+type RetentionPolicy struct {
+	SessionIdleTtl        time.Duration
+	MaxSessionsPerUser    int
+	RagResponsesRetention time.Duration
+	TopicAndTagsRetention time.Duration
+}
+
+// RetentionReport contains what a run of the janitor deleted
+type RetentionReport struct {
+	StartedAt      time.Time
+	Duration       time.Duration
+	IdleSessions   int
+	ExcessSessions int
+	RagResponses   int
+	TopicAndTags   int
+}
+
+// RetentionJanitor enforces the retention policy in the background
+type RetentionJanitor struct {
+	policy       RetentionPolicy
+	sessions     SessionRetention
+	ragResponses RagResponsesRetention
+	topicAndTags TopicAndTagsRetention
+}
+
+func NewRetentionJanitor(
+	policy RetentionPolicy,
+	sessions SessionRetention,
+	ragResponses RagResponsesRetention,
+	topicAndTags TopicAndTagsRetention,
+) (*RetentionJanitor, error) {
+	return &RetentionJanitor{
+		policy:       policy,
+		sessions:     sessions,
+		ragResponses: ragResponses,
+		topicAndTags: topicAndTags,
+	}, nil
+}
+
+// EnsureIndexes lets the stores that support it expire the documents themselves, the indexes of the
+// disabled rules are removed. The janitor still runs on them so that documents the database can't
+// expire are deleted too.
+func (j *RetentionJanitor) EnsureIndexes() error {
+	stores := []struct {
+		store     any
+		retention time.Duration
+	}{
+		{j.sessions, j.policy.SessionIdleTtl},
+		{j.ragResponses, j.policy.RagResponsesRetention},
+		{j.topicAndTags, j.policy.TopicAndTagsRetention},
+	}
+
+	var errs []error
+	for _, s := range stores {
+		indexer, ok := s.store.(RetentionIndexer)
+		if !ok {
+			continue
+		}
+		errs = append(errs, indexer.EnsureRetentionIndex(s.retention))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run enforces the retention policy once. Every rule runs even if a previous one failed.
+func (j *RetentionJanitor) Run() (RetentionReport, error) {
+	report := RetentionReport{StartedAt: time.Now()}
+	var errs []error
+
+	if j.sessions != nil && j.policy.SessionIdleTtl > 0 {
+		deleted, err := j.sessions.DeleteIdleSessions(report.StartedAt.Add(-j.policy.SessionIdleTtl))
+		report.IdleSessions = deleted
+		errs = append(errs, err)
+	}
+
+	if j.sessions != nil && j.policy.MaxSessionsPerUser > 0 {
+		deleted, err := j.sessions.TrimUserSessions(j.policy.MaxSessionsPerUser)
+		report.ExcessSessions = deleted
+		errs = append(errs, err)
+	}
+
+	if j.ragResponses != nil && j.policy.RagResponsesRetention > 0 {
+		deleted, err := j.ragResponses.DeleteRagResponsesBefore(report.StartedAt.Add(-j.policy.RagResponsesRetention))
+		report.RagResponses = deleted
+		errs = append(errs, err)
+	}
+
+	if j.topicAndTags != nil && j.policy.TopicAndTagsRetention > 0 {
+		deleted, err := j.topicAndTags.DeleteTopicAndTagsBefore(report.StartedAt.Add(-j.policy.TopicAndTagsRetention))
+		report.TopicAndTags = deleted
+		errs = append(errs, err)
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	return report, errors.Join(errs...)
+}
+
+// Start runs the janitor every interval until the context is cancelled and logs what every run deleted
+func (j *RetentionJanitor) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			report, err := j.Run()
+			if err != nil {
+				log.Printf("Retention janitor failed: %s", err.Error())
+			}
+			log.Printf(
+				"Retention janitor deleted %d idle sessions, %d sessions over the per user limit, %d rag responses and %d topic and tags in %s",
+				report.IdleSessions, report.ExcessSessions, report.RagResponses, report.TopicAndTags, report.Duration,
+			)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+const retentionIndexName = "retention_ttl"
+
+// EnsureMongoTtlIndex creates a ttl index on the date field. If the index already exists with another
+// ttl it's recreated, since mongo doesn't allow to create the same index with different options.
+// A zero ttl drops the index, so that mongo stops expiring the documents when the rule is disabled.
+func EnsureMongoTtlIndex(collection *mongo.Collection, field string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if ttl <= 0 {
+		err := collection.Indexes().DropOne(ctx, retentionIndexName)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.HasErrorCode(26) || cmdErr.HasErrorCode(27)) { // NamespaceNotFound, IndexNotFound
+			return nil
+		}
+		return err
+	}
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(retentionIndexName).SetExpireAfterSeconds(int32(ttl.Seconds())),
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, index)
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || !(cmdErr.HasErrorCode(85) || cmdErr.HasErrorCode(86)) { // IndexOptionsConflict, IndexKeySpecsConflict
+		return err
+	}
+
+	if err := collection.Indexes().DropOne(ctx, retentionIndexName); err != nil {
+		return err
+	}
+	_, err = collection.Indexes().CreateOne(ctx, index)
+	return err
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRagResponsesRetention struct {
+	before time.Time
+}
+
+func (f *fakeRagResponsesRetention) DeleteRagResponsesBefore(before time.Time) (int, error) {
+	f.before = before
+	return 4, nil
+}
+
+func TestRetentionJanitor_Run(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+
+	idle, _ := sessionService.CreateNewSession("")
+	sessionService.sessions[idle].session.UpdatedAt = time.Now().Add(-48 * time.Hour)
+
+	oldest, _ := sessionService.CreateNewSession("user")
+	sessionService.sessions[oldest].session.UpdatedAt = time.Now().Add(-time.Hour)
+	newest, _ := sessionService.CreateNewSession("user")
+	_, _ = sessionService.CreateNewSession("other_user")
+
+	ragResponses := &fakeRagResponsesRetention{}
+	janitor, _ := NewRetentionJanitor(
+		RetentionPolicy{
+			SessionIdleTtl:        24 * time.Hour,
+			MaxSessionsPerUser:    1,
+			RagResponsesRetention: 30 * 24 * time.Hour,
+		},
+		sessionService,
+		ragResponses,
+		nil,
+	)
+
+	report, err := janitor.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.IdleSessions)
+	assert.Equal(t, 1, report.ExcessSessions)
+	assert.Equal(t, 4, report.RagResponses)
+	assert.Equal(t, 0, report.TopicAndTags)
+	assert.WithinDuration(t, time.Now().Add(-30*24*time.Hour), ragResponses.before, time.Minute)
+
+	sessions, _, _ := sessionService.ListSessions("user", true, 0, 10)
+	assert.Equal(t, []string{newest}, sessionIDs(sessions))
+	_, err = sessionService.GetSession(idle)
+	assert.Error(t, err)
+}
+
+type fakeRetentionIndexer struct {
+	fakeRagResponsesRetention
+	retention *time.Duration
+}
+
+func (f *fakeRetentionIndexer) EnsureRetentionIndex(retention time.Duration) error {
+	f.retention = &retention
+	return nil
+}
+
+func TestRetentionJanitor_EnsureIndexes(t *testing.T) {
+	ragResponses := &fakeRetentionIndexer{}
+	janitor, _ := NewRetentionJanitor(RetentionPolicy{}, nil, ragResponses, nil)
+
+	// A disabled rule removes the index so that the database stops expiring the documents
+	assert.NoError(t, janitor.EnsureIndexes())
+	assert.Equal(t, time.Duration(0), *ragResponses.retention)
+
+	janitor, _ = NewRetentionJanitor(RetentionPolicy{RagResponsesRetention: 24 * time.Hour}, nil, ragResponses, nil)
+	assert.NoError(t, janitor.EnsureIndexes())
+	assert.Equal(t, 24*time.Hour, *ragResponses.retention)
+}
+
+func TestBadgerSession_Retention(t *testing.T) {
+	sessionService := newTestBadgerSession(t, BadgerSessionServiceConf{ConvMsgLimit: 10})
+
+	first, _ := sessionService.CreateNewSession("user")
+	second, _ := sessionService.CreateNewSession("user")
+	third, _ := sessionService.CreateNewSession("user")
+	assert.NoError(t, sessionService.AddMessage(first, Message{Role: User, Content: "hi"}))
+
+	deleted, err := sessionService.TrimUserSessions(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	sessions, _, _ := sessionService.ListSessions("user", true, 0, 10)
+	assert.Equal(t, []string{first, third}, sessionIDs(sessions))
+	_, err = sessionService.GetSession(second)
+	assert.Error(t, err)
+
+	deleted, err = sessionService.DeleteIdleSessions(time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+}
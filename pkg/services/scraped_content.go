@@ -0,0 +1,133 @@
+package services
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Scraped text of third party pages, like news articles and company descriptions, is untrusted: it's
+// sanitized, checked by the injection classifier and pasted in the prompts inside a data section that
+// the prompts tell the llm to never take instructions from.
+const (
+	scrapedTextOpenTag  = "<scraped_text>"
+	scrapedTextCloseTag = "</scraped_text>"
+	// withheldScrapedText replaces the scraped text that the injection classifier flags
+	withheldScrapedText = "[text withheld, it looks like it contains instructions for an AI assistant]"
+
+	maxScrapedTextLength  = 4000
+	maxScrapedLabelLength = 200
+)
+
+var (
+	// Characters that are not shown to a reader but are read by the llm, like zero width spaces and
+	// bidirectional overrides
+	invisibleCharacters = regexp.MustCompile("[\u200b-\u200f\u202a-\u202e\u2060-\u2064\u2066-\u2069\ufeff]")
+	// Markdown headers could imitate the sections of the prompts, like ## CONTEXT
+	markdownHeader = regexp.MustCompile(`(?m)^[ \t]*#+[ \t]*`)
+	// Numbers in square brackets could imitate the source blocks and the citations of the rag context
+	bracketedNumbers = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+	extraNewlines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// sanitizeScrapedText removes what could make the text look like part of the prompt and shortens it
+// to maxLength characters
+func sanitizeScrapedText(text string, maxLength int) string {
+	text = invisibleCharacters.ReplaceAllString(text, "")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+
+	// The tags of the data sections can't be closed from the inside
+	text = strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+	text = markdownHeader.ReplaceAllString(text, "")
+	text = bracketedNumbers.ReplaceAllString(text, "($1)")
+	text = extraNewlines.ReplaceAllString(strings.ReplaceAll(text, "\r", ""), "\n\n")
+	text = strings.TrimSpace(text)
+
+	if runes := []rune(text); len(runes) > maxLength {
+		text = strings.TrimSpace(string(runes[:maxLength])) + "…"
+	}
+	return text
+}
+
+// sanitizeScrapedLabel sanitizes scraped text that goes in the header of a block, like the title of an article
+func sanitizeScrapedLabel(label string) string {
+	return strings.Join(strings.Fields(sanitizeScrapedText(label, maxScrapedLabelLength)), " ")
+}
+
+// untrustedText returns the scraped text sanitized and wrapped in a data section. Text flagged by the
+// injection classifier of the rag is withheld.
+func (r *BaseRag) untrustedText(text string, source string) string {
+	text = sanitizeScrapedText(text, maxScrapedTextLength)
+	if r.injectionClassifier != nil {
+		if score := r.injectionClassifier.Classify(text); score.Suspicious {
+			log.Printf("Withheld scraped text of %s from the %s rag, injection score %.1f: %v", source, r.topic, score.Score, score.Signals)
+			text = withheldScrapedText
+		}
+	}
+	return scrapedTextOpenTag + "\n" + text + "\n" + scrapedTextCloseTag
+}
+
+type injectionSignal struct {
+	name    string
+	pattern *regexp.Regexp
+	weight  float64
+}
+
+// injectionSignals are the phrases of prompt injections. Financial news use some of them, like "ignore the
+// previous guidance", so a single weak signal is not enough to flag a text.
+var injectionSignals = []injectionSignal{
+	{"override", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|all|your|the system)\b.{0,20}\b(instructions?|prompts?|rules|directions|guidelines)\b`), 2},
+	{"role_change", regexp.MustCompile(`(?i)\b(you are now|from now on,? you|act as|pretend to be|you must now)\b`), 1},
+	{"ai_address", regexp.MustCompile(`(?i)(\bnote (to|for) (the )?(ai|llms?|language models?|assistants?|chatbots?)\b|\b(the|an?|you,?) (ai |language )?(assistant|model|llm|chatbot|ai)s? (must|should|will now|(is|are) instructed to)\b)`), 1},
+	{"prompt_reference", regexp.MustCompile(`(?i)\b(system prompt|new instructions|hidden instructions|instructions? for (the )?(ai|assistant|model))\b`), 2},
+	{"role_marker", regexp.MustCompile(`(?im)(^\s*(system|assistant|user)\s*:|&lt;\|im_(start|end)\|&gt;|\[/?inst\]|&lt;/?(system|instructions?)&gt;)`), 2},
+	{"user_manipulation", regexp.MustCompile(`(?i)\b(tell|recommend|urge|advise)\b.{0,20}\b(users?|readers?|investors?|everyone)\b.{0,20}\b(to )?(buy|sell|invest in|short)\b`), 1},
+	{"concealment", regexp.MustCompile(`(?i)\b(do not|don't|never)\b.{0,20}\b(mention|reveal|tell|disclose)\b.{0,30}\b(this|these instructions|the user)\b`), 1},
+	{"exfiltration", regexp.MustCompile(`(?i)\b(visit|click|send|include|append|add)\b.{0,40}(https?://|www\.)`), 0.5},
+}
+
+// InjectionScore is the result of the injection classifier for a text
+type InjectionScore struct {
+	Score      float64
+	Signals    []string // Names of the signals found in the text
+	Suspicious bool
+}
+
+// InjectionClassifier is a lightweight classifier of prompt injections in scraped content. The score of
+// a text is the sum of the weights of the signals it contains.
+type InjectionClassifier struct {
+	threshold float64
+}
+
+func NewInjectionClassifier(threshold float64) (*InjectionClassifier, error) {
+	return &InjectionClassifier{threshold: threshold}, nil
+}
+
+func (c *InjectionClassifier) Classify(text string) InjectionScore {
+	var score InjectionScore
+	for _, signal := range injectionSignals {
+		if signal.pattern.MatchString(text) {
+			score.Score += signal.weight
+			score.Signals = append(score.Signals, signal.name)
+		}
+	}
+	score.Suspicious = score.Score >= c.threshold
+	return score
+}
+
+// InjectionClassifierSetter is implemented by the rags that paste scraped text in their prompts
+type InjectionClassifierSetter interface {
+	SetInjectionClassifier(classifier *InjectionClassifier)
+}
+
+// SetInjectionClassifier sets the classifier of the scraped text. Without a classifier the text is
+// only sanitized.
+func (r *BaseRag) SetInjectionClassifier(classifier *InjectionClassifier) {
+	r.injectionClassifier = classifier
+}
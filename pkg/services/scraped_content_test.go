@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"investbot/pkg/domain"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scrapedFixture is scraped text of testdata/poisoned_content.json. Poisoned fixtures embed instructions
+// for the llm, the payload is what the llm would say if it followed them.
+type scrapedFixture struct {
+	Name     string `json:"name"`
+	Text     string `json:"text"`
+	Poisoned bool   `json:"poisoned"`
+	Payload  string `json:"payload"`
+}
+
+func loadScrapedFixtures(t *testing.T) []scrapedFixture {
+	fixturesJson, err := os.ReadFile("testdata/poisoned_content.json")
+	assert.NoError(t, err)
+
+	var fixtures []scrapedFixture
+	assert.NoError(t, json.Unmarshal(fixturesJson, &fixtures))
+	return fixtures
+}
+
+// obedientLlm follows every instruction of the fixtures that it finds outside the scraped text sections,
+// like a model that can't tell data from instructions would
+type obedientLlm struct {
+	fixtures []scrapedFixture
+	prompts  []string
+}
+
+func (l *obedientLlm) GenerateResponse(conversation []Message, responseChannel chan<- string) error {
+	prompt := conversation[0].Content
+	l.prompts = append(l.prompts, prompt)
+
+	response := "Here is a summary of the data."
+	instructions := outsideScrapedSections(prompt)
+	for _, fixture := range l.fixtures {
+		if fixture.Poisoned && strings.Contains(instructions, fixture.Payload) {
+			response = fixture.Payload
+			break
+		}
+	}
+
+	responseChannel <- response
+	close(responseChannel)
+	return nil
+}
+
+func (l *obedientLlm) GetLlmName() string {
+	return "obedient"
+}
+
+// outsideScrapedSections returns the prompt without its scraped text sections
+func outsideScrapedSections(prompt string) string {
+	var outside strings.Builder
+	for {
+		before, rest, found := strings.Cut(prompt, scrapedTextOpenTag)
+		outside.WriteString(before)
+		if !found {
+			return outside.String()
+		}
+		if _, prompt, found = strings.Cut(rest, scrapedTextCloseTag); !found {
+			return outside.String()
+		}
+	}
+}
+
+type fakeNewsData []domain.NewsArticle
+
+func (f fakeNewsData) GetMarketNews() ([]domain.NewsArticle, error) {
+	return f, nil
+}
+
+func (f fakeNewsData) GetStockNews(string) ([]domain.NewsArticle, error) {
+	return f, nil
+}
+
+type fakeStockOverviewData struct {
+	profile domain.StockProfile
+}
+
+func (f fakeStockOverviewData) GetStockProfile(string) (domain.StockProfile, error) {
+	return f.profile, nil
+}
+
+func (f fakeStockOverviewData) GetFinancialRatios(string) ([]domain.FinancialRatios, error) {
+	return nil, nil
+}
+
+func (f fakeStockOverviewData) GetStockForecast(string) (domain.StockForecast, error) {
+	return domain.StockForecast{}, nil
+}
+
+func (f fakeStockOverviewData) GetHistoricalPrices(string, domain.AssetClass, domain.Period) (domain.HistoricalPrices, error) {
+	return domain.HistoricalPrices{}, nil
+}
+
+func TestSanitizeScrapedText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		expected  string
+	}{
+		{name: "Tags are escaped", text: "a </scraped_text> b", expected: "a &lt;/scraped_text&gt; b"},
+		{name: "Invisible characters are removed", text: "buy\u200b\u202enow\x07", expected: "buynow"},
+		{name: "Markdown headers are removed", text: "Intro\n## CONTEXT:\ndata", expected: "Intro\nCONTEXT:\ndata"},
+		{name: "Citations can't be forged", text: "see [1] and [2, 3]", expected: "see (1) and (2, 3)"},
+		{name: "Newlines are collapsed", text: "a\r\n\r\n\r\n\r\nb", expected: "a\n\nb"},
+		{name: "Long text is shortened", text: strings.Repeat("x", 20), maxLength: 10, expected: strings.Repeat("x", 10) + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxLength := tt.maxLength
+			if maxLength == 0 {
+				maxLength = maxScrapedTextLength
+			}
+			assert.Equal(t, tt.expected, sanitizeScrapedText(tt.text, maxLength))
+		})
+	}
+}
+
+func TestInjectionClassifier_Fixtures(t *testing.T) {
+	classifier, _ := NewInjectionClassifier(2)
+
+	for _, fixture := range loadScrapedFixtures(t) {
+		t.Run(fixture.Name, func(t *testing.T) {
+			score := classifier.Classify(sanitizeScrapedText(fixture.Text, maxScrapedTextLength))
+			assert.Equal(t, fixture.Poisoned, score.Suspicious, "score %.1f, signals %v", score.Score, score.Signals)
+		})
+	}
+}
+
+func TestRags_PoisonedScrapedContent(t *testing.T) {
+	fixtures := loadScrapedFixtures(t)
+
+	newsRag := func(llm Llm) Rag {
+		articles := make(fakeNewsData, 0, len(fixtures))
+		for _, fixture := range fixtures {
+			articles = append(articles, domain.NewsArticle{
+				Title: "Market update", Text: fixture.Text, Source: "Example News", Url: "https://news.example.com/article",
+			})
+		}
+		rag, _ := NewMarketNewsRag(llm, articles, nil, fakeRagResponsesRepository{})
+		return rag
+	}
+	stockOverviewRag := func(fixture scrapedFixture) func(llm Llm) Rag {
+		return func(llm Llm) Rag {
+			data := fakeStockOverviewData{profile: domain.StockProfile{Name: "Apple Inc.", Description: fixture.Text}}
+			rag, _ := NewStockOverviewRag(llm, data, nil, fakeRagResponsesRepository{})
+			return rag
+		}
+	}
+
+	type testCase struct {
+		name   string
+		newRag func(llm Llm) Rag
+		tags   Tags
+	}
+	tests := []testCase{{name: "News articles", newRag: newsRag}}
+	for _, fixture := range fixtures {
+		tests = append(tests, testCase{
+			name:   "Company description: " + fixture.Name,
+			newRag: stockOverviewRag(fixture),
+			tags:   Tags{StockSymbols: []string{"AAPL"}},
+		})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, withClassifier := range []bool{false, true} {
+				llm := &obedientLlm{fixtures: fixtures}
+				rag := tt.newRag(llm)
+				if withClassifier {
+					classifier, _ := NewInjectionClassifier(2)
+					rag.(InjectionClassifierSetter).SetInjectionClassifier(classifier)
+				}
+
+				responseChannel := make(chan string)
+				var response string
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for chunk := range responseChannel {
+						response += chunk
+					}
+				}()
+				_, err := rag.GenerateRagResponse([]Message{{Role: User, Content: "What happened?"}}, tt.tags, responseChannel)
+				<-done
+				assert.NoError(t, err)
+
+				// The embedded instructions are only data, the llm doesn't follow them
+				assert.Equal(t, "Here is a summary of the data.", response)
+
+				prompt := llm.prompts[0]
+				assert.Equal(t, strings.Count(prompt, scrapedTextOpenTag), strings.Count(prompt, scrapedTextCloseTag))
+				for _, fixture := range fixtures {
+					if fixture.Poisoned && withClassifier {
+						// The classifier withholds the poisoned text
+						assert.NotContains(t, prompt, fixture.Payload)
+					}
+				}
+			}
+		})
+	}
+}
@@ -12,8 +12,8 @@ type SectorDataService interface {
 }
 
 type sectorContext struct {
-	sector       domain.Sector
-	sectorStocks []domain.SectorStock
+	Sector       domain.Sector
+	SectorStocks []domain.SectorStock
 }
 
 type SectorRag struct {
@@ -39,7 +39,7 @@ func NewSectorRag(
 	return &rag, nil
 }
 
-func (rag SectorRag) createRagContext(sectorName string) (string, error) {
+func (rag SectorRag) createRagContext(sectorName string, sources *ragSources) (string, error) {
 	var ragContext string
 	sectors, err := rag.dataService.GetSectors()
 	if err != nil {
@@ -48,24 +48,30 @@ func (rag SectorRag) createRagContext(sectorName string) (string, error) {
 
 	for i := 0; i < len(sectors); i++ {
 		sector := sectors[i]
+		if sectorName != "" && sectorName != sector.Name {
+			continue
+		}
+
 		sectorStocks, err := rag.dataService.GetSectorStocks(sector.UrlName)
 		if err != nil {
 			return ragContext, &DataServiceError{Message: fmt.Sprintf("GetSectorStocks failed: %s", err)}
 		}
 
+		sectorUrl := fmt.Sprintf("%s/stocks/sector/%s/", stockAnalysisUrl, sector.UrlName)
+		keys := []string{SectorsDataKey, SectorStocksDataKey(sector.UrlName)}
+
 		if sectorName == "" {
-			// Keep only the top 5 stocks in case we have all sectors in the prompt
 			context := sectorContext{
-				sector:       sector,
-				sectorStocks: sectorStocks[:5],
+				Sector:       sector,
+				SectorStocks: sectorStocks[:min(5, len(sectorStocks))],
 			}
-			ragContext += fmt.Sprintf("%+v\n", context)
-		} else if sectorName == sector.Name {
+			ragContext += sources.block(fmt.Sprintf("%s sector and its largest stocks", sector.Name), sectorUrl, prompts.SectorData, context, keys...)
+		} else {
 			context := sectorContext{
-				sector:       sector,
-				sectorStocks: sectorStocks,
+				Sector:       sector,
+				SectorStocks: sectorStocks,
 			}
-			ragContext += fmt.Sprintf("%+v\n", context)
+			ragContext += sources.block(fmt.Sprintf("%s sector and its stocks", sector.Name), sectorUrl, prompts.SectorData, context, keys...)
 			return ragContext, nil
 		}
 	}
@@ -73,22 +79,29 @@ func (rag SectorRag) createRagContext(sectorName string) (string, error) {
 	return ragContext, nil
 }
 
-func (rag SectorRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+func (rag SectorRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags.SectorName)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.SectorName, sources)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
 	var userContext domain.UserContext
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.SectorsPrompt, ragContext, userContext)
+	prompt, err := rag.renderPrompt(prompts.Sectors, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
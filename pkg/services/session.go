@@ -2,41 +2,129 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"investbot/pkg/errors"
+	investbotErr "investbot/pkg/errors"
+	"log"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
+	badger "github.com/dgraph-io/badger/v4"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+// Session holds the metadata of a conversation. Sessions created without a user have an empty UserID.
+type Session struct {
+	SessionID    string
+	UserID       string
+	Title        string
+	Archived     bool
+	TopicHistory []Topic
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	MessageCount int
+	// Summary is the running summary of the first SummarizedMessages messages of the conversation
+	Summary            string
+	SummarizedMessages int
+}
+
 type SessionService interface {
 	GetConversationBySessionId(sessionId string) ([]Message, error)
-	CreateNewSession() (sessionId string, err error)
+	CreateNewSession(userID string) (sessionId string, err error)
 	AddMessage(sessionId string, msg Message) error
+	GetSession(sessionId string) (Session, error)
+	// ListSessions returns the sessions of the user sorted by most recently updated and the total number of sessions
+	ListSessions(userID string, includeArchived bool, offset int, limit int) ([]Session, int, error)
+	RenameSession(sessionId string, title string) error
+	SetArchived(sessionId string, archived bool) error
+	// AddTopic adds the topic to the topic history of the session if it's not already part of it
+	AddTopic(sessionId string, topic Topic) error
+	// SetSummary stores the summary of the first summarizedMessages messages of the conversation
+	SetSummary(sessionId string, summary string, summarizedMessages int) error
+	DeleteSession(sessionId string) error
+	// GetMessages returns the whole conversation, without the ConvMsgLimit
+	GetMessages(sessionId string) ([]Message, error)
+	// TruncateConversation deletes the message and all the messages after it. If the summary
+	// covers deleted messages it's cleared.
+	TruncateConversation(sessionId string, messageId string) error
+	// ForkSession creates a new session for the user with a copy of the conversation up to the message, included
+	ForkSession(sessionId string, messageId string, userID string) (string, error)
+}
+
+func messageIndex(messages []Message, messageId string) int {
+	return slices.IndexFunc(messages, func(m Message) bool {
+		return m.ID == messageId
+	})
+}
+
+func messageNotFound(sessionId string, messageId string) investbotErr.MessageNotFoundError {
+	return investbotErr.MessageNotFoundError{SessionID: sessionId, MessageID: messageId}
+}
+
+// truncatedSession updates the message count of the session after the conversation is truncated
+func truncatedSession(session Session, messageCount int) Session {
+	session.MessageCount = messageCount
+	if session.SummarizedMessages > messageCount {
+		session.Summary = ""
+		session.SummarizedMessages = 0
+	}
+	session.UpdatedAt = time.Now()
+	return session
+}
+
+// forkedSession returns the metadata of a session forked from session with messageCount messages
+func forkedSession(session Session, userID string, messageCount int) Session {
+	now := time.Now()
+	forked := Session{
+		SessionID:    uuid.NewString(),
+		UserID:       userID,
+		Title:        session.Title,
+		TopicHistory: slices.Clone(session.TopicHistory),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		MessageCount: messageCount,
+	}
+	if session.SummarizedMessages <= messageCount {
+		forked.Summary = session.Summary
+		forked.SummarizedMessages = session.SummarizedMessages
+	}
+	return forked
+}
+
+type inMemorySession struct {
+	session  Session
+	messages []Message
 }
 
 type InMemorySession struct {
 	rwMutex      sync.RWMutex
-	sessions     map[string][]Message
+	sessions     map[string]*inMemorySession
 	convMsgLimit int
 }
 
 func NewInMemorySession(convMsgLimit int) (*InMemorySession, error) {
-	sessions := make(map[string][]Message)
+	sessions := make(map[string]*inMemorySession)
 	return &InMemorySession{sessions: sessions, convMsgLimit: convMsgLimit}, nil
 }
 
+func sessionNotFound(sessionId string) investbotErr.SessionNotFoundError {
+	return investbotErr.SessionNotFoundError{Message: fmt.Sprintf("sessionID: %s not found", sessionId)}
+}
+
 func (s *InMemorySession) GetConversationBySessionId(sessionId string) ([]Message, error) {
 	s.rwMutex.RLock()
 	defer s.rwMutex.RUnlock()
-	conversation, ok := s.sessions[sessionId]
+	stored, ok := s.sessions[sessionId]
 	if !ok {
-		return nil, errors.SessionNotFoundError{Message: fmt.Sprintf("sessionID: %s not found", sessionId)}
+		return nil, sessionNotFound(sessionId)
 	}
+	conversation := stored.messages
 
 	limit := s.convMsgLimit
 	if limit > len(conversation) {
@@ -56,26 +144,238 @@ func (s *InMemorySession) AddMessage(sessionId string, msg Message) error {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	conversation, ok := s.sessions[sessionId]
+	stored, ok := s.sessions[sessionId]
 	if !ok {
-		return errors.SessionNotFoundError{Message: fmt.Sprintf("sessionID: %s not found", sessionId)}
+		return sessionNotFound(sessionId)
 	}
 
-	conversation = append(conversation, msg)
-	s.sessions[sessionId] = conversation
+	stored.messages = append(stored.messages, msg)
+	stored.session.MessageCount = len(stored.messages)
+	stored.session.UpdatedAt = time.Now()
 
 	return nil
 }
 
-func (s *InMemorySession) CreateNewSession() (sessionId string, err error) {
+func (s *InMemorySession) CreateNewSession(userID string) (sessionId string, err error) {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
+	now := time.Now()
 	sessionId = uuid.NewString()
-	s.sessions[sessionId] = []Message{}
+	s.sessions[sessionId] = &inMemorySession{
+		session: Session{
+			SessionID:    sessionId,
+			UserID:       userID,
+			TopicHistory: []Topic{},
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		},
+		messages: []Message{},
+	}
 	return
 }
 
+// copySession returns a copy of the session that doesn't share the topic history with the stored one
+func copySession(session Session) Session {
+	session.TopicHistory = slices.Clone(session.TopicHistory)
+	return session
+}
+
+func (s *InMemorySession) GetSession(sessionId string) (Session, error) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return Session{}, sessionNotFound(sessionId)
+	}
+
+	return copySession(stored.session), nil
+}
+
+func (s *InMemorySession) ListSessions(userID string, includeArchived bool, offset int, limit int) ([]Session, int, error) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	userSessions := make([]Session, 0)
+	for _, stored := range s.sessions {
+		if stored.session.UserID != userID || (stored.session.Archived && !includeArchived) {
+			continue
+		}
+		userSessions = append(userSessions, copySession(stored.session))
+	}
+
+	sort.Slice(userSessions, func(i, j int) bool {
+		if userSessions[i].UpdatedAt.Equal(userSessions[j].UpdatedAt) {
+			return userSessions[i].CreatedAt.After(userSessions[j].CreatedAt)
+		}
+		return userSessions[i].UpdatedAt.After(userSessions[j].UpdatedAt)
+	})
+
+	total := len(userSessions)
+	if offset >= total {
+		return []Session{}, total, nil
+	}
+	end := min(offset+limit, total)
+
+	return userSessions[offset:end], total, nil
+}
+
+func (s *InMemorySession) updateSession(sessionId string, update func(session *Session)) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return sessionNotFound(sessionId)
+	}
+
+	update(&stored.session)
+	stored.session.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *InMemorySession) RenameSession(sessionId string, title string) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Title = title
+	})
+}
+
+func (s *InMemorySession) SetArchived(sessionId string, archived bool) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Archived = archived
+	})
+}
+
+func (s *InMemorySession) AddTopic(sessionId string, topic Topic) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		if !slices.Contains(session.TopicHistory, topic) {
+			session.TopicHistory = append(session.TopicHistory, topic)
+		}
+	})
+}
+
+func (s *InMemorySession) SetSummary(sessionId string, summary string, summarizedMessages int) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Summary = summary
+		session.SummarizedMessages = summarizedMessages
+	})
+}
+
+func (s *InMemorySession) GetMessages(sessionId string) ([]Message, error) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return nil, sessionNotFound(sessionId)
+	}
+
+	return slices.Clone(stored.messages), nil
+}
+
+func (s *InMemorySession) TruncateConversation(sessionId string, messageId string) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return sessionNotFound(sessionId)
+	}
+
+	index := messageIndex(stored.messages, messageId)
+	if index == -1 {
+		return messageNotFound(sessionId, messageId)
+	}
+
+	stored.messages = stored.messages[:index]
+	stored.session = truncatedSession(stored.session, len(stored.messages))
+	return nil
+}
+
+func (s *InMemorySession) ForkSession(sessionId string, messageId string, userID string) (string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	stored, ok := s.sessions[sessionId]
+	if !ok {
+		return "", sessionNotFound(sessionId)
+	}
+
+	index := messageIndex(stored.messages, messageId)
+	if index == -1 {
+		return "", messageNotFound(sessionId, messageId)
+	}
+
+	messages := slices.Clone(stored.messages[:index+1])
+	forked := forkedSession(stored.session, userID, len(messages))
+	s.sessions[forked.SessionID] = &inMemorySession{session: forked, messages: messages}
+
+	return forked.SessionID, nil
+}
+
+func (s *InMemorySession) DeleteSession(sessionId string) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if _, ok := s.sessions[sessionId]; !ok {
+		return sessionNotFound(sessionId)
+	}
+
+	delete(s.sessions, sessionId)
+	return nil
+}
+
+func (s *InMemorySession) DeleteIdleSessions(idleSince time.Time) (int, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	deleted := 0
+	for sessionId, stored := range s.sessions {
+		if stored.session.UpdatedAt.Before(idleSince) {
+			delete(s.sessions, sessionId)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *InMemorySession) TrimUserSessions(maxSessions int) (int, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	userSessions := make(map[string][]Session)
+	for _, stored := range s.sessions {
+		if stored.session.UserID == "" {
+			continue
+		}
+		userSessions[stored.session.UserID] = append(userSessions[stored.session.UserID], stored.session)
+	}
+
+	deleted := 0
+	for _, sessions := range userSessions {
+		for _, session := range excessSessions(sessions, maxSessions) {
+			delete(s.sessions, session.SessionID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// excessSessions returns the least recently updated sessions that exceed maxSessions
+func excessSessions(sessions []Session, maxSessions int) []Session {
+	if len(sessions) <= maxSessions {
+		return nil
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions[maxSessions:]
+}
+
 type MongoDBSessionServiceConf struct {
 	DBName         string
 	CollectionName string
@@ -88,9 +388,54 @@ type MongoDBSessionService struct {
 }
 
 type mongoSessionDocument struct {
-	SessionID string    `bson:"sessionID"`
-	Messages  []Message `bson:"messages"`
-	CreatedAt time.Time
+	SessionID    string    `bson:"sessionID"`
+	UserID       string    `bson:"userID"`
+	Title        string    `bson:"title"`
+	Archived     bool      `bson:"archived"`
+	TopicHistory []Topic   `bson:"topicHistory"`
+	Messages     []Message `bson:"messages"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time `bson:"updatedAt"`
+	// MessageCount is computed by mongoSessionProjection, it's not stored
+	MessageCount       int    `bson:"messageCount,omitempty"`
+	Summary            string `bson:"summary"`
+	SummarizedMessages int    `bson:"summarizedMessages"`
+}
+
+// mongoSessionProjection reads the session metadata and counts the messages without returning them
+var mongoSessionProjection = bson.M{
+	"sessionID":          1,
+	"userID":             1,
+	"title":              1,
+	"archived":           1,
+	"topicHistory":       1,
+	"createdat":          1,
+	"updatedAt":          1,
+	"summary":            1,
+	"summarizedMessages": 1,
+	"messageCount":       bson.M{"$size": bson.M{"$ifNull": bson.A{"$messages", bson.A{}}}},
+}
+
+func (d mongoSessionDocument) toSession() Session {
+	topicHistory := d.TopicHistory
+	if topicHistory == nil {
+		// Sessions created before the topic history existed
+		topicHistory = []Topic{}
+	}
+
+	return Session{
+		SessionID:    d.SessionID,
+		UserID:       d.UserID,
+		Title:        d.Title,
+		Archived:     d.Archived,
+		TopicHistory: topicHistory,
+		CreatedAt:    d.CreatedAt,
+		UpdatedAt:    d.UpdatedAt,
+
+		MessageCount:       d.MessageCount,
+		Summary:            d.Summary,
+		SummarizedMessages: d.SummarizedMessages,
+	}
 }
 
 func NewMongoDBSession(client *mongo.Client, conf MongoDBSessionServiceConf) (*MongoDBSessionService, error) {
@@ -106,7 +451,7 @@ func (s *MongoDBSessionService) GetConversationBySessionId(sessionId string) ([]
 	var doc mongoSessionDocument
 	err := collection.FindOne(context.TODO(), bson.M{"sessionID": sessionId}).Decode(&doc)
 	if err != nil {
-		return nil, errors.SessionNotFoundError{Message: fmt.Sprintf("sessionID: %s not found", sessionId)}
+		return nil, sessionNotFound(sessionId)
 	}
 
 	// Apply convMsgLimit if set (>0)
@@ -118,12 +463,16 @@ func (s *MongoDBSessionService) GetConversationBySessionId(sessionId string) ([]
 	return doc.Messages, nil
 }
 
-func (s *MongoDBSessionService) CreateNewSession() (string, error) {
+func (s *MongoDBSessionService) CreateNewSession(userID string) (string, error) {
+	now := time.Now()
 	sessionId := uuid.NewString()
 	document := mongoSessionDocument{
-		SessionID: sessionId,
-		Messages:  make([]Message, 0),
-		CreatedAt: time.Now(),
+		SessionID:    sessionId,
+		UserID:       userID,
+		TopicHistory: make([]Topic, 0),
+		Messages:     make([]Message, 0),
+		CreatedAt:    now,
+		UpdatedAt:    now,
 	}
 
 	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
@@ -136,11 +485,18 @@ func (s *MongoDBSessionService) CreateNewSession() (string, error) {
 }
 
 func (s *MongoDBSessionService) AddMessage(sessionId string, msg Message) error {
-	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
-
 	update := bson.M{
 		"$push": bson.M{"messages": msg},
+		"$set":  bson.M{"updatedAt": time.Now()},
 	}
+
+	return s.updateSession(sessionId, update)
+}
+
+// updateSession applies the update to the session without creating a new session
+func (s *MongoDBSessionService) updateSession(sessionId string, update bson.M) error {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
 	opts := options.UpdateOne().SetUpsert(false) // we don't create a new session here
 
 	res, err := collection.UpdateOne(context.TODO(), bson.M{"sessionID": sessionId}, update, opts)
@@ -148,8 +504,923 @@ func (s *MongoDBSessionService) AddMessage(sessionId string, msg Message) error
 		return err
 	}
 	if res.MatchedCount == 0 {
-		return errors.SessionNotFoundError{Message: fmt.Sprintf("sessionID: %s not found", sessionId)}
+		return sessionNotFound(sessionId)
+	}
+
+	return nil
+}
+
+func (s *MongoDBSessionService) GetSession(sessionId string) (Session, error) {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	var doc mongoSessionDocument
+	opts := options.FindOne().SetProjection(mongoSessionProjection)
+	err := collection.FindOne(context.TODO(), bson.M{"sessionID": sessionId}, opts).Decode(&doc)
+	if err != nil {
+		return Session{}, sessionNotFound(sessionId)
+	}
+
+	return doc.toSession(), nil
+}
+
+func (s *MongoDBSessionService) ListSessions(userID string, includeArchived bool, offset int, limit int) ([]Session, int, error) {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	filter := bson.M{"userID": userID}
+	if !includeArchived {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+
+	total, err := collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetProjection(mongoSessionProjection).
+		SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	docs := make([]mongoSessionDocument, 0)
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, 0, err
+	}
+
+	sessions := make([]Session, 0, len(docs))
+	for _, doc := range docs {
+		sessions = append(sessions, doc.toSession())
+	}
+
+	return sessions, int(total), nil
+}
+
+func (s *MongoDBSessionService) RenameSession(sessionId string, title string) error {
+	return s.updateSession(sessionId, bson.M{"$set": bson.M{"title": title, "updatedAt": time.Now()}})
+}
+
+func (s *MongoDBSessionService) SetArchived(sessionId string, archived bool) error {
+	return s.updateSession(sessionId, bson.M{"$set": bson.M{"archived": archived, "updatedAt": time.Now()}})
+}
+
+func (s *MongoDBSessionService) AddTopic(sessionId string, topic Topic) error {
+	return s.updateSession(sessionId, bson.M{
+		"$addToSet": bson.M{"topicHistory": topic},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	})
+}
+
+func (s *MongoDBSessionService) SetSummary(sessionId string, summary string, summarizedMessages int) error {
+	return s.updateSession(sessionId, bson.M{"$set": bson.M{
+		"summary":            summary,
+		"summarizedMessages": summarizedMessages,
+		"updatedAt":          time.Now(),
+	}})
+}
+
+func (s *MongoDBSessionService) getDocument(sessionId string) (mongoSessionDocument, error) {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	var doc mongoSessionDocument
+	err := collection.FindOne(context.TODO(), bson.M{"sessionID": sessionId}).Decode(&doc)
+	if err != nil {
+		return doc, sessionNotFound(sessionId)
+	}
+	doc.MessageCount = len(doc.Messages)
+
+	return doc, nil
+}
+
+func (s *MongoDBSessionService) GetMessages(sessionId string) ([]Message, error) {
+	doc, err := s.getDocument(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.Messages, nil
+}
+
+func (s *MongoDBSessionService) TruncateConversation(sessionId string, messageId string) error {
+	doc, err := s.getDocument(sessionId)
+	if err != nil {
+		return err
+	}
+
+	index := messageIndex(doc.Messages, messageId)
+	if index == -1 {
+		return messageNotFound(sessionId, messageId)
+	}
+
+	session := truncatedSession(doc.toSession(), index)
+	// The message id is part of the filter so that a concurrent truncate doesn't delete other messages
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+	res, err := collection.UpdateOne(
+		context.TODO(),
+		bson.M{"sessionID": sessionId, "messages.id": messageId},
+		bson.M{
+			"$push": bson.M{"messages": bson.M{"$each": bson.A{}, "$slice": index}},
+			"$set": bson.M{
+				"summary":            session.Summary,
+				"summarizedMessages": session.SummarizedMessages,
+				"updatedAt":          session.UpdatedAt,
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return messageNotFound(sessionId, messageId)
+	}
+
+	return nil
+}
+
+func (s *MongoDBSessionService) ForkSession(sessionId string, messageId string, userID string) (string, error) {
+	doc, err := s.getDocument(sessionId)
+	if err != nil {
+		return "", err
+	}
+
+	index := messageIndex(doc.Messages, messageId)
+	if index == -1 {
+		return "", messageNotFound(sessionId, messageId)
+	}
+
+	forked := forkedSession(doc.toSession(), userID, index+1)
+	document := mongoSessionDocument{
+		SessionID:          forked.SessionID,
+		UserID:             forked.UserID,
+		Title:              forked.Title,
+		TopicHistory:       forked.TopicHistory,
+		Messages:           doc.Messages[:index+1],
+		CreatedAt:          forked.CreatedAt,
+		UpdatedAt:          forked.UpdatedAt,
+		Summary:            forked.Summary,
+		SummarizedMessages: forked.SummarizedMessages,
+	}
+
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+	if _, err := collection.InsertOne(context.TODO(), document); err != nil {
+		return "", err
+	}
+
+	return forked.SessionID, nil
+}
+
+func (s *MongoDBSessionService) DeleteSession(sessionId string) error {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"sessionID": sessionId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return sessionNotFound(sessionId)
+	}
+
+	return nil
+}
+
+func (s *MongoDBSessionService) DeleteIdleSessions(idleSince time.Time) (int, error) {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"updatedAt": bson.M{"$lt": idleSince}},
+		// Sessions created before the updatedAt field existed
+		bson.M{"updatedAt": bson.M{"$exists": false}, "createdat": bson.M{"$lt": idleSince}},
+	}}
+	res, err := collection.DeleteMany(context.TODO(), filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res.DeletedCount), nil
+}
+
+func (s *MongoDBSessionService) TrimUserSessions(maxSessions int) (int, error) {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+
+	// Find the users over the limit first, so that only their sessions are read
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"userID": bson.M{"$nin": bson.A{"", nil}}}},
+		bson.M{"$group": bson.M{"_id": "$userID", "count": bson.M{"$sum": 1}}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": maxSessions}}},
+	}
+	cursor, err := collection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []struct {
+		UserID string `bson:"_id"`
+	}
+	if err := cursor.All(context.TODO(), &users); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, user := range users {
+		opts := options.Find().
+			SetProjection(bson.M{"sessionID": 1}).
+			SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+			SetSkip(int64(maxSessions))
+		cursor, err := collection.Find(context.TODO(), bson.M{"userID": user.UserID}, opts)
+		if err != nil {
+			return deleted, err
+		}
+
+		var docs []mongoSessionDocument
+		if err := cursor.All(context.TODO(), &docs); err != nil {
+			return deleted, err
+		}
+
+		sessionIds := make([]string, 0, len(docs))
+		for _, doc := range docs {
+			sessionIds = append(sessionIds, doc.SessionID)
+		}
+
+		res, err := collection.DeleteMany(context.TODO(), bson.M{"sessionID": bson.M{"$in": sessionIds}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(res.DeletedCount)
+	}
+
+	return deleted, nil
+}
+
+// EnsureRetentionIndex lets mongo expire the sessions that are idle for longer than the retention
+func (s *MongoDBSessionService) EnsureRetentionIndex(retention time.Duration) error {
+	collection := s.client.Database(s.conf.DBName).Collection(s.conf.CollectionName)
+	return EnsureMongoTtlIndex(collection, "updatedAt", retention)
+}
+
+type BadgerSessionServiceConf struct {
+	ConvMsgLimit int
+	// Ttl is the time a session is kept after its last update, 0 keeps the sessions forever
+	Ttl time.Duration
+}
+
+// BadgerSessionService stores the session metadata and every message under its own key, so adding a message
+// doesn't rewrite the conversation. The messages of a session share a key prefix and are ordered by a sequence number.
+type BadgerSessionService struct {
+	db   *badger.DB
+	conf BadgerSessionServiceConf
+}
+
+type badgerSessionDocument struct {
+	Session
+	NextMessageSeq uint64
+	// MessagesExpireAt is the earliest time a message of the session can expire at, the messages are refreshed
+	// when the session would outlive it
+	MessagesExpireAt time.Time
+}
+
+func NewBadgerSession(db *badger.DB, conf BadgerSessionServiceConf) (*BadgerSessionService, error) {
+	return &BadgerSessionService{db: db, conf: conf}, nil
+}
+
+const badgerSessionsPrefix = "session:"
+
+// The messages are kept a tenth of the ttl longer than their session
+const badgerMessageTtlSlackRatio = 10
+
+// The messages are refreshed in transactions of this many messages, so that long conversations don't
+// make a single transaction too big
+const badgerMessagesRefreshBatchSize = 100
+
+func badgerSessionKey(sessionId string) []byte {
+	return []byte(badgerSessionsPrefix + sessionId)
+}
+
+func badgerSessionMessagesPrefix(sessionId string) []byte {
+	return []byte(fmt.Sprintf("session_message:%s:", sessionId))
+}
+
+// The sequence is zero padded so that the lexicographic order of the keys is the order of the messages
+func badgerSessionMessageKey(sessionId string, seq uint64) []byte {
+	return append(badgerSessionMessagesPrefix(sessionId), []byte(fmt.Sprintf("%020d", seq))...)
+}
+
+// The user index allows to list the sessions of a user with a prefix scan
+func badgerUserSessionsPrefix(userID string) []byte {
+	return []byte(fmt.Sprintf("user_session:%s:", userID))
+}
+
+func badgerUserSessionKey(userID string, sessionId string) []byte {
+	return append(badgerUserSessionsPrefix(userID), []byte(sessionId)...)
+}
+
+func (s *BadgerSessionService) newEntry(key []byte, value []byte) *badger.Entry {
+	entry := badger.NewEntry(key, value)
+	if s.conf.Ttl > 0 {
+		entry = entry.WithTTL(s.conf.Ttl)
+	}
+	return entry
+}
+
+// newMessageEntry keeps the message a bit longer than the session, so that the ttl of the messages only has
+// to be refreshed once in a while and not on every update of the session. The messages that outlive their
+// session are never read since the reads go through the session document first.
+func (s *BadgerSessionService) newMessageEntry(key []byte, value []byte) *badger.Entry {
+	entry := badger.NewEntry(key, value)
+	if s.conf.Ttl > 0 {
+		entry = entry.WithTTL(s.messagesTtl())
+	}
+	return entry
+}
+
+func (s *BadgerSessionService) messagesTtl() time.Duration {
+	return s.conf.Ttl + s.conf.Ttl/badgerMessageTtlSlackRatio
+}
+
+// refreshBadgerMessagesTtl rewrites the messages of the session when the session, just updated, would outlive
+// them. It only reads the session document otherwise. The messages are rewritten after the update of the session,
+// in batches that check that the session still exists, so a failed refresh is only logged and done again on the
+// next update.
+func (s *BadgerSessionService) refreshBadgerMessagesTtl(sessionId string) {
+	if s.conf.Ttl <= 0 {
+		return
+	}
+
+	var doc badgerSessionDocument
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		doc, err = getBadgerSessionDocument(txn, sessionId)
+		return err
+	})
+	if err != nil || !doc.MessagesExpireAt.Before(time.Now().Add(s.conf.Ttl)) {
+		return
+	}
+
+	messagesExpireAt := time.Now().Add(s.messagesTtl())
+	if err := s.refreshBadgerMessagesBatches(sessionId); err != nil {
+		log.Printf("failed to refresh the ttl of the messages of session %s: %v", sessionId, err)
+		return
+	}
+
+	err = s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		doc.MessagesExpireAt = messagesExpireAt
+		return s.setBadgerSessionDocument(txn, doc)
+	})
+	if err != nil && !errors.As(err, &investbotErr.SessionNotFoundError{}) {
+		log.Printf("failed to refresh the ttl of the messages of session %s: %v", sessionId, err)
+	}
+}
+
+// refreshBadgerMessagesBatches rewrites the messages of the session that would expire before the session,
+// badgerMessagesRefreshBatchSize messages per transaction
+func (s *BadgerSessionService) refreshBadgerMessagesBatches(sessionId string) error {
+	prefix := badgerSessionMessagesPrefix(sessionId)
+	start := prefix
+	for start != nil {
+		err := s.update(func(txn *badger.Txn) error {
+			// Reading the session makes the batch conflict with a concurrent delete of the session,
+			// so the deleted messages are not written again
+			if _, err := getBadgerSessionDocument(txn, sessionId); err != nil {
+				return err
+			}
+
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			sessionExpiresAt := uint64(time.Now().Add(s.conf.Ttl).Unix())
+			var expiring []*badger.Entry
+			var next []byte
+			for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+				if len(expiring) == badgerMessagesRefreshBatchSize {
+					next = it.Item().KeyCopy(nil)
+					break
+				}
+
+				item := it.Item()
+				if item.ExpiresAt() >= sessionExpiresAt {
+					continue
+				}
+
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				expiring = append(expiring, s.newMessageEntry(item.KeyCopy(nil), value))
+			}
+
+			for _, entry := range expiring {
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+			start = next
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getBadgerSessionDocument(txn *badger.Txn, sessionId string) (badgerSessionDocument, error) {
+	var doc badgerSessionDocument
+	item, err := txn.Get(badgerSessionKey(sessionId))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return doc, sessionNotFound(sessionId)
+		}
+		return doc, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &doc)
+	})
+	return doc, err
+}
+
+// setBadgerSessionDocument stores the metadata of the session and refreshes its ttl together with the ttl of
+// the user index. The ttl of the messages is refreshed by refreshBadgerMessagesTtl after the update.
+func (s *BadgerSessionService) setBadgerSessionDocument(txn *badger.Txn, doc badgerSessionDocument) error {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.SetEntry(s.newEntry(badgerSessionKey(doc.SessionID), docBytes)); err != nil {
+		return err
+	}
+
+	if doc.UserID == "" {
+		return nil
+	}
+	return txn.SetEntry(s.newEntry(badgerUserSessionKey(doc.UserID, doc.SessionID), nil))
+}
+
+// update runs the read-modify-write transaction again when it conflicts with a concurrent update of the same session
+func (s *BadgerSessionService) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for range 3 {
+		err = s.db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *BadgerSessionService) GetConversationBySessionId(sessionId string) ([]Message, error) {
+	conversation := make([]Message, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		if _, err := getBadgerSessionDocument(txn, sessionId); err != nil {
+			return err
+		}
+
+		// Iterate from the newest message so that only the messages inside the limit are read
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := badgerSessionMessagesPrefix(sessionId)
+		seekKey := append(slices.Clone(prefix), 0xFF)
+		for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+			if s.conf.ConvMsgLimit > 0 && len(conversation) >= s.conf.ConvMsgLimit {
+				break
+			}
+
+			var msg Message
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &msg)
+			})
+			if err != nil {
+				return err
+			}
+			conversation = append(conversation, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(conversation)
+	return conversation, nil
+}
+
+func (s *BadgerSessionService) CreateNewSession(userID string) (string, error) {
+	now := time.Now()
+	doc := badgerSessionDocument{
+		Session: Session{
+			SessionID:    uuid.NewString(),
+			UserID:       userID,
+			TopicHistory: []Topic{},
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		},
+		MessagesExpireAt: now.Add(s.messagesTtl()),
+	}
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return s.setBadgerSessionDocument(txn, doc)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return doc.SessionID, nil
+}
+
+func (s *BadgerSessionService) AddMessage(sessionId string, msg Message) error {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	err = s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.SetEntry(s.newMessageEntry(badgerSessionMessageKey(sessionId, doc.NextMessageSeq), msgBytes)); err != nil {
+			return err
+		}
+
+		doc.NextMessageSeq++
+		doc.MessageCount++
+		doc.UpdatedAt = time.Now()
+		return s.setBadgerSessionDocument(txn, doc)
+	})
+	if err != nil {
+		return err
 	}
 
+	s.refreshBadgerMessagesTtl(sessionId)
 	return nil
 }
+
+func (s *BadgerSessionService) GetSession(sessionId string) (Session, error) {
+	var doc badgerSessionDocument
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		doc, err = getBadgerSessionDocument(txn, sessionId)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return doc.Session, nil
+}
+
+func (s *BadgerSessionService) ListSessions(userID string, includeArchived bool, offset int, limit int) ([]Session, int, error) {
+	userSessions := make([]Session, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := badgerUserSessionsPrefix(userID)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			sessionId := string(it.Item().Key()[len(prefix):])
+			doc, err := getBadgerSessionDocument(txn, sessionId)
+			if err != nil {
+				if errors.As(err, &investbotErr.SessionNotFoundError{}) {
+					// The session expired before its index entry
+					continue
+				}
+				return err
+			}
+
+			if doc.Archived && !includeArchived {
+				continue
+			}
+			userSessions = append(userSessions, doc.Session)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(userSessions, func(i, j int) bool {
+		if userSessions[i].UpdatedAt.Equal(userSessions[j].UpdatedAt) {
+			return userSessions[i].CreatedAt.After(userSessions[j].CreatedAt)
+		}
+		return userSessions[i].UpdatedAt.After(userSessions[j].UpdatedAt)
+	})
+
+	total := len(userSessions)
+	if offset >= total {
+		return []Session{}, total, nil
+	}
+	end := min(offset+limit, total)
+
+	return userSessions[offset:end], total, nil
+}
+
+func (s *BadgerSessionService) updateSession(sessionId string, update func(session *Session)) error {
+	err := s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		update(&doc.Session)
+		doc.UpdatedAt = time.Now()
+		return s.setBadgerSessionDocument(txn, doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.refreshBadgerMessagesTtl(sessionId)
+	return nil
+}
+
+func (s *BadgerSessionService) RenameSession(sessionId string, title string) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Title = title
+	})
+}
+
+func (s *BadgerSessionService) SetArchived(sessionId string, archived bool) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Archived = archived
+	})
+}
+
+func (s *BadgerSessionService) AddTopic(sessionId string, topic Topic) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		if !slices.Contains(session.TopicHistory, topic) {
+			session.TopicHistory = append(session.TopicHistory, topic)
+		}
+	})
+}
+
+func (s *BadgerSessionService) SetSummary(sessionId string, summary string, summarizedMessages int) error {
+	return s.updateSession(sessionId, func(session *Session) {
+		session.Summary = summary
+		session.SummarizedMessages = summarizedMessages
+	})
+}
+
+type badgerMessageEntry struct {
+	key     []byte
+	message Message
+}
+
+// getBadgerMessages returns all the messages of the session in order, with their keys
+func getBadgerMessages(txn *badger.Txn, sessionId string) ([]badgerMessageEntry, error) {
+	entries := make([]badgerMessageEntry, 0)
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	prefix := badgerSessionMessagesPrefix(sessionId)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		entry := badgerMessageEntry{key: it.Item().KeyCopy(nil)}
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &entry.message)
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func badgerMessageIndex(entries []badgerMessageEntry, messageId string) int {
+	return slices.IndexFunc(entries, func(e badgerMessageEntry) bool {
+		return e.message.ID == messageId
+	})
+}
+
+func (s *BadgerSessionService) GetMessages(sessionId string) ([]Message, error) {
+	messages := make([]Message, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		if _, err := getBadgerSessionDocument(txn, sessionId); err != nil {
+			return err
+		}
+
+		entries, err := getBadgerMessages(txn, sessionId)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			messages = append(messages, entry.message)
+		}
+		return nil
+	})
+
+	return messages, err
+}
+
+func (s *BadgerSessionService) TruncateConversation(sessionId string, messageId string) error {
+	err := s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		entries, err := getBadgerMessages(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		index := badgerMessageIndex(entries, messageId)
+		if index == -1 {
+			return messageNotFound(sessionId, messageId)
+		}
+
+		for _, entry := range entries[index:] {
+			if err := txn.Delete(entry.key); err != nil {
+				return err
+			}
+		}
+
+		// The sequence keeps growing so that new messages are never stored under a deleted key
+		doc.Session = truncatedSession(doc.Session, index)
+		return s.setBadgerSessionDocument(txn, doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.refreshBadgerMessagesTtl(sessionId)
+	return nil
+}
+
+func (s *BadgerSessionService) ForkSession(sessionId string, messageId string, userID string) (string, error) {
+	var forkedId string
+	err := s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		entries, err := getBadgerMessages(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		index := badgerMessageIndex(entries, messageId)
+		if index == -1 {
+			return messageNotFound(sessionId, messageId)
+		}
+
+		forked := badgerSessionDocument{
+			Session:          forkedSession(doc.Session, userID, index+1),
+			MessagesExpireAt: time.Now().Add(s.messagesTtl()),
+		}
+		for _, entry := range entries[:index+1] {
+			msgBytes, err := json.Marshal(entry.message)
+			if err != nil {
+				return err
+			}
+			if err := txn.SetEntry(s.newMessageEntry(badgerSessionMessageKey(forked.SessionID, forked.NextMessageSeq), msgBytes)); err != nil {
+				return err
+			}
+			forked.NextMessageSeq++
+		}
+
+		forkedId = forked.SessionID
+		return s.setBadgerSessionDocument(txn, forked)
+	})
+
+	return forkedId, err
+}
+
+// DeleteSession deletes the session document first, so that no message can be added to the session
+// afterwards, and then the messages left under its prefix
+func (s *BadgerSessionService) DeleteSession(sessionId string) error {
+	err := s.update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionId)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(badgerSessionKey(sessionId)); err != nil {
+			return err
+		}
+		if doc.UserID == "" {
+			return nil
+		}
+		return txn.Delete(badgerUserSessionKey(doc.UserID, sessionId))
+	})
+	if err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	err = s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := badgerSessionMessagesPrefix(sessionId)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A write batch splits the deletes in several transactions for long conversations
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return batch.Flush()
+}
+
+func (s *BadgerSessionService) DeleteIdleSessions(idleSince time.Time) (int, error) {
+	sessions, err := s.allSessions()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, session := range sessions {
+		if !session.UpdatedAt.Before(idleSince) {
+			continue
+		}
+		if err := s.DeleteSession(session.SessionID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (s *BadgerSessionService) TrimUserSessions(maxSessions int) (int, error) {
+	sessions, err := s.allSessions()
+	if err != nil {
+		return 0, err
+	}
+
+	userSessions := make(map[string][]Session)
+	for _, session := range sessions {
+		if session.UserID == "" {
+			continue
+		}
+		userSessions[session.UserID] = append(userSessions[session.UserID], session)
+	}
+
+	deleted := 0
+	for _, sessions := range userSessions {
+		for _, session := range excessSessions(sessions, maxSessions) {
+			if err := s.DeleteSession(session.SessionID); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// allSessions returns the metadata of every session, without the messages
+func (s *BadgerSessionService) allSessions() ([]Session, error) {
+	sessions := make([]Session, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(badgerSessionsPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var doc badgerSessionDocument
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &doc)
+			})
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, doc.Session)
+		}
+		return nil
+	})
+
+	return sessions, err
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"encoding/json"
+	"investbot/pkg/errors"
+	"investbot/pkg/services/prompts"
+	"log"
+	"strings"
+)
+
+const maxSessionTitleLength = 100
+
+type llmSessionTitleResponse struct {
+	Title string `json:"title"`
+}
+
+// SessionManagementService implements the user facing operations on sessions, like listing the sessions
+// of a user or renaming them, on top of the SessionService that stores them
+type SessionManagementService struct {
+	sessionService SessionService
+	llm            Llm
+	responseStore  RagResponsesRepository
+}
+
+func NewSessionManagementService(
+	sessionService SessionService,
+	llm Llm,
+	responsesStore RagResponsesRepository,
+) (*SessionManagementService, error) {
+	return &SessionManagementService{
+		sessionService: sessionService,
+		llm:            llm,
+		responseStore:  responsesStore,
+	}, nil
+}
+
+func (s *SessionManagementService) CreateSession(userID string) (Session, error) {
+	sessionID, err := s.sessionService.CreateNewSession(userID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return s.sessionService.GetSession(sessionID)
+}
+
+func (s *SessionManagementService) GetSession(sessionID string, userID string) (Session, []Message, error) {
+	session, err := s.authorize(sessionID, userID)
+	if err != nil {
+		return Session{}, nil, err
+	}
+
+	// The whole conversation, so that any message can be edited or forked
+	conversation, err := s.sessionService.GetMessages(sessionID)
+	if err != nil {
+		return Session{}, nil, err
+	}
+
+	return session, conversation, nil
+}
+
+// ListSessions returns a page of the sessions of the user and the total number of sessions. Pages start from 1.
+func (s *SessionManagementService) ListSessions(userID string, includeArchived bool, page int, pageSize int) ([]Session, int, error) {
+	return s.sessionService.ListSessions(userID, includeArchived, (page-1)*pageSize, pageSize)
+}
+
+// RenameSession sets the title of the session. If title is empty a title is generated from the conversation.
+func (s *SessionManagementService) RenameSession(sessionID string, userID string, title string) (Session, error) {
+	if _, err := s.authorize(sessionID, userID); err != nil {
+		return Session{}, err
+	}
+
+	title = strings.TrimSpace(title)
+	if title == "" {
+		conversation, err := s.sessionService.GetConversationBySessionId(sessionID)
+		if err != nil {
+			return Session{}, err
+		}
+
+		title, err = s.generateTitle(conversation)
+		if err != nil {
+			return Session{}, err
+		}
+	}
+
+	title = truncateTitle(title)
+
+	if err := s.sessionService.RenameSession(sessionID, title); err != nil {
+		return Session{}, err
+	}
+
+	return s.sessionService.GetSession(sessionID)
+}
+
+func (s *SessionManagementService) ArchiveSession(sessionID string, userID string, archived bool) (Session, error) {
+	if _, err := s.authorize(sessionID, userID); err != nil {
+		return Session{}, err
+	}
+
+	if err := s.sessionService.SetArchived(sessionID, archived); err != nil {
+		return Session{}, err
+	}
+
+	return s.sessionService.GetSession(sessionID)
+}
+
+// ForkSession creates a new session for the user with the conversation of the session up to the message, included
+func (s *SessionManagementService) ForkSession(sessionID string, userID string, messageID string) (Session, error) {
+	if _, err := s.authorize(sessionID, userID); err != nil {
+		return Session{}, err
+	}
+
+	forkedID, err := s.sessionService.ForkSession(sessionID, messageID, userID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return s.sessionService.GetSession(forkedID)
+}
+
+func (s *SessionManagementService) DeleteSession(sessionID string, userID string) error {
+	if _, err := s.authorize(sessionID, userID); err != nil {
+		return err
+	}
+
+	return s.sessionService.DeleteSession(sessionID)
+}
+
+// authorize checks that the session belongs to the user. Sessions created without
+// a user can be changed by anyone.
+func (s *SessionManagementService) authorize(sessionID string, userID string) (Session, error) {
+	session, err := s.sessionService.GetSession(sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if session.UserID != "" && session.UserID != userID {
+		return Session{}, errors.SessionForbiddenError{SessionID: sessionID, UserID: userID}
+	}
+
+	return session, nil
+}
+
+func (s *SessionManagementService) generateTitle(conversation []Message) (string, error) {
+	if len(conversation) == 0 {
+		return "", errors.InvalidSessionOperationError{Message: "a title can't be generated for a session without messages"}
+	}
+
+	prompt, err := prompts.Render(prompts.SessionTitle, prompts.Vars{"Conversation": conversation})
+	if err != nil {
+		return "", err
+	}
+	promptMsg := Message{
+		Role:    System,
+		Content: prompt.Text,
+	}
+
+	responseMessage, err := streamChunks(
+		func(chunkChan chan<- string) error {
+			return s.llm.GenerateResponse([]Message{promptMsg}, chunkChan)
+		},
+		nil, // no need to stream the title
+	)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		storeErr := s.responseStore.StoreRagResponse(RagResponse{
+			ModelName:     s.llm.GetLlmName(),
+			Topic:         "SessionTitle",
+			Conversation:  []Message{promptMsg},
+			Response:      responseMessage,
+			PromptName:    prompt.Name,
+			PromptVersion: prompt.Version,
+		})
+		if storeErr != nil {
+			log.Printf("Failed to store session title rag response: %s", storeErr.Error())
+		}
+	}()
+
+	// Strip formatting artifacts from the response(in case they exist)
+	strippedLlmResponse := strings.TrimPrefix(responseMessage, "```json\n")
+	strippedLlmResponse = strings.TrimSuffix(strippedLlmResponse, "\n```")
+
+	var titleResponse llmSessionTitleResponse
+	if err := json.Unmarshal([]byte(strippedLlmResponse), &titleResponse); err != nil {
+		return "", err
+	}
+
+	// Fall back to the question of the user if the llm didn't come up with a title
+	title := strings.TrimSpace(titleResponse.Title)
+	if title == "" {
+		title = firstUserMessage(conversation)
+	}
+	if title == "" {
+		return "", errors.InvalidSessionOperationError{Message: "a title can't be generated for a session without user messages"}
+	}
+
+	return title, nil
+}
+
+// firstUserMessage returns the first user message of the conversation on a single line
+func firstUserMessage(conversation []Message) string {
+	for _, message := range conversation {
+		if message.Role == User && strings.TrimSpace(message.Content) != "" {
+			return strings.Join(strings.Fields(message.Content), " ")
+		}
+	}
+	return ""
+}
+
+// truncateTitle cuts the title to maxSessionTitleLength characters, the title is cut by runes so that
+// multi-byte characters are not split
+func truncateTitle(title string) string {
+	runes := []rune(title)
+	if len(runes) <= maxSessionTitleLength {
+		return title
+	}
+	return strings.TrimSpace(string(runes[:maxSessionTitleLength]))
+}
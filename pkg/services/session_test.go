@@ -0,0 +1,297 @@
+package services
+
+import (
+	"fmt"
+	investbotErr "investbot/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemorySession_ListSessions(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+
+	first, _ := sessionService.CreateNewSession("user")
+	second, _ := sessionService.CreateNewSession("user")
+	third, _ := sessionService.CreateNewSession("user")
+	_, _ = sessionService.CreateNewSession("other_user")
+
+	assert.NoError(t, sessionService.AddMessage(first, Message{Role: User, Content: "hi"}))
+	assert.NoError(t, sessionService.SetArchived(third, true))
+
+	sessions, total, err := sessionService.ListSessions("user", false, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	// most recently updated first
+	assert.Equal(t, []string{first}, sessionIDs(sessions))
+
+	sessions, total, err = sessionService.ListSessions("user", true, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{first, second}, sessionIDs(sessions))
+
+	sessions, _, err = sessionService.ListSessions("user", true, 5, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestInMemorySession_AddTopic(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	sessionID, _ := sessionService.CreateNewSession("user")
+
+	assert.NoError(t, sessionService.AddTopic(sessionID, STOCK_OVERVIEW))
+	assert.NoError(t, sessionService.AddTopic(sessionID, ETFS))
+	assert.NoError(t, sessionService.AddTopic(sessionID, STOCK_OVERVIEW))
+
+	session, err := sessionService.GetSession(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Topic{STOCK_OVERVIEW, ETFS}, session.TopicHistory)
+
+	err = sessionService.AddTopic("missing", ETFS)
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+}
+
+func TestSessionManagementService_Ownership(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	managementService, _ := NewSessionManagementService(sessionService, nil, nil)
+
+	session, err := managementService.CreateSession("user")
+	assert.NoError(t, err)
+
+	_, _, err = managementService.GetSession(session.SessionID, "other_user")
+	assert.ErrorAs(t, err, &investbotErr.SessionForbiddenError{})
+
+	_, err = managementService.RenameSession(session.SessionID, "other_user", "title")
+	assert.ErrorAs(t, err, &investbotErr.SessionForbiddenError{})
+
+	renamed, err := managementService.RenameSession(session.SessionID, "user", "  Apple dividends ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple dividends", renamed.Title)
+
+	got, conversation, err := managementService.GetSession(session.SessionID, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple dividends", got.Title)
+	assert.Empty(t, conversation)
+
+	// a title can't be generated without messages
+	_, err = managementService.RenameSession(session.SessionID, "user", "")
+	assert.ErrorAs(t, err, &investbotErr.InvalidSessionOperationError{})
+
+	assert.NoError(t, managementService.DeleteSession(session.SessionID, "user"))
+	_, err = sessionService.GetSession(session.SessionID)
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+}
+
+func sessionIDs(sessions []Session) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		ids = append(ids, s.SessionID)
+	}
+	return ids
+}
+
+func newTestBadgerSession(t *testing.T, conf BadgerSessionServiceConf) *BadgerSessionService {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	sessionService, _ := NewBadgerSession(db, conf)
+	return sessionService
+}
+
+func TestBadgerSession_Conversation(t *testing.T) {
+	sessionService := newTestBadgerSession(t, BadgerSessionServiceConf{ConvMsgLimit: 3, Ttl: time.Hour})
+
+	sessionID, err := sessionService.CreateNewSession("user")
+	assert.NoError(t, err)
+
+	// more than 10 messages to check that the messages are ordered by sequence and not as strings
+	for i := range 12 {
+		assert.NoError(t, sessionService.AddMessage(sessionID, Message{Role: User, Content: fmt.Sprintf("message %d", i)}))
+	}
+
+	conversation, err := sessionService.GetConversationBySessionId(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{
+		{Role: User, Content: "message 9"},
+		{Role: User, Content: "message 10"},
+		{Role: User, Content: "message 11"},
+	}, conversation)
+
+	_, err = sessionService.GetConversationBySessionId("missing")
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+	err = sessionService.AddMessage("missing", Message{Role: User, Content: "hi"})
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+}
+
+func TestBadgerSession_ListAndDelete(t *testing.T) {
+	sessionService := newTestBadgerSession(t, BadgerSessionServiceConf{ConvMsgLimit: 10})
+
+	first, _ := sessionService.CreateNewSession("user")
+	second, _ := sessionService.CreateNewSession("user")
+	_, _ = sessionService.CreateNewSession("other_user")
+
+	assert.NoError(t, sessionService.AddMessage(first, Message{Role: User, Content: "hi"}))
+	assert.NoError(t, sessionService.AddTopic(first, ETFS))
+	assert.NoError(t, sessionService.AddTopic(first, ETFS))
+	assert.NoError(t, sessionService.SetArchived(second, true))
+
+	sessions, total, err := sessionService.ListSessions("user", false, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []string{first}, sessionIDs(sessions))
+	assert.Equal(t, []Topic{ETFS}, sessions[0].TopicHistory)
+
+	_, total, _ = sessionService.ListSessions("user", true, 0, 10)
+	assert.Equal(t, 2, total)
+
+	assert.NoError(t, sessionService.DeleteSession(first))
+	_, err = sessionService.GetConversationBySessionId(first)
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+	err = sessionService.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(badgerSessionMessageKey(first, 0))
+		return err
+	})
+	assert.ErrorIs(t, err, badger.ErrKeyNotFound)
+	sessions, _, _ = sessionService.ListSessions("user", true, 0, 10)
+	assert.Equal(t, []string{second}, sessionIDs(sessions))
+
+	err = sessionService.DeleteSession(first)
+	assert.ErrorAs(t, err, &investbotErr.SessionNotFoundError{})
+}
+
+func testSessionBranching(t *testing.T, sessionService SessionService) {
+	sessionID, _ := sessionService.CreateNewSession("user")
+	for i, id := range []string{"q1", "a1", "q2", "a2"} {
+		role := User
+		if i%2 == 1 {
+			role = Assistant
+		}
+		assert.NoError(t, sessionService.AddMessage(sessionID, Message{ID: id, Role: role, Content: id}))
+	}
+	assert.NoError(t, sessionService.SetSummary(sessionID, "summary", 3))
+
+	forkedID, err := sessionService.ForkSession(sessionID, "a1", "other_user")
+	assert.NoError(t, err)
+	forked, _ := sessionService.GetSession(forkedID)
+	assert.Equal(t, "other_user", forked.UserID)
+	assert.Equal(t, 2, forked.MessageCount)
+	// The summary covers messages that are not part of the fork
+	assert.Empty(t, forked.Summary)
+	forkedMessages, _ := sessionService.GetMessages(forkedID)
+	assert.Equal(t, []string{"q1", "a1"}, messageIDs(forkedMessages))
+
+	assert.NoError(t, sessionService.TruncateConversation(sessionID, "q2"))
+	messages, _ := sessionService.GetMessages(sessionID)
+	assert.Equal(t, []string{"q1", "a1"}, messageIDs(messages))
+	session, _ := sessionService.GetSession(sessionID)
+	assert.Equal(t, 2, session.MessageCount)
+	assert.Empty(t, session.Summary)
+
+	// New messages go after the remaining ones
+	assert.NoError(t, sessionService.AddMessage(sessionID, Message{ID: "q3", Role: User, Content: "q3"}))
+	messages, _ = sessionService.GetMessages(sessionID)
+	assert.Equal(t, []string{"q1", "a1", "q3"}, messageIDs(messages))
+
+	err = sessionService.TruncateConversation(sessionID, "missing")
+	assert.ErrorAs(t, err, &investbotErr.MessageNotFoundError{})
+	_, err = sessionService.ForkSession(sessionID, "q2", "user")
+	assert.ErrorAs(t, err, &investbotErr.MessageNotFoundError{})
+}
+
+func TestInMemorySession_Branching(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	testSessionBranching(t, sessionService)
+}
+
+func TestBadgerSession_Branching(t *testing.T) {
+	testSessionBranching(t, newTestBadgerSession(t, BadgerSessionServiceConf{ConvMsgLimit: 10}))
+}
+
+func messageIDs(messages []Message) []string {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestSessionManagementService_RenameSessionTitle(t *testing.T) {
+	sessionService, _ := NewInMemorySession(10)
+	llm := &fakeLlm{response: `{"title": "  "}`}
+	managementService, _ := NewSessionManagementService(sessionService, llm, fakeRagResponsesRepository{})
+	session, _ := managementService.CreateSession("user")
+
+	// Long titles are truncated by characters
+	renamed, err := managementService.RenameSession(session.SessionID, "user", strings.Repeat("é", maxSessionTitleLength+10))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("é", maxSessionTitleLength), renamed.Title)
+
+	// An empty generated title falls back to the first user message
+	assert.NoError(t, sessionService.AddMessage(session.SessionID, Message{Role: User, Content: "How are\nApple dividends taxed?"}))
+	renamed, err = managementService.RenameSession(session.SessionID, "user", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "How are Apple dividends taxed?", renamed.Title)
+}
+
+func TestBadgerSession_RefreshesMessagesTtl(t *testing.T) {
+	sessionService := newTestBadgerSession(t, BadgerSessionServiceConf{Ttl: time.Hour})
+	sessionID, _ := sessionService.CreateNewSession("user")
+
+	messageExpiresAt := func(seq uint64) time.Time {
+		var expiresAt uint64
+		err := sessionService.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(badgerSessionMessageKey(sessionID, seq))
+			if err != nil {
+				return err
+			}
+			expiresAt = item.ExpiresAt()
+			return nil
+		})
+		assert.NoError(t, err)
+		return time.Unix(int64(expiresAt), 0)
+	}
+
+	// Messages written long ago that would expire before the session, more than a refresh batch
+	messages := badgerMessagesRefreshBatchSize + 1
+	for range messages {
+		assert.NoError(t, sessionService.AddMessage(sessionID, Message{Role: User, Content: "hi"}))
+	}
+	err := sessionService.db.Update(func(txn *badger.Txn) error {
+		for seq := range uint64(messages) {
+			entry := badger.NewEntry(badgerSessionMessageKey(sessionID, seq), []byte(`{"Role":"user","Content":"hi"}`))
+			if err := txn.SetEntry(entry.WithTTL(time.Minute)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The messages are not rewritten while the session document says they outlive the session
+	assert.NoError(t, sessionService.RenameSession(sessionID, "greetings"))
+	assert.True(t, messageExpiresAt(0).Before(time.Now().Add(time.Hour)))
+
+	err = sessionService.db.Update(func(txn *badger.Txn) error {
+		doc, err := getBadgerSessionDocument(txn, sessionID)
+		if err != nil {
+			return err
+		}
+		doc.MessagesExpireAt = time.Now().Add(time.Minute)
+		return sessionService.setBadgerSessionDocument(txn, doc)
+	})
+	assert.NoError(t, err)
+
+	// The next update keeps the messages at least as long as the session
+	assert.NoError(t, sessionService.RenameSession(sessionID, "greetings"))
+	assert.False(t, messageExpiresAt(0).Before(time.Now().Add(time.Hour)))
+	assert.False(t, messageExpiresAt(uint64(messages-1)).Before(time.Now().Add(time.Hour)))
+
+	conversation, err := sessionService.GetMessages(sessionID)
+	assert.NoError(t, err)
+	assert.Len(t, conversation, messages)
+	assert.Equal(t, Message{Role: User, Content: "hi"}, conversation[0])
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"investbot/pkg/domain"
 	"investbot/pkg/services/prompts"
+	"strings"
 	"time"
 )
 
@@ -13,14 +14,6 @@ type StockFinancialsDataService interface {
 	GetCashFlows(symbol string) ([]domain.CashFlow, error)
 }
 
-type stockFinancialsContext struct {
-	currentDate      string
-	symbol           string
-	balanceSheets    []domain.BalanceSheet
-	incomeStatements []domain.IncomeStatement
-	cashFlows        []domain.CashFlow
-}
-
 type StockFinancialsRag struct {
 	BaseRag
 	dataService        StockFinancialsDataService
@@ -44,20 +37,26 @@ func NewStockFinancialsRag(
 	return &rag, nil
 }
 
-func (rag StockFinancialsRag) createRagContext(tags Tags) (string, error) {
-	ragContext := make([]stockFinancialsContext, 0, len(tags.StockSymbols))
+func (rag StockFinancialsRag) createRagContext(tags Tags, sources *ragSources) (string, error) {
+	ragContext := fmt.Sprintf("Current date: %s\n\n", time.Now().Format("2006-01-02"))
 
 	for _, symbol := range tags.StockSymbols {
-		context := stockFinancialsContext{}
-		context.symbol = symbol
-		context.currentDate = time.Now().Format("2006-01-02")
+		name := strings.ToUpper(symbol)
+		financialsUrl := fmt.Sprintf("%s/stocks/%s/financials", stockAnalysisUrl, strings.ToLower(symbol))
 
 		if tags.BalanceSheet {
 			balanceSheets, err := rag.dataService.GetBalanceSheets(symbol)
 			if err != nil {
 				return "", &DataServiceError{Message: fmt.Sprintf("GetBalanceSheets failed: %s", err)}
 			}
-			context.balanceSheets = balanceSheets
+			label := fmt.Sprintf("%s quarterly balance sheets", name)
+			if len(balanceSheets) > 0 {
+				label = appendFiscalPeriod(label, fiscalPeriod(balanceSheets[0].FiscalYear, balanceSheets[0].FiscalQuarter))
+			}
+			ragContext += sources.block(
+				label, financialsUrl+"/balance-sheet/?p=quarterly", prompts.FinancialStatementsData, balanceSheets,
+				BalanceSheetsDataKey(symbol),
+			)
 		}
 
 		if tags.CashFlow {
@@ -65,7 +64,14 @@ func (rag StockFinancialsRag) createRagContext(tags Tags) (string, error) {
 			if err != nil {
 				return "", &DataServiceError{Message: fmt.Sprintf("GetCashFlows failed: %s", err)}
 			}
-			context.cashFlows = cashFlows
+			label := fmt.Sprintf("%s quarterly cash flow statements", name)
+			if len(cashFlows) > 0 {
+				label = appendFiscalPeriod(label, fiscalPeriod(cashFlows[0].FiscalYear, cashFlows[0].FiscalQuarter))
+			}
+			ragContext += sources.block(
+				label, financialsUrl+"/cash-flow-statement/?p=quarterly", prompts.FinancialStatementsData, cashFlows,
+				CashFlowsDataKey(symbol),
+			)
 		}
 
 		if tags.IncomeStatement {
@@ -73,31 +79,43 @@ func (rag StockFinancialsRag) createRagContext(tags Tags) (string, error) {
 			if err != nil {
 				return "", &DataServiceError{Message: fmt.Sprintf("GetIncomeStatements failed: %s", err)}
 			}
-			context.incomeStatements = incomeStatements
+			label := fmt.Sprintf("%s quarterly income statements", name)
+			if len(incomeStatements) > 0 {
+				label = appendFiscalPeriod(label, fiscalPeriod(incomeStatements[0].FiscalYear, incomeStatements[0].FiscalQuarter))
+			}
+			ragContext += sources.block(
+				label, financialsUrl+"/?p=quarterly", prompts.FinancialStatementsData, incomeStatements,
+				IncomeStatementsDataKey(symbol),
+			)
 		}
-
-		ragContext = append(ragContext, context)
 	}
 
-	return fmt.Sprintf("%+v\n", ragContext), nil
+	return ragContext, nil
 }
 
-func (rag StockFinancialsRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+func (rag StockFinancialsRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags, sources)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
 	var userContext domain.UserContext
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.StockFinancialsPrompt, ragContext, userContext)
+	prompt, err := rag.renderPrompt(prompts.StockFinancials, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"investbot/pkg/domain"
 	"investbot/pkg/services/prompts"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,17 +17,8 @@ type StockOverviewDataService interface {
 }
 
 type stockHistoricalPerformance struct {
-	period           domain.Period
-	percentageChange float64
-}
-
-type stockOverviewContext struct {
-	currentDate           string
-	symbol                string
-	stockProfile          domain.StockProfile
-	stockFinancialRatios  []domain.FinancialRatios
-	stockForecast         domain.StockForecast
-	historicalPerformance []stockHistoricalPerformance
+	Period           domain.Period
+	PercentageChange float64
 }
 
 type StockOverviewRag struct {
@@ -52,14 +44,13 @@ func NewStockOverviewRag(
 	return &rag, nil
 }
 
-func (rag StockOverviewRag) createRagContext(symbols []string) (string, error) {
-	ragContext := make([]stockOverviewContext, 0, len(symbols))
+func (rag StockOverviewRag) createRagContext(symbols []string, sources *ragSources) (string, error) {
+	ragContext := fmt.Sprintf("Current date: %s\n\n", time.Now().Format("2006-01-02"))
 
 	for _, symbol := range symbols {
-		context := stockOverviewContext{
-			symbol:      symbol,
-			currentDate: time.Now().Format("2006-01-02"),
-		}
+		var stockProfile domain.StockProfile
+		var stockFinancialRatios []domain.FinancialRatios
+		var stockForecast domain.StockForecast
 
 		var wg sync.WaitGroup
 		var mu sync.Mutex
@@ -70,70 +61,62 @@ func (rag StockOverviewRag) createRagContext(symbols []string) (string, error) {
 		// Fetch stock profile
 		go func() {
 			defer wg.Done()
-			stockProfile, err := rag.dataService.GetStockProfile(symbol)
+			profile, err := rag.dataService.GetStockProfile(symbol)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				mu.Lock()
 				fetchErr = &DataServiceError{Message: fmt.Sprintf("GetStockProfile failed: %s", err)}
-				mu.Unlock()
 				return
 			}
-			mu.Lock()
-			context.stockProfile = stockProfile
-			mu.Unlock()
+			stockProfile = profile
 		}()
 
 		// Fetch financial ratios
 		go func() {
 			defer wg.Done()
-			stockFinancialRatios, err := rag.dataService.GetFinancialRatios(symbol)
+			financialRatios, err := rag.dataService.GetFinancialRatios(symbol)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				mu.Lock()
 				fetchErr = &DataServiceError{Message: fmt.Sprintf("GetFinancialRatios failed: %s", err)}
-				mu.Unlock()
 				return
 			}
-			mu.Lock()
-			context.stockFinancialRatios = stockFinancialRatios
-			mu.Unlock()
+			stockFinancialRatios = financialRatios
 		}()
 
 		// Fetch forecast
 		go func() {
 			defer wg.Done()
-			stockForecast, err := rag.dataService.GetStockForecast(symbol)
+			forecast, err := rag.dataService.GetStockForecast(symbol)
+			mu.Lock()
+			defer mu.Unlock()
 			if err != nil {
-				mu.Lock()
 				fetchErr = &DataServiceError{Message: fmt.Sprintf("GetStockForecast failed: %s", err)}
-				mu.Unlock()
 				return
 			}
-			mu.Lock()
-			context.stockForecast = stockForecast
-			mu.Unlock()
+			stockForecast = forecast
 		}()
 
 		// Fetch historical performance
 		periods := []domain.Period{domain.Period5D, domain.Period1M, domain.Period6M, domain.Period1Y, domain.Period5Y}
 		performanceList := make([]stockHistoricalPerformance, 5)
+		performanceKeys := make([]string, 5)
 
 		for i, period := range periods {
-			index, perfomancePeriod := i, period // capture loop variables for go routines
 			go func() {
 				defer wg.Done()
-				perf, err := rag.dataService.GetHistoricalPrices(symbol, domain.Stock, perfomancePeriod)
+				perf, err := rag.dataService.GetHistoricalPrices(symbol, domain.Stock, period)
+				mu.Lock()
+				defer mu.Unlock()
 				if err != nil {
-					mu.Lock()
 					fetchErr = &DataServiceError{Message: fmt.Sprintf("GetHistoricalPrices for %s failed: %s", period, err)}
-					mu.Unlock()
 					return
 				}
-				entry := stockHistoricalPerformance{
-					period:           perf.Period,
-					percentageChange: perf.PercentageChange,
+				performanceList[i] = stockHistoricalPerformance{
+					Period:           perf.Period,
+					PercentageChange: perf.PercentageChange,
 				}
-				mu.Lock()
-				performanceList[index] = entry
-				mu.Unlock()
+				performanceKeys[i] = HistoricalPricesDataKey(symbol, domain.Stock, period)
 			}()
 		}
 
@@ -144,29 +127,66 @@ func (rag StockOverviewRag) createRagContext(symbols []string) (string, error) {
 			return "", fetchErr
 		}
 
-		context.historicalPerformance = performanceList
-		ragContext = append(ragContext, context)
+		stockProfile = rag.untrustedProfile(stockProfile, symbol)
+
+		// The blocks are numbered after the fetches so that the numbers don't depend on which fetch finished first
+		name := strings.ToUpper(symbol)
+		stockUrl := fmt.Sprintf("%s/stocks/%s", stockAnalysisUrl, strings.ToLower(symbol))
+		ragContext += sources.block(
+			fmt.Sprintf("%s company profile", name), stockUrl+"/company/", prompts.StockProfileData, stockProfile,
+			StockProfileDataKey(symbol),
+		)
+		ragContext += sources.block(
+			fmt.Sprintf("%s financial ratios", name), stockUrl+"/financials/ratios/", prompts.FinancialRatiosData, stockFinancialRatios,
+			FinancialRatiosDataKey(symbol),
+		)
+		ragContext += sources.block(
+			fmt.Sprintf("%s analyst forecast", name), stockUrl+"/forecast/", prompts.StockForecastData, stockForecast,
+			StockForecastDataKey(symbol),
+		)
+		ragContext += sources.block(
+			fmt.Sprintf("%s price performance", name), stockUrl+"/history/", prompts.PricePerformanceData, performanceList,
+			performanceKeys...,
+		)
 	}
 
-	return fmt.Sprintf("%+v\n", ragContext), nil
+	return ragContext, nil
 }
 
-func (rag StockOverviewRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) error {
+// untrustedProfile sanitizes the scraped fields of the profile, the description is pasted in a data section
+func (rag StockOverviewRag) untrustedProfile(profile domain.StockProfile, symbol string) domain.StockProfile {
+	profile.Name = sanitizeScrapedLabel(profile.Name)
+	profile.Description = rag.untrustedText(profile.Description, fmt.Sprintf("the %s company profile", symbol))
+	profile.Country = sanitizeScrapedLabel(profile.Country)
+	profile.Industry = sanitizeScrapedLabel(profile.Industry)
+	profile.Sector = sanitizeScrapedLabel(profile.Sector)
+	profile.Ceo = sanitizeScrapedLabel(profile.Ceo)
+	return profile
+}
+
+func (rag StockOverviewRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
 	// Format the prompt to contain the neccessary context
-	ragContext, err := rag.createRagContext(tags.StockSymbols)
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.StockSymbols, sources)
 	if err != nil {
-		return err
+		return MessageMetadata{}, err
 	}
 
 	var userContext domain.UserContext
 	if tags.UserID != "" {
 		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
 		if err != nil {
-			return err
+			return MessageMetadata{}, err
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.StockOverviewPrompt, ragContext, userContext)
+	prompt, err := rag.renderPrompt(prompts.StockOverview, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
 
-	return rag.GenerateLllmResponse(prompt, conversation, responseChannel)
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
 }
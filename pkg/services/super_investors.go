@@ -1,6 +1,12 @@
 package services
 
-import "investbot/pkg/domain"
+import (
+	"fmt"
+	"investbot/pkg/domain"
+	"investbot/pkg/services/prompts"
+	"slices"
+	"strings"
+)
 
 type SuperInvestorDataService interface {
 	GetSuperInvestors() ([]domain.SuperInvestor, error)
@@ -22,3 +28,220 @@ func (s SuperInvestorService) GetSuperInvestors() ([]domain.SuperInvestor, error
 func (s SuperInvestorService) GetSuperInvestorPortfolio(superInvestorName string) (domain.SuperInvestorPortfolio, error) {
 	return s.dataService.GetSuperInvestorPortfolio(superInvestorName)
 }
+
+type superInvestorHolding struct {
+	Symbol         string
+	Name           string
+	PortfolioPct   float64
+	RecentActivity string
+	Shares         string
+	Value          string
+}
+
+type superInvestorSector struct {
+	Sector       string
+	PortfolioPct float64
+}
+
+type superInvestorPortfolioContext struct {
+	SuperInvestor    string
+	NumberOfHoldings int
+	Holdings         []superInvestorHolding
+	SectorAnalysis   []superInvestorSector
+}
+
+// superInvestorActivityContext is the activity of the last reported quarter
+type superInvestorActivityContext struct {
+	Buys  []superInvestorHolding // New positions and additions to existing positions
+	Sells []superInvestorHolding // Reductions of existing positions
+}
+
+type sharedHolding struct {
+	Symbol           string
+	SuperInvestorPct float64
+	UserPct          float64
+}
+
+type portfolioOverlapContext struct {
+	SharedHoldings []sharedHolding
+	// Percentage of the super investor portfolio in the holdings that the user also owns
+	SuperInvestorOverlapPct float64
+	// Percentage of the user portfolio in the holdings that the super investor also owns
+	UserOverlapPct float64
+}
+
+type SuperInvestorRag struct {
+	BaseRag
+	dataService        SuperInvestorDataService
+	userContextService UserContextDataService
+}
+
+func NewSuperInvestorRag(
+	llm Llm,
+	superInvestorDataService SuperInvestorDataService,
+	userContextService UserContextDataService,
+	responsesStore RagResponsesRepository,
+) (*SuperInvestorRag, error) {
+	rag := SuperInvestorRag{
+		dataService:        superInvestorDataService,
+		userContextService: userContextService,
+	}
+	rag.llm = llm
+	rag.topic = SUPER_INVESTORS
+	rag.responseStore = responsesStore
+
+	return &rag, nil
+}
+
+// newSuperInvestorHolding parses a scraped holding, its stock is the symbol followed by the name of
+// the company, like "MSFT - Microsoft Corp."
+func newSuperInvestorHolding(holding domain.SuperInvestorPortfolioHolding) superInvestorHolding {
+	var symbol, name string
+	if fields := strings.Fields(holding.Stock); len(fields) > 0 {
+		symbol = fields[0]
+		name = strings.TrimSpace(strings.TrimPrefix(strings.Join(fields[1:], " "), "-"))
+	}
+
+	return superInvestorHolding{
+		Symbol:         strings.ToUpper(symbol),
+		Name:           name,
+		PortfolioPct:   parseNumber(holding.PortfolioPct),
+		RecentActivity: holding.RecentActivity,
+		Shares:         holding.Shares,
+		Value:          holding.Value,
+	}
+}
+
+// portfolioOverlap returns the holdings of the super investor that are in the portfolio of the user
+func portfolioOverlap(holdings []superInvestorHolding, userPortfolio []domain.UserPortfolioHolding) portfolioOverlapContext {
+	var overlap portfolioOverlapContext
+	for _, holding := range holdings {
+		index := slices.IndexFunc(userPortfolio, func(userHolding domain.UserPortfolioHolding) bool {
+			return strings.EqualFold(userHolding.Symbol, holding.Symbol)
+		})
+		if index < 0 {
+			continue
+		}
+
+		userPct := userPortfolio[index].PortfolioPercentage
+		overlap.SharedHoldings = append(overlap.SharedHoldings, sharedHolding{
+			Symbol:           holding.Symbol,
+			SuperInvestorPct: holding.PortfolioPct,
+			UserPct:          userPct,
+		})
+		overlap.SuperInvestorOverlapPct += holding.PortfolioPct
+		overlap.UserOverlapPct += userPct
+	}
+
+	overlap.SuperInvestorOverlapPct = roundTo(overlap.SuperInvestorOverlapPct, 2)
+	overlap.UserOverlapPct = roundTo(overlap.UserOverlapPct, 2)
+	return overlap
+}
+
+func (rag SuperInvestorRag) createRagContext(superInvestorName string, userContext domain.UserContext, sources *ragSources) (string, error) {
+	var ragContext string
+	if superInvestorName == "" {
+		superInvestors, err := rag.dataService.GetSuperInvestors()
+		if err != nil {
+			return ragContext, &DataServiceError{Message: fmt.Sprintf("GetSuperInvestors failed: %s", err)}
+		}
+
+		// The scraped super investors have no order
+		names := make([]string, 0, len(superInvestors))
+		for _, superInvestor := range superInvestors {
+			names = append(names, superInvestor.Name)
+		}
+		slices.Sort(names)
+
+		ragContext += sources.block("Super investors", dataromaUrl+"/m/managers.php", prompts.SuperInvestorsData, names, SuperInvestorsDataKey)
+		return ragContext, nil
+	}
+
+	portfolio, err := rag.dataService.GetSuperInvestorPortfolio(superInvestorName)
+	if err != nil {
+		return ragContext, &DataServiceError{Message: fmt.Sprintf("GetSuperInvestorPortfolio failed: %s", err)}
+	}
+
+	portfolioContext := superInvestorPortfolioContext{
+		SuperInvestor:    superInvestorName,
+		NumberOfHoldings: len(portfolio.Holdings),
+		Holdings:         make([]superInvestorHolding, 0, len(portfolio.Holdings)),
+		SectorAnalysis:   make([]superInvestorSector, 0, len(portfolio.SectorAnalysis)),
+	}
+	for _, sector := range portfolio.SectorAnalysis {
+		portfolioContext.SectorAnalysis = append(portfolioContext.SectorAnalysis, superInvestorSector{
+			Sector:       sector.Sector,
+			PortfolioPct: parseNumber(sector.PortfolioPct),
+		})
+	}
+	var activityContext superInvestorActivityContext
+	for _, scrapedHolding := range portfolio.Holdings {
+		holding := newSuperInvestorHolding(scrapedHolding)
+		portfolioContext.Holdings = append(portfolioContext.Holdings, holding)
+
+		activity := strings.ToLower(holding.RecentActivity)
+		switch {
+		case strings.HasPrefix(activity, "buy"), strings.HasPrefix(activity, "add"):
+			activityContext.Buys = append(activityContext.Buys, holding)
+		case strings.HasPrefix(activity, "reduce"), strings.HasPrefix(activity, "sell"):
+			activityContext.Sells = append(activityContext.Sells, holding)
+		}
+	}
+
+	// All the blocks come from the same scraped portfolio
+	key := SuperInvestorPortfolioDataKey(superInvestorName)
+	ragContext += sources.block(
+		fmt.Sprintf("%s portfolio holdings and sector analysis", superInvestorName),
+		dataromaUrl+"/m/managers.php",
+		prompts.SuperInvestorPortfolioData,
+		portfolioContext,
+		key,
+	)
+	ragContext += sources.block(
+		fmt.Sprintf("%s buys and sells of the last reported quarter", superInvestorName),
+		dataromaUrl+"/m/managers.php",
+		prompts.SuperInvestorActivityData,
+		activityContext,
+		key,
+	)
+
+	if len(userContext.UserPortfolio) > 0 {
+		ragContext += sources.block(
+			fmt.Sprintf("Overlap between the %s portfolio and the user portfolio", superInvestorName),
+			dataromaUrl+"/m/managers.php",
+			prompts.PortfolioOverlapData,
+			portfolioOverlap(portfolioContext.Holdings, userContext.UserPortfolio),
+			key,
+		)
+	}
+
+	return ragContext, nil
+}
+
+func (rag SuperInvestorRag) GenerateRagResponse(conversation []Message, tags Tags, responseChannel chan<- string) (MessageMetadata, error) {
+	var userContext domain.UserContext
+	var err error
+	if tags.UserID != "" {
+		userContext, err = rag.userContextService.GetUserContext(tags.UserID)
+		if err != nil {
+			return MessageMetadata{}, err
+		}
+	}
+
+	// Format the prompt to contain the neccessary context
+	sources := &ragSources{}
+	ragContext, err := rag.createRagContext(tags.SuperInvestorName, userContext, sources)
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+
+	prompt, err := rag.renderPrompt(prompts.SuperInvestors, prompts.Vars{
+		"Context":     ragContext,
+		"UserContext": renderUserContext(userContext),
+	})
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+
+	return rag.GenerateLllmResponse(prompt, ragContext, sources, conversation, responseChannel)
+}
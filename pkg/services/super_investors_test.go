@@ -0,0 +1,126 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSuperInvestorData map[string]domain.SuperInvestorPortfolio
+
+func (f fakeSuperInvestorData) GetSuperInvestors() ([]domain.SuperInvestor, error) {
+	superInvestors := make([]domain.SuperInvestor, 0, len(f))
+	for name := range f {
+		superInvestors = append(superInvestors, domain.SuperInvestor{Name: name})
+	}
+	return superInvestors, nil
+}
+
+func (f fakeSuperInvestorData) GetSuperInvestorPortfolio(superInvestorName string) (domain.SuperInvestorPortfolio, error) {
+	return f[superInvestorName], nil
+}
+
+func (f fakeSuperInvestorData) GetSectors() ([]domain.Sector, error) {
+	return nil, nil
+}
+
+func (f fakeSuperInvestorData) GetIndustries() ([]domain.Industry, error) {
+	return nil, nil
+}
+
+func (f fakeSuperInvestorData) GetTickers() ([]domain.Ticker, error) {
+	return nil, nil
+}
+
+func (f fakeSuperInvestorData) GetEtfs() ([]domain.Etf, error) {
+	return nil, nil
+}
+
+var testSuperInvestorData = fakeSuperInvestorData{
+	"Warren Buffett - Berkshire Hathaway": {
+		Holdings: []domain.SuperInvestorPortfolioHolding{
+			{Stock: "AAPL\n    - Apple Inc.", PortfolioPct: "28.12", RecentActivity: "Reduce 13.26%", Shares: "300,000,000", Value: "$69,900,000,000"},
+			{Stock: "AXP - American Express", PortfolioPct: "16.80", Shares: "151,610,700", Value: "$41,800,000,000"},
+			{Stock: "OXY - Occidental Petroleum", PortfolioPct: "4.20", RecentActivity: "Add 2.51%", Shares: "264,941,431", Value: "$10,400,000,000"},
+			{Stock: "DPZ - Domino's Pizza", PortfolioPct: "0.49", RecentActivity: "Buy", Shares: "2,620,613", Value: "$1,200,000,000"},
+		},
+		SectorAnalysis: []domain.SuperInvestorPortfolioSectorAnalysis{{Sector: "Technology", PortfolioPct: "28.12"}},
+	},
+	"Bill Ackman - Pershing Square Capital Management": {},
+}
+
+func TestSuperInvestorRag_GenerateRagResponse(t *testing.T) {
+	llm := &fakeLlm{response: "Buffett added to OXY and bought DPZ."}
+	userContexts := fakeUserContexts{"user": {
+		UserID: "user",
+		UserPortfolio: []domain.UserPortfolioHolding{
+			{Symbol: "aapl", AssetClass: domain.Stock, PortfolioPercentage: 60},
+			{Symbol: "OXY", AssetClass: domain.Stock, PortfolioPercentage: 15},
+			{Symbol: "VOO", AssetClass: domain.ETF, PortfolioPercentage: 25},
+		},
+	}}
+	rag, _ := NewSuperInvestorRag(llm, testSuperInvestorData, userContexts, fakeRagResponsesRepository{})
+
+	metadata, err := rag.GenerateRagResponse(
+		[]Message{{Role: User, Content: "What did Buffett buy last quarter?"}},
+		Tags{SuperInvestorName: "Warren Buffett - Berkshire Hathaway", UserID: "user"},
+		make(chan string, 10),
+	)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, metadata.RagResponseID)
+	assert.Len(t, metadata.DataSources, 3)
+
+	// The holdings are parsed and the activity is split in buys and sells
+	assert.Contains(t, metadata.RagContext, "| AAPL | Apple Inc. | 28.12% | Reduce 13.26% | 300,000,000 | $69,900,000,000 |")
+	assert.Contains(t, metadata.RagContext, "| Technology | 28.12% |")
+	assert.Contains(t, metadata.RagContext, "Buys:\n| Symbol | Name | PortfolioPct | RecentActivity |\n| --- | --- | --- | --- |\n| OXY | Occidental Petroleum | 4.2% | Add 2.51% |\n| DPZ |")
+	assert.Contains(t, metadata.RagContext, "Sells:\n| Symbol | Name | PortfolioPct | RecentActivity |\n| --- | --- | --- | --- |\n| AAPL |")
+
+	// The overlap with the user portfolio
+	assert.Contains(t, metadata.RagContext, "Super investor overlap: 32.32%\nUser overlap: 75%")
+	assert.Contains(t, metadata.RagContext, "| OXY | 4.2% | 15% |")
+
+	// Without a user there is no overlap
+	metadata, err = rag.GenerateRagResponse(
+		[]Message{{Role: User, Content: "What did Buffett buy last quarter?"}},
+		Tags{SuperInvestorName: "Warren Buffett - Berkshire Hathaway"},
+		make(chan string, 10),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, metadata.DataSources, 2)
+	assert.NotContains(t, metadata.RagContext, "Overlap")
+}
+
+func TestSuperInvestorRag_GenericQuestion(t *testing.T) {
+	rag, _ := NewSuperInvestorRag(&fakeLlm{}, testSuperInvestorData, nil, fakeRagResponsesRepository{})
+
+	metadata, err := rag.GenerateRagResponse([]Message{{Role: User, Content: "Which super investors can I follow?"}}, Tags{}, make(chan string, 10))
+	assert.NoError(t, err)
+	assert.Contains(t, metadata.RagContext, "- Bill Ackman - Pershing Square Capital Management\n- Warren Buffett - Berkshire Hathaway\n")
+}
+
+func TestTagExtractor_ExtractSuperInvestorTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected Tags
+	}{
+		{name: "super investor", response: `{"super_investor_name": "Warren Buffett - Berkshire Hathaway"}`, expected: Tags{SuperInvestorName: "Warren Buffett - Berkshire Hathaway"}},
+		{name: "different case", response: `{"super_investor_name": "bill ackman - pershing square capital management"}`, expected: Tags{SuperInvestorName: "Bill Ackman - Pershing Square Capital Management"}},
+		{name: "unknown super investor", response: `{"super_investor_name": "Buffett"}`, expected: Tags{}},
+		{name: "no super investor", response: `{"super_investor_name": ""}`, expected: Tags{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeLlm{response: tt.response}
+			extractor, _ := NewTagExtractor(llm, testSuperInvestorData, nil, fakeRagResponsesRepository{})
+
+			tags, err := extractor.ExtractTags(SUPER_INVESTORS, []Message{{Role: User, Content: "What did Buffett buy?"}}, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tags)
+			assert.Contains(t, llm.prompts[0], "Warren Buffett - Berkshire Hathaway\n")
+		})
+	}
+}
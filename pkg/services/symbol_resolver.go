@@ -0,0 +1,179 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	"sort"
+	"strings"
+)
+
+type SymbolUniverseDataService interface {
+	GetTickers() ([]domain.Ticker, error)
+	GetEtfs() ([]domain.Etf, error)
+}
+
+type resolvedSymbol struct {
+	symbol     string
+	name       string
+	assetClass domain.AssetClass
+}
+
+// symbolResolver resolves symbols and asset names against the stock and etf universes
+// of the market data service
+type symbolResolver struct {
+	bySymbol map[string]resolvedSymbol
+	byName   map[string]resolvedSymbol
+}
+
+func newSymbolResolver(dataService SymbolUniverseDataService) (*symbolResolver, error) {
+	tickers, err := dataService.GetTickers()
+	if err != nil {
+		return nil, &DataServiceError{Message: "GetTickers failed: " + err.Error()}
+	}
+
+	etfs, err := dataService.GetEtfs()
+	if err != nil {
+		return nil, &DataServiceError{Message: "GetEtfs failed: " + err.Error()}
+	}
+
+	resolver := &symbolResolver{
+		bySymbol: make(map[string]resolvedSymbol, len(tickers)+len(etfs)),
+		byName:   make(map[string]resolvedSymbol, len(tickers)+len(etfs)),
+	}
+
+	for _, t := range tickers {
+		entry := resolvedSymbol{symbol: strings.ToUpper(t.Symbol), name: t.CompanyName, assetClass: domain.Stock}
+		resolver.bySymbol[entry.symbol] = entry
+		resolver.byName[normalizeAssetName(t.CompanyName)] = entry
+	}
+
+	// Etfs are added after the stocks so an etf wins when a symbol exists in both universes
+	for _, e := range etfs {
+		entry := resolvedSymbol{symbol: strings.ToUpper(e.Symbol), name: e.Name, assetClass: domain.ETF}
+		resolver.bySymbol[entry.symbol] = entry
+		resolver.byName[normalizeAssetName(e.Name)] = entry
+	}
+
+	return resolver, nil
+}
+
+// resolve returns the asset that matches the symbol or, if the symbol is empty or unknown, the exact name
+func (r *symbolResolver) resolve(symbol string, name string) (resolvedSymbol, bool) {
+	if symbol != "" {
+		if entry, found := r.bySymbol[strings.ToUpper(strings.TrimSpace(symbol))]; found {
+			return entry, true
+		}
+	}
+
+	if name != "" {
+		if entry, found := r.byName[normalizeAssetName(name)]; found {
+			return entry, true
+		}
+	}
+
+	return resolvedSymbol{}, false
+}
+
+type symbolSuggestion struct {
+	symbol string
+	score  int
+}
+
+// suggest returns up to limit symbols that are close to the query. Symbols within a small edit
+// distance of the query are ranked first, followed by assets whose name starts with or contains it.
+func (r *symbolResolver) suggest(query string, limit int) []string {
+	upperQuery := strings.ToUpper(strings.TrimSpace(query))
+	nameQuery := normalizeAssetName(query)
+	if upperQuery == "" {
+		return []string{}
+	}
+
+	maxDistance := 2
+	if len(upperQuery) <= 3 {
+		maxDistance = 1
+	}
+
+	scores := make(map[string]int)
+	addCandidate := func(symbol string, score int) {
+		if current, found := scores[symbol]; !found || score < current {
+			scores[symbol] = score
+		}
+	}
+
+	for symbol := range r.bySymbol {
+		if distance := levenshteinDistance(upperQuery, symbol); distance <= maxDistance {
+			addCandidate(symbol, distance)
+		}
+	}
+
+	if len(nameQuery) >= 3 {
+		for name, entry := range r.byName {
+			if strings.HasPrefix(name, nameQuery) {
+				addCandidate(entry.symbol, maxDistance+1)
+			} else if strings.Contains(name, " "+nameQuery) {
+				addCandidate(entry.symbol, maxDistance+2)
+			}
+		}
+	}
+
+	suggestions := make([]symbolSuggestion, 0, len(scores))
+	for symbol, score := range scores {
+		suggestions = append(suggestions, symbolSuggestion{symbol: symbol, score: score})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].score != suggestions[j].score {
+			return suggestions[i].score < suggestions[j].score
+		}
+		return suggestions[i].symbol < suggestions[j].symbol
+	})
+
+	symbols := make([]string, 0, limit)
+	for i := 0; i < len(suggestions) && i < limit; i++ {
+		symbols = append(symbols, suggestions[i].symbol)
+	}
+	return symbols
+}
+
+func levenshteinDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// Legal suffixes are dropped from the names so that "Apple" matches "Apple Inc."
+var assetNameSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "corp": true, "corporation": true, "co": true,
+	"company": true, "ltd": true, "limited": true, "plc": true, "sa": true, "nv": true, "ag": true,
+}
+
+// normalizeAssetName lowercases the name and removes punctuation and legal suffixes so that
+// names like "Apple Inc.", "APPLE INC" and "Apple" are considered the same
+func normalizeAssetName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == ' ' {
+			b.WriteRune(r)
+		}
+	}
+
+	words := strings.Fields(b.String())
+	for len(words) > 1 && assetNameSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
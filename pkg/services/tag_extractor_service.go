@@ -2,17 +2,20 @@ package services
 
 import (
 	"encoding/json"
-	"fmt"
 	"investbot/pkg/domain"
 	"investbot/pkg/services/prompts"
 	"log"
+	"slices"
 	"strings"
+	"time"
 )
 
 type MarketDataService interface {
 	GetSectors() ([]domain.Sector, error)
+	GetIndustries() ([]domain.Industry, error)
 	GetTickers() ([]domain.Ticker, error)
 	GetEtfs() ([]domain.Etf, error)
+	GetSuperInvestors() ([]domain.SuperInvestor, error)
 }
 
 type TagExtractor struct {
@@ -20,15 +23,18 @@ type TagExtractor struct {
 	marketDataService  MarketDataService
 	userContextService UserContextDataService
 	responseStore      RagResponsesRepository
+	experimentVariant
 }
 
 type llmTagExtractorResponse struct {
 	Sector          string   `json:"sector_name"`
+	Industry        string   `json:"industry_name"`
 	StockSymbols    []string `json:"stock_symbols"`
 	BalanceSheet    bool     `json:"balance_sheet"`
 	IncomeStatement bool     `json:"income_statement"`
 	CashFlow        bool     `json:"cash_flow"`
 	EtfSymbols      []string `json:"etf_symbols"`
+	SuperInvestor   string   `json:"super_investor_name"`
 }
 
 func NewTagExtractor(
@@ -58,6 +64,8 @@ func (te TagExtractor) ExtractTags(topic Topic, conversation []Message, userID s
 	switch topic {
 	case SECTORS:
 		tags, err = te.extractSectorTags(conversation, userContext)
+	case INDUSTRIES:
+		tags, err = te.extractIndustryTags(conversation, userContext)
 	case STOCK_OVERVIEW:
 		tags, err = te.extractStockOverviewTags(conversation, userContext)
 	case STOCK_FINANCIALS:
@@ -66,6 +74,10 @@ func (te TagExtractor) ExtractTags(topic Topic, conversation []Message, userID s
 		tags, err = te.extractEtfTags(conversation, userContext)
 	case NEWS:
 		tags, err = te.extractMarketNewsTags(conversation, userContext)
+	case PORTFOLIO:
+		tags, err = te.extractPortfolioTags(conversation, userContext)
+	case SUPER_INVESTORS:
+		tags, err = te.extractSuperInvestorTags(conversation, userContext)
 	}
 	return tags, err
 }
@@ -76,19 +88,20 @@ func (te TagExtractor) extractSectorTags(conversation []Message, userContext dom
 		return Tags{}, err
 	}
 
-	var sectorsPlaceholderString string
+	sectorNames := make([]string, 0, len(sectors))
 	for _, s := range sectors {
-		sectorsPlaceholderString += fmt.Sprintf("%s\n", s.UrlName)
+		sectorNames = append(sectorNames, s.UrlName)
 	}
 
-	prompt := fmt.Sprintf(prompts.SectorTagExtractorPrompt, sectorsPlaceholderString, userContext, conversation)
-	llmResponse, err := te.getLlmResponse(prompt)
+	prompt, err := te.renderPrompt(prompts.SectorTagExtractor, prompts.Vars{
+		"Sectors":      sectorNames,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
 	if err != nil {
 		return Tags{}, err
 	}
-
-	var result llmTagExtractorResponse
-	err = json.Unmarshal([]byte(llmResponse), &result)
+	result, err := te.extract(SECTORS, prompt)
 	if err != nil {
 		return Tags{}, err
 	}
@@ -96,20 +109,55 @@ func (te TagExtractor) extractSectorTags(conversation []Message, userContext dom
 	return Tags{SectorName: result.Sector}, nil
 }
 
+func (te TagExtractor) extractIndustryTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
+	industries, err := te.marketDataService.GetIndustries()
+	if err != nil {
+		return Tags{}, err
+	}
+
+	industryNames := make([]string, 0, len(industries))
+	for _, industry := range industries {
+		industryNames = append(industryNames, industry.Name)
+	}
+
+	prompt, err := te.renderPrompt(prompts.IndustryTagExtractor, prompts.Vars{
+		"Industries":   industryNames,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
+	if err != nil {
+		return Tags{}, err
+	}
+	result, err := te.extract(INDUSTRIES, prompt)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	// The industry rag looks up the industry by its exact name, an industry that is not listed is
+	// treated as an industry generic question
+	for _, name := range industryNames {
+		if strings.EqualFold(strings.TrimSpace(result.Industry), name) {
+			return Tags{IndustryName: name}, nil
+		}
+	}
+	return Tags{}, nil
+}
+
 func (te TagExtractor) extractStockOverviewTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
 	stockSymbols, err := te.marketDataService.GetTickers()
 	if err != nil {
 		return Tags{}, err
 	}
 
-	prompt := fmt.Sprintf(prompts.StockOverviewTagExtractorPrompt, stockSymbols, userContext, conversation)
-	llmResponse, err := te.getLlmResponse(prompt)
+	prompt, err := te.renderPrompt(prompts.StockOverviewTagExtractor, prompts.Vars{
+		"Tickers":      stockSymbols,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
 	if err != nil {
 		return Tags{}, err
 	}
-
-	var result llmTagExtractorResponse
-	err = json.Unmarshal([]byte(llmResponse), &result)
+	result, err := te.extract(STOCK_OVERVIEW, prompt)
 	if err != nil {
 		return Tags{}, err
 	}
@@ -123,14 +171,15 @@ func (te TagExtractor) extractStockFinancialsTags(conversation []Message, userCo
 		return Tags{}, err
 	}
 
-	prompt := fmt.Sprintf(prompts.StockFinancialsTagExtractorPrompt, stockSymbols, userContext, conversation)
-	llmResponse, err := te.getLlmResponse(prompt)
+	prompt, err := te.renderPrompt(prompts.StockFinancialsTagExtractor, prompts.Vars{
+		"Tickers":      stockSymbols,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
 	if err != nil {
 		return Tags{}, err
 	}
-
-	var result llmTagExtractorResponse
-	err = json.Unmarshal([]byte(llmResponse), &result)
+	result, err := te.extract(STOCK_FINANCIALS, prompt)
 	if err != nil {
 		return Tags{}, err
 	}
@@ -149,58 +198,107 @@ func (te TagExtractor) extractEtfTags(conversation []Message, userContext domain
 		return Tags{}, err
 	}
 
-	type etfTicker struct {
-		etfName   string
-		etfSymbol string
+	prompt, err := te.renderPrompt(prompts.EtfTagExtractor, prompts.Vars{
+		"Etfs":         etfs,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
+	if err != nil {
+		return Tags{}, err
 	}
-
-	etfSymbols := make([]etfTicker, 0, len(etfs))
-	for _, e := range etfs {
-		etfSymbols = append(etfSymbols, etfTicker{etfName: e.Name, etfSymbol: e.Symbol})
+	result, err := te.extract(ETFS, prompt)
+	if err != nil {
+		return Tags{}, err
 	}
 
-	prompt := fmt.Sprintf(prompts.EtfTagExtractorPrompt, etfSymbols, userContext, conversation)
-	llmResponse, err := te.getLlmResponse(prompt)
+	return Tags{EtfSymbols: result.EtfSymbols}, nil
+}
+
+func (te TagExtractor) extractMarketNewsTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
+	stockSymbols, err := te.marketDataService.GetTickers()
 	if err != nil {
 		return Tags{}, err
 	}
 
-	var result llmTagExtractorResponse
-	err = json.Unmarshal([]byte(llmResponse), &result)
+	prompt, err := te.renderPrompt(prompts.NewsTagExtractor, prompts.Vars{
+		"Tickers":      stockSymbols,
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
+	if err != nil {
+		return Tags{}, err
+	}
+	result, err := te.extract(NEWS, prompt)
 	if err != nil {
 		return Tags{}, err
 	}
 
-	return Tags{EtfSymbols: result.EtfSymbols}, nil
+	return Tags{StockSymbols: result.StockSymbols}, nil
 }
 
-func (te TagExtractor) extractMarketNewsTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
-	stockSymbols, err := te.marketDataService.GetTickers()
+func (te TagExtractor) extractPortfolioTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
+	// Without a portfolio there are no holdings to focus on
+	if len(userContext.UserPortfolio) == 0 {
+		return Tags{}, nil
+	}
+
+	prompt, err := te.renderPrompt(prompts.PortfolioTagExtractor, prompts.Vars{
+		"Holdings":     userContext.UserPortfolio,
+		"Conversation": conversation,
+	})
 	if err != nil {
 		return Tags{}, err
 	}
+	result, err := te.extract(PORTFOLIO, prompt)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	return Tags{StockSymbols: result.StockSymbols, EtfSymbols: result.EtfSymbols}, nil
+}
 
-	prompt := fmt.Sprintf(prompts.NewsTagExtractorPrompt, stockSymbols, userContext, conversation)
-	llmResponse, err := te.getLlmResponse(prompt)
+func (te TagExtractor) extractSuperInvestorTags(conversation []Message, userContext domain.UserContext) (Tags, error) {
+	superInvestors, err := te.marketDataService.GetSuperInvestors()
 	if err != nil {
 		return Tags{}, err
 	}
 
-	var result llmTagExtractorResponse
-	err = json.Unmarshal([]byte(llmResponse), &result)
+	superInvestorNames := make([]string, 0, len(superInvestors))
+	for _, superInvestor := range superInvestors {
+		superInvestorNames = append(superInvestorNames, superInvestor.Name)
+	}
+	slices.Sort(superInvestorNames)
+
+	prompt, err := te.renderPrompt(prompts.SuperInvestorTagExtractor, prompts.Vars{
+		"SuperInvestors": superInvestorNames,
+		"UserContext":    renderUserContext(userContext),
+		"Conversation":   conversation,
+	})
+	if err != nil {
+		return Tags{}, err
+	}
+	result, err := te.extract(SUPER_INVESTORS, prompt)
 	if err != nil {
 		return Tags{}, err
 	}
 
-	return Tags{StockSymbols: result.StockSymbols}, nil
+	// The portfolios are looked up by the exact name of the super investor
+	for _, name := range superInvestorNames {
+		if strings.EqualFold(strings.TrimSpace(result.SuperInvestor), name) {
+			return Tags{SuperInvestorName: name}, nil
+		}
+	}
+	return Tags{}, nil
 }
 
-func (te TagExtractor) getLlmResponse(prompt string) (string, error) {
+// extract returns the tags of the response of the llm to the prompt
+func (te TagExtractor) extract(topic Topic, prompt prompts.Prompt) (llmTagExtractorResponse, error) {
 	promptMsg := Message{
 		Role:    User,
-		Content: prompt,
+		Content: prompt.Text,
 	}
 
+	start := time.Now()
 	responseMessage, err := streamChunks(
 		func(chunkChan chan<- string) error {
 			return te.llm.GenerateResponse([]Message{promptMsg}, chunkChan)
@@ -208,16 +306,22 @@ func (te TagExtractor) getLlmResponse(prompt string) (string, error) {
 		nil, // no need to stream out chunks
 	)
 	if err != nil {
-		return "", err
+		te.observe(TagExtractorTarget, topic, []Message{promptMsg}, "", time.Since(start), false)
+		return llmTagExtractorResponse{}, err
 	}
+	latency := time.Since(start)
 
 	go func() {
-		storeErr := te.responseStore.StoreRagResponse(
-			te.llm.GetLlmName(),
-			"ExtractTags",
-			[]Message{promptMsg},
-			responseMessage,
-		)
+		storeErr := te.responseStore.StoreRagResponse(RagResponse{
+			ModelName:     te.llm.GetLlmName(),
+			Topic:         "ExtractTags",
+			Conversation:  []Message{promptMsg},
+			Response:      responseMessage,
+			PromptName:    prompt.Name,
+			PromptVersion: prompt.Version,
+			Experiment:    te.assignment.Experiment,
+			Variant:       te.assignment.Variant,
+		})
 		if storeErr != nil {
 			log.Printf("Failed to store tag extraction rag response: %s", storeErr.Error())
 		}
@@ -227,5 +331,8 @@ func (te TagExtractor) getLlmResponse(prompt string) (string, error) {
 	stripped := strings.TrimPrefix(responseMessage, "```json\n")
 	stripped = strings.TrimSuffix(stripped, "\n```")
 
-	return stripped, nil
+	var result llmTagExtractorResponse
+	err = json.Unmarshal([]byte(stripped), &result)
+	te.observe(TagExtractorTarget, topic, []Message{promptMsg}, responseMessage, latency, err == nil)
+	return result, err
 }
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"investbot/pkg/errors"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxTopicBuckets = 1000
+
+// TopicAndTags is a stored extraction of the topic and tags of a question
+type TopicAndTags struct {
+	Topic       Topic
+	Tags        Tags
+	Question    string
+	SessionID   string
+	UserID      string
+	Experiments []Assignment // Empty when the extractors were not part of an experiment
+	CreatedAt   time.Time
+}
+
+// TopicAndTagsQuery filters the stored extractions, the zero value of a field doesn't filter
+type TopicAndTagsQuery struct {
+	Topics []Topic
+	UserID string
+	From   time.Time // Included
+	To     time.Time // Excluded
+}
+
+// TopicAndTagsReader reads back the stored extractions, sorted from the oldest
+type TopicAndTagsReader interface {
+	QueryTopicAndTags(query TopicAndTagsQuery) ([]TopicAndTags, error)
+}
+
+// TopicAnalyticsService reports what the users ask about from the stored topic and tag extractions
+type TopicAnalyticsService struct {
+	reader TopicAndTagsReader
+}
+
+func NewTopicAnalyticsService(reader TopicAndTagsReader) (*TopicAnalyticsService, error) {
+	return &TopicAnalyticsService{reader: reader}, nil
+}
+
+// TopicBucket counts the questions of every topic asked between Start, included, and the start of the next bucket
+type TopicBucket struct {
+	Start  time.Time
+	Total  int
+	Topics map[Topic]int
+}
+
+type TopicDistribution struct {
+	From    time.Time
+	To      time.Time
+	Bucket  time.Duration
+	Totals  map[Topic]int
+	Buckets []TopicBucket
+}
+
+// TopicDistribution counts the questions of every topic asked between from and to by buckets of the given
+// size. The buckets start at from, the empty buckets are returned too so that they can be charted.
+func (s *TopicAnalyticsService) TopicDistribution(from time.Time, to time.Time, bucket time.Duration) (TopicDistribution, error) {
+	if bucket <= 0 {
+		return TopicDistribution{}, errors.InvalidTopicAnalyticsQueryError{Message: "the bucket must be positive"}
+	}
+	if !from.Before(to) {
+		return TopicDistribution{}, errors.InvalidTopicAnalyticsQueryError{Message: "from must be before to"}
+	}
+	bucketsNum := int((to.Sub(from) + bucket - 1) / bucket)
+	if bucketsNum > maxTopicBuckets {
+		return TopicDistribution{}, errors.InvalidTopicAnalyticsQueryError{Message: "the range has too many buckets, use larger buckets"}
+	}
+
+	extractions, err := s.reader.QueryTopicAndTags(TopicAndTagsQuery{From: from, To: to})
+	if err != nil {
+		return TopicDistribution{}, err
+	}
+
+	distribution := TopicDistribution{
+		From:    from,
+		To:      to,
+		Bucket:  bucket,
+		Totals:  map[Topic]int{},
+		Buckets: make([]TopicBucket, bucketsNum),
+	}
+	for i := range distribution.Buckets {
+		distribution.Buckets[i] = TopicBucket{Start: from.Add(time.Duration(i) * bucket), Topics: map[Topic]int{}}
+	}
+	for _, extraction := range extractions {
+		index := int(extraction.CreatedAt.Sub(from) / bucket)
+		if index < 0 || index >= bucketsNum {
+			continue
+		}
+		distribution.Buckets[index].Topics[extraction.Topic]++
+		distribution.Buckets[index].Total++
+		distribution.Totals[extraction.Topic]++
+	}
+
+	return distribution, nil
+}
+
+// TagMentions counts the questions that mention a symbol or a sector and the users that asked them
+type TagMentions struct {
+	Name     string
+	Mentions int
+	Users    int // Questions without a user are not counted
+}
+
+type MostMentioned struct {
+	From    time.Time
+	To      time.Time
+	Stocks  []TagMentions
+	Etfs    []TagMentions
+	Sectors []TagMentions
+}
+
+// tagCounter counts the mentions of the tags, a question counts once for each of its tags
+type tagCounter struct {
+	mentions map[string]int
+	users    map[string]map[string]bool
+}
+
+func newTagCounter() *tagCounter {
+	return &tagCounter{mentions: map[string]int{}, users: map[string]map[string]bool{}}
+}
+
+func (c *tagCounter) add(names []string, userID string) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		c.mentions[name]++
+		if userID == "" {
+			continue
+		}
+		if c.users[name] == nil {
+			c.users[name] = map[string]bool{}
+		}
+		c.users[name][userID] = true
+	}
+}
+
+// top returns the limit most mentioned tags, a limit of 0 returns them all
+func (c *tagCounter) top(limit int) []TagMentions {
+	mentions := make([]TagMentions, 0, len(c.mentions))
+	for name, count := range c.mentions {
+		mentions = append(mentions, TagMentions{Name: name, Mentions: count, Users: len(c.users[name])})
+	}
+	sort.Slice(mentions, func(a, b int) bool {
+		if mentions[a].Mentions != mentions[b].Mentions {
+			return mentions[a].Mentions > mentions[b].Mentions
+		}
+		return mentions[a].Name < mentions[b].Name
+	})
+	if limit > 0 && len(mentions) > limit {
+		mentions = mentions[:limit]
+	}
+	return mentions
+}
+
+func upperSymbols(symbols []string) []string {
+	upper := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		upper = append(upper, strings.ToUpper(strings.TrimSpace(symbol)))
+	}
+	return upper
+}
+
+// MostMentioned returns the limit stock symbols, ETF symbols and sectors most mentioned by the questions
+// asked between from and to
+func (s *TopicAnalyticsService) MostMentioned(from time.Time, to time.Time, limit int) (MostMentioned, error) {
+	extractions, err := s.reader.QueryTopicAndTags(TopicAndTagsQuery{From: from, To: to})
+	if err != nil {
+		return MostMentioned{}, err
+	}
+
+	stocks, etfs, sectors := newTagCounter(), newTagCounter(), newTagCounter()
+	for _, extraction := range extractions {
+		stocks.add(upperSymbols(extraction.Tags.StockSymbols), extraction.UserID)
+		etfs.add(upperSymbols(extraction.Tags.EtfSymbols), extraction.UserID)
+		sectors.add([]string{extraction.Tags.SectorName}, extraction.UserID)
+	}
+
+	return MostMentioned{
+		From:    from,
+		To:      to,
+		Stocks:  stocks.top(limit),
+		Etfs:    etfs.top(limit),
+		Sectors: sectors.top(limit),
+	}, nil
+}
+
+type UserTopicHistory struct {
+	UserID string
+	From   time.Time
+	To     time.Time
+	Topics map[Topic]int  // Questions of every topic of the range
+	Recent []TopicAndTags // The most recent questions first
+}
+
+// UserTopicHistory returns the topics of the questions the user asked between from and to, with the
+// limit most recent questions
+func (s *TopicAnalyticsService) UserTopicHistory(userID string, from time.Time, to time.Time, limit int) (UserTopicHistory, error) {
+	if userID == "" {
+		return UserTopicHistory{}, errors.InvalidTopicAnalyticsQueryError{Message: "the user is required"}
+	}
+
+	extractions, err := s.reader.QueryTopicAndTags(TopicAndTagsQuery{UserID: userID, From: from, To: to})
+	if err != nil {
+		return UserTopicHistory{}, err
+	}
+
+	history := UserTopicHistory{UserID: userID, From: from, To: to, Topics: map[Topic]int{}}
+	for _, extraction := range extractions {
+		history.Topics[extraction.Topic]++
+	}
+
+	history.Recent = slices.Clone(extractions)
+	slices.Reverse(history.Recent)
+	if limit > 0 && len(history.Recent) > limit {
+		history.Recent = history.Recent[:limit]
+	}
+
+	return history, nil
+}
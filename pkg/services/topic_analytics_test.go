@@ -0,0 +1,105 @@
+package services
+
+import (
+	investbotErr "investbot/pkg/errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTopicAndTagsReader []TopicAndTags
+
+func (f fakeTopicAndTagsReader) QueryTopicAndTags(query TopicAndTagsQuery) ([]TopicAndTags, error) {
+	var extractions []TopicAndTags
+	for _, extraction := range f {
+		if len(query.Topics) > 0 && !slices.Contains(query.Topics, extraction.Topic) {
+			continue
+		}
+		if query.UserID != "" && extraction.UserID != query.UserID {
+			continue
+		}
+		if extraction.CreatedAt.Before(query.From) || !extraction.CreatedAt.Before(query.To) {
+			continue
+		}
+		extractions = append(extractions, extraction)
+	}
+	return extractions, nil
+}
+
+var analyticsStart = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func analyticsExtraction(hours int, topic Topic, userID string, tags Tags) TopicAndTags {
+	return TopicAndTags{
+		Topic:     topic,
+		Tags:      tags,
+		Question:  string(topic),
+		UserID:    userID,
+		CreatedAt: analyticsStart.Add(time.Duration(hours) * time.Hour),
+	}
+}
+
+func TestTopicAnalyticsService_TopicDistribution(t *testing.T) {
+	reader := fakeTopicAndTagsReader{
+		analyticsExtraction(1, NEWS, "user", Tags{}),
+		analyticsExtraction(2, NEWS, "user", Tags{}),
+		analyticsExtraction(3, ETFS, "user", Tags{}),
+		analyticsExtraction(49, NEWS, "other_user", Tags{}),
+		analyticsExtraction(80, NEWS, "other_user", Tags{}),
+	}
+	analyticsService, _ := NewTopicAnalyticsService(reader)
+
+	distribution, err := analyticsService.TopicDistribution(analyticsStart, analyticsStart.AddDate(0, 0, 3), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, map[Topic]int{NEWS: 3, ETFS: 1}, distribution.Totals)
+	assert.Len(t, distribution.Buckets, 3)
+	assert.Equal(t, TopicBucket{Start: analyticsStart, Total: 3, Topics: map[Topic]int{NEWS: 2, ETFS: 1}}, distribution.Buckets[0])
+	// Empty buckets are returned too
+	assert.Zero(t, distribution.Buckets[1].Total)
+	assert.Equal(t, map[Topic]int{NEWS: 1}, distribution.Buckets[2].Topics)
+
+	_, err = analyticsService.TopicDistribution(analyticsStart, analyticsStart.AddDate(1, 0, 0), time.Minute)
+	assert.ErrorAs(t, err, &investbotErr.InvalidTopicAnalyticsQueryError{})
+}
+
+func TestTopicAnalyticsService_MostMentioned(t *testing.T) {
+	reader := fakeTopicAndTagsReader{
+		analyticsExtraction(1, STOCK_OVERVIEW, "user", Tags{StockSymbols: []string{"AAPL", "msft"}}),
+		analyticsExtraction(2, STOCK_FINANCIALS, "user", Tags{StockSymbols: []string{"aapl", "AAPL"}}),
+		analyticsExtraction(3, STOCK_OVERVIEW, "other_user", Tags{StockSymbols: []string{"MSFT"}}),
+		analyticsExtraction(4, STOCK_OVERVIEW, "", Tags{StockSymbols: []string{"MSFT"}}),
+		analyticsExtraction(5, ETFS, "user", Tags{EtfSymbols: []string{"SPY"}}),
+		analyticsExtraction(6, SECTORS, "user", Tags{SectorName: "Technology"}),
+	}
+	analyticsService, _ := NewTopicAnalyticsService(reader)
+
+	mostMentioned, err := analyticsService.MostMentioned(analyticsStart, analyticsStart.AddDate(0, 0, 1), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagMentions{
+		{Name: "MSFT", Mentions: 3, Users: 2},
+		{Name: "AAPL", Mentions: 2, Users: 1},
+	}, mostMentioned.Stocks)
+	assert.Equal(t, []TagMentions{{Name: "SPY", Mentions: 1, Users: 1}}, mostMentioned.Etfs)
+	assert.Equal(t, []TagMentions{{Name: "Technology", Mentions: 1, Users: 1}}, mostMentioned.Sectors)
+}
+
+func TestTopicAnalyticsService_UserTopicHistory(t *testing.T) {
+	reader := fakeTopicAndTagsReader{
+		analyticsExtraction(1, NEWS, "user", Tags{}),
+		analyticsExtraction(2, ETFS, "other_user", Tags{}),
+		analyticsExtraction(3, ETFS, "user", Tags{}),
+		analyticsExtraction(4, PORTFOLIO, "user", Tags{}),
+	}
+	analyticsService, _ := NewTopicAnalyticsService(reader)
+
+	history, err := analyticsService.UserTopicHistory("user", analyticsStart, analyticsStart.AddDate(0, 0, 1), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[Topic]int{NEWS: 1, ETFS: 1, PORTFOLIO: 1}, history.Topics)
+	assert.Len(t, history.Recent, 2)
+	assert.Equal(t, PORTFOLIO, history.Recent[0].Topic)
+	assert.Equal(t, ETFS, history.Recent[1].Topic)
+
+	_, err = analyticsService.UserTopicHistory("", analyticsStart, analyticsStart.AddDate(0, 0, 1), 2)
+	assert.ErrorAs(t, err, &investbotErr.InvalidTopicAnalyticsQueryError{})
+}
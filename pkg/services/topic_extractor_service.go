@@ -7,12 +7,14 @@ import (
 	"investbot/pkg/services/prompts"
 	"log"
 	"strings"
+	"time"
 )
 
 type TopicExtractor struct {
 	llm                Llm
 	userContextService UserContextDataService
 	responseStore      RagResponsesRepository
+	experimentVariant
 }
 
 func NewTopicExtractor(
@@ -41,12 +43,19 @@ func (te TopicExtractor) ExtractTopic(conversation []Message, userID string) (To
 		}
 	}
 
-	prompt := fmt.Sprintf(prompts.TopicExtractorPrompt, userContext, conversation)
+	prompt, err := te.renderPrompt(prompts.TopicExtractor, prompts.Vars{
+		"UserContext":  renderUserContext(userContext),
+		"Conversation": conversation,
+	})
+	if err != nil {
+		return "", err
+	}
 	promptMsg := Message{
 		Role:    User,
-		Content: prompt,
+		Content: prompt.Text,
 	}
 
+	start := time.Now()
 	responseMessage, err := streamChunks(
 		func(chunkChan chan<- string) error {
 			return te.llm.GenerateResponse([]Message{promptMsg}, chunkChan)
@@ -54,29 +63,45 @@ func (te TopicExtractor) ExtractTopic(conversation []Message, userID string) (To
 		nil, // we don't need to stream this response
 	)
 	if err != nil {
+		te.observe(TopicExtractorTarget, "", []Message{promptMsg}, "", time.Since(start), false)
 		return "", err
 	}
+	latency := time.Since(start)
 
 	go func() {
-		storeErr := te.responseStore.StoreRagResponse(
-			te.llm.GetLlmName(),
-			"ExtractTopic",
-			[]Message{promptMsg},
-			responseMessage,
-		)
+		storeErr := te.responseStore.StoreRagResponse(RagResponse{
+			ModelName:     te.llm.GetLlmName(),
+			Topic:         "ExtractTopic",
+			Conversation:  []Message{promptMsg},
+			Response:      responseMessage,
+			PromptName:    prompt.Name,
+			PromptVersion: prompt.Version,
+			Experiment:    te.assignment.Experiment,
+			Variant:       te.assignment.Variant,
+		})
 		if storeErr != nil {
 			log.Printf("Failed to store topic extraction rag response: %s", storeErr.Error())
 		}
 	}()
 
+	topic, err := parseTopic(responseMessage)
+	te.observe(TopicExtractorTarget, topic, []Message{promptMsg}, responseMessage, latency, err == nil)
+	return topic, err
+}
+
+// parseTopic returns the topic of the response of the llm
+func parseTopic(responseMessage string) (Topic, error) {
 	// Validate the response against known topics
 	topics := map[Topic]any{
 		EDUCATION:        nil,
 		SECTORS:          nil,
+		INDUSTRIES:       nil,
 		STOCK_OVERVIEW:   nil,
 		STOCK_FINANCIALS: nil,
 		ETFS:             nil,
 		NEWS:             nil,
+		PORTFOLIO:        nil,
+		SUPER_INVESTORS:  nil,
 	}
 
 	// Strip formatting artifacts from the response(in case they exist)
@@ -84,8 +109,7 @@ func (te TopicExtractor) ExtractTopic(conversation []Message, userID string) (To
 	strippedLlmResponse = strings.TrimSuffix(strippedLlmResponse, "\n```")
 
 	var topicResponse llmTopicResponse
-	err = json.Unmarshal([]byte(strippedLlmResponse), &topicResponse)
-	if err != nil {
+	if err := json.Unmarshal([]byte(strippedLlmResponse), &topicResponse); err != nil {
 		return "", err
 	}
 
@@ -2,8 +2,17 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"investbot/pkg/domain"
 	investbotErr "investbot/pkg/errors"
+	"math"
+	"strings"
+)
+
+const (
+	maxSymbolSuggestions = 5
+	// Percentages are usually rounded by the users so they don't add up to exactly 100
+	portfolioPercentageTolerance = 1.0
 )
 
 type UserContextRepository interface {
@@ -18,10 +27,14 @@ type UserContextDataService interface {
 
 type UserContextService struct {
 	userContextRepository UserContextRepository
+	dataService           SymbolUniverseDataService
 }
 
-func NewUserContextService(userContextRepository UserContextRepository) (*UserContextService, error) {
-	return &UserContextService{userContextRepository: userContextRepository}, nil
+func NewUserContextService(
+	userContextRepository UserContextRepository,
+	dataService SymbolUniverseDataService,
+) (*UserContextService, error) {
+	return &UserContextService{userContextRepository: userContextRepository, dataService: dataService}, nil
 }
 
 func (s *UserContextService) GetUserContext(userID string) (domain.UserContext, error) {
@@ -33,7 +46,9 @@ func (s *UserContextService) GetUserContext(userID string) (domain.UserContext,
 	return userContext, nil
 }
 
-func (s *UserContextService) CreateUserContext(userContext domain.UserContext) error {
+// CreateUserContext validates and normalizes the portfolio before storing the user context
+// and returns the stored user context
+func (s *UserContextService) CreateUserContext(userContext domain.UserContext) (domain.UserContext, error) {
 	// Check if user context for given user id already exists
 	dbUserContext, err := s.userContextRepository.GetUserContext(userContext.UserID)
 	if err != nil {
@@ -41,22 +56,175 @@ func (s *UserContextService) CreateUserContext(userContext domain.UserContext) e
 		if errors.As(err, &notFoundError) {
 			// do nothing in this case
 		} else {
-			return err
+			return domain.UserContext{}, err
 		}
 	}
 
 	if dbUserContext.UserID != "" {
-		return investbotErr.UserContextAlreadyExistsError{UserID: dbUserContext.UserID}
+		return domain.UserContext{}, investbotErr.UserContextAlreadyExistsError{UserID: dbUserContext.UserID}
 	}
 
-	// TODO: Check if symbols are valid and if duplicates exist
-	return s.userContextRepository.InsertUserContext(userContext)
+	userContext.UserPortfolio, err = s.normalizePortfolio(userContext.UserPortfolio)
+	if err != nil {
+		return domain.UserContext{}, err
+	}
+	userContext.InvestorProfile = normalizeInvestorProfile(userContext.InvestorProfile)
+
+	return userContext, s.userContextRepository.InsertUserContext(userContext)
 }
 
-func (s *UserContextService) UpdateUserContext(userContext domain.UserContext) error {
-	_, err := s.userContextRepository.GetUserContext(userContext.UserID)
+func (s *UserContextService) UpdateUserContext(userContext domain.UserContext) (domain.UserContext, error) {
+	dbUserContext, err := s.userContextRepository.GetUserContext(userContext.UserID)
 	if err != nil { // user context not found error is covered here
-		return err
+		return domain.UserContext{}, err
+	}
+
+	userContext.UserPortfolio, err = s.normalizePortfolio(userContext.UserPortfolio)
+	if err != nil {
+		return domain.UserContext{}, err
+	}
+
+	// Clients that only know the free-form user profile don't send the investor profile,
+	// so the stored one is kept instead of being removed
+	if userContext.InvestorProfile == nil {
+		userContext.InvestorProfile = dbUserContext.InvestorProfile
+	} else {
+		userContext.InvestorProfile = normalizeInvestorProfile(userContext.InvestorProfile)
+	}
+
+	return userContext, s.userContextRepository.UpdateUserContext(userContext)
+}
+
+func (s *UserContextService) GetRiskQuestionnaire() []domain.QuestionnaireQuestion {
+	return RiskQuestionnaire
+}
+
+// SubmitRiskQuestionnaire scores the answers of the risk questionnaire and stores the result in the
+// investor profile of the user. A user context is created if the user doesn't have one yet.
+func (s *UserContextService) SubmitRiskQuestionnaire(userID string, answers map[string]string) (domain.UserContext, error) {
+	userContext, err := s.userContextRepository.GetUserContext(userID)
+	isNewUserContext := false
+	if err != nil {
+		notFoundError := investbotErr.UserContextNotFoundError{}
+		if !errors.As(err, &notFoundError) {
+			return domain.UserContext{}, err
+		}
+		userContext = domain.UserContext{UserID: userID}
+		isNewUserContext = true
+	}
+
+	userContext.InvestorProfile, err = applyQuestionnaire(userContext.InvestorProfile, answers)
+	if err != nil {
+		return domain.UserContext{}, err
+	}
+
+	if isNewUserContext {
+		err = s.userContextRepository.InsertUserContext(userContext)
+	} else {
+		err = s.userContextRepository.UpdateUserContext(userContext)
+	}
+
+	return userContext, err
+}
+
+func (s *UserContextService) normalizePortfolio(portfolio []domain.UserPortfolioHolding) ([]domain.UserPortfolioHolding, error) {
+	if len(portfolio) == 0 {
+		return portfolio, nil
+	}
+
+	resolver, err := newSymbolResolver(s.dataService)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizePortfolio(portfolio, resolver)
+}
+
+// normalizePortfolio resolves the symbols of the holdings against the stock and etf universes,
+// uppercases them and merges holdings of the same symbol. All invalid fields are returned
+// in a single UserContextValidationError, with suggestions for the symbols that were not found.
+func normalizePortfolio(
+	portfolio []domain.UserPortfolioHolding,
+	resolver *symbolResolver,
+) ([]domain.UserPortfolioHolding, error) {
+	fieldErrors := make([]investbotErr.FieldError, 0)
+	normalized := make([]domain.UserPortfolioHolding, 0, len(portfolio))
+	symbolIndexes := make(map[string]int)
+	totalPercentage := 0.0
+	hasQuantityHoldings := false
+
+	for i, h := range portfolio {
+		field := fmt.Sprintf("user_portfolio[%d]", i)
+		totalPercentage += h.PortfolioPercentage
+		hasQuantityHoldings = hasQuantityHoldings || (h.PortfolioPercentage == 0 && h.Quantity > 0)
+
+		if h.AssetClass == domain.Crypto {
+			// There is no crypto universe to validate against
+			h.Symbol = strings.ToUpper(strings.TrimSpace(h.Symbol))
+		} else {
+			resolved, found := resolver.resolve(h.Symbol, h.Name)
+			if !found {
+				query, queryField := h.Symbol, ".symbol"
+				if strings.TrimSpace(query) == "" {
+					query, queryField = h.Name, ".name"
+				}
+				fieldErrors = append(fieldErrors, investbotErr.FieldError{
+					Field:       field + queryField,
+					Message:     fmt.Sprintf("%s was not found in stocks or etfs", query),
+					Suggestions: resolver.suggest(query, maxSymbolSuggestions),
+				})
+				continue
+			}
+
+			if resolved.assetClass != h.AssetClass {
+				fieldErrors = append(fieldErrors, investbotErr.FieldError{
+					Field:   field + ".asset_class",
+					Message: fmt.Sprintf("%s is listed as %s, not %s", resolved.symbol, resolved.assetClass, h.AssetClass),
+				})
+				continue
+			}
+
+			h.Symbol = resolved.symbol
+			if h.Name == "" {
+				h.Name = resolved.name
+			}
+		}
+
+		// Crypto holdings can be defined by name only
+		key := h.Symbol
+		if key == "" {
+			key = normalizeAssetName(h.Name)
+		}
+
+		if index, found := symbolIndexes[key]; found {
+			normalized[index].Quantity += h.Quantity
+			normalized[index].PortfolioPercentage += h.PortfolioPercentage
+			continue
+		}
+
+		symbolIndexes[key] = len(normalized)
+		normalized = append(normalized, h)
+	}
+
+	// Percentages are optional, they are only checked when at least one of them is set. The holdings with
+	// a quantity and no percentage, like the ones traded in the ledger, share the rest of the portfolio.
+	switch {
+	case totalPercentage == 0:
+	case hasQuantityHoldings && totalPercentage > 100+portfolioPercentageTolerance:
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   "user_portfolio",
+			Message: fmt.Sprintf("portfolio percentages add up to %.2f%%, expected at most 100%%", totalPercentage),
+		})
+	case !hasQuantityHoldings && math.Abs(totalPercentage-100) > portfolioPercentageTolerance:
+		fieldErrors = append(fieldErrors, investbotErr.FieldError{
+			Field:   "user_portfolio",
+			Message: fmt.Sprintf("portfolio percentages add up to %.2f%%, expected about 100%%", totalPercentage),
+		})
 	}
-	return s.userContextRepository.UpdateUserContext(userContext)
+
+	if len(fieldErrors) > 0 {
+		return nil, investbotErr.UserContextValidationError{FieldErrors: fieldErrors}
+	}
+
+	return normalized, nil
 }
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"investbot/pkg/domain"
+	investbotErr "investbot/pkg/errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePortfolio(t *testing.T) {
+	portfolio := []domain.UserPortfolioHolding{
+		{AssetClass: domain.Stock, Symbol: "aapl", Quantity: 5, PortfolioPercentage: 30},
+		{AssetClass: domain.ETF, Name: "Vanguard S&P 500 ETF", Quantity: 1, PortfolioPercentage: 40},
+		{AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 5, PortfolioPercentage: 20},
+		{AssetClass: domain.Crypto, Symbol: "btc", Quantity: 0.1, PortfolioPercentage: 10},
+	}
+
+	normalized, err := normalizePortfolio(portfolio, testSymbolResolver())
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.UserPortfolioHolding{
+		{AssetClass: domain.Stock, Symbol: "AAPL", Name: "Apple Inc.", Quantity: 10, PortfolioPercentage: 50},
+		{AssetClass: domain.ETF, Symbol: "VOO", Name: "Vanguard S&P 500 ETF", Quantity: 1, PortfolioPercentage: 40},
+		{AssetClass: domain.Crypto, Symbol: "BTC", Quantity: 0.1, PortfolioPercentage: 10},
+	}, normalized)
+}
+
+func TestNormalizePortfolio_FieldErrors(t *testing.T) {
+	portfolio := []domain.UserPortfolioHolding{
+		{AssetClass: domain.Stock, Symbol: "APPL", PortfolioPercentage: 50},
+		{AssetClass: domain.Stock, Symbol: "VOO", PortfolioPercentage: 30},
+		{AssetClass: domain.Stock, Name: "Microsoft", PortfolioPercentage: 10},
+	}
+
+	_, err := normalizePortfolio(portfolio, testSymbolResolver())
+	validationError := investbotErr.UserContextValidationError{}
+	assert.ErrorAs(t, err, &validationError)
+	assert.Equal(t, []investbotErr.FieldError{
+		{
+			Field:       "user_portfolio[0].symbol",
+			Message:     "APPL was not found in stocks or etfs",
+			Suggestions: []string{"AAPL"},
+		},
+		{
+			Field:   "user_portfolio[1].asset_class",
+			Message: "VOO is listed as etf, not stock",
+		},
+		{
+			Field:   "user_portfolio",
+			Message: "portfolio percentages add up to 90.00%, expected about 100%",
+		},
+	}, validationError.FieldErrors)
+}
+
+func TestNormalizePortfolio_QuantityHoldings(t *testing.T) {
+	// The holdings with a quantity and no percentage share the rest of the portfolio
+	portfolio := []domain.UserPortfolioHolding{
+		{AssetClass: domain.Crypto, Symbol: "BTC", PortfolioPercentage: 40},
+		{AssetClass: domain.Stock, Symbol: "AAPL", Quantity: 10},
+	}
+	_, err := normalizePortfolio(portfolio, testSymbolResolver())
+	assert.NoError(t, err)
+
+	portfolio[0].PortfolioPercentage = 120
+	_, err = normalizePortfolio(portfolio, testSymbolResolver())
+	validationError := investbotErr.UserContextValidationError{}
+	assert.ErrorAs(t, err, &validationError)
+	assert.Equal(t, "portfolio percentages add up to 120.00%, expected at most 100%", validationError.FieldErrors[0].Message)
+}
@@ -114,6 +114,17 @@ def stream_chat_response(
         yield f"[ERROR] {e}"
 
 
+def format_sources(sources: list) -> str:
+    if not sources:
+        return ""
+
+    lines = ["\n\n**Sources**"]
+    for source in sources:
+        fetched_at = source.get("fetched_at", "")[:16].replace("T", " ")
+        lines.append(f"- [{source['id']}] [{source['label']}]({source['url']}) (fetched {fetched_at})")
+    return "\n".join(lines)
+
+
 def response_generator(session_id: str, question: str):
     topic_and_tags = extract_topic_and_tags(
         session_id=session_id,
@@ -129,6 +140,14 @@ def response_generator(session_id: str, question: str):
         try:
             # Parse each chunk from JSON string literal to clean text
             text = json.loads(chunk)
+            # The last line lists the sources the response cites
+            if isinstance(text, dict) and text.get("event") == "sources":
+                yield format_sources(text.get("sources") or [])
+                continue
+            # The figures of the response were wrong and it's generated again, None discards what was shown
+            if isinstance(text, dict) and text.get("event") == "correction":
+                yield None
+                continue
             if text.strip():  # skip empty chunks
                 yield text
         except Exception as e:
@@ -181,7 +200,7 @@ if prompt:
         for chunk in response_generator(
             session_id=st.session_state.session_id, question=prompt
         ):
-            full_response += chunk
+            full_response = "" if chunk is None else full_response + chunk
             response_placeholder.markdown(full_response)
 
     # Add assistant's reply to history